@@ -0,0 +1,67 @@
+// Package auth - проверка учетных данных клиента: bearer токен или HTTP Basic
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials - клиент вообще не представился
+	ErrNoCredentials = errors.New("credentials required")
+	// ErrBadCredentials - представился, но не тем
+	ErrBadCredentials = errors.New("invalid credentials")
+)
+
+// Credentials - что сервер принимает. пустые поля не проверяются, все пустые - авторизация выключена
+type Credentials struct {
+	Token string
+	User  string
+	Pass  string
+}
+
+// Enabled говорит, задано ли хоть что-то, с чем сравнивать
+func (c Credentials) Enabled() bool {
+	return c.Token != "" || c.User != ""
+}
+
+// Check проверяет значение заголовка Authorization. сравнение за постоянное время,
+// чтобы по времени ответа нельзя было подбирать токен по символу
+func (c Credentials) Check(header string) error {
+	scheme, value, _ := strings.Cut(header, " ")
+	switch {
+	case header == "":
+		return ErrNoCredentials
+	case strings.EqualFold(scheme, "Bearer") && c.Token != "":
+		if subtle.ConstantTimeCompare([]byte(value), []byte(c.Token)) == 1 {
+			return nil
+		}
+	case strings.EqualFold(scheme, "Basic") && c.User != "":
+		raw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ErrBadCredentials
+		}
+		user, pass, _ := strings.Cut(string(raw), ":")
+		// оба сравнения делаем всегда, без короткого замыкания на неверном логине
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(c.User))
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(c.Pass))
+		if userOK&passOK == 1 {
+			return nil
+		}
+	}
+	return ErrBadCredentials
+}
+
+// Challenges - значения WWW-Authenticate для ответа 401, по одному на каждую включенную схему
+func (c Credentials) Challenges(realm string) []string {
+	var ch []string
+	if c.Token != "" {
+		ch = append(ch, `Bearer realm="`+realm+`"`)
+	}
+	if c.User != "" {
+		ch = append(ch, `Basic realm="`+realm+`", charset="UTF-8"`)
+	}
+	return ch
+}
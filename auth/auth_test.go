@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func basic(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+func TestCheck(t *testing.T) {
+	both := Credentials{Token: "secret", User: "admin", Pass: "pw"}
+	for _, tt := range []struct {
+		name   string
+		creds  Credentials
+		header string
+		want   error
+	}{
+		{"no header", both, "", ErrNoCredentials},
+		{"bearer", both, "Bearer secret", nil},
+		{"bearer scheme is case-insensitive", both, "bearer secret", nil},
+		{"wrong token", both, "Bearer secreT", ErrBadCredentials},
+		{"token prefix", both, "Bearer secre", ErrBadCredentials},
+		{"basic", both, basic("admin", "pw"), nil},
+		{"wrong password", both, basic("admin", "pW"), ErrBadCredentials},
+		{"wrong user", both, basic("root", "pw"), ErrBadCredentials},
+		{"password with a colon", Credentials{User: "u", Pass: "a:b"}, basic("u", "a:b"), nil},
+		{"broken base64", both, "Basic !!!", ErrBadCredentials},
+		{"bearer is not configured", Credentials{User: "admin", Pass: "pw"}, "Bearer secret", ErrBadCredentials},
+		{"basic is not configured", Credentials{Token: "secret"}, basic("admin", "pw"), ErrBadCredentials},
+		{"unknown scheme", both, "Digest secret", ErrBadCredentials},
+	} {
+		if err := tt.creds.Check(tt.header); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestChallenges(t *testing.T) {
+	if ch := (Credentials{}).Challenges("kv"); len(ch) != 0 {
+		t.Errorf("no credentials: got %v", ch)
+	}
+	want := []string{`Bearer realm="kv"`, `Basic realm="kv", charset="UTF-8"`}
+	if ch := (Credentials{Token: "t", User: "u"}).Challenges("kv"); !slices.Equal(ch, want) {
+		t.Errorf("got %v, want %v", ch, want)
+	}
+	if (Credentials{}).Enabled() || !(Credentials{User: "u"}).Enabled() || !(Credentials{Token: "t"}).Enabled() {
+		t.Error("Enabled does not follow the configured fields")
+	}
+}
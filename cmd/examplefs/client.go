@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// клиентские подкоманды: тот же бинарь ходит в уже запущенный сервер, чтобы не собирать URL для curl руками
+var clientCommands = map[string]struct {
+	args  int
+	usage string
+}{
+	"get":  {1, "get [flags] <key>"},
+	"set":  {2, "set [flags] <key> <value|->"},
+	"del":  {1, "del [flags] <key>"},
+	"dump": {0, "dump [flags]"},
+}
+
+// коды выхода клиента, чтобы в скриптах можно было отличить отсутствующий ключ от упавшего сервера
+const (
+	exitNotFound = 1
+	exitFailed   = 2
+)
+
+var errKeyNotFound = errors.New("key not found")
+
+type client struct {
+	base  string // например http://localhost:8080/memory
+	token string
+	http  *http.Client
+}
+
+// runClient выполняет подкоманду args[0] и возвращает код выхода
+func runClient(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("examplefs "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", envOr("EXAMPLEFS_ADDR", ":8080"), "server address (env EXAMPLEFS_ADDR)")
+	backend := fs.String("storage", envOr("EXAMPLEFS_STORAGE", "mem"), "backend the server runs, it decides the URL prefix (env EXAMPLEFS_STORAGE)")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	token := fs.String("token", envOr("EXAMPLEFS_AUTH_TOKEN", ""), "bearer token for servers started with -auth-token (env EXAMPLEFS_AUTH_TOKEN)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitFailed
+	}
+
+	c := &client{
+		base:  baseURL(*addr) + Config{Storage: *backend}.routePrefix(),
+		token: *token,
+		http:  &http.Client{Timeout: *timeout},
+	}
+	err := c.run(args[0], fs.Args(), stdin, stdout)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errKeyNotFound):
+		fmt.Fprintln(stderr, err)
+		return exitNotFound
+	default:
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+}
+
+// baseURL превращает адрес в формате -addr сервера (":8080", "host:8080") в URL
+func baseURL(addr string) string {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return "http://" + addr
+}
+
+func (c *client) run(cmd string, args []string, stdin io.Reader, stdout io.Writer) error {
+	if spec := clientCommands[cmd]; len(args) != spec.args {
+		return fmt.Errorf("usage: examplefs %s", spec.usage)
+	}
+
+	switch cmd {
+	case "get":
+		return c.do(http.MethodGet, "/"+url.PathEscape(args[0]), nil, stdout)
+	case "set":
+		var body io.Reader = strings.NewReader(args[1])
+		if args[1] == "-" { // значение из stdin, чтобы можно было положить файл через пайп
+			body = stdin
+		}
+		return c.do(http.MethodPut, "/"+url.PathEscape(args[0]), body, io.Discard)
+	case "del":
+		return c.do(http.MethodDelete, "/"+url.PathEscape(args[0]), nil, io.Discard)
+	case "dump":
+		return c.do(http.MethodGet, "/_dump", nil, stdout)
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+// do отправляет запрос и копирует тело успешного ответа в out
+func (c *client) do(method, path string, body io.Reader, out io.Writer) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errKeyNotFound
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg, &apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("server error: %s: %s (%s)", resp.Status, apiErr.Message, apiErr.Code)
+		}
+		return fmt.Errorf("server error: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		return fmt.Errorf("unable to read response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+func TestRunClient(t *testing.T) {
+	srv := httptest.NewServer(httpapi.NewRouter("/memory", storage.NewMemStorage(), nil))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		args     []string
+		stdin    string
+		wantCode int
+		wantOut  string
+	}{
+		{args: []string{"set", "a", "1"}},
+		{args: []string{"set", "b", "-"}, stdin: "from\nstdin"},
+		{args: []string{"get", "a"}, wantOut: "1"},
+		{args: []string{"get", "b"}, wantOut: "from\nstdin"},
+		{args: []string{"get", "missing"}, wantCode: exitNotFound},
+		{args: []string{"dump"}, wantOut: `{"a":"1","b":"from\nstdin"}`},
+		{args: []string{"del", "a"}},
+		{args: []string{"del", "a"}, wantCode: exitNotFound},
+		{args: []string{"get"}, wantCode: exitFailed},
+		{args: []string{"set", "a"}, wantCode: exitFailed},
+		{args: []string{"get", "-no-such-flag", "a"}, wantCode: exitFailed},
+	} {
+		args := append([]string{tt.args[0], "-addr", srv.URL}, tt.args[1:]...)
+		var stdout, stderr bytes.Buffer
+		code := runClient(args, strings.NewReader(tt.stdin), &stdout, &stderr)
+		if code != tt.wantCode {
+			t.Errorf("%v: exit code %d (%s), want %d", tt.args, code, strings.TrimSpace(stderr.String()), tt.wantCode)
+		}
+		if tt.wantOut != "" && strings.TrimSpace(stdout.String()) != tt.wantOut {
+			t.Errorf("%v: output %q, want %q", tt.args, stdout.String(), tt.wantOut)
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	for in, want := range map[string]string{
+		":8080":                  "http://localhost:8080",
+		"host:9090":              "http://host:9090",
+		"http://host:8080/":      "http://host:8080",
+		"https://kv.example.com": "https://kv.example.com",
+	} {
+		if got := baseURL(in); got != want {
+			t.Errorf("baseURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,858 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// Config - все, что нужно, чтобы поднять сервер. собирается из флагов, а если флаг не задан - из переменных окружения
+type Config struct {
+	Addr string // адрес, на котором слушаем HTTP
+	GRPC string // адрес для gRPC, пустой - gRPC не поднимаем
+	RESP string // адрес для протокола Redis, пустой - не поднимаем
+
+	// unix:///path - еще и unix сокет для HTTP, с правами SocketMode (восьмеричными, как у chmod)
+	Listen     string
+	SocketMode string
+
+	// TLS для HTTP и gRPC: с сертификатом и ключом слушаем HTTPS, с ClientCA еще и требуем сертификат клиента
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+	Storage     string // mem, file, bolt, sqlite, redis, etcd, s3, remote, dir, pebble или sharded
+	File        string // файл с данными для file, bolt и sqlite
+	Dir         string // директория для dir и pebble
+	Codec       string // формат файла для file: json, gob или msgpack
+	Force       bool   // открыть поврежденный файл, загрузив то, что читается
+	Backups     int    // сколько копий файла держать, 0 - не снимать их автоматически
+	Gzip        bool   // сжимать файл для file
+	PebbleSync  bool   // fsync журнала pebble на каждой записи
+
+	// вторая хранилка, в которую дублируются все записи. файл и директория у нее свои, остальные настройки общие
+	MirrorStorage  string
+	MirrorFile     string
+	MirrorDir      string
+	MirrorStrict   bool // ошибка записи в зеркало - ошибка запроса
+	MirrorFallback bool // читать из зеркала то, что не прочиталось из основной хранилки
+
+	// дополнительные хранилки под /storage/{name}, основная там же под именем из -storage
+	Backends []backendSpec
+
+	Shards []backendSpec // шарды -storage=sharded по порядку, имя - номер шарда
+
+	SwitchTargets []backendSpec // куда можно переключить бэкенд через /admin/backend, без них - никуда
+
+	CompactSize  int64   // порог размера журнала для фоновой компакции, 0 - без порога
+	CompactRatio float64 // порог доли мертвых записей для фоновой компакции, 0 - без порога
+
+	// write-behind для file: записи копятся в памяти и уходят в файл по времени или по количеству.
+	// оба нуля - каждая запись сразу ложится в файл
+	FlushInterval time.Duration
+	FlushEvery    int
+	WAL           bool // каждая запись до ответа ложится в <file>.wal с fsync, так write-behind ничего не теряет при падении
+
+	// перечитывать файл, когда его правит кто-то другой, после стольких тишины; 0 - не следить.
+	// FileConflict - что делать, если при этом есть еще не записанные изменения write-behind
+	FileWatch    time.Duration
+	FileConflict string
+
+	// проверять файл file перед стартом: off, refuse - не стартовать с битым файлом, read-only - открыть его на чтение
+	VerifyOnStart string
+
+	// ключ шифрования файла для file в hex или base64. сам ключ во флаг не кладем,
+	// чтобы он не светился в списке процессов: только переменная окружения или файл
+	EncryptionKey     string
+	EncryptionKeyFile string
+
+	// учетные данные для записи: токен и/или логин с паролем. пароль и токен лучше отдавать через окружение
+	AuthToken    string
+	AuthUser     string
+	AuthPass     string
+	ProtectReads bool // требовать учетные данные и для чтения
+
+	RateLimit  float64 // запросов в секунду с одного IP, 0 - без ограничения
+	RateBurst  int
+	TrustProxy bool // брать IP клиента из X-Forwarded-For
+
+	CompressMinBytes int // сжимать gzip ответы от такого размера, 0 - не сжимать
+
+	Docs bool // страница с документацией API на /docs, сам /openapi.json отдается всегда
+	UI   bool // HTML страницы для просмотра ключей на /ui
+
+	// CORS для браузерных клиентов, пустой CORSOrigins - без CORS
+	CORSOrigins     []string
+	CORSMethods     []string
+	CORSCredentials bool
+
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+
+	// etcd общий у всех реплик, что на него смотрят. EtcdPrefix приписывается к ключам, чтобы делить кластер с чужими данными
+	EtcdEndpoints   []string
+	EtcdDialTimeout time.Duration
+	EtcdPrefix      string
+
+	// s3 и совместимые с ним хранилища, учетные данные берутся из стандартной цепочки AWS
+	S3Bucket   string
+	S3Prefix   string // префикс имен объектов, чтобы делить бакет с чужими данными
+	S3Endpoint string // свой адрес, например MinIO; пустой - настоящий AWS
+
+	// remote - другой examplefs, RemoteURL вместе с префиксом бэкенда: http://host:8080/memory
+	RemoteURL   string
+	RemoteToken string
+
+	CacheSize int // 0 - без кэша
+
+	// сколько помнить ответы на запись с Idempotency-Key и сколько их держать максимум, 0 - не помнить
+	IdempotencyTTL time.Duration
+	IdempotencyMax int
+
+	// адреса, на которые POST'ом уходит каждое изменение, пусто - без вебхуков
+	Webhooks       []string
+	WebhookRetries int
+	WebhookBackoff time.Duration
+
+	// старт только для чтения: записи отвергаются, файлы открываются с O_RDONLY. переключается через /admin/readonly
+	ReadOnly bool
+
+	// журнал аудита: по строке на каждое изменение, пусто - не пишем
+	AuditFile     string
+	AuditMaxBytes int64 // размер, после которого файл ротируется, 0 - без ротации
+	AuditKeep     int   // сколько ротированных файлов хранить
+	AuditValues   bool  // писать значения целиком, а не их хэш
+
+	// лимиты -storage=mem, сверх них выкидываются давно не тронутые ключи; 0 - без ограничения
+	MemMaxEntries int
+	MemMaxBytes   int64
+
+	// сколько прежних значений ключа помнят mem и file, 0 - без истории
+	HistoryDepth int
+	// сколько mem и file держат удаленные ключи для /_undelete, 0 - удалять сразу
+	SoftDelete time.Duration
+
+	MaxKeyBytes   int // 0 - без ограничения
+	MaxValueBytes int
+	// сколько HTTP хендлеры читают из тела PUT (если MaxValueBytes выключен) и из тела _batch и _txn
+	MaxBodyBytes  int64
+	MaxBatchBytes int64
+	KeyPattern    string // регулярка, которой должен целиком соответствовать ключ, пусто - любой ключ
+
+	StorageTimeout time.Duration // дедлайн на один вызов хранилки, 0 - без дедлайна
+
+	// повторы вызовов, упавших с временной ошибкой; 0 - без повторов
+	StorageRetries     int
+	StorageRetryDelay  time.Duration
+	StorageRetryJitter float64
+
+	// breaker: после стольких падений бэкенда подряд вызовы отвергаются сразу на BreakerCooldown; 0 - без breaker
+	BreakerFailures int
+	BreakerCooldown time.Duration
+
+	ShutdownTimeout time.Duration
+
+	// таймауты http.Server, 0 - без таймаута. /_watch снимает WriteTimeout для своего потока
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	LogLevel  slog.Level
+	LogFormat string // text или json
+
+	TraceHashKeys bool // класть в span хэш ключа вместо самого ключа
+
+	args     []string // аргументы запуска, reload разбирает их заново поверх перечитанного файла
+	settings rawFlags // итоговые значения флагов строками, по ним reload видит, что поменялось
+}
+
+// envOr возвращает значение переменной окружения или def, если она не задана
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+// уровень логов всего процесса, reload меняет его на ходу
+var logLevel = new(slog.LevelVar)
+
+// newLogger собирает slog логгер с уровнем и форматом из конфига. уровень он берет из logLevel
+func newLogger(w io.Writer, cfg Config) *slog.Logger {
+	logLevel.Set(cfg.LogLevel)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if cfg.LogFormat == "json" {
+		return slog.New(httpapi.LogContext(slog.NewJSONHandler(w, opts)))
+	}
+	return slog.New(httpapi.LogContext(slog.NewTextHandler(w, opts)))
+}
+
+// parseConfig собирает конфиг из слоев: файла из -config, переменных окружения EXAMPLEFS_* и аргументов командной
+// строки (без имени программы), каждый следующий перекрывает предыдущий. ошибки валидации отдает все сразу
+func parseConfig(args []string) (cfg Config, err error) {
+	fs := flag.NewFlagSet("examplefs", flag.ContinueOnError)
+	defineFlags(fs, &cfg)
+	if cfg.settings, err = applyLayers(fs, args); err != nil {
+		return cfg, err
+	}
+	cfg.args = args
+	cfg.EncryptionKey = os.Getenv("EXAMPLEFS_ENCRYPTION_KEY")
+	return cfg, cfg.validate()
+}
+
+// defineFlags описывает все настройки сервера флагами на fs. у каждого флага есть и переменная окружения
+// (-grpc-addr - EXAMPLEFS_GRPC_ADDR), и ключ в файле -config с тем же именем, что у флага
+func defineFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.String("config", "", "YAML file with settings named like the flags, e.g. addr: :8080; environment variables and flags override it (env EXAMPLEFS_CONFIG)")
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "address to listen on; sockets passed by systemd socket activation (LISTEN_FDS) replace it (env EXAMPLEFS_ADDR)")
+	fs.StringVar(&cfg.Listen, "listen", "", "unix socket to serve HTTP on in addition to -addr, e.g. unix:///var/run/examplefs.sock; a stale socket file is removed (env EXAMPLEFS_LISTEN)")
+	fs.StringVar(&cfg.SocketMode, "socket-mode", "0660", "permissions of the -listen socket file (env EXAMPLEFS_SOCKET_MODE)")
+	fs.StringVar(&cfg.GRPC, "grpc-addr", "", "address to serve the gRPC API on, empty disables it (env EXAMPLEFS_GRPC_ADDR)")
+	fs.StringVar(&cfg.RESP, "resp-addr", "", "address to serve the Redis protocol (RESP2) on for redis-cli and Redis clients, empty disables it (env EXAMPLEFS_RESP_ADDR)")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "PEM certificate to serve HTTPS and gRPC over TLS, requires -tls-key (env EXAMPLEFS_TLS_CERT)")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "PEM private key for -tls-cert (env EXAMPLEFS_TLS_KEY)")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", "", "PEM CA bundle to require and verify client certificates against (env EXAMPLEFS_TLS_CLIENT_CA)")
+	fs.StringVar(&cfg.Storage, "storage", "mem", "backend: mem, file, bolt, sqlite, redis, etcd, s3, remote, dir, pebble or sharded (env EXAMPLEFS_STORAGE)")
+	fs.StringVar(&cfg.File, "file", "", "data file for file, bolt and sqlite backends (env EXAMPLEFS_FILE)")
+	fs.StringVar(&cfg.File, "path", "", "alias for -file")
+	fs.BoolVar(&cfg.PebbleSync, "pebble-sync", true, "fsync the pebble journal on every write; false is faster but a machine crash loses the latest writes")
+	fs.StringVar(&cfg.Dir, "dir", "", "data directory for the dir backend, one file per key, and for pebble (env EXAMPLEFS_DIR)")
+	fs.StringVar(&cfg.Codec, "codec", "json", "data file format for the file backend: json, gob or msgpack (env EXAMPLEFS_CODEC)")
+	fs.BoolVar(&cfg.Force, "force", false, "load a corrupt data file anyway, keeping everything before the damaged record")
+	fs.IntVar(&cfg.Backups, "backups", 0, "back up the data file before every full rewrite and keep this many copies, 0 disables it")
+	fs.BoolVar(&cfg.Gzip, "gzip", false, "gzip the data file of the file backend")
+	fs.StringVar(&cfg.MirrorStorage, "mirror-storage", "", "second backend every write is copied to, empty disables mirroring (env EXAMPLEFS_MIRROR_STORAGE)")
+	fs.StringVar(&cfg.MirrorFile, "mirror-file", "", "data file for a file, bolt or sqlite -mirror-storage (env EXAMPLEFS_MIRROR_FILE)")
+	fs.StringVar(&cfg.MirrorDir, "mirror-dir", "", "data directory for -mirror-storage=dir (env EXAMPLEFS_MIRROR_DIR)")
+	fs.BoolVar(&cfg.MirrorStrict, "mirror-strict", false, "fail the request when the write to -mirror-storage fails instead of only logging it")
+	fs.BoolVar(&cfg.MirrorFallback, "mirror-fallback", false, "read from -mirror-storage when the primary backend misses the key or fails")
+	fs.Func("backend", "extra backend served under /storage/<name>, as name=kind or name=kind:path, e.g. archive=bolt:/var/lib/archive.db; repeatable", func(v string) error {
+		b, err := parseBackendSpec(v)
+		if err != nil {
+			return err
+		}
+		cfg.Backends = append(cfg.Backends, b)
+		return nil
+	})
+	fs.Func("switch-target", "backend POST /admin/backend may switch to, as kind or kind:path, e.g. bolt:/var/lib/data.db; repeatable, without it every switch is refused", func(v string) error {
+		b, err := parseBackendSpec("switch=" + v)
+		if err != nil {
+			return err
+		}
+		cfg.SwitchTargets = append(cfg.SwitchTargets, b)
+		return nil
+	})
+	fs.Func("shards", "comma-separated kind:path backends for -storage=sharded, e.g. file:a.json,file:b.json,bolt:c.db; only append new shards, reordering moves keys", func(v string) error {
+		shards, err := parseShards(v)
+		if err != nil {
+			return err
+		}
+		cfg.Shards = shards
+		return nil
+	})
+	fs.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", "", "file with the AES key (hex or base64) to encrypt the data file of the file backend (env EXAMPLEFS_ENCRYPTION_KEY_FILE, or the key itself in EXAMPLEFS_ENCRYPTION_KEY)")
+	fs.Int64Var(&cfg.CompactSize, "compact-size", 0, "compact the data file of the file backend once it grows to this many bytes, 0 disables the check")
+	fs.Float64Var(&cfg.CompactRatio, "compact-ratio", 0, "compact the data file of the file backend once this share of its records is overwritten or deleted, 0 disables the check")
+	fs.DurationVar(&cfg.FlushInterval, "flush-interval", 0, "write-behind for the file backend: persist queued writes this often, a crash loses up to this much; 0 writes every change immediately")
+	fs.IntVar(&cfg.FlushEvery, "flush-every", 0, "write-behind for the file backend: persist queued writes once this many have piled up, 0 disables the check")
+	fs.DurationVar(&cfg.FileWatch, "file-watch", 0, "file backend: reload the data file when another process changes it, once it has been quiet this long, e.g. 200ms; 0 disables watching")
+	fs.StringVar(&cfg.VerifyOnStart, "verify-on-start", verifyOff, "check the data file of the file backend before serving, as examplefs fsck does: off, refuse to start if it fails, or read-only to serve what is readable without writes")
+	fs.StringVar(&cfg.FileConflict, "file-conflict", string(storage.ConflictReadOnly), "what -file-watch does when the file changes while write-behind writes are pending: ours-wins, theirs-wins or read-only until /admin/reload")
+	fs.BoolVar(&cfg.WAL, "wal", false, "file backend: fsync every write to <file>.wal before answering and persist the data file in the background (every -flush-interval, default 1s), so a crash loses nothing")
+	fs.StringVar(&cfg.AuthToken, "auth-token", "", "bearer token required for writes (env EXAMPLEFS_AUTH_TOKEN)")
+	fs.StringVar(&cfg.AuthUser, "auth-user", "", "basic auth user allowed to write (env EXAMPLEFS_AUTH_USER)")
+	fs.StringVar(&cfg.AuthPass, "auth-pass", "", "basic auth password for -auth-user (env EXAMPLEFS_AUTH_PASS)")
+	fs.BoolVar(&cfg.ProtectReads, "protect-reads", false, "require credentials for reads too")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", 0, "requests per second allowed from one client IP, 0 disables rate limiting")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", 10, "how many requests a client IP may send at once above -rate-limit")
+	fs.BoolVar(&cfg.TrustProxy, "trust-proxy", false, "take the client IP for -rate-limit from X-Forwarded-For, only behind your own proxy")
+	fs.BoolVar(&cfg.Docs, "docs", false, "serve HTML API documentation at /docs, rendered from /openapi.json")
+	fs.BoolVar(&cfg.UI, "ui", true, "serve a web UI for browsing keys at /ui; its forms write through /v1/kv and are hidden in read-only mode")
+	fs.IntVar(&cfg.CompressMinBytes, "compress-min-bytes", 1<<10, "gzip responses of at least this many bytes for clients sending Accept-Encoding: gzip, 0 disables compression")
+	cfg.CORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodPatch, http.MethodDelete}
+	fs.Func("cors-origins", "comma-separated origins allowed to call the API from a browser, e.g. https://app.example.com, or * for any; empty disables CORS", func(v string) error {
+		cfg.CORSOrigins = splitList(v)
+		return nil
+	})
+	fs.Func("cors-methods", "comma-separated methods allowed for -cors-origins (default GET,HEAD,PUT,POST,DELETE)", func(v string) error {
+		cfg.CORSMethods = splitList(strings.ToUpper(v))
+		return nil
+	})
+	fs.BoolVar(&cfg.CORSCredentials, "cors-credentials", false, "let -cors-origins send cookies and Authorization; not allowed with -cors-origins=*")
+	fs.StringVar(&cfg.RedisAddr, "redis-addr", "localhost:6379", "redis address for -storage=redis")
+	fs.StringVar(&cfg.RedisPassword, "redis-password", "", "redis password for -storage=redis")
+	fs.IntVar(&cfg.RedisDB, "redis-db", 0, "redis database number for -storage=redis")
+	cfg.EtcdEndpoints = []string{"localhost:2379"}
+	fs.Func("etcd-endpoints", "comma-separated etcd endpoints for -storage=etcd (default localhost:2379)", func(v string) error {
+		cfg.EtcdEndpoints = splitList(v)
+		return nil
+	})
+	fs.DurationVar(&cfg.EtcdDialTimeout, "etcd-dial-timeout", 5*time.Second, "how long to wait for the etcd cluster at startup for -storage=etcd")
+	fs.StringVar(&cfg.EtcdPrefix, "etcd-prefix", "", "prefix prepended to every key of -storage=etcd, e.g. examplefs/ (env EXAMPLEFS_ETCD_PREFIX)")
+	fs.StringVar(&cfg.S3Bucket, "s3-bucket", "", "bucket for -storage=s3 (env EXAMPLEFS_S3_BUCKET)")
+	fs.StringVar(&cfg.S3Prefix, "s3-prefix", "", "object name prefix for -storage=s3, e.g. examplefs/ (env EXAMPLEFS_S3_PREFIX)")
+	fs.StringVar(&cfg.S3Endpoint, "s3-endpoint", "", "custom S3 endpoint such as http://localhost:9000 for MinIO, empty uses AWS (env EXAMPLEFS_S3_ENDPOINT)")
+	fs.StringVar(&cfg.RemoteURL, "remote-url", "", "examplefs URL with the backend prefix for -storage=remote, e.g. http://host:8080/memory (env EXAMPLEFS_REMOTE_URL)")
+	fs.StringVar(&cfg.RemoteToken, "remote-token", "", "bearer token for a -storage=remote server started with -auth-token (env EXAMPLEFS_REMOTE_TOKEN)")
+	fs.DurationVar(&cfg.StorageTimeout, "storage-timeout", 0, "deadline for a single storage call, 0 disables it")
+	fs.IntVar(&cfg.StorageRetries, "storage-retries", 0, "retry storage calls that fail because the backend is unavailable or -storage-timeout ran out this many times, 0 disables retries")
+	fs.DurationVar(&cfg.StorageRetryDelay, "storage-retry-delay", 100*time.Millisecond, "pause before the first -storage-retries retry, doubled after every attempt")
+	fs.IntVar(&cfg.BreakerFailures, "breaker-failures", 0, "after this many backend failures in a row answer 503 at once for -breaker-cooldown, then let one probe call through; 0 disables the circuit breaker")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 10*time.Second, "how long an open circuit breaker rejects calls before the probe")
+	fs.Float64Var(&cfg.StorageRetryJitter, "storage-retry-jitter", 0.2, "shorten every retry pause by a random share of up to this, from 0 to 1")
+	fs.IntVar(&cfg.MaxKeyBytes, "max-key-bytes", 1<<10, "largest key accepted, 0 disables the limit")
+	fs.StringVar(&cfg.KeyPattern, "key-pattern", "", "regexp every key must fully match, e.g. [A-Za-z0-9._/-]+; keys are case-sensitive")
+	fs.IntVar(&cfg.MaxValueBytes, "max-value-bytes", 1<<20, "largest value accepted, 0 disables the limit")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", 1<<20, "largest PUT or append request body read over HTTP while -max-value-bytes is 0")
+	fs.Int64Var(&cfg.MaxBatchBytes, "max-batch-bytes", 16<<20, "largest _batch or _txn request body read over HTTP")
+	fs.IntVar(&cfg.MemMaxEntries, "mem-max-entries", 0, "evict least recently used keys of -storage=mem above this many keys, 0 disables the limit")
+	fs.Int64Var(&cfg.MemMaxBytes, "mem-max-bytes", 0, "evict least recently used keys of -storage=mem above this many bytes of keys and values, 0 disables the limit")
+	fs.IntVar(&cfg.HistoryDepth, "history", 0, "keep this many previous values of every key of mem and file backends, served at /{key}/_history and /{key}?version=N; 0 disables history")
+	fs.DurationVar(&cfg.SoftDelete, "soft-delete", 0, "keep deleted keys of mem and file backends this long, restorable with POST /{key}/_undelete; 0 deletes at once")
+	fs.StringVar(&cfg.AuditFile, "audit-file", "", "append a JSON line per write and delete to this file, empty disables the audit log (env EXAMPLEFS_AUDIT_FILE)")
+	fs.Int64Var(&cfg.AuditMaxBytes, "audit-max-bytes", 100<<20, "rotate -audit-file once it grows past this size, 0 disables rotation")
+	fs.IntVar(&cfg.AuditKeep, "audit-keep", 5, "number of rotated audit files to keep as <audit-file>.1, .2 and so on")
+	fs.BoolVar(&cfg.AuditValues, "audit-values", false, "write values into -audit-file as is instead of their hash")
+	fs.DurationVar(&cfg.IdempotencyTTL, "idempotency-ttl", time.Hour, "how long a write with an Idempotency-Key header is remembered and replayed to retries, 0 disables it")
+	fs.IntVar(&cfg.IdempotencyMax, "idempotency-max", 10000, "how many Idempotency-Key responses to keep in memory, the oldest are forgotten first")
+	fs.Func("webhook", "URL that gets a JSON POST {op, backend, key, value, timestamp} after every write and delete; repeatable", func(v string) error {
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid webhook url %q: want http(s)://host[:port]/path", v)
+		}
+		cfg.Webhooks = append(cfg.Webhooks, v)
+		return nil
+	})
+	fs.IntVar(&cfg.WebhookRetries, "webhook-retries", 5, "how many times to retry a failed -webhook delivery before giving up")
+	fs.DurationVar(&cfg.WebhookBackoff, "webhook-backoff", 500*time.Millisecond, "pause before the first -webhook retry, doubled after every attempt up to 30s")
+	fs.BoolVar(&cfg.ReadOnly, "read-only", false, "reject all writes with 405 and open data files read-only, without locking; the data file must already exist. POST /admin/readonly switches the mode at runtime, but files opened read-only stay that way until restart")
+	fs.IntVar(&cfg.CacheSize, "cache-size", 0, "number of entries in the in-memory LRU cache in front of the backend, 0 disables it")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 10*time.Second, "how long a client may take to send request headers, 0 disables the limit")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", time.Minute, "how long a client may take to send the whole request including the body, 0 disables the limit")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", time.Minute, "how long a response may take from the end of the request headers, 0 disables the limit; /_watch streams are exempt")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "how long to keep an idle keep-alive connection open, 0 falls back to -read-timeout")
+	fs.TextVar(&cfg.LogLevel, "log-level", slog.LevelInfo, "log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "log format: text or json")
+	fs.BoolVar(&cfg.TraceHashKeys, "trace-hash-keys", false, "record a hash of the key instead of the key itself in storage spans; tracing is configured with OTEL_EXPORTER_OTLP_ENDPOINT")
+}
+
+// uses - бэкенд kind нужен основной хранилке, зеркалу или одному из -backend
+func (cfg Config) uses(kind string) bool {
+	if cfg.Storage == kind || cfg.MirrorStorage == kind {
+		return true
+	}
+	for _, b := range cfg.Backends {
+		if b.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRemote проверяет адреса remote-бэкендов: без схемы http.Client ругался бы только на первом запросе
+func (cfg Config) checkRemote() error {
+	urls := make([]string, 0)
+	if cfg.Storage == "remote" || cfg.MirrorStorage == "remote" {
+		if cfg.RemoteURL == "" {
+			return errors.New("remote storage requires -remote-url")
+		}
+		urls = append(urls, cfg.RemoteURL)
+	}
+	for _, b := range cfg.Backends {
+		if b.Kind == "remote" {
+			urls = append(urls, b.Path)
+		}
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid remote url %q: want http(s)://host[:port]/prefix", raw)
+		}
+	}
+	return nil
+}
+
+// checkBackend проверяет, что бэкенду хватает настроек. flagPrefix - "" для -storage и "mirror-" для зеркала
+func checkBackend(flagPrefix, backend, file, dir string) error {
+	switch backend {
+	case "mem", "redis", "etcd", "s3", "remote", "sharded":
+	case "file", "bolt", "sqlite":
+		if file == "" {
+			return fmt.Errorf("-%sstorage=%s requires -%sfile", flagPrefix, backend, flagPrefix)
+		}
+	case "dir", "pebble":
+		if dir == "" {
+			return fmt.Errorf("-%sstorage=%s requires -%sdir", flagPrefix, backend, flagPrefix)
+		}
+	default:
+		return fmt.Errorf("unknown -%sstorage %q: want mem, file, bolt, sqlite, redis, etcd, s3, remote, dir, pebble or sharded", flagPrefix, backend)
+	}
+	return nil
+}
+
+// validate ловит несовместимые настройки на старте, а не при первом запросе. отдает все найденные проблемы разом,
+// чтобы не чинить конфиг по одной строке за запуск
+func (cfg Config) validate() error {
+	var errs []error
+	if cfg.Addr == "" {
+		errs = append(errs, errors.New("-addr must not be empty"))
+	}
+	if cfg.Listen != "" && (!strings.HasPrefix(cfg.Listen, unixScheme) || cfg.Listen == unixScheme) {
+		errs = append(errs, fmt.Errorf("invalid -listen %q: want unix:///path/to/socket", cfg.Listen))
+	}
+	if _, err := parseSocketMode(cfg.SocketMode); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkBackend("", cfg.Storage, cfg.File, cfg.Dir); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Storage == "sharded" && len(cfg.Shards) == 0 {
+		errs = append(errs, errors.New("-storage=sharded requires -shards"))
+	}
+	if cfg.MirrorStorage == "sharded" {
+		errs = append(errs, errors.New("-mirror-storage can not be sharded"))
+	}
+	paths := make(map[string]bool)
+	for _, b := range cfg.Shards {
+		if b.Path != "" && paths[b.Path] {
+			errs = append(errs, fmt.Errorf("-shards: %s is used by two shards", b.Path))
+		}
+		paths[b.Path] = true
+	}
+	seen := map[string]bool{cfg.Storage: true}
+	for _, b := range cfg.Backends {
+		if seen[b.Name] {
+			errs = append(errs, fmt.Errorf("-backend %s: name is already taken", b.Name))
+		}
+		seen[b.Name] = true
+		if b.Path != "" && (b.Path == cfg.File || b.Path == cfg.Dir) {
+			errs = append(errs, fmt.Errorf("-backend %s must not point at the same data as -storage", b.Name))
+		}
+	}
+	if cfg.MirrorStorage != "" {
+		if err := checkBackend("mirror-", cfg.MirrorStorage, cfg.MirrorFile, cfg.MirrorDir); err != nil {
+			errs = append(errs, err)
+		}
+		// у redis, etcd, s3 и remote настройки общие, так что второй такой же бэкенд был бы теми же самыми данными
+		if cfg.MirrorStorage == cfg.Storage && (cfg.Storage == "redis" || cfg.Storage == "etcd" || cfg.Storage == "s3" || cfg.Storage == "remote") || cfg.MirrorFile != "" && cfg.MirrorFile == cfg.File || cfg.MirrorDir != "" && cfg.MirrorDir == cfg.Dir {
+			errs = append(errs, errors.New("-mirror-storage must not point at the same data as -storage"))
+		}
+	}
+	if cfg.uses("s3") && cfg.S3Bucket == "" {
+		errs = append(errs, errors.New("s3 storage requires -s3-bucket"))
+	}
+	if cfg.uses("etcd") && len(cfg.EtcdEndpoints) == 0 {
+		errs = append(errs, errors.New("etcd storage requires -etcd-endpoints"))
+	}
+	if cfg.uses("etcd") && cfg.EtcdDialTimeout <= 0 {
+		errs = append(errs, errors.New("-etcd-dial-timeout must be positive"))
+	}
+	if err := cfg.checkRemote(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := storage.CodecByName(cfg.Codec); err != nil {
+		errs = append(errs, fmt.Errorf("invalid -codec: %w", err))
+	}
+	if cfg.EncryptionKey != "" && cfg.EncryptionKeyFile != "" {
+		errs = append(errs, errors.New("set either EXAMPLEFS_ENCRYPTION_KEY or -encryption-key-file, not both"))
+	}
+	if cfg.CompactSize < 0 {
+		errs = append(errs, errors.New("-compact-size must not be negative"))
+	}
+	if cfg.CompactRatio < 0 || cfg.CompactRatio > 1 {
+		errs = append(errs, errors.New("-compact-ratio must be between 0 and 1"))
+	}
+	if cfg.FileWatch < 0 {
+		errs = append(errs, errors.New("-file-watch must not be negative"))
+	}
+	if _, err := storage.ConflictPolicyByName(cfg.FileConflict); err != nil {
+		errs = append(errs, fmt.Errorf("invalid -file-conflict: %w", err))
+	}
+	if cfg.VerifyOnStart != verifyOff && cfg.VerifyOnStart != verifyRefuse && cfg.VerifyOnStart != verifyReadOnly {
+		errs = append(errs, fmt.Errorf("invalid -verify-on-start %q: want %s, %s or %s", cfg.VerifyOnStart, verifyOff, verifyRefuse, verifyReadOnly))
+	}
+	if cfg.FlushInterval < 0 || cfg.FlushEvery < 0 {
+		errs = append(errs, errors.New("-flush-interval and -flush-every must not be negative"))
+	}
+	if cfg.Backups < 0 {
+		errs = append(errs, errors.New("-backups must not be negative"))
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("-tls-cert and -tls-key must be set together"))
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		errs = append(errs, errors.New("-tls-client-ca requires -tls-cert and -tls-key"))
+	}
+	if (cfg.AuthUser == "") != (cfg.AuthPass == "") {
+		errs = append(errs, errors.New("-auth-user and -auth-pass must be set together"))
+	}
+	if cfg.ProtectReads && !cfg.credentials().Enabled() {
+		errs = append(errs, errors.New("-protect-reads requires -auth-token or -auth-user"))
+	}
+	if cfg.CORSCredentials && slices.Contains(cfg.CORSOrigins, "*") {
+		errs = append(errs, errors.New("-cors-credentials can not be combined with -cors-origins=*: list the origins explicitly"))
+	}
+	if cfg.CORSCredentials && len(cfg.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("-cors-credentials requires -cors-origins"))
+	}
+	if len(cfg.CORSOrigins) > 0 && len(cfg.CORSMethods) == 0 {
+		errs = append(errs, errors.New("-cors-methods must not be empty"))
+	}
+	if cfg.CompressMinBytes < 0 {
+		errs = append(errs, errors.New("-compress-min-bytes must not be negative"))
+	}
+	if cfg.RateLimit < 0 {
+		errs = append(errs, errors.New("-rate-limit must not be negative"))
+	}
+	if cfg.RateLimit > 0 && cfg.RateBurst < 1 {
+		errs = append(errs, errors.New("-rate-burst must be at least 1"))
+	}
+	if cfg.StorageTimeout < 0 {
+		errs = append(errs, errors.New("-storage-timeout must not be negative"))
+	}
+	if cfg.StorageRetries < 0 || cfg.StorageRetryDelay < 0 {
+		errs = append(errs, errors.New("-storage-retries and -storage-retry-delay must not be negative"))
+	}
+	if cfg.BreakerFailures < 0 || cfg.BreakerCooldown < 0 {
+		errs = append(errs, errors.New("-breaker-failures and -breaker-cooldown must not be negative"))
+	}
+	if cfg.StorageRetryJitter < 0 || cfg.StorageRetryJitter > 1 {
+		errs = append(errs, errors.New("-storage-retry-jitter must be between 0 and 1"))
+	}
+	if cfg.MaxKeyBytes < 0 || cfg.MaxValueBytes < 0 {
+		errs = append(errs, errors.New("-max-key-bytes and -max-value-bytes must not be negative"))
+	}
+	if cfg.MaxBodyBytes <= 0 || cfg.MaxBatchBytes <= 0 {
+		errs = append(errs, errors.New("-max-body-bytes and -max-batch-bytes must be positive"))
+	}
+	if _, err := regexp.Compile(cfg.keyPattern()); err != nil {
+		errs = append(errs, fmt.Errorf("invalid -key-pattern: %w", err))
+	}
+	if cfg.IdempotencyTTL < 0 || cfg.IdempotencyTTL > 0 && cfg.IdempotencyMax <= 0 {
+		errs = append(errs, errors.New("-idempotency-ttl must not be negative and needs a positive -idempotency-max"))
+	}
+	hooks := make(map[string]bool)
+	for _, u := range cfg.Webhooks {
+		if hooks[u] {
+			errs = append(errs, fmt.Errorf("-webhook %s is given twice", u))
+		}
+		hooks[u] = true
+	}
+	if cfg.WebhookRetries < 0 || cfg.WebhookBackoff < 0 {
+		errs = append(errs, errors.New("-webhook-retries and -webhook-backoff must not be negative"))
+	}
+	if cfg.AuditMaxBytes < 0 || cfg.AuditKeep < 0 {
+		errs = append(errs, errors.New("-audit-max-bytes and -audit-keep must not be negative"))
+	}
+	if cfg.MemMaxEntries < 0 || cfg.MemMaxBytes < 0 {
+		errs = append(errs, errors.New("-mem-max-entries and -mem-max-bytes must not be negative"))
+	}
+	if cfg.HistoryDepth < 0 || cfg.SoftDelete < 0 {
+		errs = append(errs, errors.New("-history and -soft-delete must not be negative"))
+	}
+	if cfg.CacheSize < 0 {
+		errs = append(errs, errors.New("-cache-size must not be negative"))
+	}
+	if cfg.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("-shutdown-timeout must not be negative"))
+	}
+	if cfg.ReadHeaderTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 {
+		errs = append(errs, errors.New("-read-header-timeout, -read-timeout, -write-timeout and -idle-timeout must not be negative"))
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("unknown -log-format %q: want text or json", cfg.LogFormat))
+	}
+	return errors.Join(errs...)
+}
+
+// backendSpec - одна хранилка из -backend
+type backendSpec struct {
+	Name string
+	Kind string // как в -storage
+	Path string // файл для file, bolt и sqlite или директория для dir
+}
+
+func parseBackendSpec(v string) (b backendSpec, err error) {
+	name, rest, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return b, fmt.Errorf("invalid backend %q: want name=kind or name=kind:path", v)
+	}
+	b.Name = name
+	b.Kind, b.Path, _ = strings.Cut(rest, ":")
+	switch b.Kind {
+	case "mem", "redis":
+		if b.Path != "" {
+			return b, fmt.Errorf("invalid backend %q: %s takes no path", v, b.Kind)
+		}
+	case "sharded":
+		return b, fmt.Errorf("invalid backend %q: sharded is only supported as -storage", v)
+	case "s3": // путь необязателен и заменяет -s3-prefix, так несколько бэкендов делят один бакет
+	case "etcd": // так же путь заменяет -etcd-prefix
+	case "file", "bolt", "sqlite", "dir", "pebble", "remote":
+		if b.Path == "" {
+			return b, fmt.Errorf("invalid backend %q: %s needs a path, as %s=%s:/path", v, b.Kind, name, b.Kind)
+		}
+	default:
+		return b, fmt.Errorf("invalid backend %q: unknown kind %q, want mem, file, bolt, sqlite, redis, etcd, s3, remote, dir or pebble", v, b.Kind)
+	}
+	return b, nil
+}
+
+// parseShards разбирает -shards. у каждого шарда синтаксис -backend без имени
+func parseShards(v string) (shards []backendSpec, err error) {
+	for i, spec := range strings.Split(v, ",") {
+		b, err := parseBackendSpec(strconv.Itoa(i) + "=" + spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shard %d: %w", i, err)
+		}
+		shards = append(shards, b)
+	}
+	return shards, nil
+}
+
+// backendConfig - конфиг, из которого newStorage собирает хранилку из -backend
+func (cfg Config) backendConfig(b backendSpec) Config {
+	m := cfg
+	m.Storage, m.File, m.Dir = b.Kind, b.Path, b.Path
+	switch {
+	case b.Kind == "s3" && b.Path != "":
+		m.S3Prefix = b.Path
+	case b.Kind == "etcd" && b.Path != "":
+		m.EtcdPrefix = b.Path
+	case b.Kind == "remote":
+		m.RemoteURL = b.Path
+	}
+	return m
+}
+
+// openBackend собирает хранилку для /admin/backend. kind и path - как в -backend, и пара должна быть
+// в -switch-target: иначе любой с доступом к админке открыл бы на сервере файл по своему выбору
+func (cfg Config) openBackend(kind, path string) (storage.Storage, error) {
+	i := slices.IndexFunc(cfg.SwitchTargets, func(b backendSpec) bool { return b.Kind == kind && b.Path == path })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s:%s is not a -switch-target", httpapi.ErrBackendNotAllowed, kind, path)
+	}
+	return newStorage(cfg.backendConfig(cfg.SwitchTargets[i]))
+}
+
+// mirrorConfig - конфиг, из которого newStorage собирает зеркало
+func (cfg Config) mirrorConfig() Config {
+	m := cfg
+	m.Storage, m.File, m.Dir = cfg.MirrorStorage, cfg.MirrorFile, cfg.MirrorDir
+	return m
+}
+
+// newStorage создает только тот бэкенд, который выбран в конфиге
+func newStorage(cfg Config) (storage.Storage, error) {
+	switch cfg.Storage {
+	case "mem":
+		return storage.NewMemStorage(storage.WithMaxEntries(cfg.MemMaxEntries), storage.WithMaxBytes(cfg.MemMaxBytes), storage.WithMemHistory(cfg.HistoryDepth), storage.WithMemSoftDelete(cfg.SoftDelete)), nil
+	case "file":
+		opts, err := cfg.fileOptions()
+		if err == nil {
+			opts, err = cfg.verifyFile(opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewFileStorage(cfg.File, opts...)
+	case "bolt":
+		return storage.NewBoltStorage(cfg.File)
+	case "sqlite":
+		return storage.NewSQLStorage(cfg.File)
+	case "redis":
+		return storage.NewRedisStorage(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	case "etcd":
+		return storage.NewEtcdStorage(cfg.EtcdEndpoints, cfg.EtcdDialTimeout, cfg.EtcdPrefix)
+	case "s3":
+		return storage.NewS3Storage(cfg.S3Bucket, cfg.S3Prefix, cfg.S3Endpoint)
+	case "sharded":
+		return newShardedStorage(cfg)
+	case "remote":
+		client := &http.Client{Timeout: storage.DefaultHTTPTimeout}
+		if cfg.RemoteToken != "" {
+			client.Transport = bearerTransport{token: cfg.RemoteToken, next: http.DefaultTransport}
+		}
+		return storage.NewHTTPStorage(cfg.RemoteURL, client), nil
+	case "dir":
+		return storage.NewDirStorage(cfg.Dir)
+	case "pebble":
+		return storage.NewPebbleStorage(cfg.Dir, storage.WithPebbleSync(cfg.PebbleSync))
+	}
+	return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+}
+
+// fileOptions - настройки FileStorage из конфига, общие для основного файла и файлов бакетов
+func (cfg Config) fileOptions() ([]storage.FileOption, error) {
+	codec, _ := storage.CodecByName(cfg.Codec) // имя уже проверено в validate
+	opts := []storage.FileOption{storage.WithCodec(codec), storage.WithForce(cfg.Force), storage.WithBackups(cfg.Backups), storage.WithCompression(cfg.Gzip), storage.WithCompaction(cfg.CompactSize, cfg.CompactRatio),
+		storage.WithFlushInterval(cfg.FlushInterval), storage.WithFlushEvery(cfg.FlushEvery), storage.WithWAL(cfg.WAL), storage.WithReadOnly(cfg.ReadOnly),
+		storage.WithFileWatch(cfg.FileWatch, storage.ConflictPolicy(cfg.FileConflict)),
+		storage.WithHistory(cfg.HistoryDepth), storage.WithSoftDelete(cfg.SoftDelete)}
+
+	raw := cfg.EncryptionKey
+	if cfg.EncryptionKeyFile != "" {
+		b, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read encryption key: %w", err)
+		}
+		raw = string(b)
+	}
+	if raw != "" {
+		key, err := parseKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, storage.WithEncryption(key))
+	}
+	return opts, nil
+}
+
+// parseKey принимает ключ в hex или в base64
+func parseKey(raw string) ([]byte, error) {
+	raw = strings.TrimSpace(raw)
+	if key, err := hex.DecodeString(raw); err == nil {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(raw); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("encryption key must be hex or base64")
+}
+
+// tlsConfig собирает настройки TLS из конфига, nil - TLS выключен. сертификат грузим сразу,
+// чтобы битый или не тот ключ ронял старт, а не первый запрос
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load TLS certificate %s and key %s: %w", cfg.TLSCert, cfg.TLSKey, err)
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in TLS client CA %s", cfg.TLSClientCA)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+func (cfg Config) limits() storage.Limits {
+	limits := storage.Limits{MaxKeyBytes: cfg.MaxKeyBytes, MaxValueBytes: cfg.MaxValueBytes}
+	if cfg.KeyPattern != "" {
+		limits.KeyPattern = regexp.MustCompile(cfg.keyPattern()) // уже проверена в validate
+	}
+	return limits
+}
+
+// keyPattern привязывает -key-pattern к началу и концу, иначе [a-z]+ пропускал бы любой ключ с буквой
+func (cfg Config) keyPattern() string {
+	return `^(?:` + cfg.KeyPattern + `)$`
+}
+
+func (cfg Config) cors() httpapi.CORSOptions {
+	return httpapi.CORSOptions{Origins: cfg.CORSOrigins, Methods: cfg.CORSMethods, Credentials: cfg.CORSCredentials}
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы
+func splitList(v string) (items []string) {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (cfg Config) credentials() auth.Credentials {
+	return auth.Credentials{Token: cfg.AuthToken, User: cfg.AuthUser, Pass: cfg.AuthPass}
+}
+
+// routePrefix - под каким путем отдаем бэкенд: память живет на /memory, все постоянные хранилки - на /file
+func (cfg Config) routePrefix() string {
+	if cfg.Storage == "mem" {
+		return "/memory"
+	}
+	return "/file"
+}
+
+// newBuckets решает, где живут бакеты /kv: у file каждый бакет - отдельный файл рядом с основным,
+// остальные бэкенды держат бакеты в той же хранилке s под префиксом "<bucket>/"
+func newBuckets(cfg Config, s storage.Storage, w wrappers) (*storage.BucketedStorage, error) {
+	if cfg.Storage == "file" {
+		opts, err := cfg.fileOptions()
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewBucketedStorage(storage.FileBuckets{Dir: cfg.File + ".buckets", Opts: opts, Limits: w.limits,
+			Audit: w.audit, AuditRawValues: cfg.AuditValues, Webhooks: w.webhooks, ReadOnly: w.readOnly}), nil
+	}
+	return storage.NewBucketedStorage(storage.PrefixBuckets{Storage: s}), nil
+}
+
+// bearerTransport добавляет токен к запросам в remote-бэкенд, запущенный с -auth-token
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
+
+// newShardedStorage открывает шарды из -shards. если какой-то не открылся, закрываем уже открытые
+func newShardedStorage(cfg Config) (storage.Storage, error) {
+	shards := make([]storage.Storage, 0, len(cfg.Shards))
+	closeAll := func() {
+		for _, s := range shards {
+			if c, ok := storage.As[io.Closer](s); ok {
+				c.Close()
+			}
+		}
+	}
+	for _, b := range cfg.Shards {
+		s, err := newStorage(cfg.backendConfig(b))
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("unable to create %s storage for shard %s: %w", b.Kind, b.Name, err)
+		}
+		shards = append(shards, s)
+	}
+	s, err := storage.NewShardedStorage(shards...)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+func TestParseBackendSpec(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    backendSpec
+		wantErr string
+	}{
+		{in: "cache=mem", want: backendSpec{Name: "cache", Kind: "mem"}},
+		{in: "old=file:/data/old.json", want: backendSpec{Name: "old", Kind: "file", Path: "/data/old.json"}},
+		{in: "bucket=s3", want: backendSpec{Name: "bucket", Kind: "s3"}},
+		{in: "api=remote:http://host:8080/memory", want: backendSpec{Name: "api", Kind: "remote", Path: "http://host:8080/memory"}},
+		{in: "=mem", wantErr: "want name=kind"},
+		{in: "mem", wantErr: "want name=kind"},
+		{in: "x=mem:/path", wantErr: "takes no path"},
+		{in: "x=file", wantErr: "needs a path"},
+		{in: "x=sharded", wantErr: "only supported as -storage"},
+		{in: "x=floppy:/a", wantErr: "unknown kind"},
+	} {
+		b, err := parseBackendSpec(tt.in)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error about %q", tt.in, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.in, err)
+		case b != tt.want:
+			t.Errorf("%s: got %+v, want %+v", tt.in, b, tt.want)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig(nil)
+	if err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	if cfg.Storage != "mem" || cfg.Addr != ":8080" || cfg.routePrefix() != "/memory" {
+		t.Errorf("defaults: storage %q, addr %q, prefix %q", cfg.Storage, cfg.Addr, cfg.routePrefix())
+	}
+
+	file := filepath.Join(t.TempDir(), "data.json")
+	if cfg, err = parseConfig([]string{"-storage", "file", "-file", file, "-backend", "cache=mem"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.routePrefix() != "/file" || len(cfg.Backends) != 1 || cfg.Backends[0].Name != "cache" {
+		t.Errorf("got prefix %q and backends %+v", cfg.routePrefix(), cfg.Backends)
+	}
+
+	for name, args := range map[string][]string{
+		"empty addr":        {"-addr", ""},
+		"file without path": {"-storage", "file"},
+		"sharded no shards": {"-storage", "sharded"},
+		"taken name":        {"-backend", "mem=mem"},
+		"bad listen":        {"-listen", "/tmp/sock"},
+		"bad socket mode":   {"-listen", "unix:///tmp/sock", "-socket-mode", "rw"},
+		"zero body limit":   {"-max-body-bytes", "0"},
+		"zero batch limit":  {"-max-batch-bytes", "0"},
+		"unknown flag":      {"-no-such-flag"},
+	} {
+		if _, err := parseConfig(args); err == nil {
+			t.Errorf("%s: %v is accepted", name, args)
+		}
+	}
+}
+
+// флаги перекрывают файл -config, а его ключи называются так же, как флаги
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("addr: :9090\nrate-limit: 5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig([]string{"-config", path, "-rate-limit", "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9090" || cfg.RateLimit != 7 {
+		t.Errorf("addr %q, rate limit %v, want :9090 from the file and 7 from the flag", cfg.Addr, cfg.RateLimit)
+	}
+
+	// переменная окружения перекрывает файл, но не флаг
+	t.Setenv("EXAMPLEFS_ADDR", ":9191")
+	t.Setenv("EXAMPLEFS_RATE_LIMIT", "9")
+	if cfg, err = parseConfig([]string{"-config", path, "-rate-limit", "7"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9191" || cfg.RateLimit != 7 {
+		t.Errorf("addr %q, rate limit %v, want :9191 from the environment and 7 from the flag", cfg.Addr, cfg.RateLimit)
+	}
+}
+
+// /admin/backend открывает только то, что перечислено в -switch-target
+func TestOpenBackendAllowlist(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "next.json")
+	cfg, err := parseConfig([]string{"-switch-target", "file:" + allowed, "-switch-target", "mem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := cfg.openBackend("file", allowed)
+	if err != nil {
+		t.Fatalf("allowed target: %v", err)
+	}
+	if c, ok := storage.As[io.Closer](s); ok {
+		c.Close()
+	}
+	if _, err = cfg.openBackend("mem", ""); err != nil {
+		t.Errorf("allowed mem target: %v", err)
+	}
+	for _, target := range [][2]string{{"file", filepath.Join(dir, "other.json")}, {"bolt", allowed}, {"file", "/etc/passwd"}} {
+		if _, err := cfg.openBackend(target[0], target[1]); !errors.Is(err, httpapi.ErrBackendNotAllowed) {
+			t.Errorf("%s:%s: got %v, want ErrBackendNotAllowed", target[0], target[1], err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rawFlags - значения флагов одного слоя строками, как их принял бы fs.Set. у повторяемых флагов значений несколько
+type rawFlags map[string][]string
+
+// recordedFlag только запоминает значения из командной строки, а разбирает их потом настоящий флаг
+type recordedFlag struct {
+	name   string
+	values rawFlags
+	isBool bool
+}
+
+func (f recordedFlag) String() string { return "" }
+
+func (f recordedFlag) Set(v string) error {
+	f.values[f.name] = append(f.values[f.name], v)
+	return nil
+}
+
+func (f recordedFlag) IsBoolFlag() bool { return f.isBool }
+
+// envName - переменная окружения флага: -grpc-addr читается из EXAMPLEFS_GRPC_ADDR
+func envName(flagName string) string {
+	return "EXAMPLEFS_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyLayers выставляет флаги fs из файла -config, окружения и args по возрастанию приоритета. слой
+// перекрывает флаг целиком: -webhook из командной строки заменяет список из файла, а не дописывается к нему.
+// ошибки всех значений собираются вместе, чтобы за один запуск было видно все, что не так.
+// возвращает, чем в итоге оказался каждый флаг, включая оставленные по умолчанию: по ним reload видит, что поменялось
+func applyLayers(fs *flag.FlagSet, args []string) (rawFlags, error) {
+	// сначала только запоминаем аргументы: путь к файлу лежит среди них, а применять их надо последними
+	cmdline := make(rawFlags)
+	rec := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	rec.SetOutput(fs.Output())
+	rec.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		b, _ := f.Value.(interface{ IsBoolFlag() bool })
+		rec.Var(recordedFlag{name: f.Name, values: cmdline, isBool: b != nil && b.IsBoolFlag()}, f.Name, f.Usage)
+	})
+	if err := rec.Parse(args); err != nil {
+		return nil, err
+	}
+	if rec.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", rec.Arg(0))
+	}
+
+	env := make(rawFlags)
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			env[f.Name] = []string{v}
+		}
+	})
+
+	var errs []error
+	layers := []configLayer{{"environment", env}, {"flag", cmdline}}
+	path := env["config"]
+	if v := cmdline["config"]; len(v) > 0 {
+		path = v
+	}
+	if len(path) > 0 && path[len(path)-1] != "" {
+		file, err := readConfigFile(fs, path[len(path)-1])
+		if err != nil {
+			errs = append(errs, err)
+		}
+		layers = append([]configLayer{{"config file", file}}, layers...)
+	}
+
+	effective := make(rawFlags)
+	fs.VisitAll(func(f *flag.Flag) {
+		effective[f.Name] = []string{f.DefValue}
+	})
+	for i, layer := range layers {
+		fs.VisitAll(func(f *flag.Flag) {
+			values, ok := layer.values[f.Name]
+			if !ok || overridden(layers[i+1:], f.Name) {
+				return
+			}
+			effective[f.Name] = values
+			for _, v := range values {
+				if err := fs.Set(f.Name, v); err != nil {
+					errs = append(errs, fmt.Errorf("%s: invalid value %q for %s: %w", layer.name, v, f.Name, err))
+				}
+			}
+		})
+	}
+	return effective, errors.Join(errs...)
+}
+
+type configLayer struct {
+	name   string // откуда значения, для ошибок
+	values rawFlags
+}
+
+// overridden - флаг задан в одном из слоев выше
+func overridden(higher []configLayer, name string) bool {
+	for _, l := range higher {
+		if _, ok := l.values[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readConfigFile читает YAML с настройками: ключи - имена флагов, значения - то, что передали бы флагу,
+// списки - для повторяемых флагов вроде webhook и backend
+func readConfigFile(fs *flag.FlagSet, path string) (rawFlags, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+
+	raw := make(rawFlags, len(doc))
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(doc)) {
+		v := doc[name]
+		if fs.Lookup(name) == nil || name == "config" {
+			errs = append(errs, fmt.Errorf("config file %s: unknown setting %q", path, name))
+			continue
+		}
+		items, ok := v.([]any)
+		if !ok {
+			items = []any{v}
+		}
+		for _, item := range items {
+			s, err := scalarString(item)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("config file %s: %s: %w", path, name, err))
+				continue
+			}
+			raw[name] = append(raw[name], s)
+		}
+	}
+	return raw, errors.Join(errs...)
+}
+
+// scalarString переводит значение из YAML в строку для flag.Value.Set
+func scalarString(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("want a string, number, bool or a list of them, got %T", v)
+}
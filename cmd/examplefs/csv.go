@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// runExportCSV пишет файл бэкенда file в CSV без сервера: examplefs export-csv [flags] <file>. возвращает код выхода.
+// файл открывается только на чтение, так что выгрузить можно и файл работающего сервера
+func runExportCSV(args []string, stdout, stderr io.Writer) int {
+	fs, cfg := csvFlags("export-csv", "<file>", stderr)
+	out := fs.String("o", "", "write the CSV to this file instead of stdout")
+	header := fs.Bool("header", true, "start with a key,value header row")
+	if err := fs.Parse(args); err != nil {
+		return exitFailed
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitFailed
+	}
+	cfg.File, cfg.ReadOnly = fs.Arg(0), true
+
+	s, err := cfg.openCSVFile()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+	defer s.Close()
+
+	w := stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to create %s: %v\n", *out, err)
+			return exitFailed
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	rows, err := storage.ExportCSV(context.Background(), s, bw, *header)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to export %s: %v\n", cfg.File, err)
+		return exitFailed
+	}
+	if *out != "" {
+		fmt.Fprintf(stdout, "exported %d keys to %s\n", rows, *out)
+	}
+	return 0
+}
+
+// runImportCSV загружает CSV в файл бэкенда без сервера: examplefs import-csv [flags] <file> [csv].
+// без csv читает stdin. файл блокируется, как сервером, так что на файле работающего сервера не сработает
+func runImportCSV(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs, cfg := csvFlags("import-csv", "<file> [csv]", stderr)
+	mode := fs.String("mode", "merge", "merge: set the rows on top of the data, replace: the rows become all of the data")
+	header := fs.Bool("header", true, "the first row is a header and is skipped")
+	fs.StringVar(&cfg.Codec, "codec", "json", "format the server writes the file in: json, gob or msgpack; a file in another one is rewritten")
+	fs.BoolVar(&cfg.Gzip, "gzip", false, "the server runs with -gzip; otherwise a compressed file is rewritten uncompressed")
+	if err := fs.Parse(args); err != nil {
+		return exitFailed
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return exitFailed
+	}
+	if *mode != "merge" && *mode != "replace" {
+		fmt.Fprintf(stderr, "unknown -mode %q: want merge or replace\n", *mode)
+		return exitFailed
+	}
+	if _, err := storage.CodecByName(cfg.Codec); err != nil {
+		fmt.Fprintf(stderr, "invalid -codec: %v\n", err)
+		return exitFailed
+	}
+	cfg.File = fs.Arg(0)
+
+	in := stdin
+	if fs.NArg() == 2 {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to open %s: %v\n", fs.Arg(1), err)
+			return exitFailed
+		}
+		defer f.Close()
+		in = f
+	}
+
+	s, err := cfg.openCSVFile()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+	report, err := storage.ImportCSV(context.Background(), s, in, *header, *mode == "replace")
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to import into %s: %v\n", cfg.File, err)
+		return exitFailed
+	}
+
+	fmt.Fprintf(stdout, "rows:     %d\n", report.Rows)
+	fmt.Fprintf(stdout, "imported: %d\n", report.Imported)
+	fmt.Fprintf(stdout, "skipped:  %d\n", len(report.Skipped))
+	for _, sk := range report.Skipped {
+		fmt.Fprintf(stdout, "  line %d: %s\n", sk.Line, sk.Reason)
+	}
+	return 0
+}
+
+// csvFlags - флаги файла, общие у export-csv и import-csv: то же, что у fsck
+func csvFlags(name, operands string, stderr io.Writer) (*flag.FlagSet, *Config) {
+	fs := flag.NewFlagSet("examplefs "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: examplefs %s [flags] %s\n", name, operands)
+		fs.PrintDefaults()
+	}
+	cfg := &Config{Codec: storage.JSONCodec.Name()}
+	cfg.EncryptionKey = os.Getenv("EXAMPLEFS_ENCRYPTION_KEY")
+	fs.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", envOr("EXAMPLEFS_ENCRYPTION_KEY_FILE", ""), "file with the AES key of an encrypted data file (env EXAMPLEFS_ENCRYPTION_KEY_FILE, or the key itself in EXAMPLEFS_ENCRYPTION_KEY)")
+	fs.IntVar(&cfg.HistoryDepth, "history", 0, "history depth the server runs with")
+	fs.DurationVar(&cfg.SoftDelete, "soft-delete", 0, "soft delete period the server runs with")
+	return fs, cfg
+}
+
+// csvFile - открытый бэкенд file, который подкоманда должна закрыть сама: сервера, который закрыл бы его, нет
+type csvFile interface {
+	storage.Storage
+	io.Closer
+}
+
+// openCSVFile открывает cfg.File как бэкенд file с настройками из флагов
+func (cfg Config) openCSVFile() (csvFile, error) {
+	opts, err := cfg.fileOptions()
+	if err != nil {
+		return nil, err
+	}
+	s, err := storage.NewFileStorage(cfg.File, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.(csvFile), nil
+}
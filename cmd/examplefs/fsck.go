@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// значения -verify-on-start
+const (
+	verifyOff      = "off"
+	verifyRefuse   = "refuse"
+	verifyReadOnly = "read-only"
+)
+
+// exitUnhealthy - fsck нашел в файле повреждения или невалидные записи и не чинил их
+const exitUnhealthy = 1
+
+// runFsck проверяет файл file бэкенда без сервера: examplefs fsck [flags] <file>. возвращает код выхода
+func runFsck(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("examplefs fsck", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: examplefs fsck [flags] <file>")
+		fs.PrintDefaults()
+	}
+	repair := fs.Bool("repair", false, "write a copy without the damaged tail and invalid records in place of the file and move the original to <file>.corrupt-<time>")
+	var cfg Config
+	cfg.EncryptionKey = os.Getenv("EXAMPLEFS_ENCRYPTION_KEY")
+	fs.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", envOr("EXAMPLEFS_ENCRYPTION_KEY_FILE", ""), "file with the AES key of an encrypted data file (env EXAMPLEFS_ENCRYPTION_KEY_FILE, or the key itself in EXAMPLEFS_ENCRYPTION_KEY)")
+	fs.IntVar(&cfg.HistoryDepth, "history", 0, "history depth the server runs with: -repair keeps this many previous values of every key")
+	fs.DurationVar(&cfg.SoftDelete, "soft-delete", 0, "soft delete period the server runs with: -repair keeps tombstones only with it")
+	if err := fs.Parse(args); err != nil {
+		return exitFailed
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitFailed
+	}
+	file := fs.Arg(0)
+
+	// кодек записан в заголовке файла, а сжатие и шифрование тоже видны по самому файлу
+	cfg.Codec = storage.JSONCodec.Name()
+	opts, err := cfg.fileOptions()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+	var report storage.FileReport
+	var aside string
+	if *repair {
+		report, aside, err = storage.RepairFile(file, opts...)
+	} else {
+		report, err = storage.CheckFile(file, opts...)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	printReport(stdout, file, report)
+	switch {
+	case aside != "":
+		fmt.Fprintf(stdout, "repaired: %d keys written to %s, the original is %s\n", report.Keys, file, aside)
+	case report.Healthy():
+	default:
+		return exitUnhealthy
+	}
+	return 0
+}
+
+func printReport(w io.Writer, file string, r storage.FileReport) {
+	format := r.Format
+	var layers []string
+	if r.Compressed {
+		layers = append(layers, "gzip")
+	}
+	if r.Encrypted {
+		layers = append(layers, "encrypted")
+	}
+	if len(layers) > 0 {
+		format += " (" + strings.Join(layers, ", ") + ")"
+	}
+	checksums := "none, the format has no checksums"
+	switch {
+	case r.Checksummed && r.Damage == "":
+		checksums = "ok"
+	case r.Checksummed:
+		checksums = "checked up to the damage"
+	}
+
+	fmt.Fprintf(w, "file:        %s\n", file)
+	fmt.Fprintf(w, "format:      %s\n", format)
+	fmt.Fprintf(w, "checksums:   %s\n", checksums)
+	fmt.Fprintf(w, "records:     %d\n", r.Records)
+	fmt.Fprintf(w, "overwritten: %d\n", r.Overwritten)
+	if r.WALRecords > 0 {
+		fmt.Fprintf(w, "wal records: %d\n", r.WALRecords)
+	}
+	fmt.Fprintf(w, "keys:        %d\n", r.Keys)
+	fmt.Fprintf(w, "invalid:     %d\n", len(r.Invalid))
+	for _, s := range r.Invalid {
+		fmt.Fprintf(w, "  %s\n", s)
+	}
+	if r.Damage != "" {
+		fmt.Fprintf(w, "damage:      %s\n", r.Damage)
+	}
+	status := "ok"
+	if !r.Healthy() {
+		status = "damaged"
+	}
+	fmt.Fprintf(w, "status:      %s\n", status)
+}
+
+// verifyFile - проверка -verify-on-start: на битом файле сервер либо не стартует, либо открывает то,
+// что читается, только на чтение. в opts без изменений возвращается, если файл целый или его еще нет
+func (cfg Config) verifyFile(opts []storage.FileOption) ([]storage.FileOption, error) {
+	if cfg.VerifyOnStart == verifyOff {
+		return opts, nil
+	}
+	if _, err := os.Stat(cfg.File); errors.Is(err, os.ErrNotExist) {
+		return opts, nil
+	}
+	start := time.Now()
+	report, err := storage.CheckFile(cfg.File, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify %s: %w", cfg.File, err)
+	}
+	if report.Healthy() {
+		slog.Info("data file verified", "file", cfg.File, "keys", report.Keys, "took", time.Since(start))
+		return opts, nil
+	}
+	problem := report.Damage
+	if problem == "" {
+		problem = fmt.Sprintf("%d invalid records, the first is %s", len(report.Invalid), report.Invalid[0])
+	}
+	if cfg.VerifyOnStart == verifyRefuse {
+		return nil, fmt.Errorf("%s failed verification: %s; inspect it with examplefs fsck or fix it with examplefs fsck -repair", cfg.File, problem)
+	}
+	slog.Warn("data file failed verification, serving it read-only", "file", cfg.File, "problem", problem)
+	return append(opts, storage.WithReadOnly(true), storage.WithForce(true)), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unixScheme = "unix://"
+
+// первый дескриптор, который systemd передает при socket activation: 0, 1 и 2 - stdin, stdout и stderr
+const listenFdsStart = 3
+
+// httpListeners открывает все, на чем слушается HTTP. сокеты от systemd заменяют -addr, а -listen слушается
+// в дополнение к ним. при ошибке уже открытые закрываются
+func (cfg Config) httpListeners() (listeners []net.Listener, err error) {
+	defer func() {
+		if err != nil {
+			for _, lis := range listeners {
+				lis.Close()
+			}
+		}
+	}()
+	if listeners, err = systemdListeners(); err != nil {
+		return listeners, err
+	}
+	if len(listeners) > 0 {
+		slog.Info("serving http on sockets passed by systemd", "count", len(listeners))
+	} else {
+		lis, err := net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			return listeners, fmt.Errorf("unable to listen on %s: %w", cfg.Addr, err)
+		}
+		listeners = append(listeners, lis)
+	}
+	if cfg.Listen != "" {
+		mode, _ := parseSocketMode(cfg.SocketMode) // проверен в validate
+		lis, err := listenUnix(strings.TrimPrefix(cfg.Listen, unixScheme), mode)
+		if err != nil {
+			return listeners, err
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
+
+// systemdListeners забирает сокеты, переданные через LISTEN_FDS, как sd_listen_fds. LISTEN_PID должен совпадать
+// с нашим pid: иначе переменные достались по наследству от родителя, и дескрипторы не наши
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	// дочерним процессам они уже не предназначены
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		lis, err := net.FileListener(f) // дублирует дескриптор, так что f закрываем в любом случае
+		f.Close()
+		if err != nil {
+			for _, lis := range listeners {
+				lis.Close()
+			}
+			return nil, fmt.Errorf("unable to use socket %d passed by systemd: %w", fd, err)
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
+
+// listenUnix слушает unix сокет path с правами mode. файл сокета, оставшийся от упавшего процесса, удаляется,
+// а живой - нет: два сервера на одном сокете принимали бы соединения вперемешку.
+// файл удаляется, когда слушатель закрывают, то есть и при graceful shutdown
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("unable to listen on %s: file exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to listen on %s: socket is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("unable to remove stale socket %s: %w", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unable to listen on %s: %w", path, err)
+	}
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("unable to set permissions of socket %s: %w", path, err)
+	}
+	return lis, nil
+}
+
+// parseSocketMode разбирает права вида 0660, как у chmod
+func parseSocketMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid -socket-mode %q: want octal permissions like 0660", s)
+	}
+	return fs.FileMode(mode), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	// путь unix сокета ограничен сотней с небольшим байт, а t.TempDir бывает длинным
+	dir, err := os.MkdirTemp("", "efs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "s.sock")
+
+	lis, err := listenUnix(path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v, want 0600", fi.Mode().Perm(), err)
+	}
+	go func(lis net.Listener) {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}(lis)
+	if _, err = listenUnix(path, 0o600); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("second listener on a live socket: got %v, want an in use error", err)
+	}
+	lis.Close()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file is left after Close: %v", err)
+	}
+
+	// сокет от упавшего процесса: файл есть, а слушать некому
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if lis, err = listenUnix(path, 0o660); err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	lis.Close()
+
+	regular := filepath.Join(dir, "file")
+	if err = os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = listenUnix(regular, 0o600); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("regular file: got %v, want a not a socket error", err)
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want os.FileMode
+		ok   bool
+	}{
+		{"0660", 0o660, true},
+		{"600", 0o600, true},
+		{"0777", 0o777, true},
+		{"1777", 0, false},
+		{"0968", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parseSocketMode(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,527 @@
+// examplefs - сервер ключ-значение с HTTP и gRPC API, а с подкомандой get/set/del/dump - клиент к нему, с fsck - проверка файла данных
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/grpcapi"
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/respapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// server - собранный, но еще не запущенный сервер. отдельно от run, чтобы его можно было проверить без реального порта
+type server struct {
+	http            *http.Server
+	router          *mux.Router  // то, что внутри http.Handler под middleware, по нему видно расхождения с документом
+	grpc            *grpc.Server // nil, если -grpc-addr не задан
+	grpcAddr        string
+	resp            *respapi.Server // nil, если -resp-addr не задан
+	respAddr        string
+	storage         storage.Storage
+	backends        *storage.StorageRegistry // все хранилки, включая основную storage
+	buckets         *storage.BucketedStorage
+	audit           *storage.AuditLog // nil, если -audit-file не задан
+	webhooks        *storage.Webhooks // nil, если нет ни одного -webhook
+	live            *liveConfig
+	draining        atomic.Bool
+	websockets      sync.WaitGroup // открытые /ws, их Shutdown не ждет
+	shutdownTimeout time.Duration
+}
+
+func newServer(cfg Config) (_ *server, err error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	s, err := newStorage(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s storage: %w", cfg.Storage, err)
+	}
+	if cfg.MirrorStorage != "" {
+		secondary, err := newStorage(cfg.mirrorConfig())
+		if err != nil {
+			if c, ok := storage.As[io.Closer](s); ok {
+				c.Close()
+			}
+			return nil, fmt.Errorf("unable to create %s mirror storage: %w", cfg.MirrorStorage, err)
+		}
+		s = storage.NewMirrorStorage(s, secondary, storage.WithStrictMirror(cfg.MirrorStrict), storage.WithReadFallback(cfg.MirrorFallback))
+	}
+
+	// журнал аудита общий для всех хранилок, закрывается последним, когда они уже закрыты
+	var audit *storage.AuditLog
+	if cfg.AuditFile != "" {
+		if audit, err = storage.NewAuditLog(cfg.AuditFile, cfg.AuditMaxBytes, cfg.AuditKeep); err != nil {
+			if c, ok := storage.As[io.Closer](s); ok {
+				c.Close()
+			}
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				audit.Close()
+			}
+		}()
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	w := wrappers{reg: reg, tracing: tracingEnabled(), audit: audit, readOnly: new(atomic.Bool)}
+	w.readOnly.Store(cfg.ReadOnly)
+	if limits := cfg.limits(); limits.Enabled() {
+		w.limits = new(atomic.Pointer[storage.Limits])
+		w.limits.Store(&limits)
+	}
+	if len(cfg.Webhooks) > 0 {
+		w.webhooks = storage.NewWebhooks(cfg.Webhooks, storage.WithWebhookRetries(cfg.WebhookRetries, cfg.WebhookBackoff))
+		reg.MustRegister(newWebhookCollector(w.webhooks))
+		defer func() {
+			if err != nil {
+				w.webhooks.Shutdown(context.Background())
+			}
+		}()
+	}
+	// под всеми обертками, чтобы /admin/backend подменял только сам бэкенд, а кэш, лимиты и метрики оставались
+	switchable := storage.NewSwitchableStorage(s)
+	if s, err = decorate(cfg, cfg.Storage, switchable, w); err != nil {
+		return nil, err
+	}
+
+	// реестр владеет всеми хранилками и закрывает их при остановке, основная в нем под именем из -storage
+	backends := storage.NewStorageRegistry()
+	if err = backends.Register(cfg.Storage, s); err != nil {
+		return nil, err
+	}
+	for _, b := range cfg.Backends {
+		if err = registerBackend(cfg, b, backends, w); err != nil {
+			backends.Close()
+			return nil, err
+		}
+	}
+
+	buckets, err := newBuckets(cfg, s, w)
+	if err != nil {
+		backends.Close()
+		return nil, err
+	}
+	// лимит запросов и учетные данные проверяются всегда, даже пустые: так reload может включить их на ходу
+	live := &liveConfig{cfg: cfg, rateLimiter: httpapi.NewRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.TrustProxy),
+		creds: new(atomic.Pointer[auth.Credentials]), limits: w.limits, webhooks: w.webhooks}
+	creds := cfg.credentials()
+	live.creds.Store(&creds)
+	srv := &server{storage: s, backends: backends, buckets: buckets, audit: audit, webhooks: w.webhooks, live: live, shutdownTimeout: cfg.ShutdownTimeout}
+	stopWatch := make(chan struct{})
+	// /file и /memory остались как есть, /storage/{backend} - то же самое для любой хранилки из реестра
+	bodyLimits := []httpapi.RouterOption{httpapi.WithMaxBodyBytes(cfg.MaxBodyBytes), httpapi.WithMaxBatchBytes(cfg.MaxBatchBytes)}
+	r := httpapi.NewRouter(cfg.routePrefix(), s, stopWatch, bodyLimits...)
+	srv.router = r
+	httpapi.HandleRegistry(r, backends, stopWatch, bodyLimits...)
+	httpapi.HandleV1(r, backends, stopWatch, bodyLimits...)
+	httpapi.HandleBuckets(r, srv.buckets, bodyLimits...)
+	r.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{})).Methods(http.MethodGet)
+	// админка только с учетными данными: без них в конфиге она закрыта, даже для GET
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(httpapi.RequireAdmin(live.creds))
+	admin.HandleFunc("/backup", httpapi.BackupHandler(s)).Methods(http.MethodPost)
+	admin.HandleFunc("/reload", httpapi.ReloadHandler(s)).Methods(http.MethodPost)
+	admin.HandleFunc("/config/reload", httpapi.ConfigReloadHandler(live.reload)).Methods(http.MethodPost)
+	admin.HandleFunc("/compact", httpapi.CompactHandler(s)).Methods(http.MethodPost)
+	admin.HandleFunc("/mirror/diff", httpapi.MirrorDiffHandler(s)).Methods(http.MethodGet)
+	admin.HandleFunc("/rebalance", httpapi.RebalanceHandler(s)).Methods(http.MethodPost)
+	admin.HandleFunc("/backend", httpapi.SwitchBackendHandler(switchable, s, cfg.openBackend)).Methods(http.MethodPost)
+	admin.HandleFunc("/readonly", httpapi.ReadOnlyHandler(w.readOnly)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/ws", httpapi.WebSocketHandler(s, live.creds, stopWatch, &srv.websockets)).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", httpapi.HealthzHandler).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", httpapi.ReadyzHandler(cfg.Storage, s)).Methods(http.MethodGet)
+	r.HandleFunc("/openapi.json", httpapi.OpenAPIHandler(cfg.routePrefix())).Methods(http.MethodGet)
+	if cfg.Docs {
+		r.HandleFunc("/docs", httpapi.DocsHandler).Methods(http.MethodGet)
+	}
+	if cfg.UI {
+		httpapi.HandleUI(r, backends, w.readOnly)
+	}
+	// документ пишется руками, так что забытый в нем маршрут видно сразу при старте
+	for _, d := range httpapi.SpecDrift(r, cfg.routePrefix()) {
+		slog.Warn("openapi document is out of date", "route", d)
+	}
+	if w.tracing {
+		// первым, чтобы span запроса покрывал все остальные middleware, а его контекст доходил до хранилки
+		r.Use(otelmux.Middleware(serviceName))
+	}
+	r.Use(httpapi.LogRequests(slog.Default()), httpapi.Metrics(reg))
+	if cfg.CompressMinBytes > 0 {
+		// внутри логов и метрик, чтобы они видели размер на проводе, и снаружи идемпотентности, чтобы та помнила несжатый ответ
+		r.Use(httpapi.Compress(cfg.CompressMinBytes))
+	}
+	r.Use(httpapi.IdentifyClients(cfg.TrustProxy))
+	r.Use(live.rateLimiter.Middleware("/healthz", "/readyz"))
+	// пробы kubernetes не должны проходить через авторизацию
+	r.Use(httpapi.RequireAuth(live.creds, cfg.ProtectReads, "/healthz", "/readyz"))
+	r.Use(httpapi.RejectWritesWhileDraining(&srv.draining))
+	if cfg.IdempotencyTTL > 0 {
+		// после авторизации, чтобы чужой запрос без пароля не занял ключ
+		r.Use(httpapi.NewIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyMax).Middleware())
+	}
+	// id запроса снаружи роутера, чтобы он был и у ответов самого роутера, и у preflight
+	handler := httpapi.RequestID()(r)
+	if cors := cfg.cors(); cors.Enabled() {
+		handler = httpapi.CORS(cors)(handler)
+	}
+	srv.http = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	srv.http.RegisterOnShutdown(func() { close(stopWatch) })
+	if cfg.GRPC != "" {
+		srv.grpc, srv.grpcAddr = grpcapi.NewServer(s, live.creds, cfg.ProtectReads, grpcOptions(tlsConfig)...), cfg.GRPC
+	}
+	if cfg.RESP != "" {
+		srv.resp, srv.respAddr = respapi.NewServer(s, live.creds, cfg.ProtectReads), cfg.RESP
+	}
+	return srv, nil
+}
+
+// wrappers - то общее для всех хранилок, что decorate навешивает на каждую из них
+type wrappers struct {
+	reg      prometheus.Registerer
+	tracing  bool
+	audit    *storage.AuditLog               // nil - без аудита
+	webhooks *storage.Webhooks               // nil - без вебхуков
+	readOnly *atomic.Bool                    // переключается через /admin/readonly сразу для всех хранилок и бакетов
+	limits   *atomic.Pointer[storage.Limits] // nil - без лимитов, иначе общие для всех хранилок и меняются reload
+}
+
+// decorate оборачивает бэкенд name во все то, что настроено в конфиге: дедлайн, повторы, breaker, кэш, лимиты, аудит,
+// вебхуки, режим только для чтения, метрики и трейсинг
+func decorate(cfg Config, name string, s storage.Storage, w wrappers) (_ storage.Storage, err error) {
+	if cfg.StorageTimeout > 0 {
+		s = storage.NewTimeoutStorage(s, cfg.StorageTimeout)
+	}
+	// повторы снаружи дедлайна, чтобы у каждой попытки был свой
+	if cfg.StorageRetries > 0 {
+		s = storage.NewRetryStorage(s, cfg.StorageRetries+1, cfg.StorageRetryDelay, storage.WithRetryJitter(cfg.StorageRetryJitter))
+	}
+	// breaker снаружи повторов: вызов, который не спасли и повторы, - одно падение, а открытый breaker не повторяется
+	if cfg.BreakerFailures > 0 {
+		s = storage.NewCircuitBreakerStorage(s, name, cfg.BreakerFailures, cfg.BreakerCooldown)
+	}
+	if cfg.CacheSize > 0 {
+		if s, err = storage.NewCachedStorage(s, cfg.CacheSize); err != nil {
+			return nil, err
+		}
+	}
+
+	// лимиты снаружи кэша: сквозь кэш As их бы не нашел, и хендлеры не узнали бы, сколько тела читать
+	if w.limits != nil {
+		s = storage.NewLimitedStorage(s, w.limits)
+	}
+	// аудит внутри лимитов, чтобы отвергнутые ими записи в журнал не попадали
+	if w.audit != nil {
+		s = storage.NewAuditedStorage(s, w.audit, name, cfg.AuditValues)
+	}
+	if w.webhooks != nil {
+		s = storage.NewNotifyingStorage(s, w.webhooks, name)
+	}
+	s = storage.NewReadOnlyStorage(s, w.readOnly)
+
+	s = storage.NewInstrumentedStorage(s, name, w.reg)
+	if w.tracing {
+		s = storage.NewTracedStorage(s, otel.GetTracerProvider(), cfg.TraceHashKeys)
+	}
+	return s, nil
+}
+
+// webhookCollector отдает счетчики доставки вебхуков с адресом в метке url. адреса меняются при перечитывании
+// конфига, так что метрики собираются заново при каждом запросе, а не регистрируются по одной на адрес
+type webhookCollector struct {
+	wh                         *storage.Webhooks
+	delivered, failed, dropped *prometheus.Desc
+}
+
+func newWebhookCollector(wh *storage.Webhooks) *webhookCollector {
+	return &webhookCollector{
+		wh:        wh,
+		delivered: prometheus.NewDesc("examplefs_webhook_delivered_total", "Webhook events delivered to the receiver.", []string{"url"}, nil),
+		failed:    prometheus.NewDesc("examplefs_webhook_failed_total", "Webhook events given up on after all retries.", []string{"url"}, nil),
+		dropped:   prometheus.NewDesc("examplefs_webhook_dropped_total", "Webhook events dropped because the delivery queue was full.", []string{"url"}, nil),
+	}
+}
+
+func (c *webhookCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.delivered
+	ch <- c.failed
+	ch <- c.dropped
+}
+
+func (c *webhookCollector) Collect(ch chan<- prometheus.Metric) {
+	// один адрес можно указать дважды, а серия с одинаковыми метками должна быть одна
+	sums := make(map[string]storage.WebhookStats)
+	for _, st := range c.wh.Stats() {
+		sum := sums[st.URL]
+		sum.Delivered += st.Delivered
+		sum.Failed += st.Failed
+		sum.Dropped += st.Dropped
+		sums[st.URL] = sum
+	}
+	for url, st := range sums {
+		ch <- prometheus.MustNewConstMetric(c.delivered, prometheus.CounterValue, float64(st.Delivered), url)
+		ch <- prometheus.MustNewConstMetric(c.failed, prometheus.CounterValue, float64(st.Failed), url)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(st.Dropped), url)
+	}
+}
+
+func registerBackend(cfg Config, b backendSpec, backends *storage.StorageRegistry, w wrappers) error {
+	raw, err := newStorage(cfg.backendConfig(b))
+	if err != nil {
+		return fmt.Errorf("unable to create %s storage for backend %s: %w", b.Kind, b.Name, err)
+	}
+	s, err := decorate(cfg, b.Name, raw, w)
+	if err == nil {
+		err = backends.Register(b.Name, s)
+	}
+	if err != nil {
+		if c, ok := storage.As[io.Closer](raw); ok {
+			c.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func grpcOptions(tlsConfig *tls.Config) []grpc.ServerOption {
+	if tlsConfig == nil {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}
+}
+
+// shutdown дожидается текущих запросов и только потом закрывает хранилку
+func (srv *server) shutdown() {
+	srv.draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+	defer cancel()
+	if err := srv.http.Shutdown(ctx); err != nil {
+		log.Printf("unable to shutdown server gracefully: %v", err)
+	}
+	// Shutdown уже закрыл stopWatch, так что /ws прощаются с клиентами
+	closed := make(chan struct{})
+	go func() {
+		srv.websockets.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		log.Println("unable to close websocket connections gracefully: timed out")
+	}
+	if srv.grpc != nil {
+		stopped := make(chan struct{})
+		go func() {
+			srv.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Println("unable to shutdown grpc server gracefully: timed out")
+			srv.grpc.Stop()
+		}
+	}
+	if srv.resp != nil {
+		if err := srv.resp.Shutdown(ctx); err != nil {
+			log.Printf("unable to shutdown resp server gracefully: %v", err)
+		}
+	}
+	srv.closeStorage()
+}
+
+func (srv *server) closeStorage() {
+	if err := srv.buckets.Close(); err != nil {
+		log.Printf("unable to close buckets: %v", err)
+	}
+	if err := srv.backends.Close(); err != nil {
+		log.Printf("unable to close storage: %v", err)
+	}
+	// записей больше не будет, так что досылаем то, что уже в очереди
+	if srv.webhooks != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+		defer cancel()
+		if err := srv.webhooks.Shutdown(ctx); err != nil {
+			log.Printf("unable to shutdown webhooks: %v", err)
+		}
+	}
+	if srv.audit != nil {
+		if err := srv.audit.Close(); err != nil {
+			log.Printf("unable to close audit log: %v", err)
+		}
+	}
+}
+
+// run поднимает сервер по конфигу и работает до SIGINT/SIGTERM
+func run(cfg Config) error {
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		return err
+	}
+	// уже после остановки сервера, чтобы досылать span последних запросов
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("unable to flush traces: %v", err)
+		}
+	}()
+
+	srv, err := newServer(cfg)
+	if err != nil {
+		return err
+	}
+
+	// останавливаемся по Ctrl+C или SIGTERM, а не падаем посреди записи
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP перечитывает конфиг и файл с данными, если их поправили руками
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	listeners, err := cfg.httpListeners()
+	if err != nil {
+		srv.closeStorage()
+		return err
+	}
+	serveErr := make(chan error, len(listeners)+2)
+	useTLS := srv.http.TLSConfig != nil // Serve сам заводит TLSConfig для HTTP/2, так что смотреть на него можно только до запуска
+	for _, lis := range listeners {
+		go func() {
+			if useTLS {
+				serveErr <- srv.http.ServeTLS(lis, "", "") // сертификат уже лежит в TLSConfig
+				return
+			}
+			serveErr <- srv.http.Serve(lis)
+		}()
+	}
+	if srv.grpc != nil {
+		lis, err := net.Listen("tcp", srv.grpcAddr)
+		if err != nil {
+			srv.http.Close()
+			srv.closeStorage()
+			return fmt.Errorf("unable to listen for grpc: %w", err)
+		}
+		go func() {
+			serveErr <- srv.grpc.Serve(lis)
+		}()
+	}
+	if srv.resp != nil {
+		lis, err := net.Listen("tcp", srv.respAddr)
+		if err != nil {
+			srv.http.Close()
+			if srv.grpc != nil {
+				srv.grpc.Stop()
+			}
+			srv.closeStorage()
+			return fmt.Errorf("unable to listen for resp: %w", err)
+		}
+		go func() {
+			serveErr <- srv.resp.Serve(lis)
+		}()
+	}
+
+	for running := true; running; {
+		select {
+		case err := <-serveErr:
+			// сервер даже не поднялся (например, порт занят)
+			srv.http.Close()
+			if srv.grpc != nil {
+				srv.grpc.Stop()
+			}
+			if srv.resp != nil {
+				srv.resp.Close()
+			}
+			srv.closeStorage()
+			return fmt.Errorf("unable to serve: %w", err)
+		case <-hup:
+			srv.live.reloadAndLog()
+			if err := httpapi.Reload(srv.storage); err != nil {
+				log.Printf("unable to reload storage: %v", err)
+			} else {
+				log.Println("storage reloaded")
+			}
+		case <-ctx.Done():
+			running = false
+		}
+	}
+
+	log.Println("shutting down")
+	srv.shutdown()
+	return nil
+}
+
+func main() {
+	// с подкомандой бинарь работает клиентом к уже запущенному серверу, а fsck, export-csv, import-csv
+	// и migrate работают с данными без сервера
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fsck":
+			os.Exit(runFsck(os.Args[2:], os.Stdout, os.Stderr))
+		case "export-csv":
+			os.Exit(runExportCSV(os.Args[2:], os.Stdout, os.Stderr))
+		case "import-csv":
+			os.Exit(runImportCSV(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:], os.Stdout, os.Stderr))
+		}
+		if _, ok := clientCommands[os.Args[1]]; ok {
+			os.Exit(runClient(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+		}
+	}
+	cfg, err := parseConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	// SetDefault заодно пускает через slog и обычный log, которым пишут хранилки
+	slog.SetDefault(newLogger(os.Stderr, cfg))
+
+	if err := run(cfg); err != nil {
+		if errors.Is(err, storage.ErrCorrupt) {
+			log.Fatalf("%v\nrestore the file from a backup or start with -force to load what can still be read", err)
+		}
+		log.Fatal(err)
+	}
+}
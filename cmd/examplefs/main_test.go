@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Barugoo/example-fs/httpapi"
+)
+
+// OpenAPI документ пишется руками, так что маршрут без операции в нем (или наоборот) роняет тест,
+// а не только пишет предупреждение при старте
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	for name, args := range map[string][]string{
+		"mem":  {"-storage", "mem", "-docs"},
+		"file": {"-storage", "file", "-file", filepath.Join(t.TempDir(), "data.json")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := parseConfig(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv, err := newServer(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.closeStorage()
+			for _, d := range httpapi.SpecDrift(srv.router, cfg.routePrefix()) {
+				t.Error(d)
+			}
+		})
+	}
+}
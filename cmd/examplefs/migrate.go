@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// exitMismatch - после migrate -verify ключи в назначении не совпали с источником
+const exitMismatch = 1
+
+// как часто migrate печатает, сколько уже перенесено
+const migrateProgressEvery = time.Second
+
+// сколько несовпавших ключей -verify перечисляет поименно
+const maxReportedMismatches = 20
+
+// runMigrate переносит все ключи из одной хранилки в другую без сервера: examplefs migrate [flags] <from> <to>.
+// from и to - kind или kind:path, как у -backend, например file:/var/lib/data.db bolt:/var/lib/data.bolt, или sharded
+// с -shards. хранилки собирает тот же newStorage, что у сервера, так что остальные их настройки (-redis-addr,
+// -codec, ключ шифрования и т.д.) берутся из тех же флагов, переменных окружения и -config. возвращает код выхода
+func runMigrate(args []string, stdout, stderr io.Writer) (code int) {
+	fs := flag.NewFlagSet("examplefs migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: examplefs migrate [flags] <from> <to>, e.g. examplefs migrate file:/var/lib/data.db bolt:/var/lib/data.bolt")
+		fs.PrintDefaults()
+	}
+	var cfg Config
+	defineFlags(fs, &cfg)
+	batch := fs.Int("batch", 1000, "keys read and written at once")
+	dryRun := fs.Bool("dry-run", false, "only read the source and report what would be copied, without opening the destination")
+	verify := fs.Bool("verify", false, "re-read keys from both sides afterwards and compare them")
+	sample := fs.Int("verify-sample", 1000, "how many random keys -verify compares, 0 compares all of them")
+
+	// хранилки - два последних аргумента, перед ними флаги
+	if len(args) < 2 || strings.HasPrefix(args[len(args)-2], "-") || strings.HasPrefix(args[len(args)-1], "-") {
+		// -h или опечатка во флаге: flag уже напечатал все сам
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			return exitFailed
+		}
+		fs.Usage()
+		return exitFailed
+	}
+	from, to := args[len(args)-2], args[len(args)-1]
+	if _, err := applyLayers(fs, args[:len(args)-2]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintf(stderr, "invalid configuration:\n%v\n", err)
+		return exitFailed
+	}
+	switch {
+	case *batch <= 0:
+		fmt.Fprintln(stderr, "-batch must be positive")
+		return exitFailed
+	case *sample < 0:
+		fmt.Fprintln(stderr, "-verify-sample must not be negative")
+		return exitFailed
+	case *verify && *dryRun:
+		fmt.Fprintln(stderr, "-verify has nothing to compare with -dry-run")
+		return exitFailed
+	case from == to:
+		fmt.Fprintln(stderr, "source and destination are the same")
+		return exitFailed
+	}
+
+	// Ctrl+C останавливает перенос между пачками, а хранилки закрываются как следует
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// источник только читаем: файл не блокируется и не переписывается, даже если формат устарел
+	srcCfg := cfg
+	srcCfg.ReadOnly = true
+	src, err := srcCfg.migrateBackend(from)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to open source %s: %v\n", from, err)
+		return exitFailed
+	}
+	defer func() {
+		if err := closeBackend(src); err != nil {
+			fmt.Fprintf(stderr, "unable to close source %s: %v\n", from, err)
+		}
+	}()
+	var dst storage.Storage
+	if !*dryRun {
+		if dst, err = cfg.migrateBackend(to); err != nil {
+			fmt.Fprintf(stderr, "unable to open destination %s: %v\n", to, err)
+			return exitFailed
+		}
+		// у назначения закрытие дописывает то, что еще не легло на диск, так что его ошибка - ошибка переноса
+		defer func() {
+			if err := closeBackend(dst); err != nil {
+				fmt.Fprintf(stderr, "unable to close destination %s: %v\n", to, err)
+				code = exitFailed
+			}
+		}()
+		if keys, err := storage.KeysPage(ctx, dst, "", 1); err == nil && len(keys) > 0 {
+			fmt.Fprintf(stdout, "destination %s is not empty: its keys are kept, the same keys are overwritten\n", to)
+		}
+	}
+
+	total := -1 // неизвестно, если хранилка не знает свой размер без полного обхода
+	if st, ok := storage.As[storage.Statter](src); ok {
+		if stats, err := st.Stats(ctx); err == nil {
+			total = stats.Keys
+		}
+	}
+	var sampled []string
+	seen := 0
+	start, printed := time.Now(), time.Now()
+	stats, err := storage.Migrate(ctx, src, dst, *batch, func(keys []string, stats storage.MigrateStats) {
+		// на проверку берем равномерную выборку ключей, не зная заранее, сколько их всего
+		for _, k := range keys {
+			seen++
+			switch {
+			case !*verify || *sample == 0:
+			case len(sampled) < *sample:
+				sampled = append(sampled, k)
+			default:
+				if i := rand.IntN(seen); i < *sample {
+					sampled[i] = k
+				}
+			}
+		}
+		if time.Since(printed) >= migrateProgressEvery {
+			printed = time.Now()
+			printProgress(stdout, stats, total, time.Since(start))
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "migration stopped after %d keys: %v\n", stats.Keys, err)
+		return exitFailed
+	}
+	verb := "copied"
+	if *dryRun {
+		verb = "would copy"
+	}
+	fmt.Fprintf(stdout, "%s %d keys (%d bytes) from %s to %s in %s\n", verb, stats.Keys, stats.Bytes, from, to, time.Since(start).Round(time.Millisecond))
+	if !*verify {
+		return 0
+	}
+
+	var keys []string // nil - все ключи
+	checked := "all keys"
+	if *sample > 0 {
+		keys = append(make([]string, 0, len(sampled)), sampled...)
+		checked = fmt.Sprintf("%d sampled keys", len(keys))
+	}
+	mismatched, err := storage.VerifyMigration(ctx, src, dst, keys, *batch)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to verify: %v\n", err)
+		return exitFailed
+	}
+	if len(mismatched) == 0 {
+		fmt.Fprintf(stdout, "verified %s: destination matches the source\n", checked)
+		return 0
+	}
+	fmt.Fprintf(stdout, "verified %s: %d differ in the destination\n", checked, len(mismatched))
+	for _, k := range mismatched[:min(len(mismatched), maxReportedMismatches)] {
+		fmt.Fprintf(stdout, "  %q\n", k)
+	}
+	return exitMismatch
+}
+
+// migrateBackend собирает хранилку по from или to из migrate тем же newStorage, что сервер собирает -backend
+func (cfg Config) migrateBackend(spec string) (storage.Storage, error) {
+	if spec == "sharded" {
+		if len(cfg.Shards) == 0 {
+			return nil, errors.New("sharded requires -shards")
+		}
+		cfg.Storage = spec
+		return newStorage(cfg)
+	}
+	b, err := parseBackendSpec("migrate=" + spec)
+	if err != nil {
+		return nil, err
+	}
+	return newStorage(cfg.backendConfig(b))
+}
+
+func closeBackend(s storage.Storage) error {
+	if c, ok := storage.As[io.Closer](s); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func printProgress(w io.Writer, stats storage.MigrateStats, total int, elapsed time.Duration) {
+	rate := float64(stats.Keys) / elapsed.Seconds()
+	if total > 0 {
+		fmt.Fprintf(w, "%d/%d keys (%.0f%%), %.0f keys/s\n", stats.Keys, total, 100*float64(stats.Keys)/float64(total), rate)
+		return
+	}
+	fmt.Fprintf(w, "%d keys, %.0f keys/s\n", stats.Keys, rate)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+func TestRunMigrate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "data.json"), filepath.Join(dir, "data.bolt")
+	src, err := storage.NewFileStorage(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = src.SetMany(ctx, map[string]string{"a": "1", "b": "2", "c": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	src.(io.Closer).Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := runMigrate([]string{"-dry-run", "file:" + from, "bolt:" + to}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "would copy 3 keys") {
+		t.Fatalf("dry run: code %d, stdout %q, stderr %q", code, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	if code := runMigrate([]string{"-batch", "2", "-verify", "-verify-sample", "0", "file:" + from, "bolt:" + to}, &stdout, &stderr); code != 0 {
+		t.Fatalf("migrate: code %d, stdout %q, stderr %q", code, stdout.String(), stderr.String())
+	}
+	if out := stdout.String(); !strings.Contains(out, "copied 3 keys") || !strings.Contains(out, "destination matches the source") {
+		t.Errorf("migrate output %q", out)
+	}
+
+	dst, err := storage.NewBoltStorage(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.(io.Closer).Close()
+	if v, err := dst.Get(ctx, "b"); err != nil || v != "2" {
+		t.Errorf("Get(b) from the destination = %q, %v", v, err)
+	}
+}
+
+func TestRunMigrateArgs(t *testing.T) {
+	for name, args := range map[string][]string{
+		"no backends":        {},
+		"one backend":        {"mem"},
+		"same backends":      {"mem", "mem"},
+		"bad batch":          {"-batch", "0", "mem", "file:/tmp/x"},
+		"verify and dry run": {"-verify", "-dry-run", "mem", "file:/tmp/x"},
+		"unknown kind":       {"floppy:/a", "mem"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := runMigrate(args, &stdout, &stderr); code != exitFailed {
+			t.Errorf("%s: code %d, want %d (stderr %q)", name, code, exitFailed, stderr.String())
+		}
+	}
+}
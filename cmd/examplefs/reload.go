@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// флаги, которые reload применяет на ходу. все остальные держат слушатели или хранилки и требуют перезапуска
+var reloadableFlags = map[string]bool{
+	"log-level":       true,
+	"rate-limit":      true,
+	"rate-burst":      true,
+	"auth-token":      true,
+	"auth-user":       true,
+	"auth-pass":       true,
+	"max-key-bytes":   true,
+	"max-value-bytes": true,
+	"key-pattern":     true,
+	"webhook":         true,
+}
+
+// флаги лимитов: применить их на ходу можно, только если при запуске лимиты были и хранилки уже обернуты
+var limitFlags = []string{"max-key-bytes", "max-value-bytes", "key-pattern"}
+
+// liveConfig - то, что работающий сервер читает на каждом запросе и что reload подменяет. каждая настройка лежит
+// целиком в одном атомарном значении или за мьютексом, так что запрос видит либо старую, либо новую, но не их смесь
+type liveConfig struct {
+	mu  sync.Mutex // один reload за раз
+	cfg Config     // с чем сервер работает сейчас: настройки, требующие перезапуска, в нем остаются прежними
+
+	rateLimiter *httpapi.RateLimiter
+	creds       *atomic.Pointer[auth.Credentials]
+	limits      *atomic.Pointer[storage.Limits] // nil, если сервер запущен без лимитов
+	webhooks    *storage.Webhooks               // nil, если сервер запущен без вебхуков
+}
+
+// reload перечитывает файл -config и окружение, разбирает аргументы запуска поверх них и применяет то, что можно.
+// невалидный конфиг не применяется совсем
+func (lc *liveConfig) reload() (res httpapi.ConfigReload, err error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	next, err := parseConfig(lc.cfg.args)
+	if err != nil {
+		return res, err
+	}
+	res.Applied, res.RequiresRestart = []string{}, []string{}
+	for _, name := range slices.Sorted(maps.Keys(next.settings)) {
+		if slices.Equal(next.settings[name], lc.cfg.settings[name]) {
+			continue
+		}
+		if !reloadableFlags[name] || !lc.canApply(name) {
+			res.RequiresRestart = append(res.RequiresRestart, name)
+			continue
+		}
+		res.Applied = append(res.Applied, name)
+		lc.cfg.settings[name] = next.settings[name]
+	}
+
+	logLevel.Set(next.LogLevel)
+	lc.rateLimiter.SetLimit(next.RateLimit, next.RateBurst)
+	creds := next.credentials()
+	lc.creds.Store(&creds)
+	if lc.limits != nil {
+		limits := next.limits()
+		lc.limits.Store(&limits)
+	}
+	if lc.webhooks != nil {
+		lc.webhooks.SetURLs(next.Webhooks)
+	}
+	return res, nil
+}
+
+// canApply - можно ли применить флаг на ходу там, где его обертка навешивается только при запуске
+func (lc *liveConfig) canApply(name string) bool {
+	switch {
+	case slices.Contains(limitFlags, name):
+		return lc.limits != nil
+	case name == "webhook":
+		return lc.webhooks != nil
+	}
+	return true
+}
+
+// reloadAndLog - reload для SIGHUP, где ответить некому, кроме лога
+func (lc *liveConfig) reloadAndLog() {
+	res, err := lc.reload()
+	if err != nil {
+		slog.Error("unable to reload config", "error", err)
+		return
+	}
+	slog.Info("config reloaded", "applied", res.Applied, "requires_restart", res.RequiresRestart)
+}
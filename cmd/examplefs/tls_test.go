@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issue выпускает сертификат, подписанный parent (или самоподписанный, если parent == nil),
+// и кладет его и ключ в PEM файлы в dir
+func issue(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.Subject = pkix.Name{CommonName: name}
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caFile, _ := issue(t, dir, "ca", nil, nil, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign})
+	_, _, certFile, keyFile := issue(t, dir, "server", ca, caKey, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	_, _, clientCert, clientKey := issue(t, dir, "client", ca, caKey, &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+
+	for name, args := range map[string][]string{
+		"cert without key": {"-tls-cert", certFile},
+		"key without cert": {"-tls-key", keyFile},
+		"ca without cert":  {"-tls-client-ca", caFile},
+	} {
+		if _, err := parseConfig(args); err == nil {
+			t.Errorf("%s: %v is accepted", name, args)
+		}
+	}
+	for name, cfg := range map[string]Config{
+		"missing cert":      {TLSCert: filepath.Join(dir, "nope.crt"), TLSKey: keyFile},
+		"key of other":      {TLSCert: certFile, TLSKey: clientKey},
+		"ca is not a pem":   {TLSCert: certFile, TLSKey: keyFile, TLSClientCA: keyFile},
+		"ca does not exist": {TLSCert: certFile, TLSKey: keyFile, TLSClientCA: filepath.Join(dir, "nope.crt")},
+	} {
+		if _, err := cfg.tlsConfig(); err == nil {
+			t.Errorf("%s: tlsConfig succeeded", name)
+		}
+	}
+	if tc, err := (Config{}).tlsConfig(); tc != nil || err != nil {
+		t.Errorf("without a certificate: got %v, %v, want TLS off", tc, err)
+	}
+
+	tc, err := Config{TLSCert: certFile, TLSKey: keyFile, TLSClientCA: caFile}.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+	srv.TLS = tc
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certs ...tls.Certificate) error {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := c.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err = get(); err == nil {
+		t.Error("request without a client certificate is accepted")
+	}
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = get(pair); err != nil {
+		t.Errorf("request with the client certificate: %v", err)
+	}
+}
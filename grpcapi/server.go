@@ -0,0 +1,135 @@
+// Package grpcapi - gRPC сервер KV из kvpb поверх storage.Storage
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/kvpb"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// kvServer - gRPC поверх той же хранилки, что и у HTTP хендлеров, так что оба API видят одни и те же данные
+type kvServer struct {
+	kvpb.UnimplementedKVServer
+	s storage.Storage
+}
+
+// NewServer собирает gRPC сервер с KV поверх s. если creds включены, проверяются они так же, как в HTTP:
+// токен или Basic в метаданных authorization, для чтения - только при protectReads. creds читаются на каждом вызове,
+// так что их можно подменить на ходу
+func NewServer(s storage.Storage, creds *atomic.Pointer[auth.Credentials], protectReads bool, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.UnaryInterceptor(requireAuth(creds, protectReads)))
+	gs := grpc.NewServer(opts...)
+	kvpb.RegisterKVServer(gs, &kvServer{s: s})
+	return gs
+}
+
+func (ks *kvServer) Get(ctx context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	value, err := ks.s.Get(ctx, req.GetKey())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvpb.GetResponse{Value: value}, nil
+}
+
+func (ks *kvServer) Set(ctx context.Context, req *kvpb.SetRequest) (*kvpb.SetResponse, error) {
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	if err := ks.s.Set(ctx, req.GetKey(), req.GetValue()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvpb.SetResponse{}, nil
+}
+
+func (ks *kvServer) Delete(ctx context.Context, req *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	if err := ks.s.Delete(ctx, req.GetKey()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvpb.DeleteResponse{}, nil
+}
+
+func (ks *kvServer) List(ctx context.Context, req *kvpb.ListRequest) (*kvpb.ListResponse, error) {
+	keys, err := ks.s.Keys(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &kvpb.ListResponse{Keys: make([]string, 0, len(keys))}
+	for _, k := range keys {
+		if strings.HasPrefix(k, req.GetPrefix()) {
+			resp.Keys = append(resp.Keys, k)
+		}
+	}
+	return resp, nil
+}
+
+// читающие методы, которые без protectReads отдаются без авторизации
+var readMethods = map[string]bool{
+	kvpb.KV_Get_FullMethodName:  true,
+	kvpb.KV_List_FullMethodName: true,
+}
+
+func requireAuth(creds *atomic.Pointer[auth.Credentials], protectReads bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		creds := creds.Load()
+		if !creds.Enabled() || readMethods[info.FullMethod] && !protectReads {
+			return handler(ctx, req)
+		}
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			header = md.Get("authorization")[0]
+		}
+		switch err := creds.Check(header); {
+		case errors.Is(err, auth.ErrNoCredentials):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case err != nil:
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcError - то же, что errorStatus в httpapi, только для кодов gRPC
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, storage.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, storage.ErrInvalidKey), errors.Is(err, storage.ErrInvalidBucket), errors.Is(err, storage.ErrTooLarge):
+		code = codes.InvalidArgument
+	case errors.Is(err, storage.ErrUnavailable):
+		code = codes.Unavailable
+	case errors.Is(err, storage.ErrNotNumeric), errors.Is(err, storage.ErrReadOnly):
+		code = codes.FailedPrecondition
+	case errors.Is(err, storage.ErrExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, storage.ErrNotSupported):
+		code = codes.Unimplemented
+	}
+	if code == codes.Internal {
+		// как и в HTTP, внутренности (пути, ошибки ОС) остаются в логе
+		slog.Error("storage error", "error", err)
+		return status.Error(code, "internal storage error")
+	}
+	return status.Error(code, err.Error())
+}
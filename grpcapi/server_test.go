@@ -0,0 +1,117 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/kvpb"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// newGRPCClient поднимает сервер на bufconn, без настоящего порта
+func newGRPCClient(t *testing.T, s storage.Storage, creds auth.Credentials, protectReads bool) kvpb.KVClient {
+	t.Helper()
+	p := new(atomic.Pointer[auth.Credentials])
+	p.Store(&creds)
+	gs := NewServer(s, p, protectReads)
+	lis := bufconn.Listen(1 << 20)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return kvpb.NewKVClient(conn)
+}
+
+func wantCode(t *testing.T, op string, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Errorf("%s: got %v (%v), want %v", op, got, err, want)
+	}
+}
+
+func TestGRPCKV(t *testing.T) {
+	ctx := context.Background()
+	kv := newGRPCClient(t, storage.NewMemStorage(), auth.Credentials{}, false)
+
+	if _, err := kv.Set(ctx, &kvpb.SetRequest{Key: "a/1", Value: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Set(ctx, &kvpb.SetRequest{Key: "b", Value: "two"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := kv.Get(ctx, &kvpb.GetRequest{Key: "a/1"}); err != nil || resp.GetValue() != "one" {
+		t.Fatalf("Get = %v, %v", resp, err)
+	}
+	if resp, err := kv.List(ctx, &kvpb.ListRequest{Prefix: "a/"}); err != nil || len(resp.GetKeys()) != 1 || resp.GetKeys()[0] != "a/1" {
+		t.Fatalf("List = %v, %v", resp, err)
+	}
+	if _, err := kv.Delete(ctx, &kvpb.DeleteRequest{Key: "a/1"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := kv.Get(ctx, &kvpb.GetRequest{Key: "a/1"})
+	wantCode(t, "Get after Delete", err, codes.NotFound)
+	_, err = kv.Delete(ctx, &kvpb.DeleteRequest{Key: "a/1"})
+	wantCode(t, "Delete of a missing key", err, codes.NotFound)
+	_, err = kv.Set(ctx, &kvpb.SetRequest{Key: ""})
+	wantCode(t, "Set with an empty key", err, codes.InvalidArgument)
+}
+
+func TestRequireAuth(t *testing.T) {
+	ctx := context.Background()
+	kv := newGRPCClient(t, storage.NewMemStorage(), auth.Credentials{Token: "secret"}, false)
+
+	_, err := kv.Set(ctx, &kvpb.SetRequest{Key: "k", Value: "v"})
+	wantCode(t, "Set without a token", err, codes.Unauthenticated)
+	_, err = kv.Set(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong"), &kvpb.SetRequest{Key: "k", Value: "v"})
+	wantCode(t, "Set with a wrong token", err, codes.PermissionDenied)
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	if _, err = kv.Set(authed, &kvpb.SetRequest{Key: "k", Value: "v"}); err != nil {
+		t.Fatalf("Set with the token: %v", err)
+	}
+	// без protectReads чтение открыто
+	if _, err = kv.Get(ctx, &kvpb.GetRequest{Key: "k"}); err != nil {
+		t.Errorf("Get without a token: %v", err)
+	}
+
+	kv = newGRPCClient(t, storage.NewMemStorage(), auth.Credentials{Token: "secret"}, true)
+	_, err = kv.Get(ctx, &kvpb.GetRequest{Key: "k"})
+	wantCode(t, "Get without a token and protectReads", err, codes.Unauthenticated)
+	_, err = kv.List(ctx, &kvpb.ListRequest{})
+	wantCode(t, "List without a token and protectReads", err, codes.Unauthenticated)
+}
+
+func TestGRPCError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want codes.Code
+	}{
+		{storage.ErrNotFound, codes.NotFound},
+		{fmt.Errorf("get: %w", storage.ErrNotFound), codes.NotFound},
+		{storage.ErrInvalidKey, codes.InvalidArgument},
+		{storage.ErrInvalidBucket, codes.InvalidArgument},
+		{storage.ErrUnavailable, codes.Unavailable},
+		{storage.ErrNotNumeric, codes.FailedPrecondition},
+		{storage.ErrNotSupported, codes.Unimplemented},
+		{errors.New("disk on fire"), codes.Internal},
+	} {
+		wantCode(t, tt.err.Error(), grpcError(tt.err), tt.want)
+	}
+}
@@ -0,0 +1,179 @@
+package httpapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// типы, которые уже сжаты: gzip сверху только тратит процессор и чуть раздувает тело
+var compressedTypes = []string{"image/", "video/", "audio/", "font/woff", "application/gzip", "application/zip", "application/zstd",
+	"application/x-7z-compressed", "application/x-bzip2", "application/x-xz", "application/x-rar-compressed"}
+
+var gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// Compress сжимает ответ gzip, если клиент прислал Accept-Encoding: gzip, а тело не меньше minSize байт.
+// пока тело меньше minSize, оно копится в буфере: маленький ответ так и уходит без сжатия, с Content-Length.
+// потоки _watch (text/event-stream) и уже сжатые типы не трогаем, Flush до решения отдает ответ без сжатия
+func Compress(minSize int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r) || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w, minSize: minSize}
+			defer gw.Close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// acceptsGzip - gzip есть в Accept-Encoding и не выключен через q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		if coding = strings.TrimSpace(coding); coding == "gzip" || coding == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter решает, сжимать ли ответ, как только это становится понятно: по заголовкам при WriteHeader
+// или по размеру тела, когда оно перерастет minSize. до этого тело лежит в buf
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status  int // из WriteHeader, 0 - хендлер его еще не звал
+	buf     bytes.Buffer
+	decided bool
+	gz      *gzip.Writer // nil - ответ идет без сжатия
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if gw.status != 0 || gw.decided {
+		return
+	}
+	if status < http.StatusOK { // 1xx уходят сразу и на ответ не влияют
+		gw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	gw.status = status
+	if !gw.compressible() {
+		gw.start(false)
+		return
+	}
+	// с Content-Length размер известен заранее, ждать тела не нужно
+	if n, err := strconv.Atoi(gw.Header().Get("Content-Length")); err == nil && n < gw.minSize {
+		gw.start(false)
+	}
+}
+
+// compressible - по заголовкам ответ можно сжимать, дальше решает только размер
+func (gw *gzipWriter) compressible() bool {
+	h := gw.Header()
+	if gw.status == http.StatusNoContent || gw.status == http.StatusNotModified || gw.status == http.StatusPartialContent || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	for _, t := range compressedTypes {
+		if strings.HasPrefix(mediaType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if gw.status == 0 {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(b)
+		}
+		return gw.ResponseWriter.Write(b)
+	}
+	gw.buf.Write(b)
+	if gw.buf.Len() >= gw.minSize {
+		if err := gw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start отправляет заголовки и то, что накопилось в buf, сжатым или как есть
+func (gw *gzipWriter) start(compress bool) (err error) {
+	gw.decided = true
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+	if compress {
+		gw.Header().Del("Content-Length")
+		gw.Header().Set("Content-Encoding", "gzip")
+		gw.gz = gzipPool.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+	if gw.buf.Len() == 0 {
+		return nil
+	}
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf.Bytes())
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf.Bytes())
+	}
+	gw.buf.Reset()
+	return err
+}
+
+// Flush отдает клиенту все, что есть. если сжатие еще не началось, ответ так и пойдет без него:
+// тот, кто зовет Flush, ждет, что байты уйдут сразу, а не после minSize
+func (gw *gzipWriter) Flush() {
+	if !gw.decided {
+		gw.start(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Close дописывает хвост gzip, а короткий ответ, который так и не перерос minSize, отдает без сжатия
+func (gw *gzipWriter) Close() {
+	if !gw.decided {
+		if gw.status == 0 { // хендлер ничего не написал - пусть net/http ответит 200 сам
+			return
+		}
+		gw.start(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+		gzipPool.Put(gw.gz)
+		gw.gz = nil
+	}
+}
+
+// Unwrap нужен http.ResponseController, чтобы дотянуться до SetWriteDeadline настоящего writer'а
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions - каким сайтам браузер даст ходить в API
+type CORSOptions struct {
+	Origins     []string // origin целиком, как https://app.example.com, или "*" - любой сайт
+	Methods     []string
+	Credentials bool // пускать запросы с куками и Authorization, с "*" браузеры так не умеют
+}
+
+func (o CORSOptions) Enabled() bool {
+	return len(o.Origins) > 0
+}
+
+// сколько браузер может не повторять preflight
+const corsMaxAge = 10 * time.Minute
+
+// заголовки ответа, которые браузер покажет скрипту, без этого тому видны только базовые
+const corsExposeHeaders = "ETag, Retry-After, Idempotent-Replayed, Content-Length, X-Request-ID"
+
+// CORS отвечает на preflight (OPTIONS с Access-Control-Request-Method) сам и добавляет Access-Control-Allow-*
+// к остальным ответам. запросы с чужих origin идут дальше как есть, только без заголовков CORS - их отвергнет браузер.
+// оборачивает роутер целиком, а не вешается через Use: на OPTIONS маршрутов нет, и mux не позвал бы middleware
+func CORS(o CORSOptions) func(http.Handler) http.Handler {
+	wildcard := slices.Contains(o.Origins, "*")
+	methods := strings.Join(o.Methods, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if !wildcard {
+				// ответ зависит от Origin, кэши не должны отдавать его другому сайту
+				w.Header().Add("Vary", "Origin")
+			}
+			if origin == "" || !wildcard && !slices.Contains(o.Origins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			if wildcard {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if o.Credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			// заголовки запроса не ограничиваем: что и кому можно, все равно решают авторизация и хендлеры
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
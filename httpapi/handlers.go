@@ -0,0 +1,1455 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// example handler. в ETag отдаем ревизию значения, на If-None-Match с ней отвечаем 304 без тела
+func GetHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		value, rev, err := storage.GetRevision(r.Context(), s, key)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		etag := `"` + rev + `"`
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		respondValue(w, r, s, key, value, rev)
+	}
+}
+
+// respondValue отдает значение ключа с ETag и типом, с которым его записали
+func respondValue(w http.ResponseWriter, r *http.Request, s storage.Storage, key, value, rev string) {
+	w.Header().Set("ETag", `"`+rev+`"`)
+	if ct := storedContentType(r.Context(), s, key, value); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	respond(w, r, http.StatusOK, valueResponse{Key: key, Value: value})
+}
+
+// самое долгое ?wait= у long polling GET и сколько после него есть у ответа, чтобы дописаться до клиента
+const (
+	maxLongPollWait    = 5 * time.Minute
+	longPollWriteSlack = 10 * time.Second
+)
+
+// LongPollHandler - GET /{key}?wait=30s&since=<etag> для клиентов без SSE: если ревизия ключа уже не since,
+// отвечает сразу, как GetHandler, иначе ждет изменения до wait. удаление - 404, не дождались - 304.
+// без since это обычный GET. остановка сервера (stop) отвечает ожидающим 304, чтобы они переподключились
+func LongPollHandler(s storage.Storage, stop <-chan struct{}) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		raw := r.URL.Query().Get("wait")
+		wait, err := time.ParseDuration(raw)
+		if err != nil || wait <= 0 || wait > maxLongPollWait {
+			httpError(w, r, fmt.Sprintf("invalid wait %q: want a duration up to %v", raw, maxLongPollWait), http.StatusBadRequest)
+			return
+		}
+		// since можно передать прямо как ETag из прошлого ответа, с кавычками
+		since := strings.Trim(strings.TrimPrefix(r.URL.Query().Get("since"), "W/"), `"`)
+
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		// ожидание может быть дольше WriteTimeout сервера, а сам ответ после него короткий
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + longPollWriteSlack))
+
+		value, rev, err := storage.WaitChange(ctx, s, key, since)
+		switch {
+		case r.Context().Err() != nil:
+			return // клиент ушел, отвечать некому
+		case err != nil && ctx.Err() != nil:
+			w.Header().Set("ETag", `"`+since+`"`)
+			w.WriteHeader(http.StatusNotModified)
+		case err != nil:
+			storageError(w, r, err)
+		default:
+			respondValue(w, r, s, key, value, rev)
+		}
+	}
+}
+
+// storedContentType - тип, с которым значение записали через PUT. пусто, если хранилка метаданных не помнит
+// или ключ успели перезаписать между чтениями - тогда тип угадает respond
+func storedContentType(ctx context.Context, s storage.Storage, key, value string) string {
+	entry, err := storage.GetEntry(ctx, s, key)
+	if err != nil || entry.Value != value {
+		return ""
+	}
+	return entry.ContentType
+}
+
+// etagMatches проверяет If-None-Match: там может быть список через запятую, слабые W/"..." теги или *
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// PostHandler - старая запись значения из пути. значения из keyActions он не пишет: так POST /{key}/incr
+// всегда означает действие, и тот же путь с %69ncr не записал бы вместо этого строку "incr"
+func PostHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		limits := limits()
+		key, err := writableKeyVar(r, limits)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		value, err := url.PathUnescape(mux.Vars(r)["value"])
+		if err != nil {
+			httpError(w, r, "invalid value: bad percent-encoding", http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(keyActions, value) {
+			httpError(w, r, fmt.Sprintf("value %q is reserved for POST /{key}/%s, write it with PUT", value, value), http.StatusBadRequest)
+			return
+		}
+		if err := limits.Check(key, value); err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		ttl, err := parseTTL(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setValue(r.Context(), s, key, value, ttl); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, valueResponse{Key: key, Value: value})
+	}
+}
+
+var errTTLNotSupported = errors.New("storage does not support ttl")
+
+// parseTTL достает необязательный ?ttl=30s из запроса, 0 значит "без ttl"
+func parseTTL(r *http.Request) (ttl time.Duration, err error) {
+	raw := r.URL.Query().Get("ttl")
+	if raw == "" {
+		return 0, nil
+	}
+	ttl, err = time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q: %w", raw, err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q: must be positive", raw)
+	}
+	return ttl, nil
+}
+
+// setValue пишет через SetWithTTL, если ttl задан, и через обычный Set иначе
+func setValue(ctx context.Context, s storage.Storage, key, value string, ttl time.Duration) (err error) {
+	if ttl == 0 {
+		return s.Set(ctx, key, value)
+	}
+	es, ok := storage.As[storage.ExpiringStorage](s)
+	if !ok {
+		return errTTLNotSupported
+	}
+	return es.SetWithTTL(ctx, key, value, ttl)
+}
+
+// keyVar достает ключ из пути и проверяет его. роутер матчит неразобранный путь (UseEncodedPath),
+// так что a%2Fb доходит сюда одним сегментом {key} и только здесь превращается в ключ a/b
+func keyVar(r *http.Request, limits storage.Limits) (key string, err error) {
+	raw := mux.Vars(r)["key"]
+	if key, err = url.PathUnescape(raw); err != nil {
+		return "", &storage.InvalidKeyError{Key: raw, Reason: "bad percent-encoding"}
+	}
+	return key, limits.CheckKey(key)
+}
+
+// reservedPrefix - с него начинаются служебные маршруты хранилки: _dump, _batch, _watch и остальные
+const reservedPrefix = "_"
+
+// checkWritable не дает записать через HTTP ключ, который начинается с reservedPrefix: ключ _dump перекрыл бы
+// маршрут, а GET /{prefix}/_dump отдавал бы дамп вместо значения. уже лежащие в хранилке такие ключи
+// (записанные по gRPC, RESP или до этой проверки) читаются и удаляются как обычно, а _restore и _import.csv
+// пишут их как есть, чтобы старый дамп восстанавливался целиком
+func checkWritable(key string) error {
+	if strings.HasPrefix(key, reservedPrefix) {
+		return &storage.InvalidKeyError{Key: key, Reason: "keys starting with " + reservedPrefix + " are reserved for endpoints such as _dump"}
+	}
+	return nil
+}
+
+// writableKeyVar - keyVar для хендлеров записи
+func writableKeyVar(r *http.Request, limits storage.Limits) (key string, err error) {
+	if key, err = keyVar(r, limits); err != nil {
+		return key, err
+	}
+	return key, checkWritable(key)
+}
+
+// storageError отвечает клиенту по ошибке хранилки и пишет ее в лог целиком, со всей цепочкой обертываний.
+// клиенту уходит только класс ошибки из errorClass: в цепочке бывают пути к файлам и ошибки ОС
+func storageError(w http.ResponseWriter, r *http.Request, err error) {
+	status, resp := errorClass(err)
+	level := slog.LevelDebug // 404 и прочие ожидаемые ответы - не повод шуметь в логах
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.Log(r.Context(), level, "storage error",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"error", err,
+	)
+	// 405 без Allow клиенту ничего не говорит, а пока хранилка только для чтения, можно только читать
+	if errors.Is(err, storage.ErrReadOnly) {
+		w.Header().Set("Allow", "GET, HEAD")
+	}
+	var circuitOpen *storage.CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitOpen.RetryAfter.Seconds()))))
+	}
+	writeError(w, r, status, resp)
+}
+
+// statusClientClosedRequest - клиент ушел, не дождавшись ответа. нестандартный код, как у nginx,
+// чтобы такие запросы не смешивались в логах и метриках с настоящими ошибками сервера
+const statusClientClosedRequest = 499
+
+// errorStatus подбирает код ответа по ошибке хранилки
+func errorStatus(err error) int {
+	status, _ := errorClass(err)
+	return status
+}
+
+// errorClass разбирает ошибку хранилки на статус и тело ответа. сообщение берется из класса ошибки, а не из err.Error(),
+// кроме типизированных ошибок, в которых нет ничего, кроме того, что прислал сам клиент
+func errorClass(err error) (status int, resp errorResponse) {
+	var (
+		tooLarge    *storage.TooLargeError
+		invalidKey  *storage.InvalidKeyError
+		circuitOpen *storage.CircuitOpenError
+		txnCheck    *storage.TxnCheckError
+	)
+	switch {
+	case errors.As(err, &tooLarge) && tooLarge.What == "key":
+		return http.StatusRequestURITooLong, errorResponse{Code: codeTooLarge, Message: tooLarge.Error()} // ключ приходит в пути
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge, errorResponse{Code: codeTooLarge, Message: tooLarge.Error()}
+	case errors.Is(err, storage.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge, errorResponse{Code: codeTooLarge, Message: "value is too large"}
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest, errorResponse{Code: codeCanceled, Message: "request canceled"}
+	case errors.Is(err, storage.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, errorResponse{Code: codeTimeout, Message: "storage call timed out"}
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound, errorResponse{Code: codeNotFound, Message: "not found"}
+	case errors.As(err, &invalidKey):
+		// с причиной, чтобы клиент мог ее показать, не разбирая текст
+		return http.StatusBadRequest, errorResponse{Code: codeInvalidKey, Message: "invalid key", Key: invalidKey.Key, Reason: invalidKey.Reason}
+	case errors.Is(err, storage.ErrInvalidKey):
+		return http.StatusBadRequest, errorResponse{Code: codeInvalidKey, Message: "invalid key"}
+	case errors.Is(err, storage.ErrInvalidBucket):
+		return http.StatusBadRequest, errorResponse{Code: codeInvalidBucket, Message: "invalid bucket name"}
+	case errors.As(err, &circuitOpen):
+		return http.StatusServiceUnavailable, errorResponse{Code: codeUnavailable, Message: circuitOpen.Error()}
+	case errors.Is(err, storage.ErrUnavailable):
+		return http.StatusServiceUnavailable, errorResponse{Code: codeUnavailable, Message: "storage is temporarily unavailable"}
+	case errors.Is(err, storage.ErrNotNumeric):
+		return http.StatusConflict, errorResponse{Code: codeNotNumeric, Message: "value is not an integer"}
+	case errors.As(err, &txnCheck):
+		return http.StatusConflict, errorResponse{Code: codeConflict, Message: "transaction check failed", Key: txnCheck.Key, Index: &txnCheck.Index}
+	case errors.Is(err, storage.ErrInvalidTxn):
+		return http.StatusBadRequest, errorResponse{Code: codeBadRequest, Message: err.Error()}
+	case errors.Is(err, storage.ErrExists):
+		return http.StatusConflict, errorResponse{Code: codeExists, Message: "key already exists"}
+	case errors.Is(err, storage.ErrReadOnly):
+		return http.StatusMethodNotAllowed, errorResponse{Code: codeReadOnly, Message: "storage is read-only"}
+	case errors.Is(err, errTTLNotSupported), errors.Is(err, errConditionalNotSupported), errors.Is(err, errIncrementNotSupported),
+		errors.Is(err, errAppendNotSupported):
+		return http.StatusNotImplemented, errorResponse{Code: codeNotSupported, Message: err.Error()}
+	case errors.Is(err, storage.ErrNotSupported):
+		return http.StatusNotImplemented, errorResponse{Code: codeNotSupported, Message: "not supported by this storage"}
+	}
+	return http.StatusInternalServerError, errorResponse{Code: codeBackendError, Message: "internal storage error"}
+}
+
+// PutHandler берет значение из тела запроса, а не из пути,
+// так что в нем могут быть слэши, пробелы, переводы строк и что угодно еще
+// maxBodyBytes работает, только если у хранилки нет своего лимита на значение
+func PutHandler(s storage.Storage, maxBodyBytes int64) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		limits := limits()
+		// ключ проверяем до чтения тела, чтобы не тянуть мегабайты ради 414
+		key, err := writableKeyVar(r, limits)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		ttl, err := parseTTL(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, bodyLimit(limits, maxBodyBytes))
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				httpError(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cond, err := parseCondition(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// тип значения запоминают хранилки с метаданными и потом отдают его в GET
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = defaultContentType
+		} else if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			httpError(w, r, "invalid Content-Type: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(storage.WithContentType(r.Context(), contentType))
+		if cond.kind != condNone && ttl != 0 {
+			httpError(w, r, "ttl can not be combined with a conditional write", http.StatusBadRequest)
+			return
+		}
+		if cond.kind != condNone {
+			conditionalPut(w, r, s, key, string(value), cond)
+			return
+		}
+
+		if err := setValue(r.Context(), s, key, string(value), ttl); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+var errConditionalNotSupported = errors.New("storage does not support conditional writes")
+
+const (
+	condNone = iota
+	condMatch
+	condAbsent
+	condDefault // ?if-absent=true: GetOrSet, тело - значение по умолчанию
+)
+
+type writeCondition struct {
+	kind   int
+	expect string // старое значение для condMatch
+}
+
+// parseCondition разбирает условие записи: If-Match или ?expect= со старым значением - это CAS,
+// If-None-Match: * - запись только если ключа еще нет, ?if-absent=true - то же, но вместо 409 отдается текущее значение
+func parseCondition(r *http.Request) (cond writeCondition, err error) {
+	expect, hasExpect := r.URL.Query()["expect"]
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+
+	n := 0
+	if hasExpect {
+		n++
+		cond = writeCondition{kind: condMatch, expect: expect[0]}
+	}
+	if ifMatch != "" {
+		n++
+		// значение можно прислать и как etag в кавычках, и просто так
+		cond = writeCondition{kind: condMatch, expect: strings.TrimSuffix(strings.TrimPrefix(ifMatch, `"`), `"`)}
+	}
+	if ifNoneMatch != "" {
+		if ifNoneMatch != "*" {
+			return cond, errors.New(`only "If-None-Match: *" is supported`)
+		}
+		n++
+		cond = writeCondition{kind: condAbsent}
+	}
+	if raw := r.URL.Query().Get("if-absent"); raw != "" {
+		ifAbsent, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cond, fmt.Errorf("invalid if-absent %q: want true or false", raw)
+		}
+		if ifAbsent {
+			n++
+			cond = writeCondition{kind: condDefault}
+		}
+	}
+	if n > 1 {
+		return cond, errors.New("at most one of If-Match, If-None-Match, ?expect= and ?if-absent= is allowed")
+	}
+	return cond, nil
+}
+
+// conditionalPut отвечает 412, если CAS проиграл, и 409, если ключ для SetIfAbsent уже есть.
+// GetOrSet ничего не проигрывает: 200 с тем, что уже было, или 201 с записанным значением по умолчанию
+func conditionalPut(w http.ResponseWriter, r *http.Request, s storage.Storage, key, value string, cond writeCondition) {
+	if cond.kind == condDefault {
+		value, existed, err := storage.GetOrSet(r.Context(), s, key, value)
+		if errors.Is(err, storage.ErrNotSupported) {
+			err = errConditionalNotSupported
+		}
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		status := http.StatusCreated
+		if existed {
+			status = http.StatusOK
+		}
+		respond(w, r, status, valueResponse{Key: key, Value: value})
+		return
+	}
+
+	cs, ok := storage.As[storage.ConditionalStorage](s)
+	if !ok {
+		storageError(w, r, errConditionalNotSupported)
+		return
+	}
+
+	if cond.kind == condAbsent {
+		set, err := cs.SetIfAbsent(r.Context(), key, value)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		if !set {
+			storageError(w, r, storage.ErrExists)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	swapped, err := cs.CompareAndSwap(r.Context(), key, cond.expect, value)
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+	if !swapped {
+		httpError(w, r, "current value does not match", http.StatusPreconditionFailed)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errIncrementNotSupported = errors.New("storage does not support increment")
+
+// IncrHandler прибавляет ?delta= (по умолчанию 1) к числу в ключе и отдает новое значение
+func IncrHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := writableKeyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		delta := int64(1)
+		if raw := r.URL.Query().Get("delta"); raw != "" {
+			var err error
+			if delta, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				httpError(w, r, fmt.Sprintf("invalid delta %q: %v", raw, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		inc, ok := storage.As[storage.Incrementer](s)
+		if !ok {
+			storageError(w, r, errIncrementNotSupported)
+			return
+		}
+		value, err := inc.Increment(r.Context(), key, delta)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, valueResponse{Key: key, Value: strconv.FormatInt(value, 10)})
+	}
+}
+
+// bodyLimit - сколько читать из тела со значением: лимит хранилки на значение, если он есть, иначе maxBodyBytes
+func bodyLimit(limits storage.Limits, maxBodyBytes int64) int64 {
+	if limits.MaxValueBytes > 0 {
+		return int64(limits.MaxValueBytes)
+	}
+	return maxBodyBytes
+}
+
+var errAppendNotSupported = errors.New("storage does not support append")
+
+// AppendHandler дописывает тело запроса в конец значения и отвечает новой длиной значения.
+// значение, которое переросло бы лимит хранилки, не меняется, а клиент получает 413
+func AppendHandler(s storage.Storage, maxBodyBytes int64) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		limits := limits()
+		key, err := writableKeyVar(r, limits)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, bodyLimit(limits, maxBodyBytes))
+		suffix, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				httpError(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		length, err := storage.Append(r.Context(), s, key, string(suffix))
+		if errors.Is(err, storage.ErrNotSupported) {
+			err = errAppendNotSupported
+		}
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, map[string]any{"key": key, "length": length})
+	}
+}
+
+// DumpHandler отдает все данные одним JSON объектом. энкодер пишет прямо в ответ,
+// так что кроме самой копии данных из хранилки в памяти ничего не копится
+func DumpHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		kv, err := storage.Dump(r.Context(), s)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, kv)
+	}
+}
+
+// как часто WatchHandler шлет комментарий-пинг, чтобы прокси не рвали тихое соединение
+const watchKeepAlive = 15 * time.Second
+
+// WatchHandler отдает изменения ключей с ?prefix= как Server-Sent Events, пока клиент не отключится
+// или сервер не начнет останавливаться (stop). если клиент не успевает читать, поток обрывается - пусть переподключится
+func WatchHandler(s storage.Storage, stop <-chan struct{}) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wt, ok := storage.As[storage.Watcher](s)
+		if !ok {
+			storageError(w, r, storage.ErrNotSupported)
+			return
+		}
+		events, err := wt.Watch(r.Context(), r.URL.Query().Get("prefix"))
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{}) // поток живет дольше WriteTimeout сервера, обрывать его по нему нельзя
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		ping := time.NewTicker(watchKeepAlive)
+		defer ping.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-stop:
+				return
+			case <-ping.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				data, _ := json.Marshal(ev)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Op, data)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// RestoreHandler принимает дамп в формате DumpHandler. ?mode=replace (по умолчанию) заменяет все данные,
+// ?mode=merge только дописывает и перезаписывает ключи из дампа
+func RestoreHandler(s storage.Storage, maxBodyBytes int64) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = "replace"
+		}
+		if mode != "replace" && mode != "merge" {
+			httpError(w, r, fmt.Sprintf("unknown mode %q: want replace or merge", mode), http.StatusBadRequest)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		var kv map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&kv); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				httpError(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			httpError(w, r, fmt.Sprintf("malformed dump: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if mode == "replace" {
+			err = storage.Replace(r.Context(), s, kv)
+		} else {
+			err = s.SetMany(r.Context(), kv)
+		}
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, map[string]int{"restored": len(kv)})
+	}
+}
+
+// ExportCSVHandler отдает все ключи строками key,value, с заголовком, пока нет ?header=false.
+// ответ идет по мере обхода хранилки, так что ошибка посреди него видна только в логе и по обрыву файла
+func ExportCSVHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header, err := csvHeaderParam(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.NewResponseController(w).SetWriteDeadline(time.Time{}) // большая хранилка пишется дольше WriteTimeout
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.csv"`)
+		if _, err := storage.ExportCSV(r.Context(), s, w, header); err != nil && r.Context().Err() == nil {
+			slog.ErrorContext(r.Context(), "unable to export csv", "path", r.URL.Path, "error", err)
+		}
+	}
+}
+
+// ImportCSVHandler загружает CSV в том виде, что отдает ExportCSVHandler: поверх данных (?mode=merge, по умолчанию)
+// или вместо них (?mode=replace). отвечает, сколько строк прочитано и записано и какие строки пропущены
+func ImportCSVHandler(s storage.Storage, maxBodyBytes int64) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = "merge"
+		}
+		if mode != "replace" && mode != "merge" {
+			httpError(w, r, fmt.Sprintf("unknown mode %q: want merge or replace", mode), http.StatusBadRequest)
+			return
+		}
+		header, err := csvHeaderParam(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report, err := storage.ImportCSV(r.Context(), s, http.MaxBytesReader(w, r.Body, maxBodyBytes), header, mode == "replace")
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr):
+			httpError(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, storage.ErrMalformedCSV):
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			storageError(w, r, err)
+		default:
+			respond(w, r, http.StatusOK, report)
+		}
+	}
+}
+
+// csvHeaderParam - ?header=, есть ли у CSV строка заголовка. без параметра - есть
+func csvHeaderParam(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("header")
+	if raw == "" {
+		return true, nil
+	}
+	header, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid header %q: want true or false", raw)
+	}
+	return header, nil
+}
+
+// MultiGetHandler отдает сразу несколько ключей из ?keys=a,b,c.
+// ненайденные ключи не валят запрос, а перечисляются в missing
+func MultiGetHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := strings.Split(r.URL.Query().Get("keys"), ",")
+
+		found, err := s.GetMany(r.Context(), keys)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		missing := make([]string, 0)
+		for _, k := range keys {
+			if _, ok := found[k]; !ok {
+				missing = append(missing, k)
+			}
+		}
+
+		respond(w, r, http.StatusOK, struct {
+			Found   map[string]string `json:"found"`
+			Missing []string          `json:"missing"`
+		}{found, missing})
+	}
+}
+
+// keysPage отдает ?limit= ключей после ?cursor= и курсор следующей страницы.
+// курсор - это последний отданный ключ, так что удаление этого ключа или запись новых между страницами
+// ничего не сдвигает: следующая страница просто начинается с первого ключа больше курсора
+func keysPage(w http.ResponseWriter, r *http.Request, s storage.Storage) {
+	q := r.URL.Query()
+	limit := defaultPageLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			httpError(w, r, fmt.Sprintf("invalid limit %q: must be a positive number", raw), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+	after, err := decodeCursor(q.Get("cursor"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// берем на ключ больше, чтобы понять, есть ли следующая страница
+	keys, err := storage.KeysPage(r.Context(), s, after, limit+1)
+	if err != nil {
+		storageError(w, r, err)
+		return
+	}
+	next := ""
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = encodeCursor(keys[len(keys)-1])
+	}
+
+	respond(w, r, http.StatusOK, struct {
+		Keys       []string `json:"keys"`
+		NextCursor string   `json:"next_cursor,omitempty"`
+	}{keys, next})
+}
+
+// курсор непрозрачный для клиента, чтобы потом можно было положить в него что-то кроме ключа
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (key string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return string(b), nil
+}
+
+// ScanHandler отдает ключи с ?prefix= вместе со значениями, не больше ?limit= штук.
+// truncated == true значит, что под префиксом есть еще ключи. пустой префикс не принимаем:
+// весь набор данных отдает _dump, а случайно выкачать его через скан не хочется
+func ScanHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prefix := r.URL.Query().Get("prefix")
+		if prefix == "" {
+			httpError(w, r, "prefix must not be empty, use _dump to get everything", http.StatusBadRequest)
+			return
+		}
+		limit, err := scanLimit(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		items := make(map[string]string)
+		truncated := false
+		err = storage.Scan(r.Context(), s, prefix, func(key, value string) bool {
+			if len(items) == limit {
+				truncated = true
+				return false
+			}
+			items[key] = value
+			return true
+		})
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		respond(w, r, http.StatusOK, struct {
+			Items     map[string]string `json:"items"`
+			Truncated bool              `json:"truncated"`
+		}{items, truncated})
+	}
+}
+
+// scanLimit разбирает ?limit= скана и поиска
+func scanLimit(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultScanLimit, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 || n > maxScanLimit {
+		return 0, fmt.Errorf("invalid limit %q: want 1..%d", raw, maxScanLimit)
+	}
+	return n, nil
+}
+
+// SearchHandler ищет ключи по ?glob= или ?regex=, не больше ?limit= штук. значения не отдает, за ними - ?keys=.
+// поиск обходит ключи целиком, если у шаблона нет постоянного начала, так что он ограничен по времени searchTimeout
+func SearchHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var p storage.KeyPattern
+		var err error
+		switch {
+		case q.Has("glob") && q.Has("regex"):
+			httpError(w, r, "glob and regex are mutually exclusive", http.StatusBadRequest)
+			return
+		case q.Has("glob"):
+			p, err = storage.GlobPattern(q.Get("glob"))
+		case q.Has("regex"):
+			p, err = storage.RegexpPattern(q.Get("regex"))
+		default:
+			httpError(w, r, "either glob or regex is required", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := scanLimit(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), searchTimeout)
+		defer cancel()
+		keys, truncated, err := storage.Match(ctx, s, p, limit)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, struct {
+			Keys      []string `json:"keys"`
+			Truncated bool     `json:"truncated"`
+		}{keys, truncated})
+	}
+}
+
+// BatchHandler принимает в теле JSON объект с парами ключ-значение и пишет их одним SetMany
+func BatchHandler(s storage.Storage, maxBodyBytes int64) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+		var kv map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&kv); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				httpError(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			httpError(w, r, fmt.Sprintf("malformed batch: %v", err), http.StatusBadRequest)
+			return
+		}
+		for key := range kv {
+			if err := checkWritable(key); err != nil {
+				storageError(w, r, err)
+				return
+			}
+		}
+
+		if err := s.SetMany(r.Context(), kv); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, map[string]int{"written": len(kv)})
+	}
+}
+
+// MetaHandler отдает метаданные ключа: когда его создали, когда последний раз писали и сколько раз.
+// значения в ответе нет, за ним - обычный GET
+func MetaHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		entry, err := storage.GetEntry(r.Context(), s, key)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		resp := metaResponse{
+			Key:         key,
+			CreatedAt:   entry.CreatedAt,
+			UpdatedAt:   entry.UpdatedAt,
+			Writes:      entry.Writes,
+			ValueBytes:  len(entry.Value),
+			ContentType: entry.ContentType,
+		}
+		if !entry.ExpiresAt.IsZero() {
+			resp.ExpiresAt = &entry.ExpiresAt
+		}
+		respond(w, r, http.StatusOK, resp)
+	}
+}
+
+type metaResponse struct {
+	Key         string     `json:"key"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Writes      uint64     `json:"writes"`
+	ValueBytes  int        `json:"value_bytes"`
+	ContentType string     `json:"content_type,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// UndeleteHandler возвращает удаленный ключ. когда вернуть нечего, отвечает 410: надгробие могли уже вычистить
+func UndeleteHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		value, err := storage.Undelete(r.Context(), s, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			httpError(w, r, "key "+strconv.Quote(key)+" has no deleted value to restore: it was never deleted or is already purged", http.StatusGone)
+			return
+		}
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, valueResponse{Key: key, Value: value})
+	}
+}
+
+// RenameHandler переносит ключ из пути в "to" из JSON тела. ключ под новым именем уже есть - 409, если не просили overwrite
+func RenameHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		var req struct {
+			To        string `json:"to"`
+			Overwrite bool   `json:"overwrite"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, defaultMaxBodyBytes)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, fmt.Sprintf("malformed rename request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.To == "" {
+			httpError(w, r, `"to" must not be empty`, http.StatusBadRequest)
+			return
+		}
+		if err := checkWritable(req.To); err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		if err := storage.Rename(r.Context(), s, key, req.To, req.Overwrite); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TxnHandler принимает {"ops": [...]} и применяет их одной транзакцией. не прошла проверка - 409
+// с номером операции в index, и ничего не записано
+func TxnHandler(s storage.Storage, maxBodyBytes int64) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+		var req struct {
+			Ops []storage.TxnOp `json:"ops"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				httpError(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			httpError(w, r, fmt.Sprintf("malformed transaction: %v", err), http.StatusBadRequest)
+			return
+		}
+		for _, op := range req.Ops {
+			if op.Type != storage.TxnSet {
+				continue
+			}
+			if err := checkWritable(op.Key); err != nil {
+				storageError(w, r, err)
+				return
+			}
+		}
+
+		if err := storage.Txn(r.Context(), s, req.Ops); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// VersionHandler отдает одно из значений ключа по номеру записи из ?version=, номера видны в /{key}/_history
+func VersionHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		raw := mux.Vars(r)["version"]
+		version, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			httpError(w, r, fmt.Sprintf("invalid version %q: %v", raw, err), http.StatusBadRequest)
+			return
+		}
+
+		v, err := storage.GetVersion(r.Context(), s, key, version)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		if v.ContentType != "" {
+			w.Header().Set("Content-Type", v.ContentType)
+		}
+		respond(w, r, http.StatusOK, valueResponse{Key: key, Value: v.Value})
+	}
+}
+
+// HistoryHandler отдает текущее и прежние значения ключа, от новых к старым
+func HistoryHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		versions, err := storage.History(r.Context(), s, key)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		resp := historyResponse{Key: key, Versions: make([]versionResponse, 0, len(versions))}
+		for _, v := range versions {
+			resp.Versions = append(resp.Versions, versionResponse{Version: v.Version, Value: v.Value, UpdatedAt: v.UpdatedAt, ContentType: v.ContentType})
+		}
+		respond(w, r, http.StatusOK, resp)
+	}
+}
+
+type historyResponse struct {
+	Key      string            `json:"key"`
+	Versions []versionResponse `json:"versions"`
+}
+
+type versionResponse struct {
+	Version     uint64    `json:"version"`
+	Value       string    `json:"value"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// MarshalJSON - как у valueResponse, двоичное значение уходит в base64
+func (v versionResponse) MarshalJSON() ([]byte, error) {
+	type plain versionResponse
+	if utf8.ValidString(v.Value) {
+		return json.Marshal(plain(v))
+	}
+	p := plain(v)
+	p.Value = base64.StdEncoding.EncodeToString([]byte(v.Value))
+	return json.Marshal(struct {
+		plain
+		Encoding string `json:"encoding"`
+	}{p, "base64"})
+}
+
+// example handler
+func DeleteHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+
+		if err := s.Delete(r.Context(), key); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeletePrefixHandler удаляет все ключи с ?prefix= и отвечает, сколько удалено. без префикса удалялось бы все,
+// так что пустой префикс проходит только вместе с ?confirm=all
+func DeletePrefixHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		prefix := q.Get("prefix")
+		if prefix == "" && q.Get("confirm") != "all" {
+			httpError(w, r, "prefix must not be empty, pass ?confirm=all to delete every key", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := storage.DeletePrefix(r.Context(), s, prefix)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, map[string]int{"deleted": deleted})
+	}
+}
+
+// example handler
+func KeysHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Has("limit") || q.Has("cursor") {
+			keysPage(w, r, s)
+			return
+		}
+
+		keys, err := s.Keys(r.Context())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, keys)
+	}
+}
+
+// BackupHandler снимает копию данных по запросу и отвечает именем файла
+func BackupHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := storage.As[storage.Backuper](s)
+		if !ok {
+			storageError(w, r, storage.ErrNotSupported)
+			return
+		}
+		name, err := b.Backup()
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, map[string]string{"file": name})
+	}
+}
+
+func Reload(s storage.Storage) error {
+	r, ok := storage.As[storage.Reloader](s)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+	return r.Reload()
+}
+
+// ReloadHandler перечитывает данные с диска. если файл битый, отвечает ошибкой, а сервер продолжает работать со старыми данными
+func ReloadHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := Reload(s); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ConfigReload - итог перечитывания конфига: какие настройки применены на ходу, а какие поменялись,
+// но начнут действовать только после перезапуска
+type ConfigReload struct {
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"`
+}
+
+// ConfigReloadHandler перечитывает конфиг через reload. если новый конфиг не прошел проверку, не меняется ничего
+func ConfigReloadHandler(reload func() (ConfigReload, error)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := reload()
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respond(w, r, http.StatusOK, res)
+	}
+}
+
+// CompactHandler запускает компакцию журнала и отвечает, сколько она заняла и сколько места освободила
+func CompactHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := storage.As[storage.Compactor](s)
+		if !ok {
+			storageError(w, r, storage.ErrNotSupported)
+			return
+		}
+		if err := c.Compact(); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		resp := map[string]any{"status": "ok"}
+		if fs, ok := storage.As[*storage.FileStorage](s); ok {
+			d, reclaimed := fs.LastCompaction()
+			resp["duration"] = d.String()
+			resp["reclaimed_bytes"] = reclaimed
+		}
+		respond(w, r, http.StatusOK, resp)
+	}
+}
+
+// started - время запуска процесса для uptime в _stats
+var started = time.Now()
+
+// StatsHandler отдает размер хранилки вместе с uptime процесса
+func StatsHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := storage.Stats(r.Context(), s)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		uptime := time.Since(started)
+		respond(w, r, http.StatusOK, struct {
+			storage.StorageStats
+			Uptime        string  `json:"uptime"`
+			UptimeSeconds float64 `json:"uptime_seconds"`
+		}{stats, uptime.Round(time.Second).String(), uptime.Seconds()})
+	}
+}
+
+// MirrorDiffHandler сверяет копии зеркала и перечисляет разошедшиеся ключи
+func MirrorDiffHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := storage.As[storage.Differ](s)
+		if !ok {
+			storageError(w, r, storage.ErrNotSupported)
+			return
+		}
+		diff, err := d.Diff(r.Context())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, struct {
+			Consistent bool `json:"consistent"`
+			storage.MirrorDiff
+		}{diff.Consistent(), diff})
+	}
+}
+
+// RebalanceHandler переносит ключи шардированной хранилки на их шарды, например после добавления шарда
+func RebalanceHandler(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rb, ok := storage.As[storage.Rebalancer](s)
+		if !ok {
+			storageError(w, r, storage.ErrNotSupported)
+			return
+		}
+		moved, err := rb.Rebalance(r.Context())
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		respond(w, r, http.StatusOK, map[string]int{"moved": moved})
+	}
+}
+
+// ErrBackendNotAllowed - open в SwitchBackendHandler не пускает на этот бэкенд, ответ 403
+var ErrBackendNotAllowed = errors.New("backend is not allowed as a switch target")
+
+// SwitchBackendHandler подменяет бэкенд под s на тот, что описан в теле: {"kind": "bolt", "path": "/data/kv.db", "copy": true}.
+// kind и path - как в -backend, open собирает по ним хранилку. с copy сначала переносит в нее все ключи и, пока
+// переносит, шлет прогресс строками NDJSON. если клиент отключится или копирование упадет, остается старый бэкенд
+func SwitchBackendHandler(sw *storage.SwitchableStorage, s storage.Storage, open func(kind, path string) (storage.Storage, error)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Kind string `json:"kind"`
+			Path string `json:"path"`
+			Copy bool   `json:"copy"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil || req.Kind == "" {
+			httpError(w, r, `expected a JSON body like {"kind": "file", "path": "/data/kv.json", "copy": true}`, http.StatusBadRequest)
+			return
+		}
+		next, err := open(req.Kind, req.Path)
+		if errors.Is(err, ErrBackendNotAllowed) {
+			httpError(w, r, fmt.Sprintf("%s storage at %q is not an allowed switch target", req.Kind, req.Path), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "unable to open storage for backend switch", "kind", req.Kind, "error", err)
+			httpError(w, r, fmt.Sprintf("unable to open %s storage, the server log has the details", req.Kind), http.StatusBadRequest)
+			return
+		}
+
+		var progress func(storage.SwapProgress)
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		if req.Copy {
+			// копирование большой хранилки может идти дольше WriteTimeout сервера
+			rc.SetWriteDeadline(time.Time{})
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			progress = func(p storage.SwapProgress) {
+				enc.Encode(map[string]int{"copied": p.Copied, "total": p.Total})
+				rc.Flush()
+			}
+		}
+		old, err := sw.Swap(r.Context(), next, req.Copy, progress)
+		if err != nil {
+			if c, ok := storage.As[io.Closer](next); ok {
+				c.Close()
+			}
+			slog.ErrorContext(r.Context(), "backend switch failed", "kind", req.Kind, "error", err)
+			if !req.Copy {
+				storageError(w, r, err)
+				return
+			}
+			_, resp := errorClass(err)
+			enc.Encode(resp)
+			return
+		}
+		if cs, ok := storage.As[*storage.CachedStorage](s); ok {
+			cs.Purge()
+		}
+		if c, ok := storage.As[io.Closer](old); ok {
+			if err := c.Close(); err != nil {
+				slog.ErrorContext(r.Context(), "unable to close previous backend", "error", err)
+			}
+		}
+		slog.InfoContext(r.Context(), "backend switched", "kind", req.Kind, "path", req.Path, "copy", req.Copy)
+
+		resp := map[string]any{"status": "switched", "kind": req.Kind}
+		if !req.Copy {
+			respond(w, r, http.StatusOK, resp)
+			return
+		}
+		enc.Encode(resp)
+	}
+}
+
+// ReadOnlyHandler показывает (GET) и переключает (POST с {"read_only": true|false}) режим только для чтения
+func ReadOnlyHandler(readOnly *atomic.Bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req struct {
+				ReadOnly *bool `json:"read_only"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil || req.ReadOnly == nil {
+				httpError(w, r, `expected a JSON body like {"read_only": true}`, http.StatusBadRequest)
+				return
+			}
+			if readOnly.Swap(*req.ReadOnly) != *req.ReadOnly {
+				slog.InfoContext(r.Context(), "read-only mode switched", "read_only", *req.ReadOnly)
+			}
+		}
+		respond(w, r, http.StatusOK, map[string]bool{"read_only": readOnly.Load()})
+	}
+}
+
+// HealthzHandler отвечает 200, пока процесс жив
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// ReadyzHandler проверяет, что хранилка готова: у файла делает Sync, у сетевых бэкендов - ping.
+// хранилкам без Ping (как память) проверять нечего
+func ReadyzHandler(backend string, s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := storage.As[storage.Pinger](s); ok {
+			if err := p.Ping(); err != nil {
+				slog.ErrorContext(r.Context(), "readiness check failed", "backend", backend, "error", err)
+				// причина только в логе: в ней бывают адреса и пути к файлам
+				respond(w, r, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "backend": backend, "code": codeUnavailable})
+				return
+			}
+		}
+		respond(w, r, http.StatusOK, map[string]string{"status": "ok", "backend": backend})
+	}
+}
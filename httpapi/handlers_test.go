@@ -0,0 +1,486 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+	"github.com/Barugoo/example-fs/storagetest"
+)
+
+// newTestServer вешает хендлеры на те же маршруты, что и main, только для одного хранилища
+func newTestServer(t *testing.T, s storage.Storage) *httptest.Server {
+	t.Helper()
+	r := mux.NewRouter()
+	r.HandleFunc("/memory/{key}", httpapi.GetHandler(s)).Methods(http.MethodGet)
+	r.HandleFunc("/memory/{key}", httpapi.PutHandler(s, testMaxBodyBytes)).Methods(http.MethodPut)
+	r.HandleFunc("/memory/_batch", httpapi.BatchHandler(s, testMaxBatchBytes)).Methods(http.MethodPost)
+	r.HandleFunc("/memory/{key}/incr", httpapi.IncrHandler(s)).Methods(http.MethodPost)
+	r.HandleFunc("/memory/{key}/{value}", httpapi.PostHandler(s)).Methods(http.MethodPost)
+	r.HandleFunc("/memory/{key}", httpapi.DeleteHandler(s)).Methods(http.MethodDelete)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// маленькие лимиты, чтобы проверять 413 без мегабайтных тел
+const (
+	testMaxBodyBytes  = 16
+	testMaxBatchBytes = 64
+)
+
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	return doWithHeader(t, method, url, body, nil)
+}
+
+func doWithHeader(t *testing.T, method, url, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestDeleteHandler(t *testing.T) {
+	srv := newTestServer(t, storage.NewMemStorage())
+	for _, tt := range []struct {
+		method, path string
+		wantStatus   int
+	}{
+		{http.MethodPost, "/memory/a/1", http.StatusOK},
+		{http.MethodDelete, "/memory/a", http.StatusNoContent},
+		{http.MethodDelete, "/memory/a", http.StatusNotFound},
+		{http.MethodDelete, "/memory/missing", http.StatusNotFound},
+	} {
+		if status, body := do(t, tt.method, srv.URL+tt.path, ""); status != tt.wantStatus {
+			t.Errorf("%s %s: got %d (%s), want %d", tt.method, tt.path, status, strings.TrimSpace(body), tt.wantStatus)
+		}
+	}
+}
+
+func TestPutHandler(t *testing.T) {
+	srv := newTestServer(t, storage.NewMemStorage())
+	for _, tt := range []struct {
+		name, key, body string
+		wantStatus      int
+	}{
+		{"plain", "a", "1", http.StatusNoContent},
+		{"multiline", "lines", "first\nsecond\n", http.StatusNoContent},
+		{"slashes and spaces", "path", "a/b c/d", http.StatusNoContent},
+		{"empty body", "empty", "", http.StatusNoContent},
+		{"with ttl", "short?ttl=1h30m", "v", http.StatusNoContent},
+		{"bad ttl", "bad?ttl=soon", "v", http.StatusBadRequest},
+		{"zero ttl", "zero?ttl=0s", "v", http.StatusBadRequest},
+		{"negative ttl", "negative?ttl=-1s", "v", http.StatusBadRequest},
+		{"too large", "big", strings.Repeat("x", testMaxBodyBytes+1), http.StatusRequestEntityTooLarge},
+	} {
+		status, body := do(t, http.MethodPut, srv.URL+"/memory/"+tt.key, tt.body)
+		if status != tt.wantStatus {
+			t.Errorf("%s: PUT got %d (%s), want %d", tt.name, status, strings.TrimSpace(body), tt.wantStatus)
+			continue
+		}
+		if status != http.StatusNoContent {
+			if status, _ = do(t, http.MethodGet, srv.URL+"/memory/"+tt.key, ""); status != http.StatusNotFound {
+				t.Errorf("%s: rejected value was stored, GET got %d", tt.name, status)
+			}
+			continue
+		}
+		// значение возвращается байт в байт, пустое тело - это пустое значение, а не ошибка
+		if status, got := do(t, http.MethodGet, srv.URL+"/memory/"+tt.key, ""); status != http.StatusOK || got != tt.body {
+			t.Errorf("%s: GET got %d %q, want %q", tt.name, status, got, tt.body)
+		}
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name, body string
+		wantStatus int
+		wantBody   string
+	}{
+		{"two keys", `{"a":"1","b":"2"}`, http.StatusOK, `{"written":2}`},
+		{"empty batch", `{}`, http.StatusOK, `{"written":0}`},
+		{"not an object", `["a","b"]`, http.StatusBadRequest, ""},
+		{"not a string value", `{"a":1}`, http.StatusBadRequest, ""},
+		{"malformed", `{"a":`, http.StatusBadRequest, ""},
+		{"too large", `{"a":"` + strings.Repeat("x", testMaxBatchBytes) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	} {
+		s := storage.NewMemStorage()
+		srv := newTestServer(t, s)
+		status, body := do(t, http.MethodPost, srv.URL+"/memory/_batch", tt.body)
+		if status != tt.wantStatus || (tt.wantBody != "" && strings.TrimSpace(body) != tt.wantBody) {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, body, tt.wantStatus, tt.wantBody)
+		}
+		keys, err := s.Keys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// отклоненный батч не пишет ничего, даже частично
+		var want map[string]string
+		json.Unmarshal([]byte(tt.body), &want)
+		if status != http.StatusOK {
+			want = nil
+		}
+		if len(keys) != len(want) {
+			t.Errorf("%s: stored keys %v, want %d", tt.name, keys, len(want))
+		}
+		for k, v := range want {
+			if got, err := s.Get(ctx, k); err != nil || got != v {
+				t.Errorf("%s: Get(%s) = %q, %v, want %q", tt.name, k, got, err, v)
+			}
+		}
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	srv := newTestServer(t, storage.NewMemStorage())
+	if status, _ := do(t, http.MethodPut, srv.URL+"/memory/k", "1"); status != http.StatusNoContent {
+		t.Fatalf("seed PUT: %d", status)
+	}
+	for _, tt := range []struct {
+		name       string
+		query      string
+		header     http.Header
+		body       string
+		wantStatus int
+		wantValue  string
+	}{
+		{"cas by expect", "?expect=1", nil, "2", http.StatusNoContent, "2"},
+		{"cas lost", "?expect=1", nil, "3", http.StatusPreconditionFailed, "2"},
+		{"cas by if-match", "", http.Header{"If-Match": {`"2"`}}, "4", http.StatusNoContent, "4"},
+		{"absent on existing key", "", http.Header{"If-None-Match": {"*"}}, "5", http.StatusConflict, "4"},
+		{"if-none-match with an etag", "", http.Header{"If-None-Match": {`"4"`}}, "6", http.StatusBadRequest, "4"},
+		{"two conditions", "?expect=4", http.Header{"If-Match": {"4"}}, "7", http.StatusBadRequest, "4"},
+		{"condition with ttl", "?expect=4&ttl=1m", nil, "8", http.StatusBadRequest, "4"},
+	} {
+		status, body := doWithHeader(t, http.MethodPut, srv.URL+"/memory/k"+tt.query, tt.body, tt.header)
+		if status != tt.wantStatus {
+			t.Errorf("%s: got %d (%s), want %d", tt.name, status, strings.TrimSpace(body), tt.wantStatus)
+		}
+		if _, v := do(t, http.MethodGet, srv.URL+"/memory/k", ""); v != tt.wantValue {
+			t.Errorf("%s: value %q, want %q", tt.name, v, tt.wantValue)
+		}
+	}
+
+	if status, _ := doWithHeader(t, http.MethodPut, srv.URL+"/memory/fresh", "v", http.Header{"If-None-Match": {"*"}}); status != http.StatusCreated {
+		t.Errorf("If-None-Match on a new key: got %d, want 201", status)
+	}
+}
+
+func TestIncrHandler(t *testing.T) {
+	srv := newTestServer(t, storage.NewMemStorage())
+	do(t, http.MethodPut, srv.URL+"/memory/text", "abc")
+	for _, tt := range []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/memory/n/incr", http.StatusOK, "1"},
+		{"/memory/n/incr?delta=10", http.StatusOK, "11"},
+		{"/memory/n/incr?delta=-12", http.StatusOK, "-1"},
+		{"/memory/n/incr?delta=1.5", http.StatusBadRequest, ""},
+		{"/memory/text/incr", http.StatusConflict, ""},
+	} {
+		status, body := do(t, http.MethodPost, srv.URL+tt.path, "")
+		if status != tt.wantStatus || (tt.wantBody != "" && body != tt.wantBody) {
+			t.Errorf("POST %s: got %d %q, want %d %q", tt.path, status, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
+
+// brokenStorage отвечает на Get заданной ошибкой
+type brokenStorage struct {
+	storage.Storage
+	err error
+}
+
+func (bs brokenStorage) Get(context.Context, string) (string, error) { return "", bs.err }
+
+func TestGetHandler(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemStorage()
+	if err := mem.Set(ctx, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name       string
+		s          storage.Storage
+		key        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"found", mem, "a", http.StatusOK, "1"},
+		{"missing", mem, "missing", http.StatusNotFound, `{"code":"NOT_FOUND","message":"not found"}`},
+		{"wrapped not found", brokenStorage{mem, fmt.Errorf("lookup: %w", storage.ErrNotFound)}, "a", http.StatusNotFound, `{"code":"NOT_FOUND","message":"not found"}`},
+		// подробности ошибки бэкенда остаются в логе, клиенту уходит только код
+		{"other error", brokenStorage{mem, errors.New("disk on fire")}, "a", http.StatusInternalServerError, `{"code":"BACKEND_ERROR","message":"internal storage error"}`},
+	} {
+		srv := newTestServer(t, tt.s)
+		status, body := do(t, http.MethodGet, srv.URL+"/memory/"+tt.key, "")
+		if status != tt.wantStatus || strings.TrimSpace(body) != tt.wantBody {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
+
+// двоичное значение текстом уходит байт в байт, а в JSON - в base64 с полем encoding
+func TestGetHandlerBinary(t *testing.T) {
+	ctx := context.Background()
+	const png = "\x89PNG\r\n\x1a\n\x00\xff"
+	mem := storage.NewMemStorage()
+	if err := mem.Set(ctx, "img", png); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Set(ctx, "txt", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, mem)
+
+	resp, err := http.Get(srv.URL + "/memory/img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != png || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("text GET = %q (%s), want %q (image/png)", b, resp.Header.Get("Content-Type"), png)
+	}
+
+	for _, tt := range []struct {
+		key, wantValue, wantEncoding string
+	}{
+		{"img", base64.StdEncoding.EncodeToString([]byte(png)), "base64"},
+		{"txt", "hello", ""},
+	} {
+		_, body := doWithHeader(t, http.MethodGet, srv.URL+"/memory/"+tt.key, "", http.Header{"Accept": {"application/json"}})
+		var got struct{ Key, Value, Encoding string }
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatalf("%s: %v in %q", tt.key, err, body)
+		}
+		if got.Key != tt.key || got.Value != tt.wantValue || got.Encoding != tt.wantEncoding {
+			t.Errorf("JSON GET %s = %+v, want value %q encoding %q", tt.key, got, tt.wantValue, tt.wantEncoding)
+		}
+	}
+}
+
+func TestLongPollHandler(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemStorage()
+	stop := make(chan struct{})
+	srv := httptest.NewServer(httpapi.NewRouter("/memory", mem, stop))
+	t.Cleanup(srv.Close)
+	if err := mem.Set(ctx, "k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	rev := storage.Revision("v1")
+	for _, tt := range []struct {
+		name       string
+		query      string
+		change     func() // что сделать, пока GET ждет
+		wantStatus int
+		wantBody   string
+	}{
+		{"without since", "?wait=1s", nil, http.StatusOK, "v1"},
+		{"stale since", "?wait=1s&since=old", nil, http.StatusOK, "v1"},
+		{"no change", "?wait=50ms&since=" + rev, nil, http.StatusNotModified, ""},
+		{"quoted etag", `?wait=50ms&since="` + rev + `"`, nil, http.StatusNotModified, ""},
+		{"changed", "?wait=5s&since=" + rev, func() { mem.Set(ctx, "k", "v2") }, http.StatusOK, "v2"},
+		{"deleted", "?wait=5s&since=" + storage.Revision("v2"), func() { mem.Delete(ctx, "k") }, http.StatusNotFound, ""},
+		{"bad wait", "?wait=forever", nil, http.StatusBadRequest, ""},
+		{"too long wait", "?wait=1h", nil, http.StatusBadRequest, ""},
+	} {
+		if tt.change != nil {
+			time.AfterFunc(50*time.Millisecond, tt.change)
+		}
+		status, body := do(t, http.MethodGet, srv.URL+"/memory/k"+tt.query, "")
+		if status != tt.wantStatus || (tt.wantBody != "" && body != tt.wantBody) {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+
+	// остановка сервера отпускает ожидающих с 304, не дожидаясь wait
+	mem.Set(ctx, "k", "v3")
+	time.AfterFunc(50*time.Millisecond, func() { close(stop) })
+	start := time.Now()
+	if status, _ := do(t, http.MethodGet, srv.URL+"/memory/k?wait=1m&since="+storage.Revision("v3"), ""); status != http.StatusNotModified || time.Since(start) > 10*time.Second {
+		t.Errorf("shutdown: got %d after %v, want 304 right away", status, time.Since(start))
+	}
+}
+
+func TestPutGetDelete(t *testing.T) {
+	srv := storagetest.NewServer(t, "/memory", storage.NewMemStorage())
+	if status, _ := do(t, http.MethodPut, srv.URL+"/memory/a", "hello/world"); status != http.StatusNoContent {
+		t.Fatalf("PUT: got %d", status)
+	}
+	if status, body := do(t, http.MethodGet, srv.URL+"/memory/a", ""); status != http.StatusOK || body != "hello/world" {
+		t.Fatalf("GET: got %d %q", status, body)
+	}
+	if status, _ := do(t, http.MethodDelete, srv.URL+"/memory/a", ""); status != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d", status)
+	}
+	if status, _ := do(t, http.MethodGet, srv.URL+"/memory/a", ""); status != http.StatusNotFound {
+		t.Fatalf("GET after DELETE: got %d", status)
+	}
+}
+
+func TestLimitsReload(t *testing.T) {
+	limits := new(atomic.Pointer[storage.Limits])
+	limits.Store(&storage.Limits{MaxKeyBytes: 5, MaxValueBytes: 10})
+	srv := storagetest.NewServer(t, "/memory", storage.NewLimitedStorage(storage.NewMemStorage(), limits))
+
+	value := strings.Repeat("v", 50)
+	if status, _ := do(t, http.MethodPut, srv.URL+"/memory/a", value); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("PUT over the value limit: got %d, want 413", status)
+	}
+	if status, _ := do(t, http.MethodPut, srv.URL+"/memory/123456789", "v"); status != http.StatusRequestURITooLong {
+		t.Fatalf("PUT over the key limit: got %d, want 414", status)
+	}
+
+	// хендлеры собраны один раз, а новые лимиты должны действовать сразу
+	limits.Store(&storage.Limits{MaxKeyBytes: 50, MaxValueBytes: 100})
+	if status, body := do(t, http.MethodPut, srv.URL+"/memory/a", value); status != http.StatusNoContent {
+		t.Fatalf("PUT after raising the value limit: got %d %s", status, body)
+	}
+	if status, body := do(t, http.MethodPut, srv.URL+"/memory/123456789", "v"); status != http.StatusNoContent {
+		t.Fatalf("PUT after raising the key limit: got %d %s", status, body)
+	}
+	if status, body := do(t, http.MethodPatch, srv.URL+"/memory/a", value); status != http.StatusOK {
+		t.Fatalf("PATCH after raising the value limit: got %d %s", status, body)
+	}
+}
+
+// кэш перед хранилкой не должен прятать ее ttl, счетчики и условные записи
+func TestCachedStorageCapabilities(t *testing.T) {
+	cached, err := storage.NewCachedStorage(storage.NewMemStorage(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := storagetest.NewServer(t, "/memory", cached)
+	if status, body := do(t, http.MethodPut, srv.URL+"/memory/a?ttl=1m", "v"); status != http.StatusNoContent {
+		t.Errorf("PUT ?ttl=: got %d %s", status, body)
+	}
+	if status, body := do(t, http.MethodPost, srv.URL+"/memory/n/incr", ""); status != http.StatusOK || !strings.Contains(body, "1") {
+		t.Errorf("POST /incr: got %d %s", status, body)
+	}
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/memory/new", strings.NewReader("v"))
+	req.Header.Set("If-None-Match", "*")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		t.Errorf("PUT If-None-Match: *: got %d", resp.StatusCode)
+	}
+}
+
+// POST /{key}/incr и другие действия - не запись значения через путь, даже закодированного иначе
+func TestLegacyPostReservedValues(t *testing.T) {
+	s := storage.NewMemStorage()
+	srv := storagetest.NewServer(t, "/memory", s)
+	if status, body := do(t, http.MethodPost, srv.URL+"/memory/k/hello", ""); status != http.StatusOK {
+		t.Fatalf("legacy POST: got %d %s", status, body)
+	}
+	if status, body := do(t, http.MethodPost, srv.URL+"/memory/n/incr", ""); status != http.StatusOK || !strings.Contains(body, "1") {
+		t.Fatalf("POST /incr: got %d %s", status, body)
+	}
+	for _, v := range []string{"%69ncr", "_ren%61me", "%5Fappend", "%5Fundelete"} {
+		if status, body := do(t, http.MethodPost, srv.URL+"/memory/k/"+v, ""); status != http.StatusBadRequest {
+			t.Errorf("legacy POST of %s: got %d %s, want 400", v, status, body)
+		}
+	}
+	if status, body := do(t, http.MethodGet, srv.URL+"/memory/k", ""); status != http.StatusOK || body != "hello" {
+		t.Errorf("GET after the rejected writes: got %d %q", status, body)
+	}
+}
+
+// лимиты тела из опций роутера доходят до хендлеров записи
+func TestRouterBodyLimits(t *testing.T) {
+	r := httpapi.NewRouter("/memory", storage.NewMemStorage(), nil, httpapi.WithMaxBodyBytes(4), httpapi.WithMaxBatchBytes(16))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"put", http.MethodPut, "/memory/a", "1234", http.StatusNoContent},
+		{"put too large", http.MethodPut, "/memory/a", "12345", http.StatusRequestEntityTooLarge},
+		{"append too large", http.MethodPatch, "/memory/a", "12345", http.StatusRequestEntityTooLarge},
+		{"batch", http.MethodPost, "/memory/_batch", `{"b":"1"}`, http.StatusOK},
+		{"batch too large", http.MethodPost, "/memory/_batch", `{"b":"1234567890"}`, http.StatusRequestEntityTooLarge},
+	} {
+		if status, body := do(t, tt.method, srv.URL+tt.path, tt.body); status != tt.want {
+			t.Errorf("%s: got %d (%s), want %d", tt.name, status, body, tt.want)
+		}
+	}
+}
+
+// ключ с _ в начале перекрыл бы служебный маршрут, поэтому HTTP его не пишет, а уже лежащий читается и удаляется
+func TestReservedKeys(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemStorage()
+	mem.Set(ctx, "_old", "1")
+	srv := httptest.NewServer(httpapi.NewRouter("/memory", mem, nil))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name, method, path, body string
+	}{
+		{"put", http.MethodPut, "/memory/_dump", "x"},
+		{"put encoded", http.MethodPut, "/memory/%5Fdump", "x"},
+		{"legacy post", http.MethodPost, "/memory/_stats/x", ""},
+		{"append", http.MethodPatch, "/memory/_x", "x"},
+		{"incr", http.MethodPost, "/memory/_n/incr", ""},
+		{"batch", http.MethodPost, "/memory/_batch", `{"a":"1","_watch":"2"}`},
+		{"txn", http.MethodPost, "/memory/_txn", `{"ops":[{"type":"set","key":"_search","value":"1"}]}`},
+		{"rename", http.MethodPost, "/memory/_old/_rename", `{"to":"_restore"}`},
+	} {
+		status, body := do(t, tt.method, srv.URL+tt.path, tt.body)
+		if status != http.StatusBadRequest || !strings.Contains(body, "INVALID_KEY") {
+			t.Errorf("%s: got %d (%s), want 400 INVALID_KEY", tt.name, status, body)
+		}
+	}
+	if keys, _ := mem.Keys(ctx); len(keys) != 1 {
+		t.Errorf("got keys %v, want only _old", keys)
+	}
+
+	if status, body := do(t, http.MethodGet, srv.URL+"/memory/_old", ""); status != http.StatusOK || body != "1" {
+		t.Errorf("GET of an existing reserved key: %d %q", status, body)
+	}
+	if status, _ := do(t, http.MethodDelete, srv.URL+"/memory/_old", ""); status != http.StatusNoContent {
+		t.Errorf("DELETE of an existing reserved key: %d", status)
+	}
+	// в середине ключа _ ничему не мешает
+	if status, _ := do(t, http.MethodPut, srv.URL+"/memory/a_b", "1"); status != http.StatusNoContent {
+		t.Errorf("PUT a_b: %d", status)
+	}
+}
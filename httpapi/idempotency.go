@@ -0,0 +1,230 @@
+package httpapi
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	maxIdempotencyKey = 255
+
+	// ответы на запись маленькие, а больший ответ не держим в памяти ради повтора
+	maxReplayBytes = 1 << 20
+
+	// тело больше самого большого, что принимают хендлеры, для сравнения повтора не дочитываем
+	maxFingerprintBytes = defaultMaxRestoreBytes
+)
+
+// IdempotencyCache помнит ответы на запись с заголовком Idempotency-Key. повтор запроса с тем же ключом
+// получает сохраненный ответ, а не выполняет запись еще раз. ключи свои у каждого клиента, так что
+// чужой ответ по угаданному ключу не получить. ttl у всех ключей один, поэтому порядок добавления
+// совпадает с порядком истечения и старые ключи выкидываются с начала списка
+type IdempotencyCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*idempotentEntry
+	order   *list.List // ключи entries от старых к новым
+}
+
+type idempotentEntry struct {
+	id          string
+	elem        *list.Element
+	fingerprint string // метод и путь запроса, для которого выдан ключ
+	expires     time.Time
+	done        chan struct{} // закрывается, когда первый запрос ответил
+
+	// заполняются до закрытия done
+	status     int
+	header     http.Header
+	body       []byte
+	bodyHash   []byte // sha256 тела запроса, с ним сравниваются тела повторов
+	replayable bool
+}
+
+func NewIdempotencyCache(ttl time.Duration, maxEntries int) *IdempotencyCache {
+	return &IdempotencyCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]*idempotentEntry), order: list.New()}
+}
+
+// Middleware выполняет запись с новым ключом и запоминает ответ. одновременный дубль ждет первого запроса
+// и получает его ответ с заголовком Idempotent-Replayed: true. ответы 5xx не запоминаются, такой запрос можно повторить.
+// повтор с тем же ключом, но другим методом, путем или телом получает 422, а не чужой ответ. тело первого
+// запроса хешируется по мере того, как его читает хендлер, так что целиком в памяти его не держим.
+// клиента берет из контекста, так что IdentifyClients должен стоять раньше
+func (ic *IdempotencyCache) Middleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyHeader)
+			if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				httpError(w, r, "Idempotency-Key must not be longer than "+strconv.Itoa(maxIdempotencyKey)+" bytes", http.StatusBadRequest)
+				return
+			}
+
+			fingerprint := r.Method + " " + r.URL.RequestURI()
+			e, first := ic.claim(storage.ClientFrom(r.Context())+"\x00"+key, fingerprint, time.Now())
+			if first {
+				rec := &replayRecorder{ResponseWriter: w}
+				body := &hashingBody{ReadCloser: r.Body, h: sha256.New()}
+				r.Body = body
+				completed := false
+				defer func() { ic.finish(e, rec, body, completed) }() // и при панике хендлера, чтобы дубли не ждали вечно
+				next.ServeHTTP(rec, r)
+				completed = true
+				return
+			}
+			if e.fingerprint != fingerprint {
+				httpError(w, r, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			h := sha256.New()
+			if n, err := io.Copy(h, io.LimitReader(r.Body, maxFingerprintBytes+1)); err != nil || n > maxFingerprintBytes {
+				httpError(w, r, "unable to read the request body", http.StatusBadRequest)
+				return
+			}
+			select {
+			case <-e.done:
+			case <-r.Context().Done():
+				return
+			}
+			if e.bodyHash != nil && !bytes.Equal(e.bodyHash, h.Sum(nil)) {
+				httpError(w, r, "Idempotency-Key was already used for a request with a different body", http.StatusUnprocessableEntity)
+				return
+			}
+			if !e.replayable {
+				httpError(w, r, "request with this Idempotency-Key failed or its response is too large to replay, retry it", http.StatusConflict)
+				return
+			}
+			for k, v := range e.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+		})
+	}
+}
+
+// claim находит запись по ключу или заводит новую. first == true - запрос с этим ключом первый и его надо выполнить
+func (ic *IdempotencyCache) claim(id, fingerprint string, now time.Time) (e *idempotentEntry, first bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	// чистим по ходу запросов, как и RateLimiter: истекшие всегда в начале списка
+	for front := ic.order.Front(); front != nil; front = ic.order.Front() {
+		old := front.Value.(*idempotentEntry)
+		if now.Before(old.expires) && ic.order.Len() < ic.maxEntries {
+			break
+		}
+		ic.remove(old)
+	}
+
+	if e, ok := ic.entries[id]; ok {
+		return e, false
+	}
+	e = &idempotentEntry{id: id, fingerprint: fingerprint, expires: now.Add(ic.ttl), done: make(chan struct{})}
+	e.elem = ic.order.PushBack(e)
+	ic.entries[id] = e
+	return e, true
+}
+
+// remove выкидывает запись, вызывается под ic.mu. тот, кто ее уже ждет, все равно получит ответ
+func (ic *IdempotencyCache) remove(e *idempotentEntry) {
+	if ic.entries[e.id] == e {
+		delete(ic.entries, e.id)
+		ic.order.Remove(e.elem)
+	}
+}
+
+// finish сохраняет ответ первого запроса и будит дубли. хендлер мог ответить, не дочитав тело,
+// так что остаток дочитываем сами: иначе хеш не совпал бы с тем же телом в повторе
+func (ic *IdempotencyCache) finish(e *idempotentEntry, rec *replayRecorder, body *hashingBody, completed bool) {
+	bodyHash, hashed := body.sum()
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if completed && rec.status == 0 { // хендлер ничего не написал - net/http ответит 200 без тела
+		rec.status, rec.header = http.StatusOK, rec.ResponseWriter.Header().Clone()
+	}
+	e.status, e.header, e.body, e.bodyHash = rec.status, rec.header, rec.body, bodyHash
+	e.replayable = rec.status != 0 && rec.status < http.StatusInternalServerError && !rec.overflow && hashed
+	if !e.replayable {
+		ic.remove(e)
+	}
+	close(e.done)
+}
+
+// hashingBody считает sha256 тела запроса, пока его читают
+type hashingBody struct {
+	io.ReadCloser
+	h    hash.Hash
+	read int64
+}
+
+func (hb *hashingBody) Read(p []byte) (n int, err error) {
+	n, err = hb.ReadCloser.Read(p)
+	hb.h.Write(p[:n])
+	hb.read += int64(n)
+	return n, err
+}
+
+// sum дочитывает тело и отдает его хеш. ok == false - тело не дочитать или оно больше maxFingerprintBytes,
+// тогда с повтором его не сравнить
+func (hb *hashingBody) sum() (sum []byte, ok bool) {
+	if _, err := io.Copy(io.Discard, io.LimitReader(hb, maxFingerprintBytes+1-hb.read)); err != nil || hb.read > maxFingerprintBytes {
+		return nil, false
+	}
+	return hb.h.Sum(nil), true
+}
+
+// replayRecorder пишет ответ клиенту и заодно копит его для повторов
+type replayRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool // ответ больше maxReplayBytes, повторить его не выйдет
+}
+
+func (rr *replayRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+		rr.header = rr.ResponseWriter.Header().Clone()
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *replayRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.WriteHeader(http.StatusOK)
+	}
+	if !rr.overflow {
+		if len(rr.body)+len(b) > maxReplayBytes {
+			rr.overflow, rr.body = true, nil
+		} else {
+			rr.body = append(rr.body, b...)
+		}
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+func (rr *replayRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
@@ -0,0 +1,111 @@
+package httpapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+func idempotentServer(t *testing.T, s storage.Storage) *httptest.Server {
+	t.Helper()
+	r := mux.NewRouter().UseEncodedPath()
+	r.HandleFunc("/memory/{key}", httpapi.PutHandler(s, 1<<20)).Methods(http.MethodPut)
+	r.Use(httpapi.NewIdempotencyCache(time.Minute, 100).Middleware())
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func putWithKey(t *testing.T, url, key, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	ctx := t.Context()
+	s := storage.NewMemStorage()
+	srv := idempotentServer(t, s)
+
+	if resp := putWithKey(t, srv.URL+"/memory/a", "k1", "one"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first PUT: %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	s.Set(ctx, "a", "changed")
+	if resp := putWithKey(t, srv.URL+"/memory/a", "k1", "one"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("repeated PUT: %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	if v, _ := s.Get(ctx, "a"); v != "changed" {
+		t.Errorf("a replay wrote the value again: %q", v)
+	}
+
+	// тот же ключ с другим телом или путем - другой запрос, а не повтор
+	if resp := putWithKey(t, srv.URL+"/memory/a", "k1", "two"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("same key, different body: got %d, want 422", resp.StatusCode)
+	}
+	if resp := putWithKey(t, srv.URL+"/memory/b", "k1", "one"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("same key, different path: got %d, want 422", resp.StatusCode)
+	}
+	if v, _ := s.Get(ctx, "a"); v != "changed" {
+		t.Errorf("a rejected request wrote the value: %q", v)
+	}
+}
+
+// одновременные дубли ждут первого запроса, а запись выполняется один раз
+func TestIdempotencyConcurrent(t *testing.T) {
+	var writes atomic.Int32
+	s := &countingStorage{Storage: storage.NewMemStorage(), sets: &writes}
+	srv := idempotentServer(t, s)
+
+	var wg sync.WaitGroup
+	var replayed atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := putWithKey(t, srv.URL+"/memory/a", "same", "v")
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("PUT: got %d", resp.StatusCode)
+			}
+			if resp.Header.Get("Idempotent-Replayed") == "true" {
+				replayed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := writes.Load(); n != 1 {
+		t.Errorf("storage got %d writes, want 1", n)
+	}
+	if n := replayed.Load(); n != 19 {
+		t.Errorf("%d responses were replayed, want 19", n)
+	}
+}
+
+type countingStorage struct {
+	storage.Storage
+	sets *atomic.Int32
+}
+
+func (cs *countingStorage) Set(ctx context.Context, key, value string) error {
+	cs.sets.Add(1)
+	time.Sleep(10 * time.Millisecond) // чтобы дубли застали запрос в работе
+	return cs.Storage.Set(ctx, key, value)
+}
@@ -0,0 +1,245 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// statusRecorder запоминает код ответа и сколько байт ушло клиенту - сам http.ResponseWriter их не отдает
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += n
+	return n, err
+}
+
+// Unwrap нужен http.ResponseController, чтобы добраться до Flush и прочего у настоящего writer'а
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// LogRequests пишет по строчке на каждый запрос
+func LogRequests(logger *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", routeTemplate(r)),
+				slog.Int("status", rec.status),
+				slog.Int("size", rec.size),
+				slog.Duration("duration", time.Since(start)),
+			)
+		})
+	}
+}
+
+// IdentifyClients кладет в контекст запроса, кто его прислал, - адрес и логин из Basic, если есть.
+// оттуда клиента берет журнал аудита хранилки. пароль и токен в контекст, конечно, не попадают
+func IdentifyClients(trustProxy bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientIP(r, trustProxy)
+			if user, _, ok := r.BasicAuth(); ok {
+				client = user + "@" + client
+			}
+			next.ServeHTTP(w, r.WithContext(storage.WithClient(r.Context(), client)))
+		})
+	}
+}
+
+// самый длинный X-Request-ID клиента, который мы принимаем, длиннее - генерируем свой
+const maxRequestID = 128
+
+// RequestID берет id запроса из X-Request-ID или генерирует UUID, кладет его в контекст и отдает в ответе.
+// по нему строки лога, журнал аудита и спаны одного запроса находятся вместе, а клиент видит его в ошибках
+func RequestID() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get("X-Request-ID")
+			if !validRequestID(id) {
+				id = uuid.NewString()
+			}
+			w.Header().Set("X-Request-ID", id)
+			next.ServeHTTP(w, r.WithContext(storage.WithRequestID(r.Context(), id)))
+		})
+	}
+}
+
+// validRequestID - id клиента попадет в логи и заголовки как есть, так что пускаем только видимый ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// LogContext дописывает к каждой строке лога request_id из контекста, если он там есть.
+// строки без контекста (log.Println хранилок) остаются как были
+func LogContext(h slog.Handler) slog.Handler {
+	return contextHandler{h}
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := storage.RequestIDFrom(ctx); id != "" {
+		rec = rec.Clone()
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// routeTemplate - шаблон маршрута вроде /file/{key}, чтобы у метрик не было метки на каждый ключ
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
+
+// Metrics считает запросы и их длительность по маршруту, методу и коду ответа
+func Metrics(reg prometheus.Registerer) mux.MiddlewareFunc {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "examplefs_http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "examplefs_http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	reg.MustRegister(requests, latency)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			labels := []string{routeTemplate(r), r.Method, strconv.Itoa(rec.status)}
+			requests.WithLabelValues(labels...).Inc()
+			latency.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// RequireAuth пускает запись только с учетными данными из creds, а чтение - тоже, если protectReads.
+// без учетных данных отвечаем 401 с WWW-Authenticate, с неверными - 403. пути из public (пробы и т.п.) не проверяются.
+// creds читаются на каждом запросе: их подменяет перечитывание конфига, а пустые выключают проверку
+func RequireAuth(creds *atomic.Pointer[auth.Credentials], protectReads bool, public ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			creds := creds.Load()
+			read := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+			if !creds.Enabled() || (read && !protectReads) || slices.Contains(public, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if authorized(w, r, creds) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// RequireAdmin - проверка для /admin: учетные данные нужны на любой запрос, и на GET тоже, а без них
+// в конфиге админка закрыта совсем. эти ручки меняют весь сервер, открытыми их оставлять нельзя даже на время
+func RequireAdmin(creds *atomic.Pointer[auth.Credentials]) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			creds := creds.Load()
+			if !creds.Enabled() {
+				httpError(w, r, "admin endpoints are disabled: no credentials are configured", http.StatusForbidden)
+				return
+			}
+			if authorized(w, r, creds) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// authorized проверяет Authorization запроса по creds, а если не прошел - сам отвечает 401 или 403
+func authorized(w http.ResponseWriter, r *http.Request, creds *auth.Credentials) bool {
+	switch err := creds.Check(r.Header.Get("Authorization")); {
+	case errors.Is(err, auth.ErrNoCredentials):
+		for _, ch := range creds.Challenges("examplefs") {
+			w.Header().Add("WWW-Authenticate", ch)
+		}
+		httpError(w, r, err.Error(), http.StatusUnauthorized)
+	case err != nil:
+		httpError(w, r, err.Error(), http.StatusForbidden)
+	default:
+		return true
+	}
+	return false
+}
+
+// RejectWritesWhileDraining отвечает 503 на запись, пока сервер останавливается:
+// уже начатые запросы дорабатывают, а новые изменения мы не принимаем, чтобы не потерять их при закрытии хранилок
+func RejectWritesWhileDraining(draining *atomic.Bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if draining.Load() && r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Connection", "close")
+				httpError(w, r, "server is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
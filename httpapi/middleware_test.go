@@ -0,0 +1,91 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+func TestRequireAuth(t *testing.T) {
+	creds := new(atomic.Pointer[auth.Credentials])
+	creds.Store(&auth.Credentials{Token: "secret", User: "admin", Pass: "pw"})
+	for _, tt := range []struct {
+		name          string
+		protectReads  bool
+		method, path  string
+		authorization string
+		wantStatus    int
+	}{
+		{"read is open", false, http.MethodGet, "/memory", "", http.StatusOK},
+		{"write without credentials", false, http.MethodPut, "/memory/k", "", http.StatusUnauthorized},
+		{"write with a wrong token", false, http.MethodPut, "/memory/k", "Bearer wrong", http.StatusForbidden},
+		{"write with the token", false, http.MethodPut, "/memory/k", "Bearer secret", http.StatusNoContent},
+		{"delete without credentials", false, http.MethodDelete, "/memory/k", "", http.StatusUnauthorized},
+		{"protected read without credentials", true, http.MethodGet, "/memory", "", http.StatusUnauthorized},
+		{"protected read with basic auth", true, http.MethodGet, "/memory", "Basic YWRtaW46cHc=", http.StatusOK},
+		{"public path", true, http.MethodGet, "/healthz", "", http.StatusOK},
+	} {
+		r := httpapi.NewRouter("/memory", storage.NewMemStorage(), nil)
+		r.HandleFunc("/healthz", httpapi.HealthzHandler)
+		r.Use(httpapi.RequireAuth(creds, tt.protectReads, "/healthz"))
+
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: got %d (%s), want %d", tt.name, rec.Code, rec.Body, tt.wantStatus)
+		}
+		if rec.Code == http.StatusUnauthorized && len(rec.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("%s: WWW-Authenticate %q, want both schemes", tt.name, rec.Header().Values("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	creds := new(atomic.Pointer[auth.Credentials])
+	creds.Store(&auth.Credentials{})
+	r := mux.NewRouter()
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(httpapi.RequireAdmin(creds))
+	admin.HandleFunc("/readonly", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet, http.MethodPost)
+
+	do := func(method, header string) int {
+		req := httptest.NewRequest(method, "/admin/readonly", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// без учетных данных в конфиге админка закрыта, а не открыта всем
+	if got := do(http.MethodPost, ""); got != http.StatusForbidden {
+		t.Errorf("POST without configured credentials: got %d, want 403", got)
+	}
+
+	creds.Store(&auth.Credentials{User: "admin", Pass: "pw"})
+	for _, tc := range []struct {
+		method, header string
+		want           int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized}, // GET тоже ждет учетных данных
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodPost, "Basic YWRtaW46bm9wZQ==", http.StatusForbidden},
+		{http.MethodGet, "Basic YWRtaW46cHc=", http.StatusOK},
+		{http.MethodPost, "Basic YWRtaW46cHc=", http.StatusOK},
+	} {
+		if got := do(tc.method, tc.header); got != tc.want {
+			t.Errorf("%s with %q: got %d, want %d", tc.method, tc.header, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiOp - одна операция в OpenAPI: метод и шаблон пути в том же виде, что у маршрута mux
+type apiOp struct {
+	method, path string
+	summary      string
+	query        []string // необязательные параметры запроса
+	body         string   // тип тела запроса, пусто - без тела
+	status       int      // код успешного ответа
+	result       string   // тип успешного ответа
+	schema       string   // схема из components у JSON ответа
+	deprecated   bool
+}
+
+// storageOps повторяет handleStorage: маршруты хранилки под prefix. меняешь одно - меняй и другое,
+// расхождение покажет SpecDrift
+func storageOps(prefix string, legacy bool) []apiOp {
+	ops := []apiOp{
+		{method: http.MethodGet, path: prefix, summary: "List keys. ?keys= returns several values, ?prefix= scans keys with their values, ?limit= and ?cursor= page through keys",
+			query: []string{"keys", "prefix", "limit", "cursor"}, status: http.StatusOK, result: "application/json"},
+		{method: http.MethodGet, path: prefix + "/_dump", summary: "Dump all keys and values as one JSON object", status: http.StatusOK, result: "application/json", schema: "KeyValues"},
+		{method: http.MethodGet, path: prefix + "/_export.csv", summary: "Stream all keys as key,value CSV rows (RFC 4180), with a header row unless ?header=false",
+			query: []string{"header"}, status: http.StatusOK, result: "text/csv"},
+		{method: http.MethodGet, path: prefix + "/_stats", summary: "Storage size and process uptime", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodGet, path: prefix + "/_search", summary: "Find keys matching ?glob= (path.Match syntax) or the RE2 ?regex=, at most ?limit=; truncated is true when there are more",
+			query: []string{"glob", "regex", "limit"}, status: http.StatusOK, result: "application/json"},
+		{method: http.MethodGet, path: prefix + "/_watch", summary: "Stream changes of keys under ?prefix= as Server-Sent Events", query: []string{"prefix"}, status: http.StatusOK, result: "text/event-stream"},
+		{method: http.MethodGet, path: prefix + "/{key}", summary: "Get a value, or an older one with ?version=. The raw value unless Accept asks for JSON. " +
+			"?wait= with ?since=<etag> waits for the value to change: 304 if it did not, 404 if the key was deleted",
+			query: []string{"version", "wait", "since"}, status: http.StatusOK, result: "application/json", schema: "Value"},
+		{method: http.MethodGet, path: prefix + "/{key}/_meta", summary: "Key metadata: creation and update time, number of writes", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodGet, path: prefix + "/{key}/_history", summary: "Previous versions of a key", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodPut, path: prefix + "/{key}", summary: "Set a value from the request body. ?ttl= expires it, If-Match, If-None-Match: * and ?expect= make the write conditional, ?if-absent=true stores the body only when the key is missing and returns the resulting value",
+			query: []string{"ttl", "expect", "if-absent"}, body: "application/octet-stream", status: http.StatusNoContent},
+		{method: http.MethodPatch, path: prefix + "/{key}", summary: "Append the request body to a value, creating the key if it is missing; returns the new length",
+			body: "application/octet-stream", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodPost, path: prefix + "/{key}/_append", summary: "Same as PATCH /{key}", body: "application/octet-stream", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodPost, path: prefix + "/_batch", summary: "Set several keys at once", body: "application/json", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodPost, path: prefix + "/_restore", summary: "Load a dump, replacing all data (?mode=replace) or merging it (?mode=merge)",
+			query: []string{"mode"}, body: "application/json", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodPost, path: prefix + "/_import.csv", summary: "Load key,value CSV rows on top of the data (?mode=merge) or instead of it (?mode=replace) in one write; " +
+			"?header=false when there is no header row. Reports rows read, keys imported and skipped rows",
+			query: []string{"mode", "header"}, body: "text/csv", status: http.StatusOK, result: "application/json"},
+		{method: http.MethodPost, path: prefix + "/_txn", summary: `Apply {"ops": [...]} of set, delete and check atomically; a failed check returns 409 with its index and writes nothing`,
+			body: "application/json", status: http.StatusNoContent},
+		{method: http.MethodPost, path: prefix + "/{key}/incr", summary: "Add ?delta= (1 by default) to a numeric value", query: []string{"delta"}, status: http.StatusOK, result: "application/json", schema: "Value"},
+		{method: http.MethodPost, path: prefix + "/{key}/_undelete", summary: "Bring back a deleted key", status: http.StatusNoContent},
+		{method: http.MethodPost, path: prefix + "/{key}/_rename", summary: `Rename a key to "to" from the JSON body, an existing target needs "overwrite": true`,
+			body: "application/json", status: http.StatusNoContent},
+		{method: http.MethodDelete, path: prefix + "/{key}", summary: "Delete a key", status: http.StatusNoContent},
+		{method: http.MethodDelete, path: prefix, summary: "Delete all keys starting with ?prefix=, an empty prefix needs ?confirm=all",
+			query: []string{"prefix", "confirm"}, status: http.StatusOK, result: "application/json"},
+	}
+	if legacy {
+		ops = append(ops, apiOp{method: http.MethodPost, path: prefix + "/{key}/{value}", summary: "Set a value taken from the path. Use PUT instead. The values incr, _undelete, _rename and _append are actions on the key and cannot be written this way", query: []string{"ttl"}, status: http.StatusOK, result: "application/json", schema: "Value"})
+		for i := range ops {
+			ops[i].deprecated = true
+		}
+	}
+	return ops
+}
+
+// apiOps - все маршруты, которые вешает examplefs, кроме /docs: та страница для людей, а не для клиентов
+func apiOps(prefix string) []apiOp {
+	ops := storageOps(prefix, true)
+	ops = append(ops, apiOp{method: http.MethodGet, path: "/storage", summary: "List storage backends", status: http.StatusOK, result: "application/json", deprecated: true})
+	ops = append(ops, storageOps("/storage/{backend}", true)...)
+	ops = append(ops, apiOp{method: http.MethodGet, path: "/v1/kv", summary: "List storage backends", status: http.StatusOK, result: "application/json"})
+	ops = append(ops, storageOps("/v1/kv/{backend}", false)...)
+	return append(ops,
+		apiOp{method: http.MethodGet, path: "/kv/{bucket}", summary: "List keys of a bucket", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodDelete, path: "/kv/{bucket}", summary: "Delete a bucket with all its keys", status: http.StatusNoContent},
+		apiOp{method: http.MethodGet, path: "/kv/{bucket}/{key}", summary: "Get a value from a bucket", status: http.StatusOK, result: "application/json", schema: "Value"},
+		apiOp{method: http.MethodPut, path: "/kv/{bucket}/{key}", summary: "Set a value in a bucket, creating the bucket", body: "application/octet-stream", status: http.StatusNoContent},
+		apiOp{method: http.MethodDelete, path: "/kv/{bucket}/{key}", summary: "Delete a key from a bucket", status: http.StatusNoContent},
+		apiOp{method: http.MethodGet, path: "/metrics", summary: "Prometheus metrics", status: http.StatusOK, result: "text/plain"},
+		apiOp{method: http.MethodPost, path: "/admin/backup", summary: "Write a backup copy of the data", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodPost, path: "/admin/reload", summary: "Reload data from disk", status: http.StatusNoContent},
+		apiOp{method: http.MethodPost, path: "/admin/config/reload", summary: "Re-read the config file and apply what can change without a restart", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodPost, path: "/admin/compact", summary: "Compact the storage journal", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodGet, path: "/admin/mirror/diff", summary: "Compare the copies of a mirrored storage", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodPost, path: "/admin/rebalance", summary: "Move keys of a sharded storage to their shards", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodPost, path: "/admin/backend", summary: "Switch the storage backend, optionally copying all keys", body: "application/json", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodGet, path: "/admin/readonly", summary: "Show read-only mode", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodPost, path: "/admin/readonly", summary: "Switch read-only mode", body: "application/json", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodGet, path: "/ws", summary: `WebSocket to the main storage: send {"action":"subscribe","prefix":...}, get, set, delete and unsubscribe, receive {"event":"set","key":...,"value":...} for subscribed prefixes`,
+			status: http.StatusSwitchingProtocols},
+		apiOp{method: http.MethodGet, path: "/healthz", summary: "Liveness probe", status: http.StatusOK, result: "text/plain"},
+		apiOp{method: http.MethodGet, path: "/readyz", summary: "Readiness probe, checks the storage", status: http.StatusOK, result: "application/json"},
+		apiOp{method: http.MethodGet, path: "/openapi.json", summary: "This document", status: http.StatusOK, result: "application/json"},
+	)
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)\}`)
+
+// OpenAPISpec собирает документ OpenAPI 3 для API с хранилкой под prefix, как у NewRouter
+func OpenAPISpec(prefix string) map[string]any {
+	paths := make(map[string]map[string]any)
+	for _, op := range apiOps(prefix) {
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+			params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]string{"type": "string"}})
+		}
+		for _, q := range op.query {
+			params = append(params, map[string]any{"name": q, "in": "query", "schema": map[string]string{"type": "string"}})
+		}
+
+		ok := map[string]any{"description": http.StatusText(op.status)}
+		if op.result != "" {
+			schema := map[string]any{}
+			if op.schema != "" {
+				schema["$ref"] = "#/components/schemas/" + op.schema
+			} else if op.result != "application/json" {
+				schema["type"] = "string"
+			}
+			ok["content"] = map[string]any{op.result: map[string]any{"schema": schema}}
+		}
+		o := map[string]any{
+			"summary": op.summary,
+			"responses": map[string]any{
+				fmt.Sprint(op.status): ok,
+				"default":             map[string]any{"$ref": "#/components/responses/Error"},
+			},
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+		if op.body != "" {
+			o["requestBody"] = map[string]any{"required": true, "content": map[string]any{op.body: map[string]any{"schema": map[string]any{}}}}
+		}
+		if op.deprecated {
+			o["deprecated"] = true
+		}
+		if paths[op.path] == nil {
+			paths[op.path] = make(map[string]any)
+		}
+		paths[op.path][strings.ToLower(op.method)] = o
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "examplefs",
+			"version":     "1",
+			"description": "Key-value storage over HTTP. Errors are JSON objects with a stable code, see the Error schema. Keys starting with _ are reserved for endpoints such as _dump and are rejected with 400 INVALID_KEY on write.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type":     "object",
+					"required": []string{"code", "message"},
+					"properties": map[string]any{
+						"code":       map[string]string{"type": "string"},
+						"message":    map[string]string{"type": "string"},
+						"key":        map[string]string{"type": "string"},
+						"reason":     map[string]string{"type": "string"},
+						"request_id": map[string]string{"type": "string"},
+					},
+				},
+				"Value": map[string]any{
+					"type":     "object",
+					"required": []string{"key", "value"},
+					"properties": map[string]any{
+						"key":      map[string]string{"type": "string"},
+						"value":    map[string]string{"type": "string"},
+						"encoding": map[string]any{"type": "string", "enum": []string{"base64"}},
+					},
+				},
+				"KeyValues": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]string{"type": "string"},
+				},
+			},
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": map[string]string{"$ref": "#/components/schemas/Error"}}},
+				},
+			},
+		},
+	}
+}
+
+// OpenAPIHandler отдает OpenAPISpec. документ собирается один раз, маршруты после старта не меняются
+func OpenAPIHandler(prefix string) func(w http.ResponseWriter, r *http.Request) {
+	body, err := json.MarshalIndent(OpenAPISpec(prefix), "", "  ")
+	if err != nil {
+		panic(err) // в документе только строки, числа и map - упасть тут может лишь опечатка в коде
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// SpecDrift обходит маршруты r и сравнивает их с OpenAPISpec: возвращает "METHOD /path" для маршрутов,
+// которых нет в документе, и для операций документа, которых нет в роутере. пусто - документ в порядке
+func SpecDrift(r *mux.Router, prefix string) []string {
+	documented := make(map[string]bool)
+	for _, op := range apiOps(prefix) {
+		documented[op.method+" "+op.path] = true
+	}
+	routed := make(map[string]bool)
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		// /v1 - только префикс подроутера, у него нет своего пути. /docs и /ui - страницы для людей, а не API
+		if err != nil || path == "/docs" || strings.HasPrefix(path, "/ui") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, m := range methods {
+			routed[m+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var drift []string
+	for op := range routed {
+		if !documented[op] {
+			drift = append(drift, op+" is routed but missing from the OpenAPI document")
+		}
+	}
+	for op := range documented {
+		if !routed[op] {
+			drift = append(drift, op+" is in the OpenAPI document but not routed")
+		}
+	}
+	slices.Sort(drift)
+	return drift
+}
+
+// страница с Redoc: сама рисует документацию по /openapi.json, скрипт берется с CDN
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>examplefs API</title>
+</head>
+<body>
+<redoc spec-url="/openapi.json"></redoc>
+<script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+// DocsHandler отдает HTML страницу с документацией API
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
@@ -0,0 +1,124 @@
+package httpapi
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+// после скольких минут тишины клиент забывается вместе со своим ведром
+const rateLimitIdle = 10 * time.Minute
+
+// RateLimiter - ведро токенов на каждый IP клиента
+type RateLimiter struct {
+	trustProxy bool // брать IP из X-Forwarded-For, если перед нами свой прокси
+
+	mu        sync.Mutex
+	rps       rate.Limit // 0 - без ограничения
+	burst     int
+	clients   map[string]*limitedClient
+	lastSweep time.Time
+}
+
+type limitedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter пускает rps запросов в секунду с одного IP и burst сразу. с rps 0 пропускает всех,
+// пока SetLimit не включит ограничение
+func NewRateLimiter(rps float64, burst int, trustProxy bool) *RateLimiter {
+	return &RateLimiter{
+		rps:        rate.Limit(rps),
+		burst:      burst,
+		trustProxy: trustProxy,
+		clients:    make(map[string]*limitedClient),
+		lastSweep:  time.Now(),
+	}
+}
+
+// SetLimit меняет лимит на ходу, в том числе уже заведенным ведрам клиентов. rps 0 выключает ограничение
+func (rl *RateLimiter) SetLimit(rps float64, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rps, rl.burst = rate.Limit(rps), burst
+	for _, c := range rl.clients {
+		c.limiter.SetLimit(rl.rps)
+		c.limiter.SetBurst(rl.burst)
+	}
+}
+
+// Middleware отвечает 429 с Retry-After, когда у клиента кончились токены. пути из public не ограничиваются
+func (rl *RateLimiter) Middleware(public ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(public, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if wait, ok := rl.allow(clientIP(r, rl.trustProxy), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				httpError(w, r, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allow берет токен из ведра ip. если токена нет, возвращает, сколько ждать следующего
+func (rl *RateLimiter) allow(ip string, now time.Time) (wait time.Duration, ok bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.rps <= 0 {
+		return 0, true
+	}
+
+	// чистим ленивно, по ходу запросов - отдельная горутина ради этого не нужна
+	if now.Sub(rl.lastSweep) >= rateLimitIdle {
+		for k, c := range rl.clients {
+			if now.Sub(c.lastSeen) >= rateLimitIdle {
+				delete(rl.clients, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	c, found := rl.clients[ip]
+	if !found {
+		c = &limitedClient{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.clients[ip] = c
+	}
+	c.lastSeen = now
+	if c.limiter.AllowN(now, 1) {
+		return 0, true
+	}
+	res := c.limiter.ReserveN(now, 1)
+	wait = res.DelayFrom(now)
+	res.CancelAt(now)
+	return wait, false
+}
+
+// clientIP - адрес клиента. за своим прокси это последний адрес в X-Forwarded-For:
+// его дописал сам прокси, а все, что левее, клиент мог подставить как угодно
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
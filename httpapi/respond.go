@@ -0,0 +1,176 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// texter - ответ, у которого есть текстовый вид. его получают клиенты без Accept: application/json,
+// так что старые клиенты, читающие сырое значение или текст ошибки, продолжают работать
+type texter interface {
+	Text() string
+}
+
+// valueResponse - найденное значение ключа
+type valueResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (v valueResponse) Text() string {
+	return v.Value
+}
+
+// MarshalJSON отдает двоичное значение в base64 с "encoding": "base64" - как есть json испортил бы байты не из UTF-8
+func (v valueResponse) MarshalJSON() ([]byte, error) {
+	type plain valueResponse
+	if utf8.ValidString(v.Value) {
+		return json.Marshal(plain(v))
+	}
+	return json.Marshal(struct {
+		plain
+		Encoding string `json:"encoding"`
+	}{plain{Key: v.Key, Value: base64.StdEncoding.EncodeToString([]byte(v.Value))}, "base64"})
+}
+
+// errorResponse - тело любого ответа с ошибкой. Code не меняется от версии к версии, по нему клиенты и ветвятся,
+// а Message - для людей. всегда JSON, даже без Accept: у ошибки нет сырого значения, которое ждал бы старый клиент
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// только у INVALID_KEY, Key еще у CONFLICT транзакции
+	Key    string `json:"key,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	Index *int `json:"index,omitempty"` // номер не прошедшей проверки транзакции, с нуля
+
+	RequestID string `json:"request_id,omitempty"` // чтобы пользователь мог назвать его, сообщая об ошибке
+}
+
+// коды ошибок API
+const (
+	codeBadRequest       = "BAD_REQUEST"
+	codeUnauthorized     = "UNAUTHORIZED"
+	codeForbidden        = "FORBIDDEN"
+	codeNotFound         = "NOT_FOUND"
+	codeGone             = "GONE"
+	codeConflict         = "CONFLICT"
+	codeExists           = "ALREADY_EXISTS"
+	codePrecondition     = "PRECONDITION_FAILED"
+	codeTooLarge         = "TOO_LARGE"
+	codeInvalidKey       = "INVALID_KEY"
+	codeInvalidBucket    = "INVALID_BUCKET"
+	codeNotNumeric       = "NOT_NUMERIC"
+	codeReadOnly         = "READ_ONLY"
+	codeNotSupported     = "NOT_SUPPORTED"
+	codeRateLimited      = "RATE_LIMITED"
+	codeCanceled         = "CANCELED"
+	codeTimeout          = "TIMEOUT"
+	codeUnavailable      = "UNAVAILABLE"
+	codeBackendError     = "BACKEND_ERROR"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+)
+
+// statusCode - код для ошибок, которые хендлеры отвечают сами, без ошибки хранилки
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codeBadRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusGone:
+		return codeGone
+	case http.StatusPreconditionFailed:
+		return codePrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusRequestURITooLong:
+		return codeTooLarge
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	case http.StatusNotImplemented:
+		return codeNotSupported
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	case http.StatusGatewayTimeout:
+		return codeTimeout
+	}
+	if status >= http.StatusInternalServerError {
+		return codeBackendError
+	}
+	return codeBadRequest
+}
+
+// respond пишет payload со статусом status в том виде, который просит клиент.
+// все хендлеры отвечают через него, чтобы JSON и текст у всех эндпоинтов выглядели одинаково.
+// payload без Text() всегда уходит как JSON
+func respond(w http.ResponseWriter, r *http.Request, status int, payload any) {
+	if t, ok := payload.(texter); ok {
+		w.Header().Add("Vary", "Accept") // один и тот же URL отдает разное тело, кэшам нужно это знать
+		if !wantsJSON(r) {
+			text := t.Text()
+			// net/http угадывает тип сам, но только если тело ушло одним Write. значение может быть
+			// и картинкой, так что угадываем явно, а длину ставим, чтобы большое значение не ушло chunked
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", http.DetectContentType([]byte(text)))
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(text)))
+			w.WriteHeader(status)
+			io.WriteString(w, text)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// writeError - единственное место, где пишется ответ с ошибкой, httpError и storageError ходят через него
+func writeError(w http.ResponseWriter, r *http.Request, status int, e errorResponse) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	e.RequestID = storage.RequestIDFrom(r.Context())
+	respond(w, r, status, e)
+}
+
+// httpError - замена http.Error: msg уходит клиенту как есть, так что в нем не должно быть ничего внутреннего
+func httpError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	writeError(w, r, status, errorResponse{Code: statusCode(status), Message: msg})
+}
+
+type alwaysJSONKey struct{}
+
+// alwaysJSON - ответы на этот запрос идут в JSON независимо от Accept, как у всего /v1
+func alwaysJSON(ctx context.Context) context.Context {
+	return context.WithValue(ctx, alwaysJSONKey{}, true)
+}
+
+// wantsJSON - клиент явно перечислил application/json в Accept или запрос пришел в /v1. */* и отсутствие
+// заголовка у старых маршрутов оставляют текст, иначе curl и браузеры вдруг начали бы получать JSON вместо значения
+func wantsJSON(r *http.Request) bool {
+	if v, _ := r.Context().Value(alwaysJSONKey{}).(bool); v {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/json" && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
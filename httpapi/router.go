@@ -0,0 +1,240 @@
+// Package httpapi - HTTP хендлеры поверх storage.Storage и сборка роутера из них
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// максимальный размер тела PUT запроса и батча по умолчанию, меняются WithMaxBodyBytes и WithMaxBatchBytes
+const (
+	defaultMaxBodyBytes  = 1 << 20
+	defaultMaxBatchBytes = 16 << 20
+
+	// дамп - это все данные сразу, так что лимит у него сильно больше
+	defaultMaxRestoreBytes = 256 << 20
+)
+
+// тип значения из PUT без Content-Type
+const defaultContentType = "application/octet-stream"
+
+// размер страницы списка ключей по умолчанию и максимум: больший ?limit= молча урезается
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 10000
+)
+
+// сколько ключей отдает скан по префиксу без ?limit= и сколько можно попросить максимум
+const (
+	defaultScanLimit = 1000
+	maxScanLimit     = 100000
+)
+
+// сколько сервер ищет ключи по шаблону в _search, прежде чем ответить 504
+const searchTimeout = 5 * time.Second
+
+// bodyLimits - сколько хендлеры записи читают из тела запроса
+type bodyLimits struct {
+	body  int64 // значение PUT и append, если у хранилки нет своего лимита на значение
+	batch int64 // _batch и _txn
+}
+
+// RouterOption меняет лимиты тела запроса у NewRouter, HandleRegistry, HandleV1 и HandleBuckets
+type RouterOption func(*bodyLimits)
+
+// WithMaxBodyBytes задает, сколько читать из тела PUT и append. лимит хранилки на значение (-max-value-bytes) важнее
+func WithMaxBodyBytes(n int64) RouterOption {
+	return func(l *bodyLimits) { l.body = n }
+}
+
+// WithMaxBatchBytes задает, сколько читать из тела _batch и _txn
+func WithMaxBatchBytes(n int64) RouterOption {
+	return func(l *bodyLimits) { l.batch = n }
+}
+
+func newBodyLimits(opts []RouterOption) bodyLimits {
+	l := bodyLimits{body: defaultMaxBodyBytes, batch: defaultMaxBatchBytes}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	return l
+}
+
+// NewRouter вешает все хендлеры хранилки s под префикс prefix, например /file.
+// закрытие stop завершает открытые потоки _watch, иначе Shutdown ждал бы их до таймаута.
+// роутер матчит закодированный путь, чтобы в ключе можно было передать слэш как %2F
+func NewRouter(prefix string, s storage.Storage, stop <-chan struct{}, opts ...RouterOption) *mux.Router {
+	r := mux.NewRouter().UseEncodedPath()
+	// ответы самого роутера в той же модели ошибок, что и у хендлеров
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { httpError(w, r, "no such endpoint", http.StatusNotFound) })
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpError(w, r, r.Method+" is not allowed here", http.StatusMethodNotAllowed)
+	})
+	handleStorage(r, prefix, func(h storageHandler) http.HandlerFunc { return deprecated(h(s)) }, stop, true, newBodyLimits(opts))
+	return r
+}
+
+// HandleRegistry вешает те же маршруты под /storage/{backend}, хранилка ищется в reg на каждый запрос
+func HandleRegistry(r *mux.Router, reg *storage.StorageRegistry, stop <-chan struct{}, opts ...RouterOption) {
+	r.HandleFunc("/storage", BackendsHandler(reg)).Methods(http.MethodGet)
+	handleStorage(r, "/storage/{backend}", func(h storageHandler) http.HandlerFunc { return deprecated(inBackend(reg, h)) }, stop, true, newBodyLimits(opts))
+}
+
+// HandleV1 вешает версионированный API: /v1/kv/{backend}/{key} для всех хранилок из reg. хендлеры те же,
+// что у старых маршрутов, но без записи значения через путь, а ответы всегда в JSON, даже без Accept.
+// старые /file, /memory и /storage остаются как были, только с заголовком Deprecation
+func HandleV1(r *mux.Router, reg *storage.StorageRegistry, stop <-chan struct{}, opts ...RouterOption) {
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(alwaysJSON(r.Context())))
+		})
+	})
+	v1.HandleFunc("/kv", BackendsHandler(reg)).Methods(http.MethodGet)
+	handleStorage(v1, "/kv/{backend}", func(h storageHandler) http.HandlerFunc { return inBackend(reg, h) }, stop, false, newBodyLimits(opts))
+}
+
+// deprecated помечает ответы маршрутов, у которых есть замена под /v1, чтобы по логам прокси и клиентов
+// было видно, кто на них еще ходит
+func deprecated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</v1/kv>; rel="successor-version"`)
+		h(w, r)
+	}
+}
+
+// keyActions - последние сегменты POST {prefix}/{key}/..., занятые действиями над ключом
+var keyActions = []string{"incr", "_undelete", "_rename", "_append"}
+
+// storageHandler собирает хендлер для конкретной хранилки
+type storageHandler func(s storage.Storage) func(w http.ResponseWriter, r *http.Request)
+
+// handleStorage - список маршрутов хранилки. bind решает, откуда хендлер возьмет хранилку:
+// у NewRouter она одна и хендлеры собираются сразу, у реестра - по имени из пути.
+// legacy добавляет старую запись значения через путь, у /v1 ее нет
+func handleStorage(r *mux.Router, prefix string, bind func(storageHandler) http.HandlerFunc, stop <-chan struct{}, legacy bool, limits bodyLimits) {
+	watch := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) { return WatchHandler(s, stop) }
+	longPoll := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) { return LongPollHandler(s, stop) }
+	put := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+		return PutHandler(s, limits.body)
+	}
+	appendValue := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+		return AppendHandler(s, limits.body)
+	}
+	batch := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+		return BatchHandler(s, limits.batch)
+	}
+	restore := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+		return RestoreHandler(s, defaultMaxRestoreBytes)
+	}
+	txn := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+		return TxnHandler(s, limits.batch)
+	}
+	importCSV := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+		return ImportCSVHandler(s, defaultMaxRestoreBytes)
+	}
+
+	// маршруты с ?keys= и ?prefix= должны идти раньше списка ключей, иначе их перехватит просто prefix
+	r.HandleFunc(prefix, bind(MultiGetHandler)).Methods(http.MethodGet).Queries("keys", "{keys}")
+	r.HandleFunc(prefix, bind(ScanHandler)).Methods(http.MethodGet).Queries("prefix", "{prefix}")
+	r.HandleFunc(prefix, bind(KeysHandler)).Methods(http.MethodGet)
+
+	r.HandleFunc(prefix+"/_dump", bind(DumpHandler)).Methods(http.MethodGet)
+	r.HandleFunc(prefix+"/_export.csv", bind(ExportCSVHandler)).Methods(http.MethodGet)
+	r.HandleFunc(prefix+"/_stats", bind(StatsHandler)).Methods(http.MethodGet)
+	r.HandleFunc(prefix+"/_search", bind(SearchHandler)).Methods(http.MethodGet)
+	r.HandleFunc(prefix+"/_watch", bind(watch)).Methods(http.MethodGet)
+	r.HandleFunc(prefix+"/{key}", bind(VersionHandler)).Methods(http.MethodGet).Queries("version", "{version}")
+	r.HandleFunc(prefix+"/{key}", bind(longPoll)).Methods(http.MethodGet).Queries("wait", "{wait}")
+	r.HandleFunc(prefix+"/{key}", bind(GetHandler)).Methods(http.MethodGet)
+	r.HandleFunc(prefix+"/{key}/_meta", bind(MetaHandler)).Methods(http.MethodGet)
+	r.HandleFunc(prefix+"/{key}/_history", bind(HistoryHandler)).Methods(http.MethodGet)
+
+	// основной способ записи - PUT со значением в теле
+	r.HandleFunc(prefix+"/{key}", bind(put)).Methods(http.MethodPut)
+	r.HandleFunc(prefix+"/{key}", bind(appendValue)).Methods(http.MethodPatch)
+	r.HandleFunc(prefix+"/_batch", bind(batch)).Methods(http.MethodPost)
+	r.HandleFunc(prefix+"/_restore", bind(restore)).Methods(http.MethodPost)
+	r.HandleFunc(prefix+"/_import.csv", bind(importCSV)).Methods(http.MethodPost)
+	r.HandleFunc(prefix+"/_txn", bind(txn)).Methods(http.MethodPost)
+
+	// действия над ключом идут раньше старого /{key}/{value} и забирают эти значения себе. это сломало
+	// совместимость: записать значение из keyActions через путь больше нельзя, PostHandler отвечает на них 400.
+	// новое действие - новое слово в keyActions
+	r.HandleFunc(prefix+"/{key}/incr", bind(IncrHandler)).Methods(http.MethodPost)
+	r.HandleFunc(prefix+"/{key}/_undelete", bind(UndeleteHandler)).Methods(http.MethodPost)
+	r.HandleFunc(prefix+"/{key}/_rename", bind(RenameHandler)).Methods(http.MethodPost)
+	r.HandleFunc(prefix+"/{key}/_append", bind(appendValue)).Methods(http.MethodPost)
+
+	// старый вариант со значением в пути оставлен для совместимости
+	if legacy {
+		r.HandleFunc(prefix+"/{key}/{value}", bind(PostHandler)).Methods(http.MethodPost)
+	}
+
+	r.HandleFunc(prefix+"/{key}", bind(DeleteHandler)).Methods(http.MethodDelete)
+	r.HandleFunc(prefix, bind(DeletePrefixHandler)).Methods(http.MethodDelete)
+}
+
+// inBackend достает хранилку по {backend} из пути, на незнакомое имя отвечает 404
+func inBackend(reg *storage.StorageRegistry, h storageHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["backend"]
+		s, ok := reg.Lookup(name)
+		if !ok {
+			httpError(w, r, fmt.Sprintf("unknown backend %q", name), http.StatusNotFound)
+			return
+		}
+		h(s)(w, r)
+	}
+}
+
+// BackendsHandler перечисляет имена, доступные под /storage/{backend}
+func BackendsHandler(reg *storage.StorageRegistry) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, http.StatusOK, reg.Names())
+	}
+}
+
+// inBucket достает бакет из пути и отдает его обычному хендлеру хранилки.
+// create == false для чтения и удаления, чтобы GET по опечатке в имени не заводил новый бакет
+func inBucket(bs *storage.BucketedStorage, create bool, h storageHandler) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := bs.Bucket(r.Context(), mux.Vars(r)["bucket"], create)
+		if err != nil {
+			storageError(w, r, err)
+			return
+		}
+		h(s)(w, r)
+	}
+}
+
+// DeleteBucketHandler удаляет бакет со всеми ключами
+func DeleteBucketHandler(bs *storage.BucketedStorage) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := bs.DeleteBucket(r.Context(), mux.Vars(r)["bucket"]); err != nil {
+			storageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleBuckets вешает маршруты /kv/{bucket}/{key}
+func HandleBuckets(r *mux.Router, bs *storage.BucketedStorage, opts ...RouterOption) {
+	limits := newBodyLimits(opts)
+	putInBucket := func(s storage.Storage) func(w http.ResponseWriter, r *http.Request) {
+		return PutHandler(s, limits.body)
+	}
+
+	r.HandleFunc("/kv/{bucket}", inBucket(bs, false, KeysHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/kv/{bucket}", DeleteBucketHandler(bs)).Methods(http.MethodDelete)
+	r.HandleFunc("/kv/{bucket}/{key}", inBucket(bs, false, GetHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/kv/{bucket}/{key}", inBucket(bs, true, putInBucket)).Methods(http.MethodPut)
+	r.HandleFunc("/kv/{bucket}/{key}", inBucket(bs, false, DeleteHandler)).Methods(http.MethodDelete)
+}
@@ -0,0 +1,210 @@
+package httpapi
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+var uiTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"time":       uiTime,
+}).ParseFS(uiFiles, "ui/*.html"))
+
+// значения длиннее на странице ключа обрезаются, целиком их отдает API
+const uiMaxValueBytes = 64 << 10
+
+// HandleUI вешает на /ui HTML страницы для людей: список ключей с фильтром по префиксу и страницу ключа.
+// данные страницы читают сами, а запись и удаление - формы, которые ходят в /v1/kv, так что через UI
+// нельзя сделать ничего сверх API. пока readOnly включен, форм нет. авторизация та же, что у API:
+// страницы висят под тем же RequireAuth
+func HandleUI(r *mux.Router, reg *storage.StorageRegistry, readOnly *atomic.Bool) {
+	static, _ := fs.Sub(uiFiles, "ui/static")
+	r.PathPrefix("/ui/_static/").Handler(http.StripPrefix("/ui/_static/", http.FileServerFS(static))).Methods(http.MethodGet)
+	r.HandleFunc("/ui", func(w http.ResponseWriter, r *http.Request) {
+		renderUI(w, r, "index.html", http.StatusOK, struct {
+			uiPage
+			Backends []string
+		}{Backends: reg.Names()})
+	}).Methods(http.MethodGet)
+	r.HandleFunc("/ui/{backend}", inUIBackend(reg, readOnly, UIKeysHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/ui/{backend}/{key}", inUIBackend(reg, readOnly, UIKeyHandler)).Methods(http.MethodGet)
+}
+
+// inUIBackend - inBackend для страниц: неизвестная хранилка - тоже страница, а не JSON
+func inUIBackend(reg *storage.StorageRegistry, readOnly *atomic.Bool, h func(storage.Storage, *atomic.Bool) func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["backend"]
+		s, ok := reg.Lookup(name)
+		if !ok {
+			uiError(w, r, http.StatusNotFound, fmt.Sprintf("unknown backend %q", name))
+			return
+		}
+		h(s, readOnly)(w, r)
+	}
+}
+
+// uiPage - то, что есть у каждой страницы, кроме списка хранилок
+type uiPage struct {
+	Backend  string
+	Writable bool
+}
+
+func newUIPage(r *http.Request, readOnly *atomic.Bool) uiPage {
+	return uiPage{Backend: mux.Vars(r)["backend"], Writable: !readOnly.Load()}
+}
+
+// UIKeysHandler - GET /ui/{backend}?prefix=&cursor=: страница ключей по возрастанию, как у GET с ?cursor=
+func UIKeysHandler(s storage.Storage, readOnly *atomic.Bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		prefix := q.Get("prefix")
+		after, err := decodeCursor(q.Get("cursor"))
+		if err != nil {
+			uiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		keys, more, err := prefixPage(r, s, prefix, after, defaultPageLimit)
+		if err != nil {
+			uiStorageError(w, r, err)
+			return
+		}
+		page := struct {
+			uiPage
+			Prefix string
+			Keys   []string
+			Next   string // курсор следующей страницы, пусто - это последняя
+		}{uiPage: newUIPage(r, readOnly), Prefix: prefix, Keys: keys}
+		if more {
+			page.Next = encodeCursor(keys[len(keys)-1])
+		}
+		renderUI(w, r, "keys.html", http.StatusOK, page)
+	}
+}
+
+// prefixPage - до limit ключей с префиксом prefix строго после after. ключи с общим префиксом идут
+// подряд, так что страница начинается с самого prefix и кончается на первом чужом ключе
+func prefixPage(r *http.Request, s storage.Storage, prefix, after string, limit int) (keys []string, more bool, err error) {
+	if after < prefix {
+		// KeysPage отдает ключи строго после after, сам prefix проверяем отдельно
+		after = prefix
+		if prefix != "" {
+			if _, err := s.Get(r.Context(), prefix); err == nil {
+				keys = append(keys, prefix)
+			} else if !errors.Is(err, storage.ErrNotFound) {
+				return nil, false, err
+			}
+		}
+	}
+	page, err := storage.KeysPage(r.Context(), s, after, limit+1-len(keys))
+	if err != nil {
+		return nil, false, err
+	}
+	for _, k := range page {
+		if !strings.HasPrefix(k, prefix) {
+			break
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) > limit {
+		return keys[:limit], true, nil
+	}
+	return keys, false, nil
+}
+
+// UIKeyHandler - GET /ui/{backend}/{key}: значение и метаданные ключа
+func UIKeyHandler(s storage.Storage, readOnly *atomic.Bool) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := keyVar(r, limits())
+		if err != nil {
+			uiStorageError(w, r, err)
+			return
+		}
+		entry, err := storage.GetEntry(r.Context(), s, key)
+		meta := err == nil
+		if errors.Is(err, storage.ErrNotSupported) {
+			entry.Value, err = s.Get(r.Context(), key)
+		}
+		if err != nil {
+			uiStorageError(w, r, err)
+			return
+		}
+
+		page := struct {
+			uiPage
+			Key       string
+			Value     string
+			Bytes     int
+			Truncated bool
+			Editable  bool // значение целиком и текстом, его можно править в форме
+			Meta      bool // бэкенд хранит метаданные
+			Entry     storage.Entry
+		}{uiPage: newUIPage(r, readOnly), Key: key, Value: entry.Value, Bytes: len(entry.Value), Meta: meta, Entry: entry}
+		if len(page.Value) > uiMaxValueBytes {
+			page.Value, page.Truncated = strings.ToValidUTF8(page.Value[:uiMaxValueBytes], ""), true
+		}
+		page.Editable = !page.Truncated && utf8.ValidString(page.Value)
+		if !utf8.ValidString(page.Value) {
+			page.Value = strconv.Quote(page.Value) // бинарное значение показываем экранированным, а не кракозябрами
+		}
+		renderUI(w, r, "key.html", http.StatusOK, page)
+	}
+}
+
+func uiStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	status, resp := errorClass(err)
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "storage error", "path", r.URL.Path, "error", err)
+	}
+	uiError(w, r, status, resp.Message)
+}
+
+func uiError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	renderUI(w, r, "error.html", status, struct {
+		uiPage
+		Status  int
+		Message string
+	}{uiPage{Backend: mux.Vars(r)["backend"]}, status, msg})
+}
+
+// renderUI рисует страницу в буфер, чтобы ошибка шаблона не оставила клиенту половину страницы с кодом 200
+func renderUI(w http.ResponseWriter, r *http.Request, name string, status int, data any) {
+	var sb strings.Builder
+	if err := uiTemplates.ExecuteTemplate(&sb, name, data); err != nil {
+		slog.ErrorContext(r.Context(), "unable to render ui page", "page", name, "error", err)
+		http.Error(w, "unable to render page", http.StatusInternalServerError)
+		return
+	}
+	// скрипты только свои: даже если значение как-то проскочит экранирование, его код не выполнится
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	fmt.Fprint(w, sb.String())
+}
+
+// uiTime - время в метаданных ключа для страницы
+func uiTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,52 @@
+// формы UI ничего не делают сами: они шлют запрос в /v1/kv и показывают ответ API
+"use strict";
+
+function keyURL(backend, key) {
+  return "/v1/kv/" + encodeURIComponent(backend) + "/" + encodeURIComponent(key);
+}
+
+async function submitAPIForm(event) {
+  event.preventDefault();
+  const form = event.currentTarget;
+  const backend = form.dataset.backend;
+  const key = form.elements.key.value;
+  const output = form.querySelector("output");
+  let req;
+  if (form.dataset.action === "delete") {
+    if (!confirm("Delete " + key + "?")) {
+      return;
+    }
+    req = { method: "DELETE" };
+  } else {
+    req = { method: "PUT", body: form.elements.value.value, headers: { "Content-Type": "text/plain; charset=utf-8" } };
+  }
+  output.textContent = "";
+  let resp;
+  try {
+    resp = await fetch(keyURL(backend, key), req);
+  } catch (err) {
+    output.textContent = "Request failed: " + err.message;
+    return;
+  }
+  if (!resp.ok) {
+    let message = resp.status + " " + resp.statusText;
+    try {
+      const body = await resp.json();
+      if (body.message) {
+        message = body.message;
+      }
+    } catch (err) {
+      // не JSON - хватит и статуса
+    }
+    output.textContent = message;
+    return;
+  }
+  const page = "/ui/" + encodeURIComponent(backend);
+  location.assign(form.dataset.action === "delete" ? page : page + "/" + encodeURIComponent(key));
+}
+
+document.addEventListener("DOMContentLoaded", function () {
+  for (const form of document.querySelectorAll("form.api")) {
+    form.addEventListener("submit", submitAPIForm);
+  }
+});
@@ -0,0 +1,283 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// действия в сообщениях клиента /ws
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsGet         = "get"
+	wsSet         = "set"
+	wsDelete      = "delete"
+)
+
+const (
+	wsSendBuffer   = 256              // сколько сообщений ждет отправки, прежде чем клиента сочтут медленным
+	wsWriteTimeout = 10 * time.Second // сколько можно писать одно сообщение
+	wsPongTimeout  = time.Minute      // без pong дольше этого соединение считается мертвым
+)
+
+// wsRequest - сообщение клиента. id, если есть, возвращается в ответе на него
+type wsRequest struct {
+	ID     string `json:"id,omitempty"`
+	Action string `json:"action"`
+	Prefix string `json:"prefix,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// wsMessage - сообщение сервера: ответ на запрос (с ok или error) или событие подписки (с event)
+type wsMessage struct {
+	ID     string         `json:"id,omitempty"`
+	Event  string         `json:"event,omitempty"`
+	Prefix string         `json:"prefix,omitempty"`
+	Key    string         `json:"key,omitempty"`
+	Value  *string        `json:"value,omitempty"`
+	OK     bool           `json:"ok,omitempty"`
+	Error  *errorResponse `json:"error,omitempty"`
+}
+
+// WebSocketHandler - GET /ws: двусторонний канал к хранилке. клиент шлет JSON вида {"action":"subscribe","prefix":"user:"},
+// get, set, delete и unsubscribe, сервер отвечает на каждый и присылает {"event":"set","key":...,"value":...}
+// по всем подпискам соединения. подписки на пересекающиеся префиксы присылают одно событие несколько раз.
+// клиента, который не успевает читать, отключаем, а не копим для него события. stop закрывает все соединения,
+// а active считает открытые: http.Server.Shutdown соединения после Hijack не ждет, так что ждать их должен сервер.
+// само соединение - GET, и RequireAuth пускает его как чтение, поэтому set и delete сверяют Authorization
+// из запроса на соединение с creds сами, на каждом действии: creds может подменить перечитывание конфига
+func WebSocketHandler(s storage.Storage, creds *atomic.Pointer[auth.Credentials], stop <-chan struct{}, active *sync.WaitGroup) func(w http.ResponseWriter, r *http.Request) {
+	limits := storage.LiveLimits(s)
+	upgrader := websocket.Upgrader{} // Origin должен совпадать с Host: чужая страница не откроет соединение с cookie пользователя
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(hijacker{w}, r, nil)
+		if err != nil {
+			return // Upgrade уже ответил клиенту
+		}
+		active.Add(1)
+		defer active.Done()
+		conn.SetReadLimit(defaultMaxBodyBytes)
+
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		c := &wsConn{conn: conn, s: s, limits: limits, creds: creds, authorization: r.Header.Get("Authorization"), out: make(chan wsMessage, wsSendBuffer), subs: make(map[string]context.CancelFunc), ctx: ctx, cancel: cancel}
+		written := make(chan struct{})
+		go func() {
+			c.writeLoop(stop)
+			close(written)
+		}()
+		c.readLoop()
+		<-written // writeLoop может еще прощаться с клиентом
+	}
+}
+
+// wsConn - одно соединение /ws. пишет в сокет только writeLoop, остальные кладут сообщения в out
+type wsConn struct {
+	conn   *websocket.Conn
+	s      storage.Storage
+	limits func() storage.Limits // читаются на каждом действии, их меняет перечитывание конфига
+
+	creds         *atomic.Pointer[auth.Credentials]
+	authorization string // заголовок Authorization запроса на соединение
+
+	out    chan wsMessage
+	ctx    context.Context // кончается вместе с соединением, от него живут подписки
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc // подписки по префиксу
+}
+
+func (c *wsConn) readLoop() {
+	defer c.cancel()
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error { return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout)) })
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return // клиент закрыл соединение, или его закрыли мы
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(wsMessage{Error: &errorResponse{Code: codeBadRequest, Message: "malformed message: " + err.Error()}})
+			continue
+		}
+		c.send(c.handle(req))
+	}
+}
+
+// handle выполняет запрос клиента и собирает ответ на него
+func (c *wsConn) handle(req wsRequest) (resp wsMessage) {
+	resp = wsMessage{ID: req.ID, Prefix: req.Prefix, Key: req.Key, OK: true}
+	var err error
+	switch req.Action {
+	case wsSubscribe:
+		err = c.subscribe(req.Prefix)
+	case wsUnsubscribe:
+		c.unsubscribe(req.Prefix)
+	case wsGet:
+		var value string
+		if err = c.limits().CheckKey(req.Key); err == nil {
+			if value, err = c.s.Get(c.ctx, req.Key); err == nil {
+				resp.Value = &value
+			}
+		}
+	case wsSet:
+		if e := c.authorize(); e != nil {
+			return wsMessage{ID: req.ID, Key: req.Key, Error: e}
+		}
+		if err = c.limits().Check(req.Key, req.Value); err == nil {
+			err = checkWritable(req.Key)
+		}
+		if err == nil {
+			err = c.s.Set(c.ctx, req.Key, req.Value)
+		}
+	case wsDelete:
+		if e := c.authorize(); e != nil {
+			return wsMessage{ID: req.ID, Key: req.Key, Error: e}
+		}
+		if err = c.limits().CheckKey(req.Key); err == nil {
+			err = c.s.Delete(c.ctx, req.Key)
+		}
+	default:
+		return wsMessage{ID: req.ID, Error: &errorResponse{Code: codeBadRequest, Message: fmt.Sprintf("unknown action %q", req.Action)}}
+	}
+	if err != nil {
+		status, e := errorClass(err)
+		if status >= http.StatusInternalServerError {
+			slog.Error("storage error", "path", "/ws", "action", req.Action, "error", err)
+		}
+		return wsMessage{ID: req.ID, Prefix: req.Prefix, Key: req.Key, Error: &e}
+	}
+	return resp
+}
+
+// authorize - проверка записи, как у RequireAuth: nil, если учетные данные не заданы или соединение открыто с верными
+func (c *wsConn) authorize() *errorResponse {
+	creds := c.creds.Load()
+	if !creds.Enabled() {
+		return nil
+	}
+	switch err := creds.Check(c.authorization); {
+	case errors.Is(err, auth.ErrNoCredentials):
+		return &errorResponse{Code: codeUnauthorized, Message: err.Error()}
+	case err != nil:
+		return &errorResponse{Code: codeForbidden, Message: err.Error()}
+	}
+	return nil
+}
+
+// subscribe пересылает события Watch по prefix в соединение. повторная подписка на тот же префикс ничего не делает
+func (c *wsConn) subscribe(prefix string) (err error) {
+	wt, ok := storage.As[storage.Watcher](c.s)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[prefix]; ok {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	events, err := wt.Watch(ctx, prefix)
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.subs[prefix] = cancel
+	go func() {
+		for ev := range events {
+			m := wsMessage{Event: ev.Op, Prefix: prefix, Key: ev.Key}
+			if ev.Op != storage.EventDelete {
+				m.Value = &ev.Value
+			}
+			c.push(m)
+		}
+		// канал закрыт не нами - хранилка закрылась или отключила нас как медленных
+		if ctx.Err() == nil {
+			c.close(websocket.CloseTryAgainLater, fmt.Sprintf("subscription to %q was dropped", prefix))
+		}
+	}()
+	return nil
+}
+
+func (c *wsConn) unsubscribe(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, ok := c.subs[prefix]; ok {
+		cancel()
+		delete(c.subs, prefix)
+	}
+}
+
+// send кладет ответ на запрос в очередь. ответы ждут места: чтение и так стоит, пока клиент не заберет свое
+func (c *wsConn) send(m wsMessage) {
+	select {
+	case c.out <- m:
+	case <-c.ctx.Done():
+	}
+}
+
+// push кладет событие в очередь, а если она полна, отключает клиента: ждать его значило бы копить события без предела
+func (c *wsConn) push(m wsMessage) {
+	select {
+	case c.out <- m:
+	case <-c.ctx.Done():
+	default:
+		c.close(websocket.CloseTryAgainLater, "client is too slow")
+	}
+}
+
+// close прощается с клиентом кодом code и рвет соединение, readLoop и writeLoop от этого выходят
+func (c *wsConn) close(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+	c.cancel()
+	c.conn.Close()
+}
+
+func (c *wsConn) writeLoop(stop <-chan struct{}) {
+	ping := time.NewTicker(watchKeepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			c.conn.Close()
+			return
+		case <-stop:
+			c.close(websocket.CloseGoingAway, "server is shutting down")
+			return
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				c.cancel()
+			}
+		case m := <-c.out:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteJSON(m); err != nil {
+				c.cancel()
+			}
+		}
+	}
+}
+
+// hijacker достает http.Hijacker через Unwrap обертки middleware: gorilla ищет его только у самого writer'а
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
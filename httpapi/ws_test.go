@@ -0,0 +1,108 @@
+package httpapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// wsServer - /ws под RequireAuth, как его вешает examplefs
+func wsServer(t *testing.T, s storage.Storage, creds auth.Credentials) (url string, live *atomic.Pointer[auth.Credentials]) {
+	t.Helper()
+	live = new(atomic.Pointer[auth.Credentials])
+	live.Store(&creds)
+	stop := make(chan struct{})
+	var active sync.WaitGroup
+	r := mux.NewRouter()
+	r.HandleFunc("/ws", httpapi.WebSocketHandler(s, live, stop, &active)).Methods(http.MethodGet)
+	r.Use(httpapi.RequireAuth(live, false))
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		close(stop)
+		active.Wait()
+		srv.Close()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws", live
+}
+
+type wsReply struct {
+	OK    bool `json:"ok"`
+	Error *struct {
+		Code string `json:"code"`
+	} `json:"error"`
+}
+
+func wsDo(t *testing.T, conn *websocket.Conn, req map[string]string) wsReply {
+	t.Helper()
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp wsReply
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return resp
+}
+
+func wsDial(t *testing.T, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWebSocketWritesRequireAuth(t *testing.T) {
+	s := storage.NewMemStorage()
+	url, live := wsServer(t, s, auth.Credentials{Token: "secret"})
+
+	anon := wsDial(t, url, nil)
+	if resp := wsDo(t, anon, map[string]string{"action": "set", "key": "a", "value": "pwned"}); resp.Error == nil || resp.Error.Code != "UNAUTHORIZED" {
+		t.Errorf("anonymous set: got %+v, want UNAUTHORIZED", resp)
+	}
+	if resp := wsDo(t, anon, map[string]string{"action": "delete", "key": "a"}); resp.Error == nil || resp.Error.Code != "UNAUTHORIZED" {
+		t.Errorf("anonymous delete: got %+v, want UNAUTHORIZED", resp)
+	}
+	// чтение без protectReads открыто
+	if resp := wsDo(t, anon, map[string]string{"action": "get", "key": "a"}); resp.Error == nil || resp.Error.Code != "NOT_FOUND" {
+		t.Errorf("anonymous get: got %+v, want NOT_FOUND", resp)
+	}
+
+	wrong := wsDial(t, url, http.Header{"Authorization": {"Bearer nope"}})
+	if resp := wsDo(t, wrong, map[string]string{"action": "set", "key": "a", "value": "pwned"}); resp.Error == nil || resp.Error.Code != "FORBIDDEN" {
+		t.Errorf("set with a wrong token: got %+v, want FORBIDDEN", resp)
+	}
+	if _, err := s.Get(context.Background(), "a"); err != storage.ErrNotFound {
+		t.Fatalf("rejected writes reached the storage: %v", err)
+	}
+
+	authed := wsDial(t, url, http.Header{"Authorization": {"Bearer secret"}})
+	if resp := wsDo(t, authed, map[string]string{"action": "set", "key": "a", "value": "1"}); !resp.OK {
+		t.Errorf("set with the token: got %+v", resp)
+	}
+
+	// новый токен из перечитанного конфига действует на уже открытые соединения
+	live.Store(&auth.Credentials{Token: "rotated"})
+	if resp := wsDo(t, authed, map[string]string{"action": "delete", "key": "a"}); resp.Error == nil || resp.Error.Code != "FORBIDDEN" {
+		t.Errorf("delete after the token changed: got %+v, want FORBIDDEN", resp)
+	}
+	live.Store(&auth.Credentials{})
+	if resp := wsDo(t, anon, map[string]string{"action": "delete", "key": "a"}); !resp.OK {
+		t.Errorf("delete with auth turned off: got %+v", resp)
+	}
+}
@@ -0,0 +1,7 @@
+// Package kvpb - сгенерированные из examplefs/kv/v1/kv.proto сообщения и стабы gRPC.
+// руками тут ничего не правим: после изменения kv.proto запусти go generate ./kvpb.
+// proto лежит по пути своего пакета, потому что под этим путем файл регистрируется в protobuf:
+// голый kv.proto совпадал с kv.proto из etcd, и процесс падал на старте
+package kvpb
+
+//go:generate buf generate
@@ -0,0 +1,144 @@
+package respapi
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// пределы на то, что клиент может прислать одной командой: без них "*999999999" заставил бы выделить память заранее
+const (
+	maxArgs      = 1024 * 1024
+	maxBulkBytes = 64 << 20
+	maxInline    = 64 << 10
+)
+
+// errProtocol - клиент прислал не RESP. после нее соединение не восстановить, так что его закрываем, как и Redis
+var errProtocol = errors.New("protocol error")
+
+// readCommand читает одну команду: multi-bulk, как шлют все клиентские библиотеки, или inline - строку
+// через пробелы, как набирают руками в telnet. bufio.Reader сам дочитывает команду, пришедшую кусками,
+// а следующие команды конвейера остаются в его буфере
+func readCommand(br *bufio.Reader) (args []string, err error) {
+	for len(args) == 0 {
+		line, err := readLine(br, maxInline)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "*") {
+			args = strings.Fields(line) // пустая строка - не команда, redis ее просто пропускает
+			continue
+		}
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxArgs {
+			return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+		}
+		if n <= 0 {
+			continue
+		}
+		args = make([]string, 0, min(n, 64)) // длине из заголовка не верим, пока не пришли сами аргументы
+		for range n {
+			arg, err := readBulk(br)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+	}
+	return args, nil
+}
+
+func readBulk(br *bufio.Reader) (string, error) {
+	line, err := readLine(br, maxInline)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return "", fmt.Errorf("%w: expected '$', got %q", errProtocol, firstByte(line))
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkBytes {
+		return "", fmt.Errorf("%w: invalid bulk length", errProtocol)
+	}
+	// буфер растет по мере того, как приходят данные, а не сразу на заявленную длину
+	var sb strings.Builder
+	if _, err = io.CopyN(&sb, br, int64(n)+2); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	s, ok := strings.CutSuffix(sb.String(), "\r\n")
+	if !ok {
+		return "", fmt.Errorf("%w: bulk string is not terminated by CRLF", errProtocol)
+	}
+	return s, nil
+}
+
+// readLine читает строку до \n и отрезает \r\n. inline команды от людей бывают и с одним \n
+func readLine(br *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+	}
+}
+
+func firstByte(s string) string {
+	if s == "" {
+		return ""
+	}
+	return s[:1]
+}
+
+// replyWriter пишет ответы RESP2 в буфер, который сбрасывается, когда конвейер клиента разобран
+type replyWriter struct {
+	*bufio.Writer
+}
+
+func (w replyWriter) status(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+// error - ответ с ошибкой. первое слово - ее класс, как у Redis: ERR, WRONGTYPE, NOAUTH и т.п.
+func (w replyWriter) error(s string) {
+	// перевод строки оборвал бы ответ посередине, а в сообщение может попасть то, что прислал клиент
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	w.WriteString("-" + s + "\r\n")
+}
+
+func (w replyWriter) integer(n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w replyWriter) bulk(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// null - отсутствующее значение, например GET несуществующего ключа
+func (w replyWriter) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w replyWriter) array(items []string) {
+	w.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, s := range items {
+		w.bulk(s)
+	}
+}
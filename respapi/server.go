@@ -0,0 +1,432 @@
+// Package respapi - слушатель протокола Redis (RESP2) поверх storage.Storage, чтобы с хранилкой
+// можно было работать из redis-cli и клиентских библиотек Redis
+package respapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// Server отвечает на команды Redis: GET, SET, DEL, EXISTS, KEYS, PING, INFO и служебные AUTH, SELECT 0, QUIT.
+// остальные получают ошибку ERR unknown command, как у самого Redis
+type Server struct {
+	s            storage.Storage
+	creds        *atomic.Pointer[auth.Credentials]
+	protectReads bool
+	started      time.Time
+
+	mu       sync.Mutex
+	lis      net.Listener
+	conns    map[net.Conn]struct{}
+	closing  atomic.Bool
+	handlers sync.WaitGroup
+}
+
+// NewServer собирает RESP сервер поверх s. авторизация та же, что у HTTP и gRPC: если creds включены,
+// клиент сначала шлет AUTH <token> или AUTH <user> <pass>, а читать без нее можно, только пока protectReads выключен
+func NewServer(s storage.Storage, creds *atomic.Pointer[auth.Credentials], protectReads bool) *Server {
+	return &Server{s: s, creds: creds, protectReads: protectReads, started: time.Now(), conns: make(map[net.Conn]struct{})}
+}
+
+// Serve принимает соединения, пока lis не закроют. после Shutdown возвращает nil
+func (srv *Server) Serve(lis net.Listener) error {
+	srv.mu.Lock()
+	srv.lis = lis
+	closing := srv.closing.Load() // Shutdown мог успеть раньше, чем Serve запустился
+	srv.mu.Unlock()
+	if closing {
+		lis.Close()
+		return nil
+	}
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if srv.closing.Load() {
+				return nil
+			}
+			return fmt.Errorf("unable to accept resp connection: %w", err)
+		}
+		srv.mu.Lock()
+		if srv.closing.Load() {
+			srv.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		srv.conns[conn] = struct{}{}
+		srv.handlers.Add(1)
+		srv.mu.Unlock()
+		go srv.serveConn(conn)
+	}
+}
+
+// Shutdown перестает принимать соединения и закрывает открытые, дав им закончить начатую команду.
+// не уложились в ctx - соединения рвутся
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.closing.Store(true)
+	if srv.lis != nil {
+		srv.lis.Close()
+	}
+	// ждущих следующей команды будит дедлайн чтения, а занятые выйдут, дописав ответ
+	for conn := range srv.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	srv.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Close()
+		return ctx.Err()
+	}
+}
+
+// Close сразу закрывает слушатель и все соединения, не дожидаясь начатых команд
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.closing.Store(true)
+	if srv.lis != nil {
+		srv.lis.Close()
+	}
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// session - состояние одного соединения
+type session struct {
+	authed bool
+	w      replyWriter
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+	defer srv.handlers.Done()
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, conn)
+		srv.mu.Unlock()
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(conn)
+	sess := &session{w: replyWriter{bufio.NewWriter(conn)}}
+	for !srv.closing.Load() {
+		args, err := readCommand(br)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				sess.w.error("ERR " + err.Error())
+				sess.w.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("resp connection closed", "remote", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		quit := srv.exec(sess, args)
+		// конвейер отвечаем одной пачкой: сбрасываем, только когда прочитанные команды кончились
+		if br.Buffered() == 0 || quit {
+			if err := sess.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// команды, которые без protectReads можно выполнять без AUTH
+var readCommands = map[string]bool{"GET": true, "EXISTS": true, "KEYS": true, "INFO": true}
+
+// exec выполняет одну команду. quit == true - клиент попросил закрыть соединение
+func (srv *Server) exec(sess *session, args []string) (quit bool) {
+	w := sess.w
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+
+	creds := srv.creds.Load()
+	switch {
+	case name == "QUIT":
+		w.status("OK")
+		return true
+	case name == "AUTH":
+		srv.auth(sess, creds, args)
+		return false
+	case name == "PING": // пробам и пулам соединений пароль не нужен
+	case creds.Enabled() && !sess.authed && (!readCommands[name] || srv.protectReads):
+		w.error("NOAUTH Authentication required.")
+		return false
+	}
+
+	ctx := context.Background()
+	var err error
+	switch name {
+	case "PING":
+		switch len(args) {
+		case 0:
+			w.status("PONG")
+		case 1:
+			w.bulk(args[0])
+		default:
+			wrongArgs(w, name)
+		}
+	case "GET":
+		if len(args) != 1 {
+			wrongArgs(w, name)
+			break
+		}
+		var value string
+		if value, err = srv.s.Get(ctx, args[0]); errors.Is(err, storage.ErrNotFound) {
+			w.null()
+			err = nil
+		} else if err == nil {
+			w.bulk(value)
+		}
+	case "SET":
+		err = srv.set(ctx, w, args)
+	case "DEL":
+		if len(args) == 0 {
+			wrongArgs(w, name)
+			break
+		}
+		deleted := 0
+		for _, key := range args {
+			if err = srv.s.Delete(ctx, key); err == nil {
+				deleted++
+			} else if errors.Is(err, storage.ErrNotFound) {
+				err = nil
+			} else {
+				break
+			}
+		}
+		if err == nil {
+			w.integer(deleted)
+		}
+	case "EXISTS":
+		if len(args) == 0 {
+			wrongArgs(w, name)
+			break
+		}
+		existing := 0
+		for _, key := range args { // как в Redis, повторенный ключ считается столько раз, сколько его назвали
+			if _, err = srv.s.Get(ctx, key); err == nil {
+				existing++
+			} else if errors.Is(err, storage.ErrNotFound) {
+				err = nil
+			} else {
+				break
+			}
+		}
+		if err == nil {
+			w.integer(existing)
+		}
+	case "KEYS":
+		if len(args) != 1 {
+			wrongArgs(w, name)
+			break
+		}
+		err = srv.keys(ctx, w, args[0])
+	case "INFO":
+		err = srv.info(ctx, w)
+	case "SELECT":
+		// баз у нас нет, но клиенты с db=0 в адресе шлют SELECT 0 при подключении
+		switch {
+		case len(args) != 1:
+			wrongArgs(w, name)
+		case args[0] == "0":
+			w.status("OK")
+		default:
+			w.error("ERR DB index is out of range")
+		}
+	default:
+		w.error(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", strings.ToLower(name), argsPreview(args)))
+	}
+	if err != nil {
+		w.error(respError(err))
+	}
+	return false
+}
+
+func (srv *Server) auth(sess *session, creds *auth.Credentials, args []string) {
+	var header string
+	switch len(args) {
+	case 1:
+		header = "Bearer " + args[0]
+	case 2:
+		header = "Basic " + base64.StdEncoding.EncodeToString([]byte(args[0]+":"+args[1]))
+	default:
+		wrongArgs(sess.w, "AUTH")
+		return
+	}
+	if !creds.Enabled() {
+		sess.w.error("ERR AUTH called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+	if err := creds.Check(header); err != nil {
+		sess.w.error("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	sess.authed = true
+	sess.w.status("OK")
+}
+
+// set - SET key value [EX seconds | PX milliseconds] [NX]. с NX и занятым ключом ответ - null, как у Redis
+func (srv *Server) set(ctx context.Context, w replyWriter, args []string) (err error) {
+	if len(args) < 2 {
+		wrongArgs(w, "SET")
+		return nil
+	}
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	var nx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "NX":
+			nx = true
+		case (opt == "EX" || opt == "PX") && ttl == 0 && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n <= 0 {
+				w.error("ERR invalid expire time in 'set' command")
+				return nil
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+		default:
+			w.error("ERR syntax error")
+			return nil
+		}
+	}
+
+	switch {
+	case nx && ttl > 0:
+		w.error("ERR SET with both NX and a ttl is not supported")
+		return nil
+	case nx:
+		cs, ok := storage.As[storage.ConditionalStorage](srv.s)
+		if !ok {
+			return storage.ErrNotSupported
+		}
+		set, err := cs.SetIfAbsent(ctx, key, value)
+		if err == nil && !set {
+			w.null()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	case ttl > 0:
+		es, ok := storage.As[storage.ExpiringStorage](srv.s)
+		if !ok {
+			return storage.ErrNotSupported
+		}
+		if err = es.SetWithTTL(ctx, key, value, ttl); err != nil {
+			return err
+		}
+	default:
+		if err = srv.s.Set(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	w.status("OK")
+	return nil
+}
+
+// keys - KEYS pattern. шаблон разбирает storage.GlobPattern, так что * не совпадает со слэшем, в отличие от Redis
+func (srv *Server) keys(ctx context.Context, w replyWriter, pattern string) (err error) {
+	p, err := storage.GlobPattern(pattern)
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return nil
+	}
+	keys, err := srv.s.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	matched := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if p.MatchString(k) {
+			matched = append(matched, k)
+		}
+	}
+	w.array(matched)
+	return nil
+}
+
+// info - INFO в формате Redis: разделы # Server и # Keyspace, только то, что у нас есть
+func (srv *Server) info(ctx context.Context, w replyWriter) (err error) {
+	stats, err := storage.Stats(ctx, srv.s)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	uptime := int(time.Since(srv.started).Seconds())
+	fmt.Fprintf(&b, "# Server\r\nredis_mode:standalone\r\nprocess_id:%d\r\nuptime_in_seconds:%d\r\n", os.Getpid(), uptime)
+	if stats.Backend != "" {
+		fmt.Fprintf(&b, "examplefs_backend:%s\r\n", stats.Backend)
+	}
+	fmt.Fprintf(&b, "\r\n# Memory\r\nused_memory_dataset:%d\r\n", stats.Bytes)
+	fmt.Fprintf(&b, "\r\n# Keyspace\r\ndb0:keys=%d,expires=0,avg_ttl=0\r\n", stats.Keys)
+	w.bulk(b.String())
+	return nil
+}
+
+func wrongArgs(w replyWriter, name string) {
+	w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// argsPreview - начало аргументов неизвестной команды для ошибки, как их показывает Redis
+func argsPreview(args []string) string {
+	var b strings.Builder
+	for _, a := range args {
+		if b.Len() > 128 {
+			break
+		}
+		fmt.Fprintf(&b, "'%s' ", a)
+	}
+	return b.String()
+}
+
+// respError - то же, что errorClass в httpapi, только для ошибок RESP
+func respError(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "ERR storage call timed out"
+	case errors.Is(err, storage.ErrInvalidKey), errors.Is(err, storage.ErrTooLarge):
+		return "ERR " + err.Error()
+	case errors.Is(err, storage.ErrReadOnly):
+		return "READONLY storage is read-only"
+	case errors.Is(err, storage.ErrUnavailable):
+		return "ERR storage is temporarily unavailable"
+	case errors.Is(err, storage.ErrNotSupported):
+		return "ERR not supported by this storage"
+	}
+	// как и в HTTP, внутренности (пути, ошибки ОС) остаются в логе
+	slog.Error("storage error", "error", err)
+	return "ERR internal storage error"
+}
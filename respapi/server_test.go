@@ -0,0 +1,149 @@
+package respapi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Barugoo/example-fs/auth"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// conn - клиент RESP для тестов: шлет команды multi-bulk и читает ответы как строки
+type conn struct {
+	t  *testing.T
+	c  net.Conn
+	br *bufio.Reader
+}
+
+func dial(t *testing.T, creds auth.Credentials, protectReads bool) *conn {
+	t.Helper()
+	p := new(atomic.Pointer[auth.Credentials])
+	p.Store(&creds)
+	srv := NewServer(storage.NewMemStorage(), p, protectReads)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	c, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &conn{t: t, c: c, br: bufio.NewReader(c)}
+}
+
+// do отправляет команду и возвращает ответ: статус и ошибка как есть (+OK, -ERR ...), число как :N,
+// строку без обрамления, null как (nil), массив как [a b]
+func (c *conn) do(args ...string) string {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.c.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.reply()
+}
+
+func (c *conn) reply() string {
+	c.t.Helper()
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "(nil)"
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(c.br, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]string, n)
+		for i := range items {
+			items[i] = c.reply()
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	return line
+}
+
+func (c *conn) want(want string, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); got != want {
+		c.t.Errorf("%s: got %q, want %q", strings.Join(args, " "), got, want)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	c := dial(t, auth.Credentials{}, false)
+	c.want("+PONG", "PING")
+	c.want("hi", "ping", "hi")
+	c.want("+OK", "SET", "a", "1")
+	c.want("+OK", "SET", "b", "2")
+	c.want("1", "GET", "a")
+	c.want("(nil)", "GET", "missing")
+	c.want("(nil)", "SET", "a", "x", "NX")
+	c.want(":3", "EXISTS", "a", "b", "a")
+	c.want("[a b]", "KEYS", "*")
+	c.want(":1", "DEL", "a", "missing")
+	c.want("(nil)", "GET", "a")
+	c.want("+OK", "SELECT", "0")
+	c.want("-ERR DB index is out of range", "SELECT", "1")
+	c.want("-ERR wrong number of arguments for 'get' command", "GET")
+	if got := c.do("FLUSHALL"); !strings.HasPrefix(got, "-ERR unknown command 'flushall'") {
+		t.Errorf("FLUSHALL: got %q", got)
+	}
+	c.want("+OK", "QUIT")
+}
+
+func TestAuth(t *testing.T) {
+	c := dial(t, auth.Credentials{Token: "secret"}, false)
+	c.want("+PONG", "PING")
+	c.want("-NOAUTH Authentication required.", "SET", "k", "v")
+	c.want("(nil)", "GET", "k") // без protectReads читать можно
+	c.want("-WRONGPASS invalid username-password pair or user is disabled.", "AUTH", "wrong")
+	c.want("+OK", "AUTH", "secret")
+	c.want("+OK", "SET", "k", "v")
+
+	c = dial(t, auth.Credentials{User: "u", Pass: "p"}, true)
+	c.want("-NOAUTH Authentication required.", "GET", "k")
+	c.want("+OK", "AUTH", "u", "p")
+	c.want("(nil)", "GET", "k")
+}
+
+func TestReadCommand(t *testing.T) {
+	// конвейер из multi-bulk и inline команды, пустая строка между ними пропускается
+	br := bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$3\r\na b\r\n\r\nSET k  v\r\n"))
+	for _, want := range [][]string{{"GET", "a b"}, {"SET", "k", "v"}} {
+		args, err := readCommand(br)
+		if err != nil || strings.Join(args, "|") != strings.Join(want, "|") {
+			t.Fatalf("got %q, %v, want %q", args, err, want)
+		}
+	}
+
+	for _, in := range []string{"*x\r\n", "*99999999\r\n", "*1\r\n+GET\r\n", "*1\r\n$999999999\r\n"} {
+		if _, err := readCommand(bufio.NewReader(strings.NewReader(in))); !errors.Is(err, errProtocol) {
+			t.Errorf("%q: got %v, want a protocol error", in, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// Appender - хранилка, которая дописывает к значению за один шаг, без чтения и записи со стороны клиента
+type Appender interface {
+	// Append дописывает suffix в конец значения key, отсутствующий ключ создается с suffix. возвращает новую длину значения
+	Append(ctx context.Context, key, suffix string) (length int, err error)
+}
+
+// Append дописывает к значению. у кого нет своего Append, дописываем через CompareAndSwap, пока он не пройдет
+func Append(ctx context.Context, s Storage, key, suffix string) (length int, err error) {
+	if a, ok := As[Appender](s); ok {
+		return a.Append(ctx, key, suffix)
+	}
+	return appendByCAS(ctx, s, key, suffix)
+}
+
+func appendByCAS(ctx context.Context, s Storage, key, suffix string) (length int, err error) {
+	cs, ok := As[ConditionalStorage](s)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	for {
+		current, err := cs.Get(ctx, key)
+		exists := err == nil
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return 0, err
+		}
+		value := current + suffix
+		if err = checkAppended(ctx, value); err != nil {
+			return 0, err
+		}
+		var done bool
+		if exists {
+			done, err = cs.CompareAndSwap(ctx, key, current, value)
+		} else {
+			done, err = cs.SetIfAbsent(ctx, key, value)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			return len(value), nil
+		}
+		// кто-то успел записать ключ между Get и CompareAndSwap, читаем заново
+		if err = ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// итоговую длину знает только хранилка, так что LimitedStorage передает ей лимит значения через контекст
+type valueLimitKey struct{}
+
+func withValueLimit(ctx context.Context, maxBytes int) context.Context {
+	if maxBytes <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, valueLimitKey{}, maxBytes)
+}
+
+// checkAppended проверяет значение после Append лимитом из контекста, до того как оно записано
+func checkAppended(ctx context.Context, value string) error {
+	if limit, _ := ctx.Value(valueLimitKey{}).(int); limit > 0 && len(value) > limit {
+		return &TooLargeError{What: "value", Size: len(value), Limit: limit}
+	}
+	return nil
+}
+
+// appendValue не трогает ttl ключа, как incr, вызывается под блокировкой
+func (ms *MemStorage) appendValue(ctx context.Context, key, suffix string) (length int, err error) {
+	current, ok := ms.lookup(key)
+	value := current + suffix
+	if err = checkAppended(ctx, value); err != nil {
+		return 0, err
+	}
+	if !ok {
+		ms.drop(key) // протухший ключ начинается заново, уже без старого ttl
+	}
+	ms.store(key, value)
+	ms.watchers.notify(Event{Op: EventSet, Key: key, Value: value})
+	return len(value), nil
+}
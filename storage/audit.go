@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type clientKey struct{}
+
+// WithClient кладет в контекст, кто делает запрос (адрес, логин), - его увидит журнал аудита
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFrom - клиент из контекста, пусто, если его никто не положил
+func ClientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+type requestIDKey struct{}
+
+// WithRequestID кладет в контекст id запроса, по которому его строки находятся в логах, журнале аудита и трейсах
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom - id запроса из контекста, пусто вне HTTP запроса
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// как часто буфер журнала аудита сбрасывается в файл, если сам не заполнился
+const auditFlushInterval = time.Second
+
+// AuditLog - журнал изменений, по JSON строке на операцию. пишется через буфер, так что запись
+// в хранилку ждет только копирования строки в память; на диск буфер уходит раз в auditFlushInterval,
+// при заполнении и в Close. файл больше maxBytes переименовывается в .1 (старый .1 в .2 и т.д.), хранится keep таких
+type AuditLog struct {
+	mu       sync.Mutex
+	name     string
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+	maxBytes int64 // 0 - без ротации
+	keep     int
+	closed   bool
+	done     chan struct{}
+}
+
+// AuditEntry - одна строка журнала. значение пишется либо целиком, либо только его ревизией - тем же хэшем, что в ETag
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Backend   string    `json:"backend"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key,omitempty"`
+	Value     *string   `json:"value,omitempty"`
+	Revision  string    `json:"revision,omitempty"`
+	TTL       string    `json:"ttl,omitempty"`
+	Keys      int       `json:"keys,omitempty"` // для replace - сколько ключей стало
+	To        string    `json:"to,omitempty"`   // для rename - новое имя ключа
+	Client    string    `json:"client,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Write добавляет строку в журнал. ошибка записи только логируется: изменение в хранилке уже произошло,
+// и отказ клиенту его не отменил бы
+func (al *AuditLog) Write(e AuditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("unable to encode audit entry: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		log.Printf("audit log %s is closed, dropping entry: %s", al.name, line)
+		return
+	}
+	if al.maxBytes > 0 && al.size > 0 && al.size+int64(len(line)) > al.maxBytes {
+		if err = al.rotate(); err != nil {
+			log.Printf("unable to rotate audit log %s: %v", al.name, err)
+		}
+	}
+	n, err := al.w.Write(line)
+	al.size += int64(n)
+	if err != nil {
+		log.Printf("unable to write audit log %s: %v", al.name, err)
+	}
+}
+
+// rotate сдвигает старые файлы на номер вверх и начинает новый, вызывается под al.mu
+func (al *AuditLog) rotate() (err error) {
+	if err = al.w.Flush(); err != nil {
+		return err
+	}
+	if err = al.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(al.name + "." + strconv.Itoa(al.keep)) // самый старый выпадает
+	for i := al.keep - 1; i >= 1; i-- {
+		os.Rename(al.name+"."+strconv.Itoa(i), al.name+"."+strconv.Itoa(i+1))
+	}
+	if al.keep > 0 {
+		if err = os.Rename(al.name, al.name+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(al.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	al.f, al.size = f, 0
+	al.w.Reset(f)
+	return nil
+}
+
+// Flush сбрасывает буфер в файл
+func (al *AuditLog) Flush() (err error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		return nil
+	}
+	return al.w.Flush()
+}
+
+func (al *AuditLog) flushLoop() {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-al.done:
+			return
+		case <-ticker.C:
+			if err := al.Flush(); err != nil {
+				log.Printf("unable to flush audit log %s: %v", al.name, err)
+			}
+		}
+	}
+}
+
+// Close дописывает буфер и закрывает файл. повторный вызов ничего не делает
+func (al *AuditLog) Close() (err error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		return nil
+	}
+	al.closed = true
+	close(al.done)
+	if err = al.w.Flush(); err != nil {
+		al.f.Close()
+		return fmt.Errorf("unable to flush audit log %s: %w", al.name, err)
+	}
+	if err = al.f.Sync(); err != nil {
+		al.f.Close()
+		return fmt.Errorf("unable to sync audit log %s: %w", al.name, err)
+	}
+	return al.f.Close()
+}
+
+// NewAuditLog открывает журнал name на дозапись. maxBytes == 0 - без ротации, keep - сколько старых файлов хранить
+func NewAuditLog(name string, maxBytes int64, keep int) (*AuditLog, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log %s: %w", name, err)
+	}
+	al := &AuditLog{name: name, f: f, w: bufio.NewWriterSize(f, 64<<10), size: fileSize(f), maxBytes: maxBytes, keep: keep, done: make(chan struct{})}
+	go al.flushLoop()
+	return al, nil
+}
+
+// AuditedStorage пишет в AuditLog каждое удачное изменение. неудачные и не сработавшие условные записи
+// в журнал не попадают - в нем только то, что на самом деле изменило данные.
+// Unwrap есть, поэтому все пишущие возможности (ttl, CAS, счетчики, Replace) переопределены здесь,
+// иначе As нашел бы их у нижней хранилки и запись прошла бы мимо журнала
+type AuditedStorage struct {
+	Storage
+	log       *AuditLog
+	backend   string
+	rawValues bool // писать значения как есть, а не ревизию
+}
+
+func (as *AuditedStorage) entry(ctx context.Context, op, key string) AuditEntry {
+	return AuditEntry{Time: time.Now().UTC(), Backend: as.backend, Op: op, Key: key, Client: ClientFrom(ctx), RequestID: RequestIDFrom(ctx)}
+}
+
+func (as *AuditedStorage) withValue(e AuditEntry, value string) AuditEntry {
+	if as.rawValues {
+		e.Value = &value
+	} else {
+		e.Revision = Revision(value)
+	}
+	return e
+}
+
+func (as *AuditedStorage) Set(ctx context.Context, key, value string) (err error) {
+	if err = as.Storage.Set(ctx, key, value); err == nil {
+		as.log.Write(as.withValue(as.entry(ctx, "set", key), value))
+	}
+	return err
+}
+
+// SetMany пишет по строке на ключ, чтобы историю ключа можно было найти grep'ом
+func (as *AuditedStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	if err = as.Storage.SetMany(ctx, kv); err == nil {
+		for k, v := range kv {
+			as.log.Write(as.withValue(as.entry(ctx, "set_many", k), v))
+		}
+	}
+	return err
+}
+
+func (as *AuditedStorage) Delete(ctx context.Context, key string) (err error) {
+	if err = as.Storage.Delete(ctx, key); err == nil {
+		as.log.Write(as.entry(ctx, "delete", key))
+	}
+	return err
+}
+
+func (as *AuditedStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](as.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err = es.SetWithTTL(ctx, key, value, ttl); err == nil {
+		e := as.withValue(as.entry(ctx, "set", key), value)
+		e.TTL = ttl.String()
+		as.log.Write(e)
+	}
+	return err
+}
+
+func (as *AuditedStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](as.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if swapped, err = cs.CompareAndSwap(ctx, key, old, new); err == nil && swapped {
+		as.log.Write(as.withValue(as.entry(ctx, "compare_and_swap", key), new))
+	}
+	return swapped, err
+}
+
+func (as *AuditedStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](as.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if set, err = cs.SetIfAbsent(ctx, key, value); err == nil && set {
+		as.log.Write(as.withValue(as.entry(ctx, "set_if_absent", key), value))
+	}
+	return set, err
+}
+
+func (as *AuditedStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	if value, existed, err = GetOrSet(ctx, as.Storage, key, defaultValue); err == nil && !existed {
+		as.log.Write(as.withValue(as.entry(ctx, "set_if_absent", key), value))
+	}
+	return value, existed, err
+}
+
+// в журнал идет только дописанный кусок: значение целиком могло вырасти до мегабайт
+func (as *AuditedStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	if length, err = Append(ctx, as.Storage, key, suffix); err == nil {
+		as.log.Write(as.withValue(as.entry(ctx, "append", key), suffix))
+	}
+	return length, err
+}
+
+func (as *AuditedStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](as.Storage)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	if value, err = inc.Increment(ctx, key, delta); err == nil {
+		as.log.Write(as.withValue(as.entry(ctx, "increment", key), strconv.FormatInt(value, 10)))
+	}
+	return value, err
+}
+
+// Replace подменяет все данные, так что пишем одну строку с числом ключей, а не весь снимок
+func (as *AuditedStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	if value, err = Undelete(ctx, as.Storage, key); err == nil {
+		as.log.Write(as.withValue(as.entry(ctx, "undelete", key), value))
+	}
+	return value, err
+}
+
+func (as *AuditedStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	if err = Replace(ctx, as.Storage, kv); err == nil {
+		e := as.entry(ctx, "replace", "")
+		e.Keys = len(kv)
+		as.log.Write(e)
+	}
+	return err
+}
+
+func (as *AuditedStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	if err = Rename(ctx, as.Storage, oldKey, newKey, overwrite); err == nil {
+		e := as.entry(ctx, "rename", oldKey)
+		e.To = newKey
+		as.log.Write(e)
+	}
+	return err
+}
+
+// Txn пишет по строке на каждую запись транзакции, как SetMany, с общим request_id
+func (as *AuditedStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	if err = Txn(ctx, as.Storage, ops); err != nil {
+		return err
+	}
+	for _, op := range txnWrites(ops) {
+		if op.Type == TxnSet {
+			as.log.Write(as.withValue(as.entry(ctx, "txn_set", op.Key), op.Value))
+		} else {
+			as.log.Write(as.entry(ctx, "txn_delete", op.Key))
+		}
+	}
+	return nil
+}
+
+// DeletePrefix тоже одной строкой: префикс вместо ключа и сколько ключей удалено
+func (as *AuditedStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	if deleted, err = DeletePrefix(ctx, as.Storage, prefix); err == nil {
+		e := as.entry(ctx, "delete_prefix", prefix)
+		e.Keys = deleted
+		as.log.Write(e)
+	}
+	return deleted, err
+}
+
+// Dump здесь только ради As[Dumper]: без него Replace нашелся бы у бэкенда и прошел мимо журнала
+func (as *AuditedStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return Dump(ctx, as.Storage)
+}
+
+// Close сбрасывает журнал на диск и закрывает нижнюю хранилку. сам журнал общий для всех бэкендов,
+// его закрывает тот, кто открыл
+func (as *AuditedStorage) Close() (err error) {
+	if err = as.log.Flush(); err != nil {
+		log.Printf("unable to flush audit log: %v", err)
+	}
+	if c, ok := As[io.Closer](as.Storage); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (as *AuditedStorage) Unwrap() Storage {
+	return as.Storage
+}
+
+// NewAuditedStorage пишет изменения s в al с меткой backend. rawValues - писать сами значения, а не их ревизии
+func NewAuditedStorage(s Storage, al *AuditLog, backend string, rawValues bool) Storage {
+	return &AuditedStorage{Storage: s, log: al, backend: backend, rawValues: rawValues}
+}
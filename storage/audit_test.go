@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readAudit(t *testing.T, name string) (entries []AuditEntry) {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e AuditEntry
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad audit line %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditedStorage(t *testing.T) {
+	ctx := WithClient(context.Background(), "tester")
+	name := filepath.Join(t.TempDir(), "audit.log")
+	al, err := NewAuditLog(name, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAuditedStorage(NewMemStorage(), al, "memory", false)
+
+	s.Set(ctx, "a", "1")
+	s.Delete(ctx, "missing") // неудачное удаление в журнал не попадает
+	if cs, ok := As[ConditionalStorage](s); ok {
+		cs.CompareAndSwap(ctx, "a", "wrong", "2") // не сработал - тоже не попадает
+		cs.CompareAndSwap(ctx, "a", "1", "2")
+		cs.SetIfAbsent(ctx, "a", "3")
+	} else {
+		t.Fatal("AuditedStorage must be a ConditionalStorage")
+	}
+	if inc, ok := As[Incrementer](s); ok {
+		inc.Increment(ctx, "n", 5)
+	}
+	// Replace ищется через As[Dumper] и должен найти обертку, а не MemStorage
+	if err = Replace(ctx, s, map[string]string{"x": "1", "y": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete(ctx, "x")
+	if err = al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAudit(t, name)
+	want := []struct{ op, key, revision string }{
+		{"set", "a", Revision("1")},
+		{"compare_and_swap", "a", Revision("2")},
+		{"increment", "n", Revision("5")},
+		{"replace", "", ""},
+		{"delete", "x", ""},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Op != w.op || e.Key != w.key || e.Revision != w.revision || e.Backend != "memory" || e.Client != "tester" || e.Value != nil {
+			t.Errorf("entry %d = %+v, want op=%s key=%s revision=%s", i, e, w.op, w.key, w.revision)
+		}
+	}
+	if entries[3].Keys != 2 {
+		t.Errorf("replace entry keys = %d, want 2", entries[3].Keys)
+	}
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "audit.log")
+	al, err := NewAuditLog(name, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAuditedStorage(NewMemStorage(), al, "memory", true)
+	for i := 0; i < 20; i++ {
+		s.Set(ctx, "key", "value")
+	}
+	if err = al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for _, n := range []string{name, name + ".1", name + ".2"} {
+		fi, err := os.Stat(n)
+		if err != nil {
+			t.Fatalf("%s: %v", n, err)
+		}
+		if fi.Size() > 200 {
+			t.Errorf("%s is %d bytes, want at most 200", n, fi.Size())
+		}
+		for _, e := range readAudit(t, n) {
+			if e.Value == nil || *e.Value != "value" {
+				t.Errorf("%s: entry %+v has no raw value", n, e)
+			}
+			total++
+		}
+	}
+	if _, err = os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 must not be kept: %v", name, err)
+	}
+	if total == 0 || total == 20 {
+		t.Errorf("got %d entries across kept files, want some rotated out", total)
+	}
+}
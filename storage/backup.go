@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backuper - хранилка, которая умеет сохранить копию своих данных рядом с собой
+type Backuper interface {
+	// Backup возвращает имя созданного файла
+	Backup() (name string, err error)
+}
+
+// имя копии - основной файл плюс ".bak-" и время в UTC. ширина времени фиксированная,
+// так что сортировка имен совпадает с хронологической
+const (
+	backupInfix      = ".bak-"
+	backupTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// Backup копирует текущий файл в <file>.bak-<время> и выкидывает лишние старые копии
+func (fs *FileStorage) Backup() (name string, err error) {
+	log.Println("called file storage Backup method")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return "", ErrClosed
+	}
+	if err = fs.flush(); err != nil {
+		return "", err
+	}
+	return fs.backup()
+}
+
+// backup вызывается под блокировкой, так что файл не меняется, пока мы его копируем
+func (fs *FileStorage) backup() (name string, err error) {
+	stamp := time.Now().UTC().Format(backupTimeFormat)
+	if runtime.GOOS == "windows" {
+		stamp = strings.ReplaceAll(stamp, ":", "-") // двоеточие в имени файла windows не пускает
+	}
+	name = fs.name + backupInfix + stamp
+	if err = copyFile(fs.name, name, fs.opts.fileMode); err != nil {
+		return "", fmt.Errorf("unable to back up %s: %w", fs.name, err)
+	}
+	if fs.opts.backups > 0 {
+		pruneBackups(fs.name, fs.opts.backups)
+	}
+	return name, nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(dst)
+		}
+	}()
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	// копия нужна как раз на случай падения, так что без fsync она бесполезна
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// listBackups - копии файла name, от старых к новым
+func listBackups(name string) []string {
+	matches, _ := filepath.Glob(name + backupInfix + "*")
+	backups := matches[:0]
+	for _, m := range matches {
+		if filepath.Ext(m) == lockSuffix { // NewFileStorage на копии оставляет рядом свой .lock
+			continue
+		}
+		backups = append(backups, m)
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+func latestBackup(name string) string {
+	backups := listBackups(name)
+	if len(backups) == 0 {
+		return ""
+	}
+	return backups[len(backups)-1]
+}
+
+// pruneBackups оставляет keep самых свежих копий
+func pruneBackups(name string, keep int) {
+	backups := listBackups(name)
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Printf("warning: unable to remove old backup %s: %v", backups[0], err)
+		}
+		os.Remove(backups[0] + lockSuffix) // если копию открывали, рядом остался ее .lock
+		backups = backups[1:]
+	}
+}
@@ -0,0 +1,37 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+	"github.com/Barugoo/example-fs/storagetest"
+)
+
+func newBenchMem(b *testing.B) storage.Storage {
+	return storage.NewMemStorage()
+}
+
+func newBenchFile(b *testing.B) storage.Storage {
+	s, err := storage.NewFileStorage(filepath.Join(b.TempDir(), "data.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+func BenchmarkMemStorage(b *testing.B) {
+	storagetest.BenchmarkStorage(b, newBenchMem)
+}
+
+func BenchmarkFileStorage(b *testing.B) {
+	storagetest.BenchmarkStorage(b, newBenchFile)
+}
+
+func BenchmarkMemStorageHTTP(b *testing.B) {
+	storagetest.BenchmarkHTTP(b, newBenchMem)
+}
+
+func BenchmarkFileStorageHTTP(b *testing.B) {
+	storagetest.BenchmarkHTTP(b, newBenchFile)
+}
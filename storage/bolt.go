@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bolt
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// все пары лежат в одном бакете
+var boltBucket = []byte("kv")
+
+func (bs *BoltStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called bolt storage Get method")
+
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		// v живет только до конца транзакции, string() делает копию
+		value = string(v)
+		return nil
+	})
+	return value, err
+}
+
+func (bs *BoltStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called bolt storage Set method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to put key into bolt: %w", err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called bolt storage Delete method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b.Get([]byte(key)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (bs *BoltStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called bolt storage Keys method")
+
+	keys = make([]string, 0)
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		// bolt обходит ключи в побайтовом порядке - это тот же порядок, что дает sort.Strings
+		return tx.Bucket(boltBucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys in bolt: %w", err)
+	}
+	return keys, nil
+}
+
+// Scan идет курсором от prefix: ключи в bolt отсортированы, так что остальную базу не трогаем
+func (bs *BoltStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	log.Println("called bolt storage Scan method")
+
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !fn(string(k), string(v)) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to scan bolt: %w", err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	log.Println("called bolt storage KeysPage method")
+
+	keys = make([]string, 0, limit)
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		k, _ := c.Seek([]byte(afterKey))
+		if k != nil && string(k) == afterKey {
+			k, _ = c.Next()
+		}
+		for ; k != nil && len(keys) < limit; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys in bolt: %w", err)
+	}
+	return keys, nil
+}
+
+func (bs *BoltStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called bolt storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, k := range keys {
+			if v := b.Get([]byte(k)); v != nil {
+				kv[k] = string(v)
+			}
+		}
+		return nil
+	})
+	return kv, err
+}
+
+// SetMany пишет весь батч одной транзакцией
+func (bs *BoltStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called bolt storage SetMany method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for k, v := range kv {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to put keys into bolt: %w", err)
+	}
+	return nil
+}
+
+// у bolt один писатель за раз, так что проверка и запись в одной Update атомарны
+func (bs *BoltStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	log.Println("called bolt storage CompareAndSwap method")
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if v := b.Get([]byte(key)); v == nil || string(v) != old {
+			return nil
+		}
+		swapped = true
+		return b.Put([]byte(key), []byte(new))
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to swap key in bolt: %w", err)
+	}
+	return swapped, nil
+}
+
+func (bs *BoltStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	log.Println("called bolt storage SetIfAbsent method")
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b.Get([]byte(key)) != nil {
+			return nil
+		}
+		set = true
+		return b.Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to put key into bolt: %w", err)
+	}
+	return set, nil
+}
+
+func (bs *BoltStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	log.Println("called bolt storage Increment method")
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		v := b.Get([]byte(key))
+		if value, err = addInt(string(v), v != nil, delta); err != nil {
+			return err
+		}
+		return b.Put([]byte(key), []byte(strconv.FormatInt(value, 10)))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to increment key in bolt: %w", err)
+	}
+	return value, nil
+}
+
+func (bs *BoltStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	log.Println("called bolt storage Dump method")
+
+	kv = make(map[string]string)
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			kv[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to dump bolt: %w", err)
+	}
+	return kv, nil
+}
+
+// Replace пересоздает бакет в одной транзакции, так что читатели видят либо старые данные, либо новые
+func (bs *BoltStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called bolt storage Replace method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(boltBucket)
+		if err != nil {
+			return err
+		}
+		for k, v := range kv {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to replace bolt contents: %w", err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) Ping() (err error) {
+	return bs.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (bs *BoltStorage) Close() (err error) {
+	log.Println("called bolt storage Close method")
+	return bs.db.Close()
+}
+
+func NewBoltStorage(path string) (Storage, error) {
+	// таймаут нужен, чтобы второй процесс на том же файле не висел вечно на блокировке bolt
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bolt db %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create bucket in bolt db %s: %w", path, err)
+	}
+	return &BoltStorage{db: db}, nil
+}
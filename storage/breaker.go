@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen - бэкенд недавно падал подряд, и вызов отвергнут, не дойдя до него. это ErrUnavailable,
+// так что для клиентов это те же 503, но без ожидания очередного таймаута
+var ErrCircuitOpen = fmt.Errorf("circuit breaker is open: %w", ErrUnavailable)
+
+// CircuitOpenError - ErrCircuitOpen вместе с тем, когда breaker пустит пробный вызов
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrCircuitOpen, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// CircuitState - состояние breaker, числа идут в метрику
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // вызовы идут в бэкенд, считаем падения подряд
+	CircuitOpen                         // вызовы сразу отвергаются, ждем конца паузы
+	CircuitHalfOpen                     // пауза кончилась, один пробный вызов решает, закрыться или открыться снова
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreakerStorage перестает звать бэкенд после threshold падений подряд и отвечает ErrCircuitOpen
+// сразу, пока не пройдет cooldown. потом пропускает один пробный вызов: удачный закрывает breaker,
+// неудачный открывает его на следующий cooldown. падением считаются только ошибки самого бэкенда,
+// ErrNotFound, плохой ключ и прочие ответы по существу запроса breaker не трогают.
+// Unwrap есть, поэтому все возможности, которые ходят в бэкенд, переопределены здесь
+type CircuitBreakerStorage struct {
+	Storage
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time // подменяется в тестах, чтобы не ждать реального времени
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int       // падения подряд в CircuitClosed
+	openedAt time.Time // когда breaker открылся в последний раз
+	probing  bool      // пробный вызов уже идет, остальные в CircuitHalfOpen отвергаются
+}
+
+// State - текущее состояние для метрик и логов
+func (cb *CircuitBreakerStorage) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// setState вызывается под cb.mu
+func (cb *CircuitBreakerStorage) setState(state CircuitState) {
+	if cb.state != state {
+		log.Printf("circuit breaker of %s backend: %s -> %s", cb.name, cb.state, state)
+	}
+	cb.state = state
+}
+
+// allow решает, пускать ли вызов. probe == true - это пробный вызов в CircuitHalfOpen
+func (cb *CircuitBreakerStorage) allow() (probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen {
+		if wait := cb.openedAt.Add(cb.cooldown).Sub(cb.now()); wait > 0 {
+			return false, &CircuitOpenError{RetryAfter: wait}
+		}
+		cb.setState(CircuitHalfOpen)
+	}
+	if cb.state == CircuitHalfOpen {
+		if cb.probing {
+			return false, &CircuitOpenError{RetryAfter: cb.cooldown}
+		}
+		cb.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (cb *CircuitBreakerStorage) done(probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if probe {
+		cb.probing = false
+	}
+	if !isBackendFailure(err) {
+		cb.failures = 0
+		if probe {
+			cb.setState(CircuitClosed)
+		}
+		return
+	}
+	cb.failures++
+	if probe || cb.state == CircuitClosed && cb.failures >= cb.threshold {
+		cb.failures = 0
+		cb.openedAt = cb.now()
+		cb.setState(CircuitOpen)
+	}
+}
+
+// isBackendFailure отделяет сбой бэкенда от ответа по существу запроса. отмена вызова клиентом
+// о бэкенде ничего не говорит, а вот истекший дедлайн говорит
+func isBackendFailure(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrInvalidKey),
+		errors.Is(err, ErrTooLarge),
+		errors.Is(err, ErrNotNumeric),
+		errors.Is(err, ErrNotSupported),
+		errors.Is(err, ErrReadOnly),
+		errors.Is(err, ErrExists),
+		errors.Is(err, context.Canceled):
+		return false
+	}
+	return true
+}
+
+func guard[T any](cb *CircuitBreakerStorage, fn func() (T, error)) (v T, err error) {
+	probe, err := cb.allow()
+	if err != nil {
+		return v, err
+	}
+	v, err = fn()
+	cb.done(probe, err)
+	return v, err
+}
+
+func (cb *CircuitBreakerStorage) Get(ctx context.Context, key string) (value string, err error) {
+	return guard(cb, func() (string, error) { return cb.Storage.Get(ctx, key) })
+}
+
+func (cb *CircuitBreakerStorage) Set(ctx context.Context, key, value string) (err error) {
+	_, err = guard(cb, func() (noValue, error) { return noValue{}, cb.Storage.Set(ctx, key, value) })
+	return err
+}
+
+func (cb *CircuitBreakerStorage) Delete(ctx context.Context, key string) (err error) {
+	_, err = guard(cb, func() (noValue, error) { return noValue{}, cb.Storage.Delete(ctx, key) })
+	return err
+}
+
+func (cb *CircuitBreakerStorage) Keys(ctx context.Context) (keys []string, err error) {
+	return guard(cb, func() ([]string, error) { return cb.Storage.Keys(ctx) })
+}
+
+func (cb *CircuitBreakerStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	return guard(cb, func() (map[string]string, error) { return cb.Storage.GetMany(ctx, keys) })
+}
+
+func (cb *CircuitBreakerStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	_, err = guard(cb, func() (noValue, error) { return noValue{}, cb.Storage.SetMany(ctx, kv) })
+	return err
+}
+
+func (cb *CircuitBreakerStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](cb.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	_, err = guard(cb, func() (noValue, error) { return noValue{}, es.SetWithTTL(ctx, key, value, ttl) })
+	return err
+}
+
+func (cb *CircuitBreakerStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](cb.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return guard(cb, func() (bool, error) { return cs.CompareAndSwap(ctx, key, old, new) })
+}
+
+func (cb *CircuitBreakerStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](cb.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return guard(cb, func() (bool, error) { return cs.SetIfAbsent(ctx, key, value) })
+}
+
+func (cb *CircuitBreakerStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	// guard возвращает одно значение, так что existed достаем через замыкание
+	value, err = guard(cb, func() (v string, err error) {
+		v, existed, err = GetOrSet(ctx, cb.Storage, key, defaultValue)
+		return v, err
+	})
+	return value, existed, err
+}
+
+func (cb *CircuitBreakerStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	return guard(cb, func() (int, error) { return Append(ctx, cb.Storage, key, suffix) })
+}
+
+func (cb *CircuitBreakerStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](cb.Storage)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return guard(cb, func() (int64, error) { return inc.Increment(ctx, key, delta) })
+}
+
+func (cb *CircuitBreakerStorage) GetEntry(ctx context.Context, key string) (entry Entry, err error) {
+	eg, ok := As[EntryGetter](cb.Storage)
+	if !ok {
+		return entry, ErrNotSupported
+	}
+	return guard(cb, func() (Entry, error) { return eg.GetEntry(ctx, key) })
+}
+
+func (cb *CircuitBreakerStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return guard(cb, func() (map[string]string, error) { return Dump(ctx, cb.Storage) })
+}
+
+func (cb *CircuitBreakerStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	_, err = guard(cb, func() (noValue, error) { return noValue{}, Replace(ctx, cb.Storage, kv) })
+	return err
+}
+
+func (cb *CircuitBreakerStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	_, err = guard(cb, func() (noValue, error) { return noValue{}, Rename(ctx, cb.Storage, oldKey, newKey, overwrite) })
+	return err
+}
+
+func (cb *CircuitBreakerStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	return guard(cb, func() (string, error) { return Undelete(ctx, cb.Storage, key) })
+}
+
+func (cb *CircuitBreakerStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	_, err = guard(cb, func() (noValue, error) { return noValue{}, Txn(ctx, cb.Storage, ops) })
+	return err
+}
+
+func (cb *CircuitBreakerStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	return guard(cb, func() (int, error) { return DeletePrefix(ctx, cb.Storage, prefix) })
+}
+
+func (cb *CircuitBreakerStorage) Unwrap() Storage {
+	return cb.Storage
+}
+
+// NewCircuitBreakerStorage открывает breaker бэкенда name после threshold падений подряд на cooldown
+func NewCircuitBreakerStorage(s Storage, name string, threshold int, cooldown time.Duration) Storage {
+	return &CircuitBreakerStorage{Storage: s, name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
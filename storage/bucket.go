@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInvalidBucket - имя бакета не прошло проверку
+var ErrInvalidBucket = errors.New("invalid bucket name")
+
+// имена бакетов попадают в пути файлов и в префиксы ключей, поэтому алфавит узкий
+var bucketNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func ValidateBucket(name string) error {
+	if !bucketNameRe.MatchString(name) {
+		return fmt.Errorf("%w %q: want 1-64 characters of A-Z, a-z, 0-9, '_' or '-'", ErrInvalidBucket, name)
+	}
+	return nil
+}
+
+// BucketBackend знает, где физически лежат бакеты
+type BucketBackend interface {
+	// Open открывает бакет. если create == false и бакета нет, возвращает ErrNotFound
+	Open(ctx context.Context, bucket string, create bool) (Storage, error)
+	// Drop удаляет бакет вместе со всеми ключами, s - открытый ранее бакет
+	Drop(ctx context.Context, bucket string, s Storage) error
+}
+
+// BucketedStorage раздает независимые хранилки по имени бакета, так что одинаковые ключи в разных бакетах не пересекаются
+type BucketedStorage struct {
+	backend BucketBackend
+
+	mu      sync.Mutex
+	buckets map[string]Storage
+}
+
+// Bucket возвращает хранилку бакета, создавая его при create == true
+func (bs *BucketedStorage) Bucket(ctx context.Context, name string, create bool) (Storage, error) {
+	if err := ValidateBucket(name); err != nil {
+		return nil, err
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if s, ok := bs.buckets[name]; ok {
+		return s, nil
+	}
+	s, err := bs.backend.Open(ctx, name, create)
+	if err != nil {
+		return nil, err
+	}
+	bs.buckets[name] = s
+	return s, nil
+}
+
+// DeleteBucket удаляет бакет целиком
+func (bs *BucketedStorage) DeleteBucket(ctx context.Context, name string) error {
+	if err := ValidateBucket(name); err != nil {
+		return err
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	s, ok := bs.buckets[name]
+	if !ok {
+		var err error
+		if s, err = bs.backend.Open(ctx, name, false); err != nil {
+			return err
+		}
+	}
+	err := bs.backend.Drop(ctx, name, s)
+	if errors.Is(err, ErrReadOnly) {
+		bs.buckets[name] = s // Drop ничего не тронул, бакет остается открытым
+		return err
+	}
+	delete(bs.buckets, name)
+	return err
+}
+
+// Close закрывает все открытые бакеты
+func (bs *BucketedStorage) Close() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var errs []error
+	for name, s := range bs.buckets {
+		if c, ok := As[io.Closer](s); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("unable to close bucket %s: %w", name, err))
+			}
+		}
+		delete(bs.buckets, name)
+	}
+	return errors.Join(errs...)
+}
+
+func NewBucketedStorage(backend BucketBackend) *BucketedStorage {
+	return &BucketedStorage{backend: backend, buckets: make(map[string]Storage)}
+}
+
+// FileBuckets хранит каждый бакет в отдельном файле FileStorage внутри dir
+type FileBuckets struct {
+	Dir    string
+	Opts   []FileOption
+	Limits *atomic.Pointer[Limits] // nil - без лимитов. у бакета свой файл, основная хранилка его не проверяет
+
+	Audit          *AuditLog // если задан, изменения бакетов тоже попадают в журнал аудита
+	AuditRawValues bool
+	Webhooks       *Webhooks // если заданы, о записях в бакеты тоже рассылаются события
+
+	ReadOnly *atomic.Bool // если задан и поднят, бакеты не создаются, не удаляются и в них не пишут
+}
+
+func (fb FileBuckets) readOnly() bool {
+	return fb.ReadOnly != nil && fb.ReadOnly.Load()
+}
+
+func (fb FileBuckets) path(bucket string) string {
+	return filepath.Join(fb.Dir, bucket+".json")
+}
+
+func (fb FileBuckets) Open(ctx context.Context, bucket string, create bool) (Storage, error) {
+	if _, err := os.Stat(fb.path(bucket)); errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return nil, ErrNotFound
+		}
+		if fb.readOnly() {
+			return nil, ErrReadOnly
+		}
+	}
+	s, err := NewFileStorage(fb.path(bucket), fb.Opts...)
+	if err != nil {
+		return nil, err
+	}
+	if fb.Limits != nil {
+		s = NewLimitedStorage(s, fb.Limits)
+	}
+	if fb.Audit != nil {
+		s = NewAuditedStorage(s, fb.Audit, "kv/"+bucket, fb.AuditRawValues)
+	}
+	if fb.Webhooks != nil {
+		s = NewNotifyingStorage(s, fb.Webhooks, "kv/"+bucket)
+	}
+	if fb.ReadOnly != nil {
+		s = NewReadOnlyStorage(s, fb.ReadOnly)
+	}
+	return s, nil
+}
+
+func (fb FileBuckets) Drop(ctx context.Context, bucket string, s Storage) error {
+	if fb.readOnly() {
+		return ErrReadOnly
+	}
+	if c, ok := As[io.Closer](s); ok {
+		c.Close()
+	}
+	if err := os.Remove(fb.path(bucket)); err != nil {
+		return fmt.Errorf("unable to remove bucket file: %w", err)
+	}
+	return nil
+}
+
+// PrefixBuckets кладет все бакеты в одну хранилку, приписывая к ключам префикс "<bucket>/".
+// в имени бакета не бывает '/', так что префиксы разных бакетов не пересекаются
+type PrefixBuckets struct {
+	Storage Storage
+}
+
+func (pb PrefixBuckets) Open(ctx context.Context, bucket string, create bool) (Storage, error) {
+	ps := &prefixStorage{Storage: pb.Storage, prefix: bucket + "/"}
+	if !create {
+		keys, err := ps.Keys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return nil, ErrNotFound
+		}
+	}
+	return ps, nil
+}
+
+func (pb PrefixBuckets) Drop(ctx context.Context, bucket string, s Storage) error {
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.Delete(ctx, k); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixStorage - вид на часть общей хранилки с ключами, начинающимися с prefix
+type prefixStorage struct {
+	Storage
+	prefix string
+}
+
+func (ps *prefixStorage) Get(ctx context.Context, key string) (value string, err error) {
+	return ps.Storage.Get(ctx, ps.prefix+key)
+}
+
+func (ps *prefixStorage) Set(ctx context.Context, key, value string) (err error) {
+	return ps.Storage.Set(ctx, ps.prefix+key, value)
+}
+
+func (ps *prefixStorage) Delete(ctx context.Context, key string) (err error) {
+	return ps.Storage.Delete(ctx, ps.prefix+key)
+}
+
+func (ps *prefixStorage) Keys(ctx context.Context) (keys []string, err error) {
+	all, err := ps.Storage.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys = make([]string, 0)
+	for _, k := range all {
+		if strings.HasPrefix(k, ps.prefix) {
+			keys = append(keys, strings.TrimPrefix(k, ps.prefix))
+		}
+	}
+	return keys, nil
+}
+
+func (ps *prefixStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	full := make([]string, len(keys))
+	for i, k := range keys {
+		full[i] = ps.prefix + k
+	}
+	found, err := ps.Storage.GetMany(ctx, full)
+	if err != nil {
+		return nil, err
+	}
+	kv = make(map[string]string, len(found))
+	for k, v := range found {
+		kv[strings.TrimPrefix(k, ps.prefix)] = v
+	}
+	return kv, nil
+}
+
+func (ps *prefixStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	full := make(map[string]string, len(kv))
+	for k, v := range kv {
+		full[ps.prefix+k] = v
+	}
+	return ps.Storage.SetMany(ctx, full)
+}
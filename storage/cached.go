@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedStorage держит перед любой хранилкой ограниченный LRU кэш в памяти.
+// чтение идет сначала в кэш, запись - сквозная: сначала в бэкенд, и только после успеха в кэш.
+// Unwrap у кэша нет намеренно: запись мимо него (например, с ttl) оставила бы в кэше устаревшее значение.
+// ключ с ttl держится в кэше не дольше своего срока, а если бэкенд сам выкинул протухшие ключи
+// или перечитал данные (Invalidator), кэш сбрасывается целиком
+type CachedStorage struct {
+	Storage
+
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // в начале самые свежие
+	gen     uint64     // растет при каждой записи, чтобы Get не положил в кэш значение, которое уже перезаписали
+
+	invalidations atomic.Uint64 // последнее увиденное Invalidations бэкенда
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   string
+	expires time.Time // нулевое - ключ без ttl
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+func (cs *CachedStorage) Get(ctx context.Context, key string) (value string, err error) {
+	cs.sync()
+	cs.mu.Lock()
+	if el, ok := cs.lookup(key, time.Now()); ok {
+		value = el.Value.(*cacheEntry).value
+		cs.mu.Unlock()
+		cs.hits.Add(1)
+		return value, nil
+	}
+	gen := cs.gen
+	cs.mu.Unlock()
+	cs.misses.Add(1)
+
+	value, expires, cacheable, err := cs.fetch(ctx, key)
+	if err != nil {
+		return value, err
+	}
+
+	cs.mu.Lock()
+	if cacheable && cs.gen == gen { // пока мы ходили в бэкенд, никто ничего не записал
+		cs.put(key, value, expires)
+	}
+	cs.mu.Unlock()
+	return value, nil
+}
+
+// fetch читает ключ из бэкенда и говорит, можно ли его кэшировать и до какого момента. у бэкенда с ttl срок
+// берется из метаданных, а без них значение в кэш не идет: его могли записать с ttl, и кэш отдавал бы его и после
+func (cs *CachedStorage) fetch(ctx context.Context, key string) (value string, expires time.Time, cacheable bool, err error) {
+	if !cs.expiring() {
+		value, err = cs.Storage.Get(ctx, key)
+		return value, expires, true, err
+	}
+	if entry, err := GetEntry(ctx, cs.Storage, key); !errors.Is(err, ErrNotSupported) {
+		return entry.Value, entry.ExpiresAt, true, err
+	}
+	value, err = cs.Storage.Get(ctx, key)
+	return value, expires, false, err
+}
+
+// expiring - бэкенд умеет ttl, и прочитанное из него значение может протухнуть
+func (cs *CachedStorage) expiring() bool {
+	_, ok := As[ExpiringStorage](cs.Storage)
+	return ok
+}
+
+// sync сбрасывает кэш, если бэкенд с прошлого раза сам выкинул или перечитал данные
+func (cs *CachedStorage) sync() {
+	inv, ok := As[Invalidator](cs.Storage)
+	if !ok {
+		return
+	}
+	if n := inv.Invalidations(); cs.invalidations.Swap(n) != n {
+		cs.Purge()
+	}
+}
+
+func (cs *CachedStorage) Set(ctx context.Context, key, value string) (err error) {
+	gen := cs.generation()
+	if err = cs.Storage.Set(ctx, key, value); err != nil {
+		// бэкенд мог и успеть записать значение, так что просто забываем ключ
+		cs.invalidate(key)
+		return err
+	}
+
+	cs.mu.Lock()
+	if cs.gen == gen {
+		cs.put(key, value, time.Time{}) // обычный Set снимает ttl
+	} else {
+		// параллельная запись могла дойти до бэкенда позже нашей - не угадываем, а забываем ключ
+		cs.remove(key)
+	}
+	cs.gen++
+	cs.mu.Unlock()
+	return nil
+}
+
+func (cs *CachedStorage) Delete(ctx context.Context, key string) (err error) {
+	err = cs.Storage.Delete(ctx, key)
+	cs.invalidate(key)
+	return err
+}
+
+func (cs *CachedStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	kv = make(map[string]string, len(keys))
+	misses := make([]string, 0, len(keys))
+
+	cs.sync()
+	now := time.Now()
+	cs.mu.Lock()
+	for _, k := range keys {
+		if el, ok := cs.lookup(k, now); ok {
+			kv[k] = el.Value.(*cacheEntry).value
+		} else {
+			misses = append(misses, k)
+		}
+	}
+	gen := cs.gen
+	cs.mu.Unlock()
+	cs.hits.Add(uint64(len(keys) - len(misses)))
+	cs.misses.Add(uint64(len(misses)))
+
+	if len(misses) == 0 {
+		return kv, nil
+	}
+	fetched, err := cs.Storage.GetMany(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+
+	// GetMany не отдает сроков ttl, так что с бэкенда, который их умеет, прочитанное в кэш не кладем
+	cacheable := !cs.expiring()
+	cs.mu.Lock()
+	for k, v := range fetched {
+		kv[k] = v
+		if cacheable && cs.gen == gen {
+			cs.put(k, v, time.Time{})
+		}
+	}
+	cs.mu.Unlock()
+	return kv, nil
+}
+
+func (cs *CachedStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	gen := cs.generation()
+	err = cs.Storage.SetMany(ctx, kv)
+
+	cs.mu.Lock()
+	for k, v := range kv {
+		if err == nil && cs.gen == gen {
+			cs.put(k, v, time.Time{})
+		} else {
+			cs.remove(k)
+		}
+	}
+	cs.gen++
+	cs.mu.Unlock()
+	return err
+}
+
+// Close, Ping и Backup не трогают данные, так что их пробрасываем в бэкенд как есть
+func (cs *CachedStorage) Close() (err error) {
+	if c, ok := As[io.Closer](cs.Storage); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (cs *CachedStorage) Ping() (err error) {
+	if p, ok := As[Pinger](cs.Storage); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+// копия снимается с бэкенда и кэш не трогает
+func (cs *CachedStorage) Backup() (name string, err error) {
+	if b, ok := As[Backuper](cs.Storage); ok {
+		return b.Backup()
+	}
+	return "", ErrNotSupported
+}
+
+// Watch видит только записи, дошедшие до бэкенда, а через кэш проходят все записи, так что ничего не теряется
+func (cs *CachedStorage) Watch(ctx context.Context, prefix string) (events <-chan Event, err error) {
+	if w, ok := As[Watcher](cs.Storage); ok {
+		return w.Watch(ctx, prefix)
+	}
+	return nil, ErrNotSupported
+}
+
+// Scan идет в бэкенд мимо кэша и в кэш ничего не кладет, чтобы большой обход не вытеснил горячие ключи
+func (cs *CachedStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	return Scan(ctx, cs.Storage, prefix, fn)
+}
+
+// KeysPage кэш не трогает, как и Keys
+func (cs *CachedStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	return KeysPage(ctx, cs.Storage, afterKey, limit)
+}
+
+// статистика - про данные бэкенда, кэш в ней не участвует
+func (cs *CachedStorage) Stats(ctx context.Context) (stats StorageStats, err error) {
+	return Stats(ctx, cs.Storage)
+}
+
+// сверка зеркала идет по бэкендам, кэш в ней не участвует
+func (cs *CachedStorage) Diff(ctx context.Context) (diff MirrorDiff, err error) {
+	if d, ok := As[Differ](cs.Storage); ok {
+		return d.Diff(ctx)
+	}
+	return diff, ErrNotSupported
+}
+
+// метаданные кэш не хранит, так что за ними всегда идем в бэкенд
+func (cs *CachedStorage) GetEntry(ctx context.Context, key string) (entry Entry, err error) {
+	return GetEntry(ctx, cs.Storage, key)
+}
+
+func (cs *CachedStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	value, err = Undelete(ctx, cs.Storage, key)
+	cs.invalidate(key)
+	return value, err
+}
+
+// истории кэш тоже не хранит
+func (cs *CachedStorage) History(ctx context.Context, key string) (versions []VersionedValue, err error) {
+	return History(ctx, cs.Storage, key)
+}
+
+func (cs *CachedStorage) GetVersion(ctx context.Context, key string, version uint64) (value VersionedValue, err error) {
+	return GetVersion(ctx, cs.Storage, key, version)
+}
+
+// перенос ключей между шардами значений не меняет, кэш остается верным
+func (cs *CachedStorage) Rebalance(ctx context.Context, retired ...Storage) (moved int, err error) {
+	if rb, ok := As[Rebalancer](cs.Storage); ok {
+		return rb.Rebalance(ctx, retired...)
+	}
+	return 0, ErrNotSupported
+}
+
+// компакция данных не меняет, так что кэш остается как есть
+func (cs *CachedStorage) Compact() (err error) {
+	if c, ok := As[Compactor](cs.Storage); ok {
+		return c.Compact()
+	}
+	return ErrNotSupported
+}
+
+// значение с ttl сразу в кэш не кладем: следующий Get прочитает его вместе со сроком
+func (cs *CachedStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](cs.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	err = es.SetWithTTL(ctx, key, value, ttl)
+	cs.invalidate(key)
+	return err
+}
+
+// условные записи решает бэкенд по своему значению, а не по кэшу, который мог и отстать
+func (cs *CachedStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	c, ok := As[ConditionalStorage](cs.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	swapped, err = c.CompareAndSwap(ctx, key, old, new)
+	cs.invalidate(key)
+	return swapped, err
+}
+
+func (cs *CachedStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	c, ok := As[ConditionalStorage](cs.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	set, err = c.SetIfAbsent(ctx, key, value)
+	cs.invalidate(key)
+	return set, err
+}
+
+func (cs *CachedStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	value, existed, err = GetOrSet(ctx, cs.Storage, key, defaultValue)
+	cs.invalidate(key)
+	return value, existed, err
+}
+
+func (cs *CachedStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](cs.Storage)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	value, err = inc.Increment(ctx, key, delta)
+	cs.invalidate(key)
+	return value, err
+}
+
+// дамп идет в бэкенд мимо кэша, как и Scan
+func (cs *CachedStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return Dump(ctx, cs.Storage)
+}
+
+func (cs *CachedStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	err = Replace(ctx, cs.Storage, kv)
+	cs.Purge()
+	return err
+}
+
+func (cs *CachedStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	length, err = Append(ctx, cs.Storage, key, suffix)
+	cs.invalidate(key)
+	return length, err
+}
+
+func (cs *CachedStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	err = Rename(ctx, cs.Storage, oldKey, newKey, overwrite)
+	cs.invalidate(oldKey)
+	cs.invalidate(newKey)
+	return err
+}
+
+func (cs *CachedStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	err = Txn(ctx, cs.Storage, ops)
+	for _, op := range txnWrites(ops) {
+		cs.invalidate(op.Key)
+	}
+	return err
+}
+
+// удаленные ключи кэш по префиксу не найдет дешевле, чем сбросив его целиком
+func (cs *CachedStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	deleted, err = DeletePrefix(ctx, cs.Storage, prefix)
+	cs.Purge()
+	return deleted, err
+}
+
+// после Reload в бэкенде могут быть совсем другие данные, так что кэш сбрасываем целиком
+func (cs *CachedStorage) Reload() (err error) {
+	r, ok := As[Reloader](cs.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	err = r.Reload()
+	cs.Purge()
+	return err
+}
+
+// Purge сбрасывает кэш целиком, например после того, как бэкенд под ним подменили
+func (cs *CachedStorage) Purge() {
+	cs.mu.Lock()
+	cs.gen++
+	cs.entries = make(map[string]*list.Element, cs.size)
+	cs.lru.Init()
+	cs.mu.Unlock()
+}
+
+// Hits и Misses - счетчики попаданий в кэш для метрик
+func (cs *CachedStorage) Hits() uint64   { return cs.hits.Load() }
+func (cs *CachedStorage) Misses() uint64 { return cs.misses.Load() }
+
+func (cs *CachedStorage) generation() uint64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.gen
+}
+
+func (cs *CachedStorage) invalidate(key string) {
+	cs.mu.Lock()
+	cs.gen++
+	cs.remove(key)
+	cs.mu.Unlock()
+}
+
+// lookup, put и remove работают под cs.mu. lookup отдает живую запись и поднимает ее в lru, протухшую выкидывает
+func (cs *CachedStorage) lookup(key string, now time.Time) (el *list.Element, ok bool) {
+	if el, ok = cs.entries[key]; !ok {
+		return nil, false
+	}
+	if el.Value.(*cacheEntry).expired(now) {
+		cs.remove(key)
+		return nil, false
+	}
+	cs.lru.MoveToFront(el)
+	return el, true
+}
+
+func (cs *CachedStorage) put(key, value string, expires time.Time) {
+	if el, ok := cs.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		e.value, e.expires = value, expires
+		cs.lru.MoveToFront(el)
+		return
+	}
+	cs.entries[key] = cs.lru.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	for cs.lru.Len() > cs.size {
+		oldest := cs.lru.Back()
+		cs.lru.Remove(oldest)
+		delete(cs.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (cs *CachedStorage) remove(key string) {
+	if el, ok := cs.entries[key]; ok {
+		cs.lru.Remove(el)
+		delete(cs.entries, key)
+	}
+}
+
+// NewCachedStorage оборачивает s кэшем на size записей
+func NewCachedStorage(s Storage, size int) (Storage, error) {
+	if size <= 0 {
+		return nil, errors.New("cache size must be positive")
+	}
+	return &CachedStorage{
+		Storage: s,
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}, nil
+}
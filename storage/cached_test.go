@@ -0,0 +1,268 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Barugoo/example-fs/storage"
+	"github.com/Barugoo/example-fs/storagetest"
+)
+
+func newCached(t *testing.T, s storage.Storage, size int) *storage.CachedStorage {
+	t.Helper()
+	cs, err := storage.NewCachedStorage(s, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs.(*storage.CachedStorage)
+}
+
+func TestCachedStorageHitsAndEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := storagetest.NewFakeStorage(nil)
+	cs := newCached(t, backend, 2)
+
+	for _, k := range []string{"a", "b"} {
+		if err := cs.Set(ctx, k, "v"+k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 3 {
+		if v, err := cs.Get(ctx, "a"); err != nil || v != "va" {
+			t.Fatalf("Get(a) = %q, %v", v, err)
+		}
+	}
+	if n := backend.CallCount("Get"); n != 0 {
+		t.Errorf("written keys should be served from the cache, backend got %d Get calls", n)
+	}
+	if cs.Hits() != 3 || cs.Misses() != 0 {
+		t.Errorf("hits %d misses %d, want 3 and 0", cs.Hits(), cs.Misses())
+	}
+
+	// c вытесняет b: a только что читали
+	if err := cs.Set(ctx, "c", "vc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Get(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if n := backend.CallCount("Get"); n != 1 {
+		t.Errorf("evicted key should come from the backend, got %d Get calls", n)
+	}
+}
+
+func TestCachedStorageFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	backend := storagetest.NewFakeStorage(map[string]string{"a": "old"})
+	cs := newCached(t, backend, 10)
+	if _, err := cs.Get(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	boom := errors.New("disk full")
+	backend.Fail("Set", boom)
+	if err := cs.Set(ctx, "a", "new"); !errors.Is(err, boom) {
+		t.Fatalf("Set: got %v, want %v", err, boom)
+	}
+	if v, err := cs.Get(ctx, "a"); err != nil || v != "old" {
+		t.Errorf("after a failed Set: got %q, %v, want the backend value", v, err)
+	}
+}
+
+// расширенные записи идут в бэкенд и сбрасывают ключ в кэше, так что следующее чтение видит новое значение
+func TestCachedStorageExtendedWrites(t *testing.T) {
+	ctx := context.Background()
+	cs := newCached(t, storage.NewMemStorage(), 10)
+	prime := func(key, want string) {
+		t.Helper()
+		if err := cs.Set(ctx, key, want); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := cs.Get(ctx, key); v != want {
+			t.Fatalf("Get(%s) = %q, want %q", key, v, want)
+		}
+	}
+	wantValue := func(op, key, want string) {
+		t.Helper()
+		if v, err := cs.Get(ctx, key); err != nil || v != want {
+			t.Errorf("after %s: Get(%s) = %q, %v, want %q", op, key, v, err, want)
+		}
+	}
+
+	es, ok := storage.As[storage.ExpiringStorage](cs)
+	if !ok {
+		t.Fatal("cache hides SetWithTTL of the backend")
+	}
+	prime("ttl", "a")
+	if err := es.SetWithTTL(ctx, "ttl", "b", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	wantValue("SetWithTTL", "ttl", "b")
+
+	c, ok := storage.As[storage.ConditionalStorage](cs)
+	if !ok {
+		t.Fatal("cache hides CompareAndSwap of the backend")
+	}
+	prime("cas", "a")
+	if swapped, err := c.CompareAndSwap(ctx, "cas", "a", "b"); err != nil || !swapped {
+		t.Fatalf("CompareAndSwap = %v, %v", swapped, err)
+	}
+	wantValue("CompareAndSwap", "cas", "b")
+	if set, err := c.SetIfAbsent(ctx, "new", "x"); err != nil || !set {
+		t.Fatalf("SetIfAbsent = %v, %v", set, err)
+	}
+	wantValue("SetIfAbsent", "new", "x")
+	if v, existed, err := storage.GetOrSet(ctx, cs, "lazy", "d"); err != nil || existed || v != "d" {
+		t.Fatalf("GetOrSet = %q, %v, %v", v, existed, err)
+	}
+	wantValue("GetOrSet", "lazy", "d")
+
+	inc, ok := storage.As[storage.Incrementer](cs)
+	if !ok {
+		t.Fatal("cache hides Increment of the backend")
+	}
+	prime("n", "1")
+	if v, err := inc.Increment(ctx, "n", 41); err != nil || v != 42 {
+		t.Fatalf("Increment = %d, %v", v, err)
+	}
+	wantValue("Increment", "n", "42")
+
+	prime("r", "old")
+	if err := storage.Replace(ctx, cs, map[string]string{"r": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	wantValue("Replace", "r", "new")
+	if _, err := cs.Get(ctx, "n"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("after Replace: Get(n) = %v, want ErrNotFound", err)
+	}
+	if kv, err := storage.Dump(ctx, cs); err != nil || len(kv) != 1 || kv["r"] != "new" {
+		t.Errorf("Dump = %v, %v", kv, err)
+	}
+}
+
+// без возможности у бэкенда кэш отвечает ErrNotSupported, а не пишет мимо условия
+func TestCachedStorageUnsupported(t *testing.T) {
+	ctx := context.Background()
+	cs := newCached(t, storagetest.NewFakeStorage(nil), 10)
+	if _, err := cs.Increment(ctx, "n", 1); !errors.Is(err, storage.ErrNotSupported) {
+		t.Errorf("Increment: got %v, want ErrNotSupported", err)
+	}
+	if _, err := cs.CompareAndSwap(ctx, "k", "a", "b"); !errors.Is(err, storage.ErrNotSupported) {
+		t.Errorf("CompareAndSwap: got %v, want ErrNotSupported", err)
+	}
+	if err := cs.SetWithTTL(ctx, "k", "v", time.Minute); !errors.Is(err, storage.ErrNotSupported) {
+		t.Errorf("SetWithTTL: got %v, want ErrNotSupported", err)
+	}
+}
+
+// ttlOnly умеет ttl, но не отдает метаданных, так что кэш не знает сроков прочитанных значений
+type ttlOnly struct{ storage.ExpiringStorage }
+
+// кэш не отдает ключ после его срока, как бы значение с ttl в него ни попало
+func TestCachedStorageTTL(t *testing.T) {
+	ctx := context.Background()
+	const ttl = 50 * time.Millisecond
+	for _, tt := range []struct {
+		name    string
+		backend storage.ExpiringStorage
+	}{
+		{"with metadata", storage.NewMemStorage().(storage.ExpiringStorage)},
+		{"without metadata", ttlOnly{storage.NewMemStorage().(storage.ExpiringStorage)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newCached(t, tt.backend, 10)
+			if err := cs.SetWithTTL(ctx, "through", "1", ttl); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.backend.SetWithTTL(ctx, "around", "2", ttl); err != nil {
+				t.Fatal(err)
+			}
+			if err := cs.Set(ctx, "forever", "3"); err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range map[string]string{"through": "1", "around": "2", "forever": "3"} {
+				if v, err := cs.Get(ctx, k); err != nil || v != want {
+					t.Fatalf("Get(%s) before the deadline = %q, %v", k, v, err)
+				}
+			}
+			if kv, err := cs.GetMany(ctx, []string{"through", "around"}); err != nil || len(kv) != 2 {
+				t.Fatalf("GetMany before the deadline = %v, %v", kv, err)
+			}
+
+			time.Sleep(ttl + 20*time.Millisecond)
+			for _, k := range []string{"through", "around"} {
+				if v, err := cs.Get(ctx, k); !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("Get(%s) after the deadline = %q, %v, want ErrNotFound", k, v, err)
+				}
+			}
+			if kv, err := cs.GetMany(ctx, []string{"through", "around", "forever"}); err != nil || len(kv) != 1 {
+				t.Errorf("GetMany after the deadline = %v, %v, want only forever", kv, err)
+			}
+		})
+	}
+}
+
+// invalidating - бэкенд, который меняет данные сам и сообщает об этом через Invalidations
+type invalidating struct {
+	storage.Storage
+	n uint64
+}
+
+func (s *invalidating) Invalidations() uint64 { return s.n }
+
+func TestCachedStorageInvalidations(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemStorage()
+	backend := &invalidating{Storage: mem}
+	cs := newCached(t, backend, 10)
+	if err := cs.Set(ctx, "k", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	mem.Set(ctx, "k", "changed") // мимо кэша, как чистка ttl или перечитанный файл
+	if v, _ := cs.Get(ctx, "k"); v != "old" {
+		t.Fatalf("Get = %q, want the cached value before the backend reports a change", v)
+	}
+	backend.n++
+	if v, err := cs.Get(ctx, "k"); err != nil || v != "changed" {
+		t.Errorf("Get after Invalidations changed = %q, %v, want the backend value", v, err)
+	}
+}
+
+// FileStorage сообщает о перечитанном файле, даже если Reload позвали в обход кэша, как это делает слежка за файлом
+func TestCachedStorageFileReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path, other := filepath.Join(dir, "data"), filepath.Join(dir, "other")
+	fs, err := storage.NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.(io.Closer).Close()
+	cs := newCached(t, fs, 10)
+	if err = cs.Set(ctx, "k", "ours"); err != nil {
+		t.Fatal(err)
+	}
+
+	// другой процесс подменяет файл переименованием, как это делают редакторы
+	o, err := storage.NewFileStorage(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Set(ctx, "k", "theirs")
+	o.(io.Closer).Close()
+	if err = os.Rename(other, path); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.(storage.Reloader).Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cs.Get(ctx, "k"); err != nil || v != "theirs" {
+		t.Errorf("Get after the file was reloaded = %q, %v, want theirs", v, err)
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec - формат, в котором FileStorage кладет записи на диск
+type Codec interface {
+	// Name пишется в заголовок файла, по нему при открытии проверяем, тем ли кодеком файл записан
+	Name() string
+	Encode(w io.Writer, kv map[string]string) (err error)
+	Decode(r io.Reader) (kv map[string]string, err error)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Encode(w io.Writer, kv map[string]string) (err error) {
+	return json.NewEncoder(w).Encode(kv)
+}
+
+func (jsonCodec) Decode(r io.Reader) (kv map[string]string, err error) {
+	err = json.NewDecoder(r).Decode(&kv)
+	return kv, err
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Encode(w io.Writer, kv map[string]string) (err error) {
+	return gob.NewEncoder(w).Encode(kv)
+}
+
+func (gobCodec) Decode(r io.Reader) (kv map[string]string, err error) {
+	err = gob.NewDecoder(r).Decode(&kv)
+	return kv, err
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Encode(w io.Writer, kv map[string]string) (err error) {
+	return msgpack.NewEncoder(w).Encode(kv)
+}
+
+func (msgpackCodec) Decode(r io.Reader) (kv map[string]string, err error) {
+	err = msgpack.NewDecoder(r).Decode(&kv)
+	return kv, err
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{} // по умолчанию: медленнее, зато файл можно прочитать глазами
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// CodecByName находит кодек по имени из конфига
+func CodecByName(name string) (Codec, error) {
+	for _, c := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q: want json, gob or msgpack", name)
+}
+
+// файл начинается со строки "EXFS2 <кодек>\n", дальше идут записи.
+// каждая запись - это длина в uvarint, столько байт кодека и crc32 от них: gob и msgpack сами себя не разделяют,
+// а декодеры на общем потоке читают с запасом и съели бы начало следующей записи.
+// EXFS1 - то же самое без контрольных сумм, такие файлы читаем и при открытии переписываем в EXFS2
+const (
+	fileMagic   = "EXFS2 "
+	fileMagicV1 = "EXFS1 "
+)
+
+// запись длиннее этого - почти наверняка мусор в длине, а не настоящее значение
+const maxFrameSize = 1 << 30
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type fileHeader struct {
+	codec       string
+	checksummed bool // false у EXFS1
+}
+
+func writeHeader(w io.Writer, c Codec) (err error) {
+	_, err = io.WriteString(w, fileMagic+c.Name()+"\n")
+	return err
+}
+
+// readHeader читает заголовок файла. ok == false - заголовка нет (старый JSON формат или пустой файл),
+// в этом случае из r ничего не вычитано
+func readHeader(r *bufio.Reader) (hdr fileHeader, ok bool, err error) {
+	magic, err := r.Peek(len(fileMagic))
+	if err != nil {
+		return hdr, false, nil
+	}
+	switch string(magic) {
+	case fileMagic:
+		hdr.checksummed = true
+	case fileMagicV1:
+	default:
+		return hdr, false, nil
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return hdr, false, fmt.Errorf("%w: unable to read file header: %w", ErrCorrupt, err)
+	}
+	hdr.codec = strings.TrimSuffix(line[len(fileMagic):], "\n")
+	return hdr, true, nil
+}
+
+func encodeFrame(buf *bytes.Buffer, c Codec, kv map[string]string) (err error) {
+	var payload bytes.Buffer
+	if err = c.Encode(&payload, kv); err != nil {
+		return err
+	}
+	buf.Write(binary.AppendUvarint(nil, uint64(payload.Len())))
+	buf.Write(payload.Bytes())
+	buf.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(payload.Bytes(), crcTable)))
+	return nil
+}
+
+// readN читает ровно n байт, как io.ReadFull, но растит буфер по мере чтения, а не сразу по длине из файла:
+// мусор вместо длины в коротком файле не должен выделять гигабайт
+func readN(r io.Reader, n int) (b []byte, err error) {
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, r, int64(n))
+	if read < int64(n) && (err == nil || err == io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+// decodeFrame возвращает io.EOF, только если файл кончился ровно на границе записи.
+// обрезанная запись, неверная сумма и мусор в длине - это ErrCorrupt
+func decodeFrame(r *bufio.Reader, c Codec, hdr fileHeader) (kv map[string]string, err error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to read record length: %w", ErrCorrupt, err)
+	}
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("%w: record length %d is too large", ErrCorrupt, n)
+	}
+	size := int(n)
+	if hdr.checksummed {
+		size += crc32.Size
+	}
+	frame, err := readN(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated record: %w", ErrCorrupt, err)
+	}
+	payload := frame[:n]
+	if hdr.checksummed {
+		want := binary.BigEndian.Uint32(frame[n:])
+		if got := crc32.Checksum(payload, crcTable); got != want {
+			return nil, fmt.Errorf("%w: checksum mismatch: got %08x, want %08x", ErrCorrupt, got, want)
+		}
+	}
+	if kv, err = c.Decode(bytes.NewReader(payload)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return kv, nil
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// Compactor - хранилка, которая умеет выкинуть из журнала перезаписанные и удаленные записи
+type Compactor interface {
+	Compact() (err error)
+}
+
+type compactionStats struct {
+	duration  time.Duration
+	reclaimed int64
+}
+
+// как часто фоновая компакция проверяет пороги
+const compactCheckInterval = 30 * time.Second
+
+// компакция пишет в свой временный файл, чтобы не столкнуться с rewrite, который идет под блокировкой
+const compactSuffix = ".compact" + tmpSuffix
+
+// Compact пишет свежий снимок живых ключей в новый файл и подменяет им журнал.
+// снимок копируется под RLock, а пишется на диск без блокировки, так что чтение и запись не стоят.
+// то, что успели дописать в журнал за это время, в конце переносится в новый файл как есть
+func (fs *FileStorage) Compact() (err error) {
+	log.Println("called file storage Compact method")
+	fs.compactMu.Lock()
+	defer fs.compactMu.Unlock()
+
+	start := time.Now()
+	fs.mu.RLock()
+	if err = fs.writable(); err != nil {
+		fs.mu.RUnlock()
+		return err
+	}
+	m := make(map[string]string, len(fs.m))
+	for k, v := range fs.m {
+		if !fs.expired(k) {
+			m[k] = v
+		}
+	}
+	exp, meta := maps.Clone(fs.exp), maps.Clone(fs.meta)
+	history, versions := make(map[string][]VersionedValue), 0
+	for k, old := range fs.history {
+		if _, ok := m[k]; ok {
+			history[k] = slices.Clone(old)
+			versions += len(old)
+		}
+	}
+	deleted := maps.Clone(fs.deleted)
+	o := fs.opts
+	offset, rewrites, records := fs.size, fs.rewrites, fs.records
+	fs.mu.RUnlock()
+
+	tmpName := fs.name + compactSuffix
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, o.fileMode)
+	if err != nil {
+		return fmt.Errorf("unable to create temp file %s: %w", tmpName, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+	if err = writeSnapshot(tmp, o, m, exp, meta, history, deleted); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return ErrClosed
+	}
+	if fs.rewrites != rewrites {
+		// пока мы писали, файл целиком переписали (Replace, Reload и т.п.) - он и так компактный
+		tmp.Close()
+		os.Remove(tmpName)
+		return nil
+	}
+
+	// хвост журнала лежит в том же формате (куски шифрования и gzip потоки самодостаточны),
+	// поэтому его можно просто дописать байтами после снимка
+	oldSize := fs.size
+	if tail := fs.size - offset; tail > 0 {
+		if err = copyTail(tmp, fs.name, offset, tail); err != nil {
+			return fmt.Errorf("unable to copy log tail: %w", err)
+		}
+	}
+	if err = fs.install(tmp); err != nil {
+		return err
+	}
+	fs.records = len(m) + versions + 2*len(deleted) + fs.records - records
+	fs.rewrites++
+	fs.lastCompaction = compactionStats{duration: time.Since(start), reclaimed: oldSize - fs.size}
+	return nil
+}
+
+func copyTail(dst io.Writer, name string, offset, n int64) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err = src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.CopyN(dst, src, n)
+	return err
+}
+
+// LastCompaction - сколько заняла последняя компакция и сколько байт она освободила
+func (fs *FileStorage) LastCompaction() (duration time.Duration, reclaimed int64) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.lastCompaction.duration, fs.lastCompaction.reclaimed
+}
+
+// liveRecords - сколько записей журнала нужно, чтобы восстановить память:
+// по одной на ключ и на каждое прежнее значение, по две на надгробие
+func (fs *FileStorage) liveRecords() int {
+	return len(fs.m) + fs.versions() + 2*len(fs.deleted)
+}
+
+// needsCompaction сравнивает журнал с порогами из WithCompaction
+func (fs *FileStorage) needsCompaction() bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	dead := fs.records - fs.liveRecords()
+	if fs.closed || dead <= 0 {
+		return false
+	}
+	if fs.opts.compactSize > 0 && fs.size >= fs.opts.compactSize {
+		return true
+	}
+	return fs.opts.compactRatio > 0 && float64(dead)/float64(fs.records) >= fs.opts.compactRatio
+}
+
+// compactLoop раз в compactCheckInterval запускает компакцию, если журнал перерос пороги
+func (fs *FileStorage) compactLoop(done chan struct{}) {
+	ticker := time.NewTicker(compactCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !fs.needsCompaction() {
+				continue
+			}
+			if err := fs.Compact(); err != nil && err != ErrClosed {
+				log.Printf("background compaction of %s failed: %v", fs.name, err)
+			}
+		}
+	}
+}
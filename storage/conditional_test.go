@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func conditionalBackends(t *testing.T) map[string]ConditionalStorage {
+	t.Helper()
+	dir := t.TempDir()
+	open := map[string]func() (Storage, error){
+		"mem":  func() (Storage, error) { return NewMemStorage(), nil },
+		"file": func() (Storage, error) { return NewFileStorage(filepath.Join(dir, "data.json")) },
+		"bolt": func() (Storage, error) { return NewBoltStorage(filepath.Join(dir, "data.bolt")) },
+		"sql":  func() (Storage, error) { return NewSQLStorage(filepath.Join(dir, "data.db")) },
+	}
+	backends := make(map[string]ConditionalStorage, len(open))
+	for name, fn := range open {
+		s, err := fn()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c, ok := s.(io.Closer); ok {
+			t.Cleanup(func() { c.Close() })
+		}
+		backends[name] = s.(ConditionalStorage)
+	}
+	return backends
+}
+
+func TestConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range conditionalBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Set(ctx, "k", "1"); err != nil {
+				t.Fatal(err)
+			}
+			for _, tt := range []struct {
+				op        string
+				key       string
+				old, new  string
+				wantOK    bool
+				wantValue string
+			}{
+				{op: "cas", key: "k", old: "1", new: "2", wantOK: true, wantValue: "2"},
+				{op: "cas", key: "k", old: "1", new: "3", wantOK: false, wantValue: "2"},
+				{op: "cas", key: "missing", old: "", new: "x", wantOK: false},
+				{op: "absent", key: "k", new: "4", wantOK: false, wantValue: "2"},
+				{op: "absent", key: "fresh", new: "5", wantOK: true, wantValue: "5"},
+				{op: "absent", key: "fresh", new: "6", wantOK: false, wantValue: "5"},
+			} {
+				var ok bool
+				var err error
+				if tt.op == "cas" {
+					ok, err = s.CompareAndSwap(ctx, tt.key, tt.old, tt.new)
+				} else {
+					ok, err = s.SetIfAbsent(ctx, tt.key, tt.new)
+				}
+				if err != nil || ok != tt.wantOK {
+					t.Errorf("%s(%s, %q, %q) = %v, %v, want %v", tt.op, tt.key, tt.old, tt.new, ok, err, tt.wantOK)
+				}
+				if tt.wantValue == "" {
+					continue
+				}
+				if v, err := s.Get(ctx, tt.key); err != nil || v != tt.wantValue {
+					t.Errorf("after %s(%s): Get = %q, %v, want %q", tt.op, tt.key, v, err, tt.wantValue)
+				}
+			}
+		})
+	}
+}
+
+// счетчик на CAS не теряет инкременты, когда его крутят несколько горутин сразу
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range conditionalBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Set(ctx, "counter", "0"); err != nil {
+				t.Fatal(err)
+			}
+			const workers, perWorker = 8, 25
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < perWorker; {
+						v, err := s.Get(ctx, "counter")
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						n, _ := strconv.Atoi(v)
+						ok, err := s.CompareAndSwap(ctx, "counter", v, strconv.Itoa(n+1))
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if ok {
+							i++
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if v, err := s.Get(ctx, "counter"); err != nil || v != strconv.Itoa(workers*perWorker) {
+				t.Errorf("counter = %q, %v, want %d", v, err, workers*perWorker)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+	"github.com/Barugoo/example-fs/storagetest"
+)
+
+// must - для бэкендов, которые открываются с ошибкой: в тесте она значит, что проверять нечего
+func must(t *testing.T, s storage.Storage, err error) storage.Storage {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMemStorage(t *testing.T) {
+	storagetest.TestStorage(t, func() storage.Storage { return storage.NewMemStorage() })
+}
+
+// под вытеснение попадать не должны: ключей в проверках меньше, чем влезает
+func TestMemStorageLRU(t *testing.T) {
+	storagetest.TestStorage(t, func() storage.Storage { return storage.NewMemStorage(storage.WithMaxEntries(1000)) })
+}
+
+func TestFileStorage(t *testing.T) {
+	storagetest.TestStorage(t, func() storage.Storage {
+		s, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "data.json"))
+		return must(t, s, err)
+	})
+}
+
+func TestDirStorage(t *testing.T) {
+	storagetest.TestStorage(t, func() storage.Storage {
+		s, err := storage.NewDirStorage(t.TempDir())
+		return must(t, s, err)
+	})
+}
+
+func TestBoltStorage(t *testing.T) {
+	storagetest.TestStorage(t, func() storage.Storage {
+		s, err := storage.NewBoltStorage(filepath.Join(t.TempDir(), "data.db"))
+		return must(t, s, err)
+	})
+}
+
+func TestSQLStorage(t *testing.T) {
+	storagetest.TestStorage(t, func() storage.Storage {
+		s, err := storage.NewSQLStorage(filepath.Join(t.TempDir(), "data.sqlite"))
+		return must(t, s, err)
+	})
+}
+
+func TestPebbleStorage(t *testing.T) {
+	storagetest.TestStorage(t, func() storage.Storage {
+		s, err := storage.NewPebbleStorage(t.TempDir())
+		return must(t, s, err)
+	})
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// ErrWrongKey - файл зашифрован другим ключом
+var ErrWrongKey = errors.New("wrong encryption key")
+
+// зашифрованный файл начинается с открытой строки "EXFSENC1 <проверка ключа>\n",
+// дальше идут куски: длина в uvarint, nonce и шифротекст AES-GCM. внутри кусков лежит
+// обычное содержимое файла - заголовок EXFS2 и записи, сжатые или нет.
+// проверка ключа - зашифрованная известная строка, по ней неправильный ключ видно сразу,
+// а не по невнятной ошибке декодирования где-то посреди файла
+const (
+	encMagic    = "EXFSENC1 "
+	encCheck    = "examplefs key check"
+	encChunkMax = 64 << 10 // rewrite режет поток на куски такого размера
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal шифрует b со свежим случайным nonce и кладет nonce перед шифротекстом
+func seal(aead cipher.AEAD, b []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	rand.Read(nonce) // crypto/rand не возвращает ошибок
+	return aead.Seal(nonce, nonce, b, nil)
+}
+
+func unseal(aead cipher.AEAD, b []byte) ([]byte, error) {
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+}
+
+func writeEncHeader(w io.Writer, aead cipher.AEAD) (err error) {
+	_, err = io.WriteString(w, encMagic+hex.EncodeToString(seal(aead, []byte(encCheck)))+"\n")
+	return err
+}
+
+// readEncHeader проверяет ключ по заголовку. ok == false - файл не зашифрован, из r ничего не вычитано
+func readEncHeader(r *bufio.Reader, aead cipher.AEAD) (ok bool, err error) {
+	magic, err := r.Peek(len(encMagic))
+	if err != nil || string(magic) != encMagic {
+		return false, nil
+	}
+	if aead == nil {
+		return true, errors.New("file is encrypted, but no encryption key is configured")
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return true, fmt.Errorf("%w: unable to read encryption header: %w", ErrCorrupt, err)
+	}
+	check, err := hex.DecodeString(strings.TrimSuffix(line[len(encMagic):], "\n"))
+	if err != nil {
+		return true, fmt.Errorf("%w: malformed encryption header: %w", ErrCorrupt, err)
+	}
+	if plain, err := unseal(aead, check); err != nil || string(plain) != encCheck {
+		return true, ErrWrongKey
+	}
+	return true, nil
+}
+
+// chunkWriter шифрует все, что в него пишут, кусками не больше encChunkMax
+type chunkWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+}
+
+func (cw *chunkWriter) Write(b []byte) (int, error) {
+	cw.buf = append(cw.buf, b...)
+	for len(cw.buf) >= encChunkMax {
+		if err := cw.emit(cw.buf[:encChunkMax]); err != nil {
+			return 0, err
+		}
+		cw.buf = cw.buf[encChunkMax:]
+	}
+	return len(b), nil
+}
+
+// Close дописывает остаток. сам нижний writer не закрывает
+func (cw *chunkWriter) Close() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	err := cw.emit(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *chunkWriter) emit(b []byte) error {
+	sealed := seal(cw.aead, b)
+	var frame bytes.Buffer
+	frame.Write(binary.AppendUvarint(nil, uint64(len(sealed))))
+	frame.Write(sealed)
+	_, err := cw.w.Write(frame.Bytes())
+	return err
+}
+
+// chunkReader расшифровывает куски по одному и отдает их содержимое подряд
+type chunkReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	cur  []byte
+}
+
+func (cr *chunkReader) Read(b []byte) (int, error) {
+	for len(cr.cur) == 0 {
+		n, err := binary.ReadUvarint(cr.r)
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: unable to read encrypted chunk: %w", ErrCorrupt, err)
+		}
+		if n > maxFrameSize {
+			return 0, fmt.Errorf("%w: encrypted chunk length %d is too large", ErrCorrupt, n)
+		}
+		sealed, err := readN(cr.r, int(n))
+		if err != nil {
+			return 0, fmt.Errorf("%w: truncated encrypted chunk: %w", ErrCorrupt, err)
+		}
+		// ключ уже проверен по заголовку, так что не сошедшаяся подпись - это порча файла
+		if cr.cur, err = unseal(cr.aead, sealed); err != nil {
+			return 0, fmt.Errorf("%w: unable to decrypt chunk: %w", ErrCorrupt, err)
+		}
+	}
+	n := copy(b, cr.cur)
+	cr.cur = cr.cur[n:]
+	return n, nil
+}
+
+// ReEncrypt перешифровывает файл новым ключом. nil вместо ключа снимает шифрование
+func (fs *FileStorage) ReEncrypt(newKey []byte) (err error) {
+	log.Println("called file storage ReEncrypt method")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	var aead cipher.AEAD
+	if newKey != nil {
+		if aead, err = newAEAD(newKey); err != nil {
+			return err
+		}
+	}
+	old := fs.opts.aead
+	fs.opts.aead = aead
+	if err = fs.rewrite(); err != nil {
+		fs.opts.aead = old // на диске остался файл со старым ключом
+		return fmt.Errorf("unable to re-encrypt file %s: %w", fs.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorageEncryption(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{1}, 32)
+	other := bytes.Repeat([]byte{2}, 32)
+	for _, tt := range []struct {
+		name string
+		opts []FileOption
+	}{
+		{"json", nil},
+		{"gob", []FileOption{WithCodec(gobCodec{})}},
+		{"gzip", []FileOption{WithCompression(true)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data")
+			open := func(key []byte) (*FileStorage, error) {
+				s, err := NewFileStorage(path, append(tt.opts, WithEncryption(key))...)
+				if err != nil {
+					return nil, err
+				}
+				return s.(*FileStorage), nil
+			}
+
+			s, err := open(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = s.Set(ctx, "secret", "plaintext password"); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(raw, []byte("plaintext password")) || bytes.Contains(raw, []byte("secret")) {
+				t.Fatal("data file contains the plaintext")
+			}
+
+			if _, err = open(other); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("open with a wrong key: got %v, want ErrWrongKey", err)
+			}
+			if _, err = open(nil); err == nil {
+				t.Error("encrypted file opened without a key")
+			}
+
+			// смена ключа: старый больше не подходит, новый читает те же данные
+			if s, err = open(key); err != nil {
+				t.Fatal(err)
+			}
+			if err = s.ReEncrypt(other); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			if _, err = open(key); !errors.Is(err, ErrWrongKey) {
+				t.Errorf("open with the old key after ReEncrypt: got %v, want ErrWrongKey", err)
+			}
+			if s, err = open(other); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := s.Get(ctx, "secret"); err != nil || v != "plaintext password" {
+				t.Errorf("Get after ReEncrypt = %q, %v", v, err)
+			}
+
+			// ReEncrypt(nil) снимает шифрование
+			if err = s.ReEncrypt(nil); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			if s, err = open(nil); err != nil {
+				t.Fatalf("open without a key after decrypting: %v", err)
+			}
+			defer s.Close()
+			if v, err := s.Get(ctx, "secret"); err != nil || v != "plaintext password" {
+				t.Errorf("Get after decrypting = %q, %v", v, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrMalformedCSV - CSV не разобрать дальше: незакрытая или лишняя кавычка. такой импорт не пишет ничего
+var ErrMalformedCSV = errors.New("malformed csv")
+
+// заголовок, который пишет ExportCSV
+var csvHeader = []string{"key", "value"}
+
+// CSVImport - итог ImportCSV
+type CSVImport struct {
+	Rows     int          `json:"rows"`     // строк с данными, без заголовка
+	Imported int          `json:"imported"` // сколько ключей записано: ключ, повторенный в нескольких строках, берется из последней
+	Skipped  []CSVSkipped `json:"skipped"`  // строки, которые не записаны
+}
+
+// CSVSkipped - пропущенная строка: не два поля, ключ не проходит ValidateKey или лимиты
+type CSVSkipped struct {
+	Line   int    `json:"line"` // строка файла, с которой начинается запись, с единицы
+	Reason string `json:"reason"`
+}
+
+// ExportCSV пишет в w строки key,value по возрастанию ключей, кавычки по RFC 4180, header - с заголовком.
+// строки уходят по мере обхода, а не собираются целиком в памяти
+func ExportCSV(ctx context.Context, s Storage, w io.Writer, header bool) (rows int, err error) {
+	cw := csv.NewWriter(w)
+	if header {
+		if err = cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+	}
+	var werr error
+	err = Scan(ctx, s, "", func(key, value string) bool {
+		if werr = cw.Write([]string{key, value}); werr != nil {
+			return false // клиент ушел, дальше писать некуда
+		}
+		rows++
+		return true
+	})
+	if err = errors.Join(err, werr); err != nil {
+		return rows, err
+	}
+	cw.Flush()
+	return rows, cw.Error()
+}
+
+// ReadCSV разбирает то, что пишет ExportCSV. header - первая строка заголовок, она пропускается, какой бы ни была.
+// строки, которые не записать, попадают в Skipped, а не валят весь файл; ошибка - только ErrMalformedCSV и чтение r.
+// \r\n внутри значения в кавычках encoding/csv читает как \n
+func ReadCSV(r io.Reader, header bool, limits Limits) (kv map[string]string, report CSVImport, err error) {
+	br := bufio.NewReader(r)
+	// Excel сохраняет UTF-8 CSV с BOM, и без этого он стал бы частью первого ключа
+	if bom, _ := br.Peek(3); string(bom) == "\ufeff" {
+		br.Discard(3)
+	}
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1 // число полей проверяем сами, чтобы пропустить строку, а не бросить файл
+
+	kv = make(map[string]string)
+	report.Skipped = make([]CSVSkipped, 0)
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			return nil, report, fmt.Errorf("%w: %v", ErrMalformedCSV, perr)
+		}
+		if err != nil {
+			return nil, report, err
+		}
+		if first && header {
+			continue
+		}
+		report.Rows++
+		line, _ := cr.FieldPos(0)
+		if len(record) != 2 {
+			report.Skipped = append(report.Skipped, CSVSkipped{Line: line, Reason: fmt.Sprintf("expected 2 fields, got %d", len(record))})
+			continue
+		}
+		if err := limits.Check(record[0], record[1]); err != nil {
+			report.Skipped = append(report.Skipped, CSVSkipped{Line: line, Reason: err.Error()})
+			continue
+		}
+		kv[record[0]] = record[1]
+	}
+	report.Imported = len(kv)
+	return kv, report, nil
+}
+
+// ImportCSV загружает CSV из r в s одной записью: SetMany поверх того, что есть, или Replace всех данных с replace.
+// у FileStorage это одна дописанная пачка или одна перезапись файла. битый CSV не пишет ничего
+func ImportCSV(ctx context.Context, s Storage, r io.Reader, header, replace bool) (report CSVImport, err error) {
+	kv, report, err := ReadCSV(r, header, LimitsOf(s))
+	if err != nil {
+		return report, err
+	}
+	if replace {
+		err = Replace(ctx, s, kv)
+	} else if len(kv) > 0 {
+		err = s.SetMany(ctx, kv)
+	}
+	return report, err
+}
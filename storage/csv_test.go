@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// все, что экспортировано, импортируется обратно тем же: запятые, кавычки и переводы строк в значениях тоже
+func TestCSVRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	want := map[string]string{
+		"plain":   "value",
+		"comma":   "a,b",
+		"quote":   `say "hi"`,
+		"newline": "line 1\nline 2",
+		"empty":   "",
+		"dir/key": "nested",
+	}
+	src := NewMemStorage()
+	if err := src.SetMany(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range []bool{true, false} {
+		var buf bytes.Buffer
+		rows, err := ExportCSV(ctx, src, &buf, header)
+		if err != nil || rows != len(want) {
+			t.Fatalf("ExportCSV = %d, %v, want %d rows", rows, err, len(want))
+		}
+		if got := strings.HasPrefix(buf.String(), "key,value\n"); got != header {
+			t.Errorf("header %v: export starts with %q", header, strings.SplitN(buf.String(), "\n", 2)[0])
+		}
+
+		dst := NewMemStorage()
+		report, err := ImportCSV(ctx, dst, &buf, header, false)
+		if err != nil || report.Rows != len(want) || report.Imported != len(want) || len(report.Skipped) != 0 {
+			t.Fatalf("ImportCSV = %+v, %v", report, err)
+		}
+		got, err := Dump(ctx, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s = %q, want %q", k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		in       string
+		header   bool
+		want     map[string]string
+		skipped  []int // строки, которые пропущены
+		wantErr  error
+		wantRows int
+	}{
+		{name: "bom and crlf", in: "\ufeffkey,value\r\na,\"1\r\n2\"\r\n", header: true, want: map[string]string{"a": "1\n2"}, wantRows: 1},
+		{name: "header is skipped whatever it is", in: "k,v\nb,2\n", header: true, want: map[string]string{"b": "2"}, wantRows: 1},
+		{name: "last duplicate wins", in: "a,1\na,2\n", want: map[string]string{"a": "2"}, wantRows: 2},
+		{name: "bad rows are skipped", in: "a,1\nonly\nx,y,z\n,empty key\nb,2\n", want: map[string]string{"a": "1", "b": "2"}, skipped: []int{2, 3, 4}, wantRows: 5},
+		{name: "unclosed quote", in: "a,\"1\nb,2\n", wantErr: ErrMalformedCSV},
+		{name: "stray quote", in: "a,1\"2\n", wantErr: ErrMalformedCSV},
+	} {
+		kv, report, err := ReadCSV(strings.NewReader(tt.in), tt.header, Limits{})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(kv) != len(tt.want) || report.Rows != tt.wantRows || report.Imported != len(tt.want) || len(report.Skipped) != len(tt.skipped) {
+			t.Errorf("%s: got %v %+v", tt.name, kv, report)
+			continue
+		}
+		for k, v := range tt.want {
+			if kv[k] != v {
+				t.Errorf("%s: %s = %q, want %q", tt.name, k, kv[k], v)
+			}
+		}
+		for i, line := range tt.skipped {
+			if report.Skipped[i].Line != line {
+				t.Errorf("%s: skipped line %d, want %d", tt.name, report.Skipped[i].Line, line)
+			}
+		}
+	}
+}
+
+// битый CSV с replace не трогает старые данные
+func TestImportCSVMalformedKeepsData(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	s.Set(ctx, "old", "v")
+	if _, err := ImportCSV(ctx, s, strings.NewReader("a,1\nb,\"2\n"), false, true); !errors.Is(err, ErrMalformedCSV) {
+		t.Fatalf("got %v, want ErrMalformedCSV", err)
+	}
+	if v, err := s.Get(ctx, "old"); err != nil || v != "v" {
+		t.Errorf("old = %q, %v after a failed import", v, err)
+	}
+}
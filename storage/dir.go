@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dir - каждый ключ лежит в своем файле внутри директории
+type DirStorage struct {
+	dir string
+}
+
+// имя файла - это ключ в base64url без паддинга. в алфавите нет ни '/', ни '.',
+// так что ключ вроде ../../etc/passwd физически не может выйти за пределы директории
+var dirKeyEncoding = base64.RawURLEncoding
+
+// временные файлы начинаются с точки - такое имя никогда не получится из base64url
+const dirTmpPattern = ".tmp-*"
+
+func (ds *DirStorage) path(key string) (string, error) {
+	if key == "" {
+		return "", &InvalidKeyError{Key: key, Reason: "must not be empty"}
+	}
+	name := dirKeyEncoding.EncodeToString([]byte(key))
+	if len(name) > 255 { // больше не дает большинство файловых систем
+		return "", &InvalidKeyError{Key: key, Reason: "too long for dir storage"}
+	}
+	return filepath.Join(ds.dir, name), nil
+}
+
+func (ds *DirStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called dir storage Get method")
+
+	p, err := ds.path(key)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read key file: %w", err)
+	}
+	return string(b), nil
+}
+
+// Set пишет значение во временный файл и переименовывает его поверх старого,
+// так что читатель никогда не увидит недописанное значение
+func (ds *DirStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called dir storage Set method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	p, err := ds.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(ds.dir, dirTmpPattern)
+	if err != nil {
+		return fmt.Errorf("unable to create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.WriteString(value); err != nil {
+		return fmt.Errorf("unable to write temp file: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("unable to sync temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to close temp file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("unable to replace key file: %w", err)
+	}
+	syncDir(ds.dir)
+	return nil
+}
+
+func (ds *DirStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called dir storage Delete method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	p, err := ds.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("unable to remove key file: %w", err)
+	}
+	return nil
+}
+
+func (ds *DirStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called dir storage Keys method")
+
+	entries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dir %s: %w", ds.dir, err)
+	}
+
+	keys = make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		k, err := dirKeyEncoding.DecodeString(e.Name())
+		if err != nil {
+			continue // чужой файл, положенный руками
+		}
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (ds *DirStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called dir storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	for _, k := range keys {
+		v, err := ds.Get(ctx, k)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		kv[k] = v
+	}
+	return kv, nil
+}
+
+// SetMany пишет ключи по одному: каждый файл меняется атомарно, но батч целиком - нет
+func (ds *DirStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called dir storage SetMany method")
+
+	for k, v := range kv {
+		if err = ds.Set(ctx, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DirStorage) Ping() (err error) {
+	info, err := os.Stat(ds.dir)
+	if err != nil {
+		return fmt.Errorf("unable to stat dir %s: %w", ds.dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", ds.dir)
+	}
+	return nil
+}
+
+func NewDirStorage(dir string) (Storage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create dir %s: %w", dir, err)
+	}
+
+	// недописанные временные файлы остаются после падения посреди Set
+	leftovers, _ := filepath.Glob(filepath.Join(dir, dirTmpPattern))
+	for _, name := range leftovers {
+		os.Remove(name)
+	}
+	return &DirStorage{dir: dir}, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ключи с путями и спецсимволами хранятся как обычные ключи и не выходят за пределы директории
+func TestDirStorageKeys(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dir := filepath.Join(root, "data")
+	s, err := NewDirStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{
+		"../escape",
+		"../../etc/passwd",
+		"/etc/passwd",
+		"/abs/path",
+		"a/b",
+		"a%2Fb",
+		"..%2F..%2Fescape",
+		`..\windows`,
+		"..",
+		".",
+		".hidden",
+		"dot.in.key",
+	} {
+		if err := s.Set(ctx, key, "v:"+key); err != nil {
+			t.Errorf("Set(%q): %v", key, err)
+			continue
+		}
+		if v, err := s.Get(ctx, key); err != nil || v != "v:"+key {
+			t.Errorf("Get(%q) = %q, %v", key, v, err)
+		}
+	}
+
+	// рядом с директорией ничего не появилось, а внутри нет ни вложенных директорий, ни скрытых файлов
+	if entries, err := os.ReadDir(root); err != nil || len(entries) != 1 {
+		t.Errorf("entries next to the data dir: %v, %v", entries, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.ContainsAny(e.Name(), "./\\%") {
+			t.Errorf("unexpected entry %q in the data dir", e.Name())
+		}
+	}
+	if keys, err := s.Keys(ctx); err != nil || len(keys) != 12 || keys[0] != "." {
+		t.Errorf("Keys = %q, %v", keys, err)
+	}
+	if err := s.Delete(ctx, "../escape"); err != nil {
+		t.Errorf("Delete(../escape): %v", err)
+	}
+
+	for name, key := range map[string]string{"empty": "", "too long": strings.Repeat("k", 200)} {
+		if err := s.Set(ctx, key, "v"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Set of a %s key: got %v, want ErrInvalidKey", name, err)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get of a %s key: got %v, want ErrInvalidKey", name, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// Dumper - хранилка, которая умеет отдать и заменить весь набор данных разом, а не по ключу
+type Dumper interface {
+	Dump(ctx context.Context) (kv map[string]string, err error)
+	// Replace заменяет все содержимое на kv: ключи, которых нет в kv, пропадают
+	Replace(ctx context.Context, kv map[string]string) (err error)
+}
+
+// Dump отдает все данные хранилки. у кого нет своего Dump, собираем через Keys и GetMany.
+// ttl в дамп не попадает, восстановленные ключи будут вечными
+func Dump(ctx context.Context, s Storage) (kv map[string]string, err error) {
+	if d, ok := As[Dumper](s); ok {
+		return d.Dump(ctx)
+	}
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetMany(ctx, keys)
+}
+
+// Replace заменяет содержимое хранилки на kv. без своего Replace это удаление лишних ключей и SetMany,
+// так что посреди замены читатель может увидеть смесь старого и нового
+func Replace(ctx context.Context, s Storage, kv map[string]string) (err error) {
+	if d, ok := As[Dumper](s); ok {
+		return d.Replace(ctx, kv)
+	}
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, keep := kv[k]; keep {
+			continue
+		}
+		if err = s.Delete(ctx, k); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return s.SetMany(ctx, kv)
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Entry - значение вместе с тем, когда и сколько раз его писали. чтение метаданные не трогает
+type Entry struct {
+	Value     string
+	CreatedAt time.Time // первая запись ключа; после удаления ключ создается заново
+	UpdatedAt time.Time // последняя запись
+	Writes    uint64    // сколько раз ключ записывали с CreatedAt, включая первую запись
+	ExpiresAt time.Time // нулевое - ключ без ttl
+
+	// ContentType - тип, с которым значение записали через WithContentType. пусто - тип при записи не передали
+	ContentType string
+}
+
+type contentTypeKey struct{}
+
+// WithContentType передает хранилке тип записываемого значения, например Content-Type из PUT.
+// его запоминают Set, SetWithTTL и условные записи у хранилок с метаданными, остальные про него не знают
+func WithContentType(ctx context.Context, contentType string) context.Context {
+	return context.WithValue(ctx, contentTypeKey{}, contentType)
+}
+
+// ContentTypeFrom - тип значения из контекста, пусто, если его не передали
+func ContentTypeFrom(ctx context.Context) string {
+	ct, _ := ctx.Value(contentTypeKey{}).(string)
+	return ct
+}
+
+// EntryGetter - хранилка, которая помнит метаданные ключей
+type EntryGetter interface {
+	GetEntry(ctx context.Context, key string) (entry Entry, err error)
+}
+
+// GetEntry читает ключ с метаданными. у бэкендов без метаданных это ErrNotSupported
+func GetEntry(ctx context.Context, s Storage, key string) (entry Entry, err error) {
+	if eg, ok := As[EntryGetter](s); ok {
+		return eg.GetEntry(ctx, key)
+	}
+	return entry, ErrNotSupported
+}
+
+// entryMeta хранится в MemStorage рядом со значением, как и дедлайны ttl
+type entryMeta struct {
+	created     time.Time
+	updated     time.Time
+	writes      uint64
+	contentType string
+}
+
+func (ms *MemStorage) GetEntry(ctx context.Context, key string) (entry Entry, err error) {
+	log.Println("called mem storage GetEntry method")
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	value, ok := ms.lookup(key)
+	if !ok {
+		return entry, ErrNotFound
+	}
+	meta := ms.meta[key]
+	return Entry{Value: value, CreatedAt: meta.created, UpdatedAt: meta.updated, Writes: meta.writes, ExpiresAt: ms.exp[key], ContentType: meta.contentType}, nil
+}
+
+// touch отмечает запись ключа, вызывается из store. existed == false - ключ создается заново
+func (ms *MemStorage) touch(key string, existed bool) {
+	now := ms.clock()()
+	if ms.meta == nil {
+		ms.meta = make(map[string]entryMeta)
+	}
+	meta := ms.meta[key]
+	if !existed {
+		meta = entryMeta{created: now}
+	}
+	meta.updated = now
+	meta.writes++
+	meta.contentType = "" // тип относится к прежнему значению, новый ставит setContentType
+	ms.meta[key] = meta
+}
+
+// setContentType запоминает тип из контекста за только что записанным ключом, вызывается под блокировкой
+func (ms *MemStorage) setContentType(ctx context.Context, key string) {
+	if ct := ContentTypeFrom(ctx); ct != "" {
+		meta := ms.meta[key]
+		meta.contentType = ct
+		ms.meta[key] = meta
+	}
+}
+
+// record - запись журнала, из которой при загрузке восстановится ключ целиком: значение, ttl и метаданные
+func (ms *MemStorage) record(key string) logRecord {
+	return setRecord(key, ms.m, ms.exp, ms.meta)
+}
+
+// nextRecord собирает запись журнала для value в key, ничего не меняя, как renameRecords: метаданные
+// такие, какие насчитал бы store. нулевой deadline - ключ без ttl. вызывается под блокировкой
+func (ms *MemStorage) nextRecord(key, value, contentType string, deadline time.Time) logRecord {
+	now := ms.clock()().Format(time.RFC3339Nano)
+	rec := logRecord{Op: opSet, Key: key, Value: value, Created: now, Updated: now, Writes: "1", Type: contentType}
+	if _, ok := ms.lookup(key); ok {
+		if meta, ok := ms.meta[key]; ok {
+			rec.Created, rec.Writes = meta.created.Format(time.RFC3339Nano), strconv.FormatUint(meta.writes+1, 10)
+		}
+	}
+	if !deadline.IsZero() {
+		rec.Expires = deadline.Format(time.RFC3339Nano)
+	}
+	return rec
+}
+
+// liveDeadline - ttl живого ключа, его сохраняют Increment и Append. нулевой, если ttl нет или ключ протух
+func (ms *MemStorage) liveDeadline(key string) time.Time {
+	if _, ok := ms.lookup(key); !ok {
+		return time.Time{}
+	}
+	return ms.exp[key]
+}
+
+func setRecord(key string, m map[string]string, exp map[string]time.Time, meta map[string]entryMeta) logRecord {
+	rec := logRecord{Op: opSet, Key: key, Value: m[key]}
+	if deadline, ok := exp[key]; ok {
+		rec.Expires = deadline.Format(time.RFC3339Nano)
+	}
+	if em, ok := meta[key]; ok {
+		rec.Created = em.created.Format(time.RFC3339Nano)
+		rec.Updated = em.updated.Format(time.RFC3339Nano)
+		rec.Writes = strconv.FormatUint(em.writes, 10)
+		rec.Type = em.contentType
+	}
+	return rec
+}
+
+// applyMeta восстанавливает метаданные из записи журнала. у записей старых версий их нет,
+// тогда остается то, что насчитал touch при проигрывании, а файл потом переписывается уже с ними
+func (ms *MemStorage) applyMeta(rec logRecord) (err error) {
+	if rec.Updated == "" {
+		return nil
+	}
+	var meta entryMeta
+	if meta.created, err = time.Parse(time.RFC3339Nano, rec.Created); err != nil {
+		return fmt.Errorf("invalid created time: %w", err)
+	}
+	if meta.updated, err = time.Parse(time.RFC3339Nano, rec.Updated); err != nil {
+		return fmt.Errorf("invalid updated time: %w", err)
+	}
+	if meta.writes, err = strconv.ParseUint(rec.Writes, 10, 64); err != nil {
+		return fmt.Errorf("invalid write counter: %w", err)
+	}
+	meta.contentType = rec.Type
+	ms.meta[rec.Key] = meta
+	return nil
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// сколько операций кладем в одну транзакцию etcd: сервер по умолчанию не принимает больше 128 (--max-txn-ops)
+const etcdTxnOps = 128
+
+// ключ, который читает Ping, как у etcdctl endpoint health. пустой ключ etcd не принимает, а префикс может быть пустым
+const etcdHealthKey = "health"
+
+// сколько ключей Keys читает за один запрос, чтобы не тянуть весь диапазон одним ответом
+const etcdPageSize = 1000
+
+// etcd. данные общие для всех реплик сервиса, которые смотрят в один кластер.
+// kv и watcher - интерфейсы клиента, а не сам клиент, чтобы их можно было подменить
+type EtcdStorage struct {
+	client  *clientv3.Client
+	kv      clientv3.KV
+	watcher clientv3.Watcher
+	prefix  string // к нему дописываются ключи, так несколько сервисов делят один кластер
+}
+
+// wrapEtcdErr отделяет отказ самого etcd от проблем со связью и кворумом,
+// все второе - недоступность, ее можно повторить
+func wrapEtcdErr(op string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("unable to %s: %w", op, err)
+	}
+	var eerr rpctypes.EtcdError
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, clientv3.ErrNoAvailableEndpoints) ||
+		status.Code(err) == codes.Unavailable || status.Code(err) == codes.DeadlineExceeded ||
+		errors.As(err, &eerr) && eerr.Code() == codes.Unavailable {
+		return fmt.Errorf("unable to %s: %w: %w", op, ErrUnavailable, err)
+	}
+	return fmt.Errorf("unable to %s: %w", op, err)
+}
+
+func (es *EtcdStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called etcd storage Get method")
+
+	resp, err := es.kv.Get(ctx, es.prefix+key)
+	if err != nil {
+		return "", wrapEtcdErr("get key", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return "", ErrNotFound
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (es *EtcdStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called etcd storage Set method")
+
+	if _, err = es.kv.Put(ctx, es.prefix+key, value); err != nil {
+		return wrapEtcdErr("set key", err)
+	}
+	return nil
+}
+
+func (es *EtcdStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called etcd storage Delete method")
+
+	resp, err := es.kv.Delete(ctx, es.prefix+key)
+	if err != nil {
+		return wrapEtcdErr("delete key", err)
+	}
+	if resp.Deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Keys читает диапазон под префиксом страницами. etcd отдает ключи отсортированными,
+// так что следующая страница начинается сразу за последним ключом предыдущей
+func (es *EtcdStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called etcd storage Keys method")
+
+	keys = make([]string, 0)
+	from, end := es.prefix, clientv3.GetPrefixRangeEnd(es.prefix)
+	if from == "" {
+		from = "\x00" // пустой ключ etcd не принимает, а с end "\x00" это диапазон от from до конца
+	}
+	for {
+		resp, err := es.kv.Get(ctx, from, clientv3.WithRange(end), clientv3.WithKeysOnly(), clientv3.WithLimit(etcdPageSize))
+		if err != nil {
+			return nil, wrapEtcdErr("list keys", err)
+		}
+		for _, kv := range resp.Kvs {
+			keys = append(keys, strings.TrimPrefix(string(kv.Key), es.prefix))
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return keys, nil
+		}
+		from = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// GetMany читает ключи транзакциями по etcdTxnOps, внутри одной транзакции они из одной ревизии
+func (es *EtcdStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called etcd storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	for chunk := range chunks(keys, etcdTxnOps) {
+		ops := make([]clientv3.Op, len(chunk))
+		for i, key := range chunk {
+			ops[i] = clientv3.OpGet(es.prefix + key)
+		}
+		resp, err := es.kv.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return nil, wrapEtcdErr("get keys", err)
+		}
+		for i, r := range resp.Responses {
+			if kvs := r.GetResponseRange().Kvs; len(kvs) > 0 {
+				kv[chunk[i]] = string(kvs[0].Value)
+			}
+		}
+	}
+	return kv, nil
+}
+
+// SetMany пишет транзакциями по etcdTxnOps ключей: больше etcd в одну не примет,
+// так что атомарна запись только в пределах одной транзакции
+func (es *EtcdStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called etcd storage SetMany method")
+
+	ops := make([]clientv3.Op, 0, min(len(kv), etcdTxnOps))
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		if _, err := es.kv.Txn(ctx).Then(ops...).Commit(); err != nil {
+			return wrapEtcdErr("set keys", err)
+		}
+		ops = ops[:0]
+		return nil
+	}
+	for key, value := range kv {
+		ops = append(ops, clientv3.OpPut(es.prefix+key, value))
+		if len(ops) == etcdTxnOps {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func (es *EtcdStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	log.Println("called etcd storage CompareAndSwap method")
+
+	k := es.prefix + key
+	resp, err := es.kv.Txn(ctx).If(clientv3.Compare(clientv3.Value(k), "=", old)).Then(clientv3.OpPut(k, new)).Commit()
+	if err != nil {
+		return false, wrapEtcdErr("compare and swap key", err)
+	}
+	return resp.Succeeded, nil
+}
+
+// у отсутствующего ключа CreateRevision равна нулю
+func (es *EtcdStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	log.Println("called etcd storage SetIfAbsent method")
+
+	k := es.prefix + key
+	resp, err := es.kv.Txn(ctx).If(clientv3.Compare(clientv3.CreateRevision(k), "=", 0)).Then(clientv3.OpPut(k, value)).Commit()
+	if err != nil {
+		return false, wrapEtcdErr("set key if absent", err)
+	}
+	return resp.Succeeded, nil
+}
+
+// Watch переводит watch etcd в Event. изменения видны все, откуда бы они ни пришли, в том числе
+// записи других реплик. канал закрывается, если etcd оборвал watch, например после компакции ревизий
+func (es *EtcdStorage) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	log.Println("called etcd storage Watch method")
+
+	ctx, cancel := context.WithCancel(ctx)
+	// без WithRequireLeader watch молча висел бы на узле, отрезанном от кластера
+	wch := es.watcher.Watch(clientv3.WithRequireLeader(ctx), es.prefix+prefix, clientv3.WithPrefix())
+	events := make(chan Event, watchBuffer)
+	go func() {
+		defer close(events)
+		defer cancel()
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				log.Printf("etcd watch of prefix %q stopped: %v", prefix, err)
+				return
+			}
+			for _, ev := range resp.Events {
+				e := Event{Op: EventSet, Key: strings.TrimPrefix(string(ev.Kv.Key), es.prefix), Value: string(ev.Kv.Value)}
+				if ev.Type == mvccpb.DELETE {
+					e.Op, e.Value = EventDelete, ""
+				}
+				select {
+				case events <- e:
+				default:
+					log.Printf("dropping slow watcher of prefix %q", prefix)
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Ping читает по кворуму, так что отвечает ошибкой и тогда, когда сам узел жив, но кластер без лидера
+func (es *EtcdStorage) Ping() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = es.kv.Get(ctx, etcdHealthKey, clientv3.WithCountOnly()); err != nil {
+		return wrapEtcdErr("ping", err)
+	}
+	return nil
+}
+
+func (es *EtcdStorage) Close() (err error) {
+	log.Println("called etcd storage Close method")
+	return es.client.Close()
+}
+
+// chunks режет s на куски не длиннее n
+func chunks(s []string, n int) func(yield func([]string) bool) {
+	return func(yield func([]string) bool) {
+		for len(s) > 0 {
+			c := s[:min(n, len(s))]
+			if !yield(c) {
+				return
+			}
+			s = s[len(c):]
+		}
+	}
+}
+
+// NewEtcdStorage подключается к кластеру etcd. prefix приписывается ко всем ключам, пустой - ключи как есть
+func NewEtcdStorage(endpoints []string, dialTimeout time.Duration, prefix string) (Storage, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create etcd client: %w", err)
+	}
+	es := &EtcdStorage{client: client, kv: client.KV, watcher: client.Watcher, prefix: prefix}
+
+	// проверяем соединение сразу, а не на первом запросе
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if _, err := es.kv.Get(ctx, etcdHealthKey, clientv3.WithCountOnly()); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to connect to etcd %s: %w", strings.Join(endpoints, ","), wrapEtcdErr("ping", err))
+	}
+	return es, nil
+}
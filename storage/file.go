@@ -0,0 +1,1282 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// file
+type FileStorage struct {
+	*MemStorage // встроем реализацию хранилки в памяти
+	f           *os.File
+	lock        *os.File // держит блокировку, пока хранилка открыта
+	name        string
+	opts        fileOptions
+	closed      bool
+
+	size      int64         // текущий размер файла, для метрик
+	lastFlush time.Duration // сколько заняла последняя запись на диск
+
+	records  int    // сколько записей сейчас в журнале, живых и перезаписанных
+	rewrites uint64 // растет при каждой подмене файла, чтобы Compact понял, что его снимок устарел
+
+	pending   []logRecord   // записи write-behind, которые еще не дошли до файла
+	flushKick chan struct{} // будит flushLoop, когда набралась пачка
+	flushDone chan struct{} // останавливает flushLoop
+
+	wal     *os.File // nil без WithWAL. в нем лежат как раз записи из pending
+	walSize int64
+
+	watchDone chan struct{} // останавливает слежку за файлом, nil без WithFileWatch
+	diskState os.FileInfo   // каким мы оставили файл после своей последней записи
+	conflict  bool          // файл поменяли снаружи при ConflictReadOnly, записи отвергаются до Reload
+
+	compactMu      sync.Mutex    // одна компакция за раз
+	compactDone    chan struct{} // останавливает фоновую компакцию
+	lastCompaction compactionStats
+}
+
+// и переопределим только метод сет - чтение будет идти из мапки
+func (fs *FileStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called file storage Set method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = ValidateKey(key); err != nil {
+		return err
+	}
+	// блокировку держим до конца записи в файл, иначе записи в логе лягут не в том порядке, в котором менялась мапка
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	return fs.commit(fs.nextRecord(key, value, ContentTypeFrom(ctx), time.Time{}))
+}
+
+// весь батч уходит в файл одной записью, а не по записи на ключ
+func (fs *FileStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called file storage SetMany method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = validateKeys(kv); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	recs := make([]logRecord, 0, len(kv))
+	for k, v := range kv {
+		recs = append(recs, fs.nextRecord(k, v, "", time.Time{}))
+	}
+	return fs.commit(recs...)
+}
+
+// дедлайн пишем в журнал вместе со значением, чтобы ttl переживал перезапуск
+func (fs *FileStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	log.Println("called file storage SetWithTTL method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = ValidateKey(key); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	return fs.commit(fs.nextRecord(key, value, ContentTypeFrom(ctx), fs.clock()().Add(ttl)))
+}
+
+// в журнал попадает только удачная замена, проигравший CAS файл не трогает
+func (fs *FileStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	log.Println("called file storage CompareAndSwap method")
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+	if err = ValidateKey(key); err != nil {
+		return false, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return false, err
+	}
+	if v, ok := fs.lookup(key); !ok || v != old {
+		return false, nil
+	}
+	if err = fs.commit(fs.nextRecord(key, new, ContentTypeFrom(ctx), time.Time{})); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (fs *FileStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	log.Println("called file storage SetIfAbsent method")
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+	if err = ValidateKey(key); err != nil {
+		return false, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return false, err
+	}
+	if _, ok := fs.lookup(key); ok {
+		return false, nil
+	}
+	if err = fs.commit(fs.nextRecord(key, value, ContentTypeFrom(ctx), time.Time{})); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetOrSet пишет в журнал, только если ключа не было
+func (fs *FileStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	log.Println("called file storage GetOrSet method")
+	if err = ctx.Err(); err != nil {
+		return "", false, err
+	}
+	if err = ValidateKey(key); err != nil {
+		return "", false, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return "", false, err
+	}
+	if value, ok := fs.lookup(key); ok {
+		return value, true, nil
+	}
+	if err = fs.commit(fs.nextRecord(key, defaultValue, ContentTypeFrom(ctx), time.Time{})); err != nil {
+		return "", false, err
+	}
+	return defaultValue, false, nil
+}
+
+func (fs *FileStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	log.Println("called file storage Increment method")
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err = ValidateKey(key); err != nil {
+		return 0, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return 0, err
+	}
+	current, ok := fs.lookup(key)
+	if value, err = addInt(current, ok, delta); err != nil {
+		return 0, err
+	}
+	if err = fs.commit(fs.nextRecord(key, strconv.FormatInt(value, 10), "", fs.liveDeadline(key))); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// Append пишет в журнал значение целиком, а не только suffix: так запись остается обычной записью set
+func (fs *FileStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	log.Println("called file storage Append method")
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err = ValidateKey(key); err != nil {
+		return 0, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return 0, err
+	}
+	current, _ := fs.lookup(key)
+	value := current + suffix
+	if err = checkAppended(ctx, value); err != nil {
+		return 0, err
+	}
+	if err = fs.commit(fs.nextRecord(key, value, "", fs.liveDeadline(key))); err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
+
+// Replace пишет новый снимок одним атомарным rewrite, а не по записи в журнал на ключ
+func (fs *FileStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called file storage Replace method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = validateKeys(kv); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	oldM, oldExp, oldMeta, oldHistory, oldDeleted, oldBytes := fs.m, fs.exp, fs.meta, fs.history, fs.deleted, fs.bytes
+	fs.replace(kv)
+	if err = fs.rewrite(); err != nil {
+		// на диске остался старый файл, пусть и память с ним совпадает
+		fs.m, fs.exp, fs.meta, fs.history, fs.deleted, fs.bytes = oldM, oldExp, oldMeta, oldHistory, oldDeleted, oldBytes
+		return err
+	}
+	return nil
+}
+
+// openReadOnly - NewFileStorage для WithReadOnly: только читаем файл как есть, даже если его стоило бы переписать
+func openReadOnly(filename string, o fileOptions) (_ Storage, err error) {
+	file, err := os.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file %s: %w", filename, err)
+	}
+	ms, records, migrate, err := loadFile(file, filename, o)
+	if err == nil && o.wal {
+		err = replayWALReadOnly(filename, ms, o)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if migrate {
+		log.Printf("file %s is in an outdated format, it is not migrated in read-only mode", filename)
+	}
+	fs := &FileStorage{MemStorage: ms, f: file, name: filename, opts: o, size: fileSize(file), records: records}
+	// так реплика только для чтения подхватывает все, что пишет в файл основной экземпляр
+	if o.watchDebounce > 0 {
+		if err = fs.watchFile(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// writable проверяет, что данные можно менять, вызывается под блокировкой
+func (fs *FileStorage) writable() error {
+	if fs.closed {
+		return ErrClosed
+	}
+	if fs.opts.readOnly {
+		return ErrReadOnly
+	}
+	if fs.conflict {
+		return fmt.Errorf("%w: file %s was changed by another process while writes were pending, reload it to continue", ErrReadOnly, fs.name)
+	}
+	return nil
+}
+
+// Reload перечитывает файл с диска, если его поменяли в обход сервера. файл открываем заново,
+// потому что редактор мог подменить его новым. если файл не читается, остаются старые данные
+func (fs *FileStorage) Reload() (err error) {
+	log.Println("called file storage Reload method")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+	if fs.conflict {
+		// файл поменяли снаружи поверх наших отложенных записей, и Reload - это согласие взять файл как есть
+		log.Printf("WARNING: dropping %d pending writes to take the externally changed file %s", len(fs.pending), fs.name)
+		fs.discardPending()
+		fs.conflict = false
+	}
+	// отложенные записи сначала дописываем в старый файл, как если бы write-behind не было
+	if err = fs.flush(); err != nil {
+		return err
+	}
+	return fs.reload()
+}
+
+// reload - Reload без блокировки и без сброса отложенных записей, вызывается под блокировкой
+func (fs *FileStorage) reload() (err error) {
+	flag := os.O_RDWR | os.O_APPEND
+	if fs.opts.readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(fs.name, flag, fs.opts.fileMode)
+	if err != nil {
+		return fmt.Errorf("unable to reopen file %s: %w", fs.name, err)
+	}
+	o := fs.opts
+	o.force = false // при живом сервере лучше отказаться, чем тихо потерять часть данных
+	ms, records, migrate, err := loadFile(file, fs.name, o)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	fs.adopt(ms)
+	fs.invalidations.Add(1) // в том числе когда перечитал watch, а не Reload через кэш
+	fs.f.Close()
+	fs.f = file
+	fs.size = fileSize(file)
+	fs.records = records
+	fs.rewrites++ // файл подменили, так что начатая компакция уже не годится
+	if fs.watchDone != nil {
+		fs.noteDiskState()
+	}
+	if migrate && !fs.opts.readOnly {
+		return fs.rewrite()
+	}
+	return nil
+}
+
+// удаление тоже меняет данные, так что и его переопределяем
+func (fs *FileStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called file storage Delete method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	if _, ok := fs.lookup(key); !ok {
+		fs.drop(key) // протухший ключ просто вычищаем, в журнале его удалять незачем
+		return fmt.Errorf("unable to delete key from memorystorage: %w", ErrNotFound)
+	}
+	return fs.commit(logRecord{Op: opDelete, Key: key, Deleted: fs.clock()().Format(time.RFC3339Nano)})
+}
+
+// Rename пишет новый ключ и удаление старого одной записью в файл и меняет память, только когда она удалась
+func (fs *FileStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	log.Println("called file storage Rename method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = ValidateKey(newKey); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	recs, err := fs.renameRecords(oldKey, newKey, overwrite)
+	if err != nil || len(recs) == 0 {
+		return err
+	}
+	return fs.commit(recs...)
+}
+
+// Txn пишет все изменения транзакции одной записью в файл и применяет их к памяти, только когда она удалась
+func (fs *FileStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	log.Println("called file storage Txn method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.Type == TxnSet {
+			if err = ValidateKey(op.Key); err != nil {
+				return err
+			}
+		}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return err
+	}
+	recs, err := fs.txnRecords(ops)
+	if err != nil || len(recs) == 0 {
+		return err
+	}
+	return fs.commit(recs...)
+}
+
+// DeletePrefix пишет удаления всех ключей в журнал одной записью в файл, как SetMany
+func (fs *FileStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	log.Println("called file storage DeletePrefix method")
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return 0, err
+	}
+	var keys []string
+	for k := range fs.m {
+		if _, ok := fs.lookup(k); ok && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	sort.Strings(keys)
+	now := fs.clock()().Format(time.RFC3339Nano)
+	recs := make([]logRecord, 0, len(keys))
+	for _, k := range keys {
+		recs = append(recs, logRecord{Op: opDelete, Key: k, Deleted: now})
+	}
+	if err = fs.commit(recs...); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// Close сбрасывает файл на диск и закрывает его. повторный вызов ничего не делает,
+// а Set и Delete после закрытия возвращают ErrClosed
+func (fs *FileStorage) Close() (err error) {
+	log.Println("called file storage Close method")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return nil
+	}
+	fs.closed = true
+	fs.stopSweeper()
+	fs.watchers.closeAll()
+	if fs.compactDone != nil {
+		close(fs.compactDone)
+	}
+	if fs.flushDone != nil {
+		close(fs.flushDone)
+	}
+	if fs.watchDone != nil {
+		close(fs.watchDone)
+	}
+	if fs.lock != nil { // в режиме только для чтения блокировки нет
+		defer fs.lock.Close() // закрытие дескриптора снимает блокировку
+	}
+	if fs.wal != nil {
+		defer fs.wal.Close() // после удачного flush он пустой, а после неудачного проиграется при следующем открытии
+	}
+
+	if err = fs.flush(); err != nil {
+		fs.f.Close()
+		return fmt.Errorf("unable to flush pending writes to %s: %w", fs.name, err)
+	}
+
+	if err = fs.f.Sync(); err != nil {
+		fs.f.Close()
+		return fmt.Errorf("unable to sync file %s: %w", fs.name, err)
+	}
+	if err = fs.f.Close(); err != nil {
+		return fmt.Errorf("unable to close file %s: %w", fs.name, err)
+	}
+	return nil
+}
+
+func (fs *FileStorage) Watch(ctx context.Context, prefix string) (events <-chan Event, err error) {
+	log.Println("called file storage Watch method")
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if fs.closed {
+		return nil, ErrClosed
+	}
+	return fs.watchers.subscribe(ctx, prefix), nil
+}
+
+// Ping проверяет, что файл все еще открыт и в него можно писать
+func (fs *FileStorage) Ping() (err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+	if err = fs.f.Sync(); err != nil {
+		return fmt.Errorf("unable to sync file %s: %w", fs.name, err)
+	}
+	return nil
+}
+
+// на диске храним не снимок мапки, а журнал операций - по записи на каждое изменение.
+// так каждая запись стоит O(1), а не перезапись всего файла
+type logRecord struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+
+	// момент истечения ttl в RFC3339, пусто для вечных ключей
+	Expires string `json:"expires,omitempty"`
+
+	// метаданные Entry на момент записи. в журналах старых версий их нет
+	Created string `json:"created,omitempty"`
+	Updated string `json:"updated,omitempty"`
+	Writes  string `json:"writes,omitempty"`
+	Type    string `json:"type,omitempty"` // Content-Type значения, если его передали
+
+	// когда ключ удалили, у записей delete. по нему после перезапуска считается срок хранения надгробия
+	Deleted string `json:"deleted,omitempty"`
+}
+
+const (
+	opSet    = "set"
+	opDelete = "delete"
+)
+
+// commit пишет записи в журнал и применяет их к памяти, только когда запись удалась: иначе клиент получил бы
+// ошибку, а чтения уже видели бы значение, которое пропадет после перезапуска. вызывается под блокировкой
+func (fs *FileStorage) commit(recs ...logRecord) (err error) {
+	if err = fs.appendRecords(recs...); err != nil {
+		return err
+	}
+	return fs.applyRecords(recs)
+}
+
+// appendRecords пишет операции в журнал, а в режиме write-behind только ставит их в очередь. вызывается под блокировкой
+func (fs *FileStorage) appendRecords(recs ...logRecord) (err error) {
+	if fs.wal != nil {
+		if err = fs.logAhead(recs); err != nil {
+			return err
+		}
+	}
+	if fs.opts.writeBehind() {
+		fs.queue(recs...)
+		return nil
+	}
+	return fs.writeRecords(recs...)
+}
+
+// writeRecords дописывает операции в конец журнала одним вызовом Write
+func (fs *FileStorage) writeRecords(recs ...logRecord) (err error) {
+	start := time.Now()
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		if err = encodeFrame(&buf, fs.opts.codec, recordMap(rec)); err != nil {
+			return fmt.Errorf("unable to encode record: %w", err)
+		}
+	}
+	if fs.opts.compress {
+		// дописать в законченный gzip поток нельзя, зато gzip.Reader читает несколько потоков подряд
+		// как один - так что каждая запись уходит отдельным gzip потоком в конец файла
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(buf.Bytes()) // в bytes.Buffer запись не падает
+		if err = zw.Close(); err != nil {
+			return fmt.Errorf("unable to compress record: %w", err)
+		}
+		buf = zbuf
+	}
+	if fs.opts.aead != nil {
+		var ebuf bytes.Buffer
+		cw := &chunkWriter{w: &ebuf, aead: fs.opts.aead}
+		cw.Write(buf.Bytes())
+		cw.Close()
+		buf = ebuf
+	}
+	// файл открыт с os.O_APPEND, так что запись всегда уходит в конец
+	n, err := fs.f.Write(buf.Bytes())
+	fs.size += int64(n)
+	fs.records += len(recs)
+	fs.lastFlush = time.Since(start)
+	if fs.watchDone != nil {
+		fs.noteDiskState()
+	}
+	if err != nil {
+		return fmt.Errorf("unable to append record to the file: %w", err)
+	}
+	return nil
+}
+
+// rewrite перезаписывает журнал с нуля так, чтобы в нем остались только живые ключи, вызывается под блокировкой.
+// пишем во временный файл рядом и переименовываем его поверх основного - rename атомарный,
+// так что на диске всегда лежит либо старый, либо новый полный файл
+func (fs *FileStorage) rewrite() (err error) {
+	// старый файл rewrite заменяет целиком, так что копию снимаем до него
+	if fs.opts.backups > 0 && fs.size > 0 {
+		if _, err = fs.backup(); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	tmpName := fs.name + tmpSuffix
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fs.opts.fileMode)
+	if err != nil {
+		return fmt.Errorf("unable to create temp file %s: %w", tmpName, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = writeSnapshot(tmp, fs.opts, fs.m, fs.exp, fs.meta, fs.history, fs.deleted); err != nil {
+		return err
+	}
+	if err = fs.install(tmp); err != nil {
+		return err
+	}
+	fs.pending = nil // снимок сделан из памяти, так что отложенные записи в нем уже есть
+	if err := fs.truncateWAL(); err != nil {
+		// снимок уже на месте, а лишние записи в WAL при открытии только проиграются еще раз
+		log.Printf("unable to truncate write-ahead log after rewriting %s: %v", fs.name, err)
+	}
+	fs.records = fs.liveRecords()
+	fs.rewrites++
+	fs.lastFlush = time.Since(start)
+	return nil
+}
+
+// writeSnapshot пишет в w полный файл с живыми ключами из m. прежние значения из history идут перед
+// текущим, от старых к новым, так что при проигрывании история ключа складывается обратно сама.
+// надгробия из deleted идут после живых ключей
+func writeSnapshot(w io.Writer, o fileOptions, m map[string]string, exp map[string]time.Time, meta map[string]entryMeta, history map[string][]VersionedValue, deleted map[string]tombstone) (err error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buried := make([]string, 0, len(deleted))
+	for k := range deleted {
+		buried = append(buried, k)
+	}
+	sort.Strings(buried)
+
+	// пишем через буфер, чтобы не делать по системному вызову на запись
+	// слои снаружи внутрь: шифрование, сжатие, записи
+	bw := bufio.NewWriter(w)
+	w = bw
+	var cw *chunkWriter
+	if o.aead != nil {
+		if err = writeEncHeader(bw, o.aead); err != nil {
+			return fmt.Errorf("unable to write encryption header into the file: %w", err)
+		}
+		cw = &chunkWriter{w: bw, aead: o.aead}
+		w = cw
+	}
+	var zw *gzip.Writer
+	if o.compress {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	if err = writeHeader(w, o.codec); err != nil {
+		return fmt.Errorf("unable to write header into the file: %w", err)
+	}
+	for _, k := range keys {
+		recs := make([]logRecord, 0, len(history[k])+1)
+		for _, v := range history[k] {
+			recs = append(recs, versionRecord(k, v, meta[k]))
+		}
+		if err = writeFrames(w, o.codec, append(recs, setRecord(k, m, exp, meta))); err != nil {
+			return err
+		}
+	}
+	for _, k := range buried {
+		if err = writeFrames(w, o.codec, tombstoneRecords(k, deleted[k])); err != nil {
+			return err
+		}
+	}
+	if zw != nil {
+		if err = zw.Close(); err != nil {
+			return fmt.Errorf("unable to compress data: %w", err)
+		}
+	}
+	if cw != nil {
+		if err = cw.Close(); err != nil {
+			return fmt.Errorf("unable to encrypt data: %w", err)
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return fmt.Errorf("unable to write data into the file: %w", err)
+	}
+	return nil
+}
+
+func writeFrames(w io.Writer, c Codec, recs []logRecord) (err error) {
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		buf.Reset()
+		if err = encodeFrame(&buf, c, recordMap(rec)); err != nil {
+			return fmt.Errorf("unable to encode data into the file: %w", err)
+		}
+		if _, err = w.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("unable to write data into the file: %w", err)
+		}
+	}
+	return nil
+}
+
+// install ставит дописанный временный файл на место основного, вызывается под блокировкой
+func (fs *FileStorage) install(tmp *os.File) (err error) {
+	// без fsync после падения по новому имени может оказаться пустой файл
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("unable to sync temp file %s: %w", tmp.Name(), err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to close temp file %s: %w", tmp.Name(), err)
+	}
+	if err = os.Rename(tmp.Name(), fs.name); err != nil {
+		return fmt.Errorf("unable to replace file %s: %w", fs.name, err)
+	}
+	syncDir(filepath.Dir(fs.name))
+
+	// старый дескриптор смотрит на уже удаленный файл, переоткрываем
+	file, err := os.OpenFile(fs.name, os.O_RDWR|os.O_APPEND, fs.opts.fileMode)
+	if err != nil {
+		return fmt.Errorf("unable to reopen file %s: %w", fs.name, err)
+	}
+	fs.f.Close()
+	fs.f = file
+	fs.size = fileSize(file)
+	if fs.watchDone != nil {
+		fs.noteDiskState()
+	}
+	return nil
+}
+
+// FileSize - размер файла с данными в байтах
+func (fs *FileStorage) FileSize() int64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.size
+}
+
+// LastFlushDuration - сколько заняла последняя запись в файл
+func (fs *FileStorage) LastFlushDuration() time.Duration {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.lastFlush
+}
+
+func fileSize(f *os.File) int64 {
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+const (
+	tmpSuffix  = ".tmp"
+	lockSuffix = ".lock"
+)
+
+// acquireLock берет эксклюзивную блокировку на файл name+".lock" рядом с данными.
+// блокируем не сам файл, потому что rewrite подменяет его новым, а блокировка живет на старом.
+// сам .lock после Close не удаляем: блокировку держит дескриптор, а не существование файла,
+// и удаление только открыло бы гонку с процессом, который как раз его открывает
+func acquireLock(name string, mode os.FileMode) (*os.File, error) {
+	lock, err := os.OpenFile(name+lockSuffix, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file %s: %w", name+lockSuffix, err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("unable to lock %s: %w", name, err)
+	}
+	return lock, nil
+}
+
+// syncDir сбрасывает на диск саму директорию, чтобы переименование пережило падение.
+// не на всех системах директорию можно открыть и синкнуть, поэтому ошибки игнорируем
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// loadFile проигрывает файл в новую MemStorage. migrate == true - файл в устаревшем
+// или не совпадающем с опциями формате, и его нужно переписать через rewrite
+// records - сколько записей в журнале, по ним считается доля мертвых записей для компакции
+func loadFile(file *os.File, filename string, o fileOptions) (ms *MemStorage, records int, migrate bool, err error) {
+	// восстанавливаем данные, проигрывая журнал с начала
+	ms = &MemStorage{m: make(map[string]string)}
+	ms.historyDepth, ms.softDelete = o.historyDepth, o.softDelete
+	br := bufio.NewReader(file)
+	encrypted, err := readEncHeader(br, o.aead)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("unable to open file %s: %w", filename, err)
+	}
+	if encrypted {
+		br = bufio.NewReader(&chunkReader{r: br, aead: o.aead})
+	}
+	compressed := isGzip(br)
+	if compressed {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("unable to decode contents of file %s: %w: %w", filename, ErrCorrupt, err)
+		}
+		br = bufio.NewReader(zr)
+	}
+	hdr, framed, err := readHeader(br)
+	noMeta := false
+	switch {
+	case err != nil:
+	case framed && hdr.codec != o.codec.Name():
+		return nil, 0, false, fmt.Errorf("file %s is encoded with %s codec, but the storage is configured with %s: start with the matching codec", filename, hdr.codec, o.codec.Name())
+	case framed:
+		records, noMeta, err = replayFrames(ms, br, o.codec, hdr)
+	default:
+		// заголовка нет - это журнал или снимок в JSON от старых версий, либо новый пустой файл
+		err = replayJSON(ms, br, nil)
+	}
+	recovered := false
+	if errors.Is(err, ErrCorrupt) && o.force {
+		// все, что успели прочитать до битой записи, уже в ms - с этим и стартуем, а файл переписываем начисто
+		log.Printf("warning: loading %s despite corruption, data after the damaged record is lost: %v", filename, err)
+		err, recovered = nil, true
+	}
+	if errors.Is(err, ErrCorrupt) {
+		if b := latestBackup(filename); b != "" {
+			return nil, 0, false, fmt.Errorf("unable to decode contents of file %s (the latest backup is %s): %w", filename, b, err)
+		}
+	}
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("unable to decode contents of file %s: %w", filename, err)
+	}
+	// переводим старый файл в нынешний формат (или сжимаем, или разжимаем), а новому пишем заголовок
+	migrate = !framed || !hdr.checksummed || noMeta || recovered || compressed != o.compress || encrypted != (o.aead != nil)
+	return ms, records, migrate, nil
+
+}
+
+// isGzip смотрит на магические байты gzip, ничего не вычитывая из r
+func isGzip(r *bufio.Reader) bool {
+	magic, err := r.Peek(2)
+	return err == nil && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// replayFrames проигрывает записи файла с заголовком и возвращает, сколько записей успел применить.
+// noMeta == true - в файле есть записи без метаданных от старых версий
+func replayFrames(ms *MemStorage, r *bufio.Reader, c Codec, hdr fileHeader) (n int, noMeta bool, err error) {
+	for ; ; n++ {
+		raw, err := decodeFrame(r, c, hdr)
+		if err == io.EOF {
+			return n, noMeta, nil
+		}
+		if err != nil {
+			return n, noMeta, fmt.Errorf("record #%d: %w", n+1, err)
+		}
+		rec, ok := asLogRecord(raw)
+		if !ok {
+			return n, noMeta, fmt.Errorf("%w: unexpected record #%d", ErrCorrupt, n+1)
+		}
+		if err = applyRecord(ms, rec); err != nil {
+			return n, noMeta, fmt.Errorf("%w: record #%d: %w", ErrCorrupt, n+1, err)
+		}
+		noMeta = noMeta || rec.Op == opSet && rec.Updated == ""
+	}
+}
+
+// errValueTooLarge - одно JSON значение в файле без заголовка больше maxFrameSize
+var errValueTooLarge = errors.New("value is too large")
+
+// valueLimitReader не дает json.Decoder вычитать в память одно значение больше limit:
+// без этого многогигабайтный файл одним объектом сначала съел бы всю память и только потом дал ошибку.
+// start двигает тот, кто читает, после каждого значения
+type valueLimitReader struct {
+	r     io.Reader
+	read  int64
+	start int64
+	limit int64
+}
+
+func (l *valueLimitReader) Read(p []byte) (n int, err error) {
+	if l.read-l.start > l.limit {
+		return 0, errValueTooLarge
+	}
+	n, err = l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+// firstByte - первый значащий байт JSON значения, по нему видно, объект ли это
+func firstByte(raw json.RawMessage) byte {
+	if raw = bytes.TrimLeft(raw, " \t\r\n"); len(raw) == 0 {
+		return 0
+	}
+	return raw[0]
+}
+
+// replayJSON читает файлы без заголовка: JSON журнал по записи на строку
+// или еще более старый формат - один JSON объект со всеми ключами. applied, если не nil, видит каждую примененную запись
+func replayJSON(ms *MemStorage, r io.Reader, applied func(logRecord)) (err error) {
+	lr := &valueLimitReader{r: r, limit: maxFrameSize}
+	dec := json.NewDecoder(lr)
+	legacy := false
+	for n := 0; ; n++ {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF { // файл может быть пустой
+			return nil
+		} else if errors.Is(err, errValueTooLarge) {
+			// это не ErrCorrupt, чтобы WithForce не переписал огромный, но, может быть, целый файл пустым
+			return fmt.Errorf("%w: record #%d is larger than %d bytes", ErrTooLarge, n+1, maxFrameSize)
+		} else if err != nil {
+			// у этого формата нет сумм, так что битый JSON - единственный признак, что файл обрезан
+			return fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+		lr.start = dec.InputOffset()
+
+		// null тоже раскодировался бы в map без ошибки, только в nil
+		if firstByte(value) != '{' {
+			if n == 0 {
+				return fmt.Errorf("%w: top-level JSON value is not an object", ErrUnsupportedFormat)
+			}
+			return fmt.Errorf("%w: unexpected record #%d", ErrCorrupt, n+1)
+		}
+		var raw map[string]string
+		if err := json.Unmarshal(value, &raw); err != nil {
+			if n == 0 {
+				return fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+			}
+			return fmt.Errorf("%w: record #%d: %w", ErrCorrupt, n+1, err)
+		}
+		// Unmarshal молча оставил бы последний из повторов, а какой из них верный - не угадать
+		if dup, ok := duplicateKey(value); ok {
+			return fmt.Errorf("%w: record #%d has duplicate key %q", ErrCorrupt, n+1, dup)
+		}
+
+		rec, ok := asLogRecord(raw)
+		switch {
+		case !ok && n == 0:
+			ms.m, legacy = raw, true
+			ms.recount()
+			for k := range ms.m { // времени записи старый формат не хранит, считаем ключи созданными сейчас
+				ms.touch(k, false)
+			}
+		case !ok || legacy:
+			return fmt.Errorf("%w: unexpected record #%d", ErrCorrupt, n+1)
+		default:
+			if err = applyRecord(ms, rec); err != nil {
+				return fmt.Errorf("%w: record #%d: %w", ErrCorrupt, n+1, err)
+			}
+			if applied != nil {
+				applied(rec)
+			}
+		}
+	}
+}
+
+// duplicateKey ищет повтор ключа на верхнем уровне объекта, который уже раскодировался в map[string]string
+func duplicateKey(obj json.RawMessage) (key string, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	dec.Token() // {
+	seen := make(map[string]struct{})
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return "", false
+		}
+		k, _ := t.(string)
+		if _, ok := seen[k]; ok {
+			return k, true
+		}
+		seen[k] = struct{}{}
+		var skip json.RawMessage
+		if err = dec.Decode(&skip); err != nil {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func applyRecord(ms *MemStorage, rec logRecord) (err error) {
+	switch {
+	case rec.Op == opSet && rec.Expires != "":
+		deadline, err := time.Parse(time.RFC3339Nano, rec.Expires)
+		if err != nil {
+			return err
+		}
+		ms.setWithDeadline(rec.Key, rec.Value, deadline)
+		return ms.applyMeta(rec)
+	case rec.Op == opSet:
+		ms.set(rec.Key, rec.Value)
+		return ms.applyMeta(rec)
+	case rec.Op == opDelete:
+		if _, ok := ms.lookup(rec.Key); ok {
+			deleted := ms.clock()() // журналы старых версий время удаления не пишут
+			if rec.Deleted != "" {
+				if deleted, err = time.Parse(time.RFC3339Nano, rec.Deleted); err != nil {
+					return err
+				}
+			}
+			ms.bury(rec.Key, deleted)
+		}
+		ms.drop(rec.Key)
+	}
+	return nil
+}
+
+// recordMap - запись журнала в виде, который понимает Codec
+func recordMap(rec logRecord) map[string]string {
+	raw := map[string]string{"op": rec.Op, "key": rec.Key}
+	if rec.Op == opSet {
+		raw["value"] = rec.Value
+		// json заменил бы байты не из UTF-8 на U+FFFD, так что двоичные значения пишем в base64
+		if !utf8.ValidString(rec.Value) {
+			raw["value"], raw["encoding"] = base64.StdEncoding.EncodeToString([]byte(rec.Value)), "base64"
+		}
+	}
+	for k, v := range map[string]string{"expires": rec.Expires, "created": rec.Created, "updated": rec.Updated, "writes": rec.Writes, "type": rec.Type, "deleted": rec.Deleted} {
+		if v != "" {
+			raw[k] = v
+		}
+	}
+	return raw
+}
+
+// asLogRecord проверяет, похож ли прочитанный объект на запись журнала.
+// старый формат - это один объект со всеми ключами, его записи журнала не напоминают
+func asLogRecord(raw map[string]string) (rec logRecord, ok bool) {
+	for k := range raw {
+		switch k {
+		case "op", "key", "value", "encoding", "expires", "created", "updated", "writes", "type", "deleted":
+		default:
+			return rec, false
+		}
+	}
+	rec = logRecord{Op: raw["op"], Key: raw["key"], Value: raw["value"], Expires: raw["expires"], Created: raw["created"], Updated: raw["updated"], Writes: raw["writes"], Type: raw["type"], Deleted: raw["deleted"]}
+	switch raw["encoding"] {
+	case "":
+	case "base64":
+		b, err := base64.StdEncoding.DecodeString(rec.Value)
+		if err != nil {
+			return rec, false
+		}
+		rec.Value = string(b)
+	default:
+		return rec, false
+	}
+	_, hasKey := raw["key"]
+	return rec, hasKey && (rec.Op == opSet || rec.Op == opDelete)
+}
+
+// FileOption настраивает NewFileStorage
+type FileOption func(*fileOptions)
+
+type fileOptions struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	codec    Codec
+	force    bool
+	backups  int // сколько копий держать, 0 - не снимать их перед rewrite
+	compress bool
+	key      []byte
+	aead     cipher.AEAD // собирается из key в NewFileStorage
+
+	compactSize  int64   // размер журнала, после которого запускается компакция, 0 - без порога
+	compactRatio float64 // доля мертвых записей, после которой запускается компакция, 0 - без порога
+
+	flushInterval time.Duration // write-behind: как часто сбрасывать записи в файл, 0 - не по времени
+	flushEvery    int           // write-behind: сколько записей копить до сброса, 0 - не по количеству
+	wal           bool          // WithWAL
+	watchDebounce time.Duration // WithFileWatch, 0 - не следить за файлом
+	onConflict    ConflictPolicy
+
+	readOnly bool
+
+	historyDepth int           // WithHistory
+	softDelete   time.Duration // WithSoftDelete
+}
+
+// по умолчанию файл доступен только владельцу - в значениях бывают токены и пароли
+func defaultFileOptions() fileOptions {
+	return fileOptions{fileMode: 0600, dirMode: 0700, codec: JSONCodec}
+}
+
+// WithFileMode задает права файла с данными. уже существующий файл тоже приводится к ним
+func WithFileMode(mode os.FileMode) FileOption {
+	return func(o *fileOptions) { o.fileMode = mode.Perm() }
+}
+
+// WithCodec задает формат записей в файле. файл, записанный другим кодеком, NewFileStorage не откроет
+func WithCodec(c Codec) FileOption {
+	return func(o *fileOptions) { o.codec = c }
+}
+
+// WithForce открывает поврежденный файл, а не возвращает ErrCorrupt: загружается все до первой битой записи.
+// нужен для ручного восстановления, после открытия файл перезаписывается уже без битого хвоста
+func WithForce(force bool) FileOption {
+	return func(o *fileOptions) { o.force = force }
+}
+
+// WithBackups снимает копию файла перед каждой полной перезаписью и держит keep самых свежих копий
+func WithBackups(keep int) FileOption {
+	return func(o *fileOptions) { o.backups = keep }
+}
+
+// WithCompression сжимает файл gzip'ом. несжатый файл при открытии с этой опцией сжимается,
+// и наоборот - сжатый файл без нее разжимается, так что опцию можно включать и выключать когда угодно
+func WithCompression(enabled bool) FileOption {
+	return func(o *fileOptions) { o.compress = enabled }
+}
+
+// WithEncryption шифрует файл AES-GCM ключом key длиной 16, 24 или 32 байта.
+// незашифрованный файл при открытии с ключом шифруется, а файл с другим ключом не откроется с ErrWrongKey
+func WithEncryption(key []byte) FileOption {
+	return func(o *fileOptions) { o.key = key }
+}
+
+// WithCompaction включает фоновую компакцию: журнал переписывается, когда он дорос до size байт
+// или когда доля перезаписанных и удаленных записей в нем достигла deadRatio. нулевой порог не проверяется
+func WithCompaction(size int64, deadRatio float64) FileOption {
+	return func(o *fileOptions) { o.compactSize, o.compactRatio = size, deadRatio }
+}
+
+// WithDirMode задает права директорий, которые приходится создавать под файл
+func WithDirMode(mode os.FileMode) FileOption {
+	return func(o *fileOptions) { o.dirMode = mode.Perm() }
+}
+
+// WithReadOnly открывает файл только на чтение: он не создается, не блокируется, не мигрирует и не компактится,
+// а все записи возвращают ErrReadOnly. блокировки нет, чтобы файл мог читать второй экземпляр рядом с пишущим
+func WithReadOnly(readOnly bool) FileOption {
+	return func(o *fileOptions) { o.readOnly = readOnly }
+}
+
+func NewFileStorage(filename string, opts ...FileOption) (_ Storage, err error) { // и здесь мы тоже возвраащем интерфейс
+	o := defaultFileOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.key != nil {
+		if o.aead, err = newAEAD(o.key); err != nil {
+			return nil, err
+		}
+	}
+	if o.wal && !o.writeBehind() {
+		o.flushInterval = walFlushInterval
+	}
+
+	if o.readOnly {
+		return openReadOnly(filename, o)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), o.dirMode); err != nil {
+		return nil, fmt.Errorf("unable to create directory for %s: %w", filename, err)
+	}
+
+	// второй процесс на том же файле затер бы данные первого, поэтому сразу падаем
+	lock, err := acquireLock(filename, o.fileMode)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			lock.Close()
+		}
+	}()
+
+	// временный файл остается, если процесс упал посреди rewrite или компакции до rename.
+	// основной файл при этом не тронут, так что недописанную копию просто выкидываем
+	for _, tmpName := range []string{filename + tmpSuffix, filename + compactSuffix} {
+		if err := os.Remove(tmpName); err == nil {
+			log.Printf("removed leftover temp file %s", tmpName)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unable to remove leftover temp file %s: %w", tmpName, err)
+		}
+	}
+
+	// мы открываем (или создаем файл если он не существует (os.O_CREATE)), в режиме чтения и записи (os.O_RDWR) и дописываем в конец (os.O_APPEND)
+	// права нового файла берем из опций (по умолчанию 0600), umask может их только урезать
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, o.fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file %s: %w", filename, err)
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+		}
+	}()
+	// у существующего файла права остаются старыми, поэтому приводим их к нужным
+	if info, err := file.Stat(); err == nil && info.Mode().Perm()&^o.fileMode != 0 {
+		if err := file.Chmod(o.fileMode); err != nil {
+			log.Printf("warning: file %s has mode %v, unable to change it to %v: %v", filename, info.Mode().Perm(), o.fileMode, err)
+		}
+	}
+
+	ms, records, migrate, err := loadFile(file, filename, o)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStorage{
+		MemStorage: ms,
+		f:          file,
+		lock:       lock,
+		name:       filename,
+		opts:       o,
+		size:       fileSize(file),
+		records:    records,
+	}
+	if o.wal {
+		// WAL проигрывается поверх файла, и восстановленные записи сразу уходят в файл, чтобы начать с пустым WAL
+		if fs.wal, fs.pending, err = openWAL(filename, ms, o); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				fs.wal.Close()
+			}
+		}()
+		fs.walSize = fileSize(fs.wal)
+	}
+	if migrate {
+		if err := fs.rewrite(); err != nil {
+			return nil, fmt.Errorf("unable to migrate file %s: %w", filename, err)
+		}
+	}
+	if err = fs.flush(); err == nil {
+		err = fs.truncateWAL() // в WAL мог остаться только недописанный хвост без единой целой записи
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to persist writes recovered from %s: %w", filename+walSuffix, err)
+	}
+	if o.compactSize > 0 || o.compactRatio > 0 {
+		fs.compactDone = make(chan struct{})
+		go fs.compactLoop(fs.compactDone)
+	}
+	if o.watchDebounce > 0 {
+		if err = fs.watchFile(); err != nil {
+			return nil, err
+		}
+	}
+	if o.writeBehind() {
+		fs.flushKick = make(chan struct{}, 1)
+		fs.flushDone = make(chan struct{})
+		go fs.flushLoop(fs.flushDone)
+	}
+	return fs, nil
+}
@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStorageAtomicRewrite(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		data string // содержимое основного файла до открытия
+		tmp  string // недописанный временный файл от упавшего rewrite, "" - его нет
+		want map[string]string
+	}{
+		{
+			name: "legacy file is converted through a temp file",
+			data: `{"a":"1","b":"2"}`,
+			want: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name: "leftover temp file is discarded",
+			data: `{"op":"set","key":"a","value":"1"}` + "\n",
+			tmp:  `{"op":"set","key":"a","val`,
+			want: map[string]string{"a": "1"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tmp != "" {
+				if err := os.WriteFile(path+tmpSuffix, []byte(tt.tmp), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s, err := NewFileStorage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(path + tmpSuffix); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("temp file is still there: %v", err)
+			}
+			// после rewrite запись идет в новый файл, а не в удаленный старый
+			if err = s.Set(ctx, "c", "3"); err != nil {
+				t.Fatal(err)
+			}
+			tt.want["c"] = "3"
+			s.(*FileStorage).Close()
+
+			if s, err = NewFileStorage(path); err != nil {
+				t.Fatal(err)
+			}
+			defer s.(*FileStorage).Close()
+			for k, v := range tt.want {
+				if got, err := s.Get(ctx, k); err != nil || got != v {
+					t.Errorf("Get(%s) after reopening = %q, %v, want %q", k, got, err, v)
+				}
+			}
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(raw), `{"a":"1","b":"2"}`) {
+				t.Errorf("file is still in the legacy format: %s", raw)
+			}
+		})
+	}
+}
+
+func TestFileStorageClose(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := s.(*FileStorage)
+	if err = fs.Set(ctx, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	for op, err := range map[string]error{"Set": fs.Set(ctx, "b", "2"), "Delete": fs.Delete(ctx, "a")} {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("%s after Close: got %v, want ErrClosed", op, err)
+		}
+	}
+
+	if s, err = NewFileStorage(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*FileStorage).Close()
+	if v, err := s.Get(ctx, "a"); err != nil || v != "1" {
+		t.Errorf("Get(a) after reopening = %q, %v", v, err)
+	}
+}
+
+// удаление из файлового хранилища переживает переоткрытие файла
+func TestFileStorageDelete(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete: got %v, want ErrNotFound", err)
+	}
+	s.(*FileStorage).Close()
+
+	s, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*FileStorage).Close()
+	if _, err = s.Get(ctx, "a"); err == nil {
+		t.Error("deleted key is back after reopening")
+	}
+	if v, err := s.Get(ctx, "b"); err != nil || v != "2" {
+		t.Errorf("Get(b) after reopening = %q, %v", v, err)
+	}
+}
+
+func TestFileStorageModes(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		existing os.FileMode // права уже лежащего файла, 0 - файла нет
+		opts     []FileOption
+		wantFile os.FileMode
+		wantDir  os.FileMode
+	}{
+		{name: "defaults", wantFile: 0600, wantDir: 0700},
+		{name: "custom", opts: []FileOption{WithFileMode(0640), WithDirMode(0750)}, wantFile: 0640, wantDir: 0750},
+		{name: "world writable file is tightened", existing: 0666, wantFile: 0600},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "nested")
+			path := filepath.Join(dir, "data.json")
+			if tt.existing != 0 {
+				if err := os.MkdirAll(dir, 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s, err := NewFileStorage(path, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.(*FileStorage).Close()
+
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != tt.wantFile {
+				t.Errorf("file mode %v, %v, want %v", info.Mode().Perm(), err, tt.wantFile)
+			}
+			if tt.wantDir != 0 {
+				if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != tt.wantDir {
+					t.Errorf("dir mode %v, %v, want %v", info.Mode().Perm(), err, tt.wantDir)
+				}
+			}
+		})
+	}
+}
+
+// второй NewFileStorage на тот же файл падает сразу, а после Close файл снова можно открыть
+func TestFileStorageLock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileStorage(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second open: got %v, want ErrLocked", err)
+	}
+	if err = s.Set(ctx, "a", "1"); err != nil {
+		t.Errorf("Set after a failed second open: %v", err)
+	}
+	if err = s.(*FileStorage).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("open after Close: %v", err)
+	}
+	defer s.(*FileStorage).Close()
+	if v, err := s.Get(ctx, "a"); err != nil || v != "1" {
+		t.Errorf("Get(a) = %q, %v", v, err)
+	}
+}
+
+// обрезанный или испорченный хвост - это ErrCorrupt, а -force поднимает все до битой записи и чинит файл
+func TestFileStorageCorruptTail(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		codec  string
+		damage string
+	}{
+		{"json", "truncate"},
+		{"gob", "truncate"},
+		{"gob", "flip"},
+		{"msgpack", "truncate"},
+		{"msgpack", "flip"},
+	} {
+		t.Run(tt.codec+"/"+tt.damage, func(t *testing.T) {
+			c, err := CodecByName(tt.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "data")
+			s, err := NewFileStorage(path, WithCodec(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{"a", "b", "last"} {
+				if err = s.Set(ctx, k, "value of "+k); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.(*FileStorage).Close()
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.damage == "truncate" {
+				raw = raw[:len(raw)-3]
+			} else {
+				raw[len(raw)-6] ^= 0xff // байт внутри последней записи, перед crc
+			}
+			if err = os.WriteFile(path, raw, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = NewFileStorage(path, WithCodec(c)); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("open of a damaged file: got %v, want ErrCorrupt", err)
+			}
+			if s, err = NewFileStorage(path, WithCodec(c), WithForce(true)); err != nil {
+				t.Fatalf("forced open: %v", err)
+			}
+			for _, k := range []string{"a", "b"} {
+				if v, err := s.Get(ctx, k); err != nil || v != "value of "+k {
+					t.Errorf("Get(%s) after the forced open = %q, %v", k, v, err)
+				}
+			}
+			if _, err = s.Get(ctx, "last"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(last) from the damaged record: got %v, want ErrNotFound", err)
+			}
+			s.(*FileStorage).Close()
+
+			// после принудительного открытия файл переписан, и обычное открытие снова работает
+			if s, err = NewFileStorage(path, WithCodec(c)); err != nil {
+				t.Fatalf("open after the forced recovery: %v", err)
+			}
+			s.(*FileStorage).Close()
+		})
+	}
+}
+
+// байты не из UTF-8 переживают и дозапись в лог, и переоткрытие, в любом кодеке
+func TestFileStorageBinaryValues(t *testing.T) {
+	ctx := context.Background()
+	values := map[string]string{
+		"binary": "\x89PNG\r\n\x1a\n\x00\xff\xfe",
+		"text":   "just text",
+		"base64": "AAEC", // похоже на base64, но без encoding остается как есть
+	}
+	for _, name := range []string{"json", "gob", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			c, err := CodecByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "data")
+			s, err := NewFileStorage(path, WithCodec(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range values {
+				if err = s.Set(ctx, k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.(*FileStorage).Close()
+
+			if s, err = NewFileStorage(path, WithCodec(c)); err != nil {
+				t.Fatal(err)
+			}
+			defer s.(*FileStorage).Close()
+			for k, want := range values {
+				if v, err := s.Get(ctx, k); err != nil || v != want {
+					t.Errorf("Get(%s) after reopen = %q, %v, want %q", k, v, err, want)
+				}
+			}
+		})
+	}
+}
+
+// если запись в журнал упала, в памяти должно остаться то же, что и на диске
+func TestFileStorageFailedAppend(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewFileStorage(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := s.(*FileStorage)
+	defer fs.Close()
+	for k, v := range map[string]string{"a": "old", "n": "1", "gone": "x"} {
+		if err = fs.Set(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// файл только для чтения: Write на нем падает, а сама хранилка ничего не заметит
+	ro, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := fs.f
+	fs.f = ro
+	defer func() { fs.f = rw; ro.Close() }()
+
+	for op, call := range map[string]func() error{
+		"Set":        func() error { return fs.Set(ctx, "a", "new") },
+		"SetMany":    func() error { return fs.SetMany(ctx, map[string]string{"a": "new", "b": "new"}) },
+		"SetWithTTL": func() error { return fs.SetWithTTL(ctx, "a", "new", time.Hour) },
+		"CompareAndSwap": func() error {
+			_, err := fs.CompareAndSwap(ctx, "a", "old", "new")
+			return err
+		},
+		"SetIfAbsent": func() error { _, err := fs.SetIfAbsent(ctx, "b", "new"); return err },
+		"GetOrSet":    func() error { _, _, err := fs.GetOrSet(ctx, "b", "new"); return err },
+		"Increment":   func() error { _, err := fs.Increment(ctx, "n", 1); return err },
+		"Append":      func() error { _, err := fs.Append(ctx, "a", "new"); return err },
+		"Delete":      func() error { return fs.Delete(ctx, "gone") },
+		"DeletePrefix": func() error {
+			_, err := fs.DeletePrefix(ctx, "go")
+			return err
+		},
+	} {
+		if err := call(); err == nil {
+			t.Errorf("%s: no error while the file is not writable", op)
+		}
+	}
+
+	for k, want := range map[string]string{"a": "old", "n": "1", "gone": "x"} {
+		if v, err := fs.Get(ctx, k); err != nil || v != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", k, v, err, want)
+		}
+	}
+	if _, err := fs.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(b) = %v, want ErrNotFound", err)
+	}
+	if e, err := fs.GetEntry(ctx, "a"); err != nil || !e.ExpiresAt.IsZero() || e.Writes != 1 {
+		t.Errorf("entry of a = %+v, %v, want one write without ttl", e, err)
+	}
+}
+
+// Increment и Append сохраняют ttl ключа и после перезапуска
+
+func TestFileStorageKeepsTTL(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewFileStorage(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := s.(*FileStorage)
+	if err = fs.SetWithTTL(ctx, "n", "1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.SetWithTTL(ctx, "s", "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.Increment(ctx, "n", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.Append(ctx, "s", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewFileStorage(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs = s.(*FileStorage)
+	defer fs.Close()
+	for k, want := range map[string]string{"n": "2", "s": "ab"} {
+		e, err := fs.GetEntry(ctx, k)
+		if err != nil || e.Value != want || e.ExpiresAt.IsZero() || e.Writes != 2 {
+			t.Errorf("entry of %s = %+v, %v, want %q with ttl after two writes", k, e, err, want)
+		}
+	}
+}
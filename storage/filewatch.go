@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConflictPolicy - что делать, если файл поменяли снаружи, пока у нас есть записи, еще не дошедшие до файла
+type ConflictPolicy string
+
+const (
+	// ConflictOursWins переписывает файл тем, что в памяти: чужие изменения теряются
+	ConflictOursWins ConflictPolicy = "ours-wins"
+	// ConflictTheirsWins берет файл как есть: теряются наши еще не записанные изменения
+	ConflictTheirsWins ConflictPolicy = "theirs-wins"
+	// ConflictReadOnly не трогает ни файл, ни память и отвергает записи, пока конфликт не разрешат через Reload
+	ConflictReadOnly ConflictPolicy = "read-only"
+)
+
+// ConflictPolicyByName проверяет имя политики из конфига
+func ConflictPolicyByName(name string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(name); p {
+	case ConflictOursWins, ConflictTheirsWins, ConflictReadOnly:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q: want %s, %s or %s", name, ConflictOursWins, ConflictTheirsWins, ConflictReadOnly)
+}
+
+// WithFileWatch следит за файлом через fsnotify и перечитывает его, когда его поменял кто-то другой.
+// события копятся debounce, так что серия быстрых записей дает одно перечитывание. onConflict решает,
+// что делать с нашими отложенными записями write-behind, если они есть, незнакомая политика - как ConflictReadOnly.
+// без write-behind запись, которая успела проскочить между чужой правкой и перечитыванием, достается старому файлу.
+// debounce 0 - не следить
+func WithFileWatch(debounce time.Duration, onConflict ConflictPolicy) FileOption {
+	if _, err := ConflictPolicyByName(string(onConflict)); err != nil {
+		onConflict = ConflictReadOnly
+	}
+	return func(o *fileOptions) { o.watchDebounce, o.onConflict = debounce, onConflict }
+}
+
+// watchFile запускает слежку за файлом. следим за директорией, а не за самим файлом: инструменты часто
+// пишут новый файл рядом и переименовывают его поверх, и watch на старом inode такой замены бы не увидел
+func (fs *FileStorage) watchFile() (err error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch file %s: %w", fs.name, err)
+	}
+	if err = w.Add(filepath.Dir(fs.name)); err != nil {
+		w.Close()
+		return fmt.Errorf("unable to watch file %s: %w", fs.name, err)
+	}
+	fs.noteDiskState()
+	fs.watchDone = make(chan struct{})
+	go fs.watchLoop(w, fs.watchDone)
+	return nil
+}
+
+func (fs *FileStorage) watchLoop(w *fsnotify.Watcher, done chan struct{}) {
+	defer w.Close()
+	name := filepath.Clean(fs.name)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case e := <-w.Events:
+			if filepath.Clean(e.Name) == name && !e.Has(fsnotify.Chmod) {
+				timer.Reset(fs.opts.watchDebounce)
+			}
+		case err := <-w.Errors:
+			log.Printf("watch of %s failed: %v", fs.name, err)
+		case <-timer.C:
+			fs.externalChange()
+		}
+	}
+}
+
+// noteDiskState запоминает, каким мы оставили файл после своей записи, чтобы отличить ее от чужой.
+// вызывается под блокировкой
+func (fs *FileStorage) noteDiskState() {
+	if info, err := fs.f.Stat(); err == nil {
+		fs.diskState = info
+	}
+}
+
+// changedOnDisk - файл под именем fs.name уже не тот, что мы оставили: другой inode, размер или время изменения
+func (fs *FileStorage) changedOnDisk() bool {
+	info, err := os.Stat(fs.name)
+	if err != nil || fs.diskState == nil {
+		return true
+	}
+	return !os.SameFile(info, fs.diskState) || info.Size() != fs.diskState.Size() || !info.ModTime().Equal(fs.diskState.ModTime())
+}
+
+// externalChange перечитывает файл, если его поменяли снаружи. свои записи тоже будят watch, их отсеивает changedOnDisk
+func (fs *FileStorage) externalChange() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed || fs.conflict || !fs.changedOnDisk() {
+		return
+	}
+	if len(fs.pending) > 0 {
+		log.Printf("WARNING: file %s was changed by another process while %d writes were not yet written to it, resolving with %s",
+			fs.name, len(fs.pending), fs.opts.onConflict)
+		switch fs.opts.onConflict {
+		case ConflictOursWins:
+			if err := fs.rewrite(); err != nil {
+				log.Printf("unable to overwrite externally changed file %s: %v", fs.name, err)
+			}
+			return
+		case ConflictReadOnly:
+			fs.conflict = true
+			log.Printf("WARNING: storage %s is read-only until it is reloaded, a reload takes the file and drops the pending writes", fs.name)
+			return
+		case ConflictTheirsWins:
+			fs.discardPending()
+		}
+	}
+	if err := fs.reload(); err != nil {
+		log.Printf("unable to reload externally changed file %s: %v", fs.name, err)
+		return
+	}
+	log.Printf("reloaded %s after an external change", fs.name)
+}
+
+// discardPending выкидывает отложенные записи вместе с их копией в WAL, вызывается под блокировкой
+func (fs *FileStorage) discardPending() {
+	fs.pending = nil
+	if err := fs.truncateWAL(); err != nil {
+		log.Printf("unable to truncate write-ahead log of %s: %v", fs.name, err)
+	}
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileReport - что CheckFile нашел в файле FileStorage
+type FileReport struct {
+	Format      string // "EXFS2 json", "EXFS1 gob", "json" для файлов без заголовка от старых версий
+	Encrypted   bool
+	Compressed  bool
+	Checksummed bool // у записей есть crc32: только у EXFS2
+	Records     int  // сколько записей журнала применено, кроме WAL
+	Keys        int  // живых ключей после проигрывания, без невалидных
+	Overwritten int  // записей, которые перекрыла более поздняя запись того же ключа
+	WALRecords  int  // записей в WAL рядом с файлом, они проигрываются поверх него
+
+	// записи, которые читаются, но не годятся: ключ не проходит ValidateKey, незнакомая запись, кривые метаданные.
+	// при проигрывании они пропускаются, а -repair их выкидывает
+	Invalid []string
+	// Damage - почему чтение остановилось раньше конца файла: обрыв, несовпавшая сумма, мусор.
+	// все после этого места потеряно. пусто - файл дочитан до конца
+	Damage string
+}
+
+// Healthy - файл дочитан до конца и в нем нет невалидных записей
+func (r FileReport) Healthy() bool {
+	return r.Damage == "" && len(r.Invalid) == 0
+}
+
+// CheckFile проверяет файл FileStorage, не открывая его как хранилку: ни блокировки, ни миграции, ни записи.
+// повреждения файла - это отчет, а ошибка - только если файл не прочитать совсем: его нет, нет ключа шифрования,
+// это вообще не файл examplefs. из опций нужны только WithEncryption, WithHistory и WithSoftDelete,
+// кодек берется из заголовка файла
+func CheckFile(filename string, opts ...FileOption) (report FileReport, err error) {
+	o, err := checkOptions(opts)
+	if err != nil {
+		return report, err
+	}
+	report, _, _, err = scanFile(filename, o)
+	return report, err
+}
+
+// RepairFile переписывает файл без битого хвоста и невалидных записей, в том же кодеке, сжатии и шифровании,
+// а оригинал вместе с его WAL откладывает рядом как <file>.corrupt-<время>. aside == "" - файл целый
+// и его не трогали. блокировку файла берет так же, как NewFileStorage, так что на файле живого сервера не сработает
+func RepairFile(filename string, opts ...FileOption) (report FileReport, aside string, err error) {
+	o, err := checkOptions(opts)
+	if err != nil {
+		return report, "", err
+	}
+	lock, err := acquireLock(filename, o.fileMode)
+	if err != nil {
+		return report, "", err
+	}
+	defer lock.Close()
+
+	report, ms, out, err := scanFile(filename, o)
+	if err != nil || report.Healthy() {
+		return report, "", err
+	}
+
+	tmpName := filename + tmpSuffix
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, o.fileMode)
+	if err != nil {
+		return report, "", fmt.Errorf("unable to create temp file %s: %w", tmpName, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+	if err = writeSnapshot(tmp, out, ms.m, ms.exp, ms.meta, ms.history, ms.deleted); err != nil {
+		return report, "", err
+	}
+	if err = tmp.Sync(); err != nil {
+		return report, "", fmt.Errorf("unable to sync temp file %s: %w", tmpName, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return report, "", fmt.Errorf("unable to close temp file %s: %w", tmpName, err)
+	}
+
+	stamp := time.Now().UTC().Format(backupTimeFormat)
+	if runtime.GOOS == "windows" {
+		stamp = strings.ReplaceAll(stamp, ":", "-")
+	}
+	aside = filename + ".corrupt-" + stamp
+	if err = os.Rename(filename, aside); err != nil {
+		return report, "", fmt.Errorf("unable to move %s aside: %w", filename, err)
+	}
+	// WAL уже проигран в новый файл, а оставленный на месте проигрался бы поверх него еще раз
+	if err := os.Rename(filename+walSuffix, aside+walSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("warning: unable to move write-ahead log %s aside: %v", filename+walSuffix, err)
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		return report, "", fmt.Errorf("unable to replace file %s (the original is %s): %w", filename, aside, err)
+	}
+	syncDir(filepath.Dir(filename))
+	return report, aside, nil
+}
+
+func checkOptions(opts []FileOption) (o fileOptions, err error) {
+	o = defaultFileOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.key != nil {
+		if o.aead, err = newAEAD(o.key); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// scanFile - общая часть CheckFile и RepairFile. в отличие от loadFile не останавливается на первой плохой записи,
+// если границы записей целы, а собирает все. out - опции, с которыми файл записан, для RepairFile
+func scanFile(filename string, o fileOptions) (report FileReport, ms *MemStorage, out fileOptions, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return report, nil, out, fmt.Errorf("unable to open file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	ms = &MemStorage{m: make(map[string]string)}
+	ms.historyDepth, ms.softDelete = o.historyDepth, o.softDelete
+	br := bufio.NewReader(file)
+	if report.Encrypted, err = readEncHeader(br, o.aead); err != nil {
+		return report, nil, out, fmt.Errorf("unable to open file %s: %w", filename, err)
+	}
+	if report.Encrypted {
+		br = bufio.NewReader(&chunkReader{r: br, aead: o.aead})
+	}
+	if report.Compressed = isGzip(br); report.Compressed {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			report.Damage = err.Error()
+			return report, ms, out, nil
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	out = o
+	out.compress = report.Compressed
+	if !report.Encrypted {
+		out.aead = nil
+	}
+	hdr, framed, err := readHeader(br)
+	switch {
+	case err != nil:
+		report.Damage = err.Error()
+	case framed:
+		report.Format, report.Checksummed = strings.TrimSpace(fileMagicV1), hdr.checksummed
+		if hdr.checksummed {
+			report.Format = strings.TrimSpace(fileMagic)
+		}
+		report.Format += " " + hdr.codec
+		if out.codec, err = CodecByName(hdr.codec); err != nil {
+			return report, nil, out, fmt.Errorf("file %s: %w", filename, err)
+		}
+		scanFrames(ms, br, out.codec, hdr, &report)
+	default:
+		report.Format, out.codec = "json", JSONCodec
+		// у старого JSON нет границ записей, кроме самого JSON, так что дальше первой ошибки не прочитать
+		seen := make(map[string]bool)
+		if err = replayJSON(ms, br, func(rec logRecord) { report.count(seen, rec.Key) }); errors.Is(err, ErrCorrupt) {
+			report.Damage = err.Error()
+		} else if err != nil {
+			return report, nil, out, fmt.Errorf("unable to decode contents of file %s: %w", filename, err)
+		}
+	}
+
+	if err = scanWAL(filename, ms, out, &report); err != nil {
+		return report, nil, out, err
+	}
+	// ключи из старых версий, которые ValidateKey уже не пропустит: через API их не прочитать и не удалить
+	for _, k := range sortedKeys(ms.m) {
+		if err := ValidateKey(k); err != nil {
+			report.Invalid = append(report.Invalid, err.Error())
+			ms.drop(k)
+		}
+	}
+	for k := range ms.m {
+		if _, ok := ms.lookup(k); ok {
+			report.Keys++
+		}
+	}
+	return report, ms, out, nil
+}
+
+// scanFrames - replayFrames для проверки: пропускает записи, которые не применить, и идет дальше,
+// а останавливается, только когда потеряны границы записей
+func scanFrames(ms *MemStorage, r *bufio.Reader, c Codec, hdr fileHeader, report *FileReport) {
+	seen := make(map[string]bool)
+	for n := 1; ; n++ {
+		raw, err := decodeFrame(r, c, hdr)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			report.Damage = fmt.Sprintf("record #%d: %v", n, err)
+			return
+		}
+		rec, ok := asLogRecord(raw)
+		if !ok {
+			report.Invalid = append(report.Invalid, fmt.Sprintf("record #%d: unexpected record", n))
+			continue
+		}
+		if err = applyRecord(ms, rec); err != nil {
+			report.Invalid = append(report.Invalid, fmt.Sprintf("record #%d: key %q: %v", n, rec.Key, err))
+			continue
+		}
+		report.count(seen, rec.Key)
+	}
+}
+
+// count учитывает целую запись ключа key, seen - ключи, которые уже встречались
+func (r *FileReport) count(seen map[string]bool, key string) {
+	r.Records++
+	if seen[key] {
+		r.Overwritten++
+	}
+	seen[key] = true
+}
+
+// scanWAL проигрывает WAL рядом с файлом, если он есть. недописанный хвост WAL - не повреждение:
+// такие записи клиенту не подтверждались
+func scanWAL(filename string, ms *MemStorage, o fileOptions, report *FileReport) (err error) {
+	wal, err := os.Open(filename + walSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open write-ahead log %s: %w", filename+walSuffix, err)
+	}
+	defer wal.Close()
+	recs, _, err := replayWAL(ms, wal, o)
+	if errors.Is(err, ErrCorrupt) {
+		report.Damage = err.Error()
+		return nil
+	}
+	report.WALRecords = len(recs)
+	return err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
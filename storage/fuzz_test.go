@@ -0,0 +1,93 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// файлы, которые загрузка обязана отвергнуть ошибкой
+var malformedFiles = map[string]string{
+	"truncated object":   `{"a": "1", "b": "2`,
+	"truncated log":      `{"op":"set","key":"a","value":"1"}` + "\n" + `{"op":"set","key":"b","val`,
+	"duplicate keys":     `{"a": "1", "a": "2"}`,
+	"deep nesting":       strings.Repeat("[", 10001) + strings.Repeat("]", 10001), // глубже, чем пускает encoding/json
+	"deep nested object": `{"a": ` + strings.Repeat(`{"a": `, 1000) + `"1"` + strings.Repeat("}", 1001),
+	"array":              `["a", "b"]`,
+	"number":             `42`,
+	"string":             `"a"`,
+	"null":               `null`,
+	"non-string value":   `{"a": 1}`,
+}
+
+func loadFile(t *testing.T, data []byte) (storage.Storage, string, error) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := storage.NewFileStorage(name)
+	return s, name, err
+}
+
+func closeStorage(s storage.Storage) error {
+	if c, ok := storage.As[io.Closer](s); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func TestFileStorageLoadMalformed(t *testing.T) {
+	for name, data := range malformedFiles {
+		s, _, err := loadFile(t, []byte(data))
+		if err == nil {
+			closeStorage(s)
+			t.Errorf("%s: loaded without an error", name)
+		}
+	}
+}
+
+// что бы ни лежало в файле, NewFileStorage не паникует. если файл загрузился, то после Close
+// он так же загружается еще раз и с теми же данными
+func FuzzFileStorageLoad(f *testing.F) {
+	for _, data := range malformedFiles {
+		f.Add([]byte(data))
+	}
+	f.Add([]byte(`{"a": "1", "b": "2"}`))
+	f.Add([]byte(`{"op":"set","key":"a","value":"1"}` + "\n" + `{"op":"delete","key":"a"}` + "\n"))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ctx := context.Background()
+		s, name, err := loadFile(t, data)
+		if err != nil {
+			return
+		}
+		before, err := storage.Dump(ctx, s)
+		if err != nil {
+			t.Fatalf("Dump: %v", err)
+		}
+		if err = closeStorage(s); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		s, err = storage.NewFileStorage(name)
+		if err != nil {
+			t.Fatalf("file that loaded once does not load again: %v", err)
+		}
+		defer closeStorage(s)
+		after, err := storage.Dump(ctx, s)
+		if err != nil {
+			t.Fatalf("Dump after reopening: %v", err)
+		}
+		if !maps.Equal(before, after) {
+			t.Fatalf("reopened file has %v, want %v", after, before)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// история нужна, чтобы вернуть значение, которое затерли по ошибке. прежние значения живут в MemStorage
+// рядом с метаданными, а FileStorage хранит их в том же журнале: каждая запись ключа и так лежит в нем
+// отдельной записью, проигрывание восстанавливает историю само, а снимок пишет прежние значения перед текущим.
+// в лимиты WithMaxEntries и WithMaxBytes история не входит
+
+// VersionedValue - одно из значений ключа. Version - номер записи ключа, как Entry.Writes на момент записи
+type VersionedValue struct {
+	Version     uint64
+	Value       string
+	UpdatedAt   time.Time
+	ContentType string
+}
+
+// Versioner - хранилка, которая помнит прежние значения ключей
+type Versioner interface {
+	// History отдает текущее значение и сохраненные прежние, от новых к старым
+	History(ctx context.Context, key string) (versions []VersionedValue, err error)
+	// GetVersion отдает значение с номером version, ErrNotFound - такого уже (или еще) нет
+	GetVersion(ctx context.Context, key string, version uint64) (value VersionedValue, err error)
+}
+
+// History читает историю ключа. у бэкендов без истории это ErrNotSupported
+func History(ctx context.Context, s Storage, key string) (versions []VersionedValue, err error) {
+	if v, ok := As[Versioner](s); ok {
+		return v.History(ctx, key)
+	}
+	return nil, ErrNotSupported
+}
+
+// GetVersion читает одно из значений ключа по номеру
+func GetVersion(ctx context.Context, s Storage, key string, version uint64) (value VersionedValue, err error) {
+	if v, ok := As[Versioner](s); ok {
+		return v.GetVersion(ctx, key, version)
+	}
+	return value, ErrNotSupported
+}
+
+// WithMemHistory держит depth прежних значений каждого ключа, 0 - без истории
+func WithMemHistory(depth int) MemOption {
+	return func(o *memOptions) { o.historyDepth = depth }
+}
+
+// WithHistory держит depth прежних значений каждого ключа и сохраняет их в файле, 0 - без истории
+func WithHistory(depth int) FileOption {
+	return func(o *fileOptions) { o.historyDepth = depth }
+}
+
+func (ms *MemStorage) History(ctx context.Context, key string) (versions []VersionedValue, err error) {
+	log.Println("called mem storage History method")
+	if ms.historyDepth <= 0 {
+		return nil, ErrNotSupported
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	value, ok := ms.lookup(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	meta := ms.meta[key]
+	old := ms.history[key]
+	versions = make([]VersionedValue, 0, len(old)+1)
+	versions = append(versions, VersionedValue{Version: meta.writes, Value: value, UpdatedAt: meta.updated, ContentType: meta.contentType})
+	for i := len(old) - 1; i >= 0; i-- {
+		versions = append(versions, old[i])
+	}
+	return versions, nil
+}
+
+func (ms *MemStorage) GetVersion(ctx context.Context, key string, version uint64) (value VersionedValue, err error) {
+	log.Println("called mem storage GetVersion method")
+	versions, err := ms.History(ctx, key)
+	if err != nil {
+		return value, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return value, ErrNotFound
+}
+
+// remember откладывает текущее значение живого ключа в историю перед перезаписью, вызывается из store.
+// у нового ключа история начинается заново, даже если от протухшего что-то осталось
+func (ms *MemStorage) remember(key string, existed bool) {
+	if ms.historyDepth <= 0 {
+		return
+	}
+	if !existed {
+		delete(ms.history, key)
+		return
+	}
+	if ms.history == nil {
+		ms.history = make(map[string][]VersionedValue)
+	}
+	meta := ms.meta[key]
+	old := append(ms.history[key], VersionedValue{Version: meta.writes, Value: ms.m[key], UpdatedAt: meta.updated, ContentType: meta.contentType})
+	if len(old) > ms.historyDepth {
+		old = old[len(old)-ms.historyDepth:]
+	}
+	ms.history[key] = old
+}
+
+// versions - сколько прежних значений сейчас хранится, они тоже живые записи журнала
+func (ms *MemStorage) versions() (n int) {
+	for _, old := range ms.history {
+		n += len(old)
+	}
+	return n
+}
+
+// versionRecord - запись журнала для прежнего значения. created у всех значений ключа общий, берем его из текущих метаданных
+func versionRecord(key string, v VersionedValue, current entryMeta) logRecord {
+	return logRecord{
+		Op:      opSet,
+		Key:     key,
+		Value:   v.Value,
+		Created: current.created.Format(time.RFC3339Nano),
+		Updated: v.UpdatedAt.Format(time.RFC3339Nano),
+		Writes:  strconv.FormatUint(v.Version, 10),
+		Type:    v.ContentType,
+	}
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHTTPTimeout - таймаут клиента NewHTTPStorage, если свой клиент не передали
+const DefaultHTTPTimeout = 10 * time.Second
+
+// сколько ключей просим за раз: в ?keys= они едут в URL, а страницы и скан сервер сам ограничивает сверху
+const (
+	httpGetManyChunk = 100
+	httpPageLimit    = 10000
+	httpScanLimit    = 100000
+)
+
+// HTTPStorage - другой экземпляр examplefs, к которому ходим через его HTTP API. так серверы можно
+// ставить цепочкой: локальный кэш или зеркало впереди, настоящие данные на удаленном
+type HTTPStorage struct {
+	base   string // например http://host:8080/memory, без / в конце
+	client *http.Client
+}
+
+// RemoteError - удаленный сервер ответил ошибкой. коды ошибок examplefs (или, у других серверов, статусы)
+// разворачиваются обратно в ошибки хранилки, а 502-504 считаются недоступностью бэкенда
+type RemoteError struct {
+	StatusCode int
+	Code       string // code из тела ошибки examplefs, пустой у других серверов
+	Body       string // message из тела ошибки examplefs или тело целиком
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+func (e *RemoteError) Unwrap() error {
+	switch e.Code {
+	case "NOT_NUMERIC":
+		return ErrNotNumeric
+	case "ALREADY_EXISTS":
+		return ErrExists
+	case "READ_ONLY":
+		return ErrReadOnly
+	case "TOO_LARGE":
+		return ErrTooLarge
+	case "INVALID_KEY":
+		return ErrInvalidKey
+	case "NOT_SUPPORTED":
+		return ErrNotSupported
+	case "UNAVAILABLE", "TIMEOUT":
+		return ErrUnavailable
+	}
+	switch e.StatusCode {
+	case http.StatusConflict:
+		return ErrNotNumeric
+	case http.StatusNotImplemented:
+		return ErrNotSupported
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+func (hs *HTTPStorage) keyURL(key string) string {
+	return hs.base + "/" + url.PathEscape(key)
+}
+
+// do отправляет запрос и возвращает ответ с успешным статусом, тело закрывает вызывающий.
+// 404 превращается в ErrNotFound, остальные ошибки - в RemoteError с текстом из тела
+func (hs *HTTPStorage) do(ctx context.Context, method, target string, body io.Reader) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+	// тот же id запроса в логах удаленного сервера связывает их с нашими
+	if id := RequestIDFrom(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	// тип значения удаленный сервер берет из Content-Type, как и у обычного клиента
+	if ct := ContentTypeFrom(ctx); ct != "" && method == http.MethodPut {
+		req.Header.Set("Content-Type", ct)
+	}
+	resp, err = hs.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	rerr := &RemoteError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	var apiErr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(msg, &apiErr) == nil && apiErr.Code != "" {
+		rerr.Code, rerr.Body = apiErr.Code, apiErr.Message
+	}
+	return nil, rerr
+}
+
+// getJSON - GET, ответ которого декодируется в out
+func (hs *HTTPStorage) getJSON(ctx context.Context, op, target string, out any) (err error) {
+	resp, err := hs.do(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("unable to %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode %s response: %w", op, err)
+	}
+	return nil
+}
+
+func (hs *HTTPStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called http storage Get method")
+
+	resp, err := hs.do(ctx, http.MethodGet, hs.keyURL(key), nil)
+	if errors.Is(err, ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to get key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// без Accept сервер отдает значение как есть, без JSON
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read value: %w", err)
+	}
+	return string(b), nil
+}
+
+func (hs *HTTPStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called http storage Set method")
+	return hs.put(ctx, hs.keyURL(key), value)
+}
+
+func (hs *HTTPStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	log.Println("called http storage SetWithTTL method")
+	return hs.put(ctx, hs.keyURL(key)+"?ttl="+url.QueryEscape(ttl.String()), value)
+}
+
+func (hs *HTTPStorage) put(ctx context.Context, target, value string) (err error) {
+	resp, err := hs.do(ctx, http.MethodPut, target, strings.NewReader(value))
+	if err != nil {
+		return fmt.Errorf("unable to set key: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (hs *HTTPStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called http storage Delete method")
+
+	resp, err := hs.do(ctx, http.MethodDelete, hs.keyURL(key), nil)
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("unable to delete key: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (hs *HTTPStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called http storage Keys method")
+
+	keys = make([]string, 0)
+	if err = hs.getJSON(ctx, "list keys", hs.base, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// KeysPage ходит за страницами, пока не наберет limit: сервер отдает не больше своего максимума за раз
+func (hs *HTTPStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	log.Println("called http storage KeysPage method")
+
+	keys = make([]string, 0, min(limit, httpPageLimit))
+	cursor := ""
+	if afterKey != "" {
+		cursor = base64.RawURLEncoding.EncodeToString([]byte(afterKey))
+	}
+	for len(keys) < limit {
+		var page struct {
+			Keys       []string `json:"keys"`
+			NextCursor string   `json:"next_cursor"`
+		}
+		q := url.Values{"limit": {strconv.Itoa(min(limit-len(keys), httpPageLimit))}, "cursor": {cursor}}
+		if err = hs.getJSON(ctx, "list keys", hs.base+"?"+q.Encode(), &page); err != nil {
+			return nil, err
+		}
+		keys = append(keys, page.Keys...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	return keys, nil
+}
+
+// Scan берет ключи с префиксом одним запросом к ?prefix=. если сервер обрезал ответ
+// или префикс пустой, выкачиваем _dump и фильтруем сами
+func (hs *HTTPStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	log.Println("called http storage Scan method")
+
+	var kv map[string]string
+	if prefix != "" { // пустой префикс сервер не принимает
+		var scan struct {
+			Items     map[string]string `json:"items"`
+			Truncated bool              `json:"truncated"`
+		}
+		q := url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(httpScanLimit)}}
+		if err = hs.getJSON(ctx, "scan", hs.base+"?"+q.Encode(), &scan); err != nil {
+			return err
+		}
+		if !scan.Truncated {
+			kv = scan.Items
+		}
+	}
+	if kv == nil {
+		if kv, err = hs.Dump(ctx); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn(k, kv[k]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (hs *HTTPStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	log.Println("called http storage Dump method")
+
+	kv = make(map[string]string)
+	if err = hs.getJSON(ctx, "dump", hs.base+"/_dump", &kv); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// GetMany просит ключи пачками через ?keys=. ключи с запятой так не передать, за ними ходим по одному
+func (hs *HTTPStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called http storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	plain := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !strings.Contains(k, ",") {
+			plain = append(plain, k)
+			continue
+		}
+		v, err := hs.Get(ctx, k)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		kv[k] = v
+	}
+
+	for len(plain) > 0 {
+		chunk := plain[:min(len(plain), httpGetManyChunk)]
+		plain = plain[len(chunk):]
+
+		var found struct {
+			Found map[string]string `json:"found"`
+		}
+		q := url.Values{"keys": {strings.Join(chunk, ",")}}
+		if err = hs.getJSON(ctx, "get keys", hs.base+"?"+q.Encode(), &found); err != nil {
+			return nil, err
+		}
+		for k, v := range found.Found {
+			kv[k] = v
+		}
+	}
+	return kv, nil
+}
+
+// SetMany пишет через _batch, так что атомарность такая же, как у SetMany удаленного бэкенда
+func (hs *HTTPStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called http storage SetMany method")
+
+	body, err := json.Marshal(kv)
+	if err != nil {
+		return fmt.Errorf("unable to encode batch: %w", err)
+	}
+	resp, err := hs.do(ctx, http.MethodPost, hs.base+"/_batch", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to set keys: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (hs *HTTPStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	log.Println("called http storage Increment method")
+
+	resp, err := hs.do(ctx, http.MethodPost, hs.keyURL(key)+"/incr?delta="+strconv.FormatInt(delta, 10), nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to increment key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read value: %w", err)
+	}
+	if value, err = strconv.ParseInt(string(b), 10, 64); err != nil {
+		return 0, fmt.Errorf("unable to parse incremented value %q: %w", b, err)
+	}
+	return value, nil
+}
+
+// Ping просит одну страницу из одного ключа: это дешево и заодно проверяет, что по адресу правда examplefs
+func (hs *HTTPStorage) Ping() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hs.KeysPage(ctx, "", 1)
+	return err
+}
+
+// NewHTTPStorage ходит в examplefs по baseURL - адресу вместе с префиксом бэкенда, например
+// http://host:8080/memory. client == nil - обычный клиент с DefaultHTTPTimeout
+func NewHTTPStorage(baseURL string, client *http.Client) Storage {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultHTTPTimeout}
+	}
+	return &HTTPStorage{base: strings.TrimSuffix(baseURL, "/"), client: client}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestAddInt(t *testing.T) {
+	for _, tt := range []struct {
+		current string
+		exists  bool
+		delta   int64
+		want    int64
+		wantErr bool
+	}{
+		{exists: false, delta: 5, want: 5},
+		{current: "10", exists: true, delta: -3, want: 7},
+		{current: "-1", exists: true, delta: 1, want: 0},
+		{current: "abc", exists: true, delta: 1, wantErr: true},
+		{current: "1.5", exists: true, delta: 1, wantErr: true},
+		{current: "", exists: true, delta: 1, wantErr: true},
+		{current: strconv.FormatInt(math.MaxInt64, 10), exists: true, delta: 1, wantErr: true},
+		{current: strconv.FormatInt(math.MinInt64, 10), exists: true, delta: -1, wantErr: true},
+	} {
+		got, err := addInt(tt.current, tt.exists, tt.delta)
+		if tt.wantErr {
+			if !errors.Is(err, ErrNotNumeric) {
+				t.Errorf("addInt(%q, %v, %d): got %v, want ErrNotNumeric", tt.current, tt.exists, tt.delta, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("addInt(%q, %v, %d) = %d, %v, want %d", tt.current, tt.exists, tt.delta, got, err, tt.want)
+		}
+	}
+}
+
+// инкременты из нескольких горутин не теряются ни в одном бэкенде
+func TestIncrementConcurrent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mr := miniredis.RunT(t)
+	for name, open := range map[string]func() (Storage, error){
+		"mem":   func() (Storage, error) { return NewMemStorage(), nil },
+		"file":  func() (Storage, error) { return NewFileStorage(filepath.Join(dir, "data.json")) },
+		"bolt":  func() (Storage, error) { return NewBoltStorage(filepath.Join(dir, "data.bolt")) },
+		"sql":   func() (Storage, error) { return NewSQLStorage(filepath.Join(dir, "data.db")) },
+		"redis": func() (Storage, error) { return NewRedisStorage(mr.Addr(), "", 0) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, ok := s.(io.Closer); ok {
+				defer c.Close()
+			}
+			inc := s.(Incrementer)
+
+			const workers, perWorker = 8, 50
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						if _, err := inc.Increment(ctx, "counter", 1); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if v, err := s.Get(ctx, "counter"); err != nil || v != strconv.Itoa(workers*perWorker) {
+				t.Errorf("counter = %q, %v, want %d", v, err, workers*perWorker)
+			}
+
+			if err = s.Set(ctx, "text", "abc"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = inc.Increment(ctx, "text", 1); !errors.Is(err, ErrNotNumeric) {
+				t.Errorf("Increment of a non-number: got %v, want ErrNotNumeric", err)
+			}
+		})
+	}
+}
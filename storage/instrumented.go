@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentedStorage считает обращения к любой хранилке и их длительность для prometheus.
+// встраиваем Storage целиком и переопределяем только то, что хотим посчитать
+type InstrumentedStorage struct {
+	Storage
+
+	ops       *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	notFound  prometheus.Counter
+	errs      *prometheus.CounterVec
+}
+
+// observe считает операцию, начатую в start. длительность пишется и для неудачных вызовов:
+// медленный таймаут - как раз то, что хочется увидеть в p99
+func (is *InstrumentedStorage) observe(op string, start time.Time, err error) {
+	is.ops.WithLabelValues(op).Inc()
+	is.durations.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNotFound):
+		is.notFound.Inc()
+	default:
+		is.errs.WithLabelValues(op).Inc()
+	}
+}
+
+func (is *InstrumentedStorage) Get(ctx context.Context, key string) (value string, err error) {
+	start := time.Now()
+	value, err = is.Storage.Get(ctx, key)
+	is.observe("get", start, err)
+	return value, err
+}
+
+func (is *InstrumentedStorage) Set(ctx context.Context, key, value string) (err error) {
+	start := time.Now()
+	err = is.Storage.Set(ctx, key, value)
+	is.observe("set", start, err)
+	return err
+}
+
+func (is *InstrumentedStorage) Delete(ctx context.Context, key string) (err error) {
+	start := time.Now()
+	err = is.Storage.Delete(ctx, key)
+	is.observe("delete", start, err)
+	return err
+}
+
+func (is *InstrumentedStorage) Keys(ctx context.Context) (keys []string, err error) {
+	start := time.Now()
+	keys, err = is.Storage.Keys(ctx)
+	is.observe("keys", start, err)
+	return keys, err
+}
+
+func (is *InstrumentedStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	start := time.Now()
+	kv, err = is.Storage.GetMany(ctx, keys)
+	is.observe("get_many", start, err)
+	return kv, err
+}
+
+func (is *InstrumentedStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	start := time.Now()
+	err = is.Storage.SetMany(ctx, kv)
+	is.observe("set_many", start, err)
+	return err
+}
+
+// SetWithTTL, CompareAndSwap и SetIfAbsent считаются, только если их умеет хранилка ниже, иначе ErrNotSupported, как в AuditedStorage
+func (is *InstrumentedStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](is.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	start := time.Now()
+	err = es.SetWithTTL(ctx, key, value, ttl)
+	is.observe("set_with_ttl", start, err)
+	return err
+}
+
+func (is *InstrumentedStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](is.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	start := time.Now()
+	swapped, err = cs.CompareAndSwap(ctx, key, old, new)
+	is.observe("compare_and_swap", start, err)
+	return swapped, err
+}
+
+func (is *InstrumentedStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](is.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	start := time.Now()
+	set, err = cs.SetIfAbsent(ctx, key, value)
+	is.observe("set_if_absent", start, err)
+	return set, err
+}
+
+// Unwrap дает хендлерам добраться до возможностей обернутой хранилки (ttl, Close и т.п.)
+func (is *InstrumentedStorage) Unwrap() Storage {
+	return is.Storage
+}
+
+// NewInstrumentedStorage оборачивает s и регистрирует метрики в reg с меткой backend.
+// для FileStorage дополнительно отдаются размер файла, длительность последней записи и последней компакции,
+// для кэша, повторов и breaker - их счетчики (см. storageCollector)
+func NewInstrumentedStorage(s Storage, backend string, reg prometheus.Registerer) Storage {
+	labels := prometheus.Labels{"backend": backend}
+	is := &InstrumentedStorage{
+		Storage: s,
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "examplefs_storage_operations_total",
+			Help:        "Storage operations by type.",
+			ConstLabels: labels,
+		}, []string{"operation"}),
+		// от сотни микросекунд (mem) до десятков секунд (rewrite большого файла или недоступный бэкенд)
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "examplefs_storage_operation_duration_seconds",
+			Help:        "Duration of storage operations by type.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"operation"}),
+		notFound: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "examplefs_storage_not_found_total",
+			Help:        "Storage lookups for keys that do not exist.",
+			ConstLabels: labels,
+		}),
+		errs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "examplefs_storage_errors_total",
+			Help:        "Storage operations that failed with an error other than not found.",
+			ConstLabels: labels,
+		}, []string{"operation"}),
+	}
+	reg.MustRegister(is.ops, is.durations, is.notFound, is.errs, newStorageCollector(s, labels))
+	return is
+}
+
+// metricsAs - As для метрик: в отличие от As проходит и сквозь кэш. читать счетчики файла или breaker
+// под ним безопасно, а писать мимо кэша через найденное тут нельзя
+func metricsAs[T any](s Storage) (t T, ok bool) {
+	for s != nil {
+		if t, ok = As[T](s); ok {
+			return t, true
+		}
+		s = innerOf(s)
+	}
+	return t, false
+}
+
+// innerOf отдает хранилку под последним декоратором цепочки, если это кэш, иначе nil
+func innerOf(s Storage) Storage {
+	for {
+		w, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	if cs, ok := s.(*CachedStorage); ok {
+		return cs.Storage
+	}
+	return nil
+}
+
+// storageCollector отдает метрики возможностей хранилки: размер файла, состояние breaker, попадания в кэш и т.п.
+// бэкенд ищется заново при каждом запросе, а не один раз при старте: /admin/backend может подменить его на ходу,
+// и у нового бэкенда может не оказаться тех же возможностей
+type storageCollector struct {
+	s Storage
+
+	fileSize, lastFlush, lastCompaction, reclaimed *prometheus.Desc
+	evictions, retries, circuitState               *prometheus.Desc
+	cacheHits, cacheMisses                         *prometheus.Desc
+}
+
+func newStorageCollector(s Storage, labels prometheus.Labels) *storageCollector {
+	desc := func(name, help string) *prometheus.Desc { return prometheus.NewDesc(name, help, nil, labels) }
+	return &storageCollector{
+		s:              s,
+		fileSize:       desc("examplefs_file_size_bytes", "Current size of the FileStorage data file."),
+		lastFlush:      desc("examplefs_file_last_flush_seconds", "Duration of the last FileStorage write to disk."),
+		lastCompaction: desc("examplefs_file_last_compaction_seconds", "Duration of the last FileStorage compaction."),
+		reclaimed:      desc("examplefs_file_last_compaction_reclaimed_bytes", "Bytes freed by the last FileStorage compaction."),
+		evictions:      desc("examplefs_mem_evictions_total", "Keys dropped by MemStorage to stay within its entry and byte limits."),
+		retries:        desc("examplefs_storage_retries_total", "Storage calls repeated after a transient error."),
+		circuitState:   desc("examplefs_storage_circuit_state", "Circuit breaker state: 0 closed, 1 open, 2 half-open."),
+		cacheHits:      desc("examplefs_cache_hits_total", "Reads served from the in-memory cache."),
+		cacheMisses:    desc("examplefs_cache_misses_total", "Reads that had to go to the backend."),
+	}
+}
+
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.fileSize, c.lastFlush, c.lastCompaction, c.reclaimed,
+		c.evictions, c.retries, c.circuitState, c.cacheHits, c.cacheMisses} {
+		ch <- d
+	}
+}
+
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(d *prometheus.Desc, v float64) { ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v) }
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+
+	if fs, ok := metricsAs[*FileStorage](c.s); ok {
+		gauge(c.fileSize, float64(fs.FileSize()))
+		gauge(c.lastFlush, fs.LastFlushDuration().Seconds())
+		d, n := fs.LastCompaction()
+		gauge(c.lastCompaction, d.Seconds())
+		gauge(c.reclaimed, float64(n))
+	}
+	if ms, ok := metricsAs[*MemStorage](c.s); ok && ms.lru != nil {
+		counter(c.evictions, float64(ms.Evictions()))
+	}
+	if rs, ok := metricsAs[*RetryStorage](c.s); ok {
+		counter(c.retries, float64(rs.Retries()))
+	}
+	if cb, ok := metricsAs[*CircuitBreakerStorage](c.s); ok {
+		gauge(c.circuitState, float64(cb.State()))
+	}
+	if cs, ok := metricsAs[*CachedStorage](c.s); ok {
+		counter(c.cacheHits, float64(cs.Hits()))
+		counter(c.cacheMisses, float64(cs.Misses()))
+	}
+}
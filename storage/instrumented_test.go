@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gather отдает значения метрик из reg по имени, для векторов - по значению метки operation
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "operation" {
+					name += "/" + l.GetValue()
+				}
+			}
+			switch {
+			case m.GetGauge() != nil:
+				got[name] = m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				got[name] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return got
+}
+
+func TestInstrumentedStorageOperations(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	s := NewInstrumentedStorage(NewMemStorage(), "memory", reg)
+
+	if es, ok := As[ExpiringStorage](s); !ok {
+		t.Fatal("InstrumentedStorage must be an ExpiringStorage")
+	} else if err := es.SetWithTTL(ctx, "a", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	cs, ok := As[ConditionalStorage](s)
+	if !ok {
+		t.Fatal("InstrumentedStorage must be a ConditionalStorage")
+	}
+	cs.CompareAndSwap(ctx, "a", "1", "2")
+	cs.SetIfAbsent(ctx, "b", "1")
+	s.SetMany(ctx, map[string]string{"c": "1"})
+	s.GetMany(ctx, []string{"a", "c"})
+	s.Get(ctx, "missing")
+
+	got := gather(t, reg)
+	for _, op := range []string{"set_with_ttl", "compare_and_swap", "set_if_absent", "set_many", "get_many", "get"} {
+		if got["examplefs_storage_operations_total/"+op] != 1 {
+			t.Errorf("%s: got %v operations, want 1", op, got["examplefs_storage_operations_total/"+op])
+		}
+	}
+	if got["examplefs_storage_not_found_total"] != 1 {
+		t.Errorf("not found = %v, want 1", got["examplefs_storage_not_found_total"])
+	}
+}
+
+// метрики бэкенда находятся сквозь кэш и после подмены бэкенда на ходу
+func TestInstrumentedStorageBackendMetrics(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFileStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	switchable := NewSwitchableStorage(NewMemStorage())
+	cached, err := NewCachedStorage(NewCircuitBreakerStorage(switchable, "test", 3, time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	s := NewInstrumentedStorage(cached, "test", reg)
+
+	got := gather(t, reg)
+	if _, ok := got["examplefs_storage_circuit_state"]; !ok {
+		t.Error("no circuit state behind the cache")
+	}
+	if _, ok := got["examplefs_cache_hits_total"]; !ok {
+		t.Error("no cache hits")
+	}
+	if _, ok := got["examplefs_file_size_bytes"]; ok {
+		t.Error("file size reported for a memory backend")
+	}
+
+	if _, err = switchable.Swap(ctx, fs, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer switchable.Close()
+	if err = s.Set(ctx, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	got = gather(t, reg)
+	if got["examplefs_file_size_bytes"] == 0 {
+		t.Errorf("file size after swap = %v, want > 0", got["examplefs_file_size_bytes"])
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ключи во всех бэкендах - это произвольные байтовые строки и они чувствительны к регистру:
+// Foo и foo - два разных ключа. никакой нормализации (регистр, юникод, слэши) хранилки не делают,
+// иначе один и тот же ключ по-разному вел бы себя в mem, redis и sql
+
+// InvalidKeyError объясняет, чем ключ не подошел
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid key %q: %s", e.Key, e.Reason)
+}
+
+func (e *InvalidKeyError) Unwrap() error {
+	return ErrInvalidKey
+}
+
+// ValidateKey - правила, общие для всех бэкендов: непустой ключ в UTF-8 без управляющих символов.
+// такой ключ без сюрпризов проходит и через путь URL, и через JSON дампа, и через имя файла DirStorage
+func ValidateKey(key string) error {
+	switch {
+	case key == "":
+		return &InvalidKeyError{Key: key, Reason: "must not be empty"}
+	case !utf8.ValidString(key):
+		return &InvalidKeyError{Key: key, Reason: "must be valid UTF-8"}
+	case strings.ContainsFunc(key, unicode.IsControl):
+		return &InvalidKeyError{Key: key, Reason: "must not contain control characters"}
+	}
+	return nil
+}
+
+// validateKeys - ValidateKey для батча, до первого плохого ключа
+func validateKeys(kv map[string]string) error {
+	for k := range kv {
+		if err := ValidateKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
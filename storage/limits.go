@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTooLarge - ключ или значение больше, чем разрешено
+var ErrTooLarge = errors.New("too large")
+
+// TooLargeError уточняет, что именно не влезло в лимит
+type TooLargeError struct {
+	What  string // "key" или "value"
+	Size  int
+	Limit int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("%s is too large: %d bytes, limit is %d", e.What, e.Size, e.Limit)
+}
+
+func (e *TooLargeError) Unwrap() error {
+	return ErrTooLarge
+}
+
+// Limits - максимальные размеры ключа и значения в байтах, 0 - без ограничения.
+// KeyPattern дополнительно сужает алфавит ключей, nil - годится любой ключ, прошедший ValidateKey
+type Limits struct {
+	MaxKeyBytes   int
+	MaxValueBytes int
+	KeyPattern    *regexp.Regexp
+}
+
+func (l Limits) Enabled() bool {
+	return l.MaxKeyBytes > 0 || l.MaxValueBytes > 0 || l.KeyPattern != nil
+}
+
+// CheckKey всегда прогоняет ValidateKey, так что даже пустые Limits не пропустят пустой ключ
+func (l Limits) CheckKey(key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	if l.MaxKeyBytes > 0 && len(key) > l.MaxKeyBytes {
+		return &TooLargeError{What: "key", Size: len(key), Limit: l.MaxKeyBytes}
+	}
+	if l.KeyPattern != nil && !l.KeyPattern.MatchString(key) {
+		return &InvalidKeyError{Key: key, Reason: "must match " + l.KeyPattern.String()}
+	}
+	return nil
+}
+
+func (l Limits) Check(key, value string) error {
+	if err := l.CheckKey(key); err != nil {
+		return err
+	}
+	if l.MaxValueBytes > 0 && len(value) > l.MaxValueBytes {
+		return &TooLargeError{What: "value", Size: len(value), Limit: l.MaxValueBytes}
+	}
+	return nil
+}
+
+// LimitedStorage не пускает в хранилку слишком большие ключи и значения. HTTP хендлеры проверяют
+// то же самое раньше, а этот декоратор защищает всех остальных: gRPC, батчи, восстановление из дампа
+type LimitedStorage struct {
+	Storage
+	limits *atomic.Pointer[Limits]
+}
+
+func (ls *LimitedStorage) Set(ctx context.Context, key, value string) (err error) {
+	if err = ls.limits.Load().Check(key, value); err != nil {
+		return err
+	}
+	return ls.Storage.Set(ctx, key, value)
+}
+
+func (ls *LimitedStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	limits := ls.limits.Load() // весь батч проверяем одними лимитами, даже если их подменят посреди
+	for k, v := range kv {
+		if err = limits.Check(k, v); err != nil {
+			return err
+		}
+	}
+	return ls.Storage.SetMany(ctx, kv)
+}
+
+func (ls *LimitedStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](ls.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err = ls.limits.Load().Check(key, value); err != nil {
+		return err
+	}
+	return es.SetWithTTL(ctx, key, value, ttl)
+}
+
+func (ls *LimitedStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](ls.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if err = ls.limits.Load().Check(key, new); err != nil {
+		return false, err
+	}
+	return cs.CompareAndSwap(ctx, key, old, new)
+}
+
+func (ls *LimitedStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](ls.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if err = ls.limits.Load().Check(key, value); err != nil {
+		return false, err
+	}
+	return cs.SetIfAbsent(ctx, key, value)
+}
+
+// значение по умолчанию проверяем заранее, даже если ключ есть и оно не пригодится: иначе
+// слишком большой default проходил бы или нет в зависимости от того, успел ли кто-то записать ключ
+func (ls *LimitedStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	if err = ls.limits.Load().Check(key, defaultValue); err != nil {
+		return "", false, err
+	}
+	return GetOrSet(ctx, ls.Storage, key, defaultValue)
+}
+
+func (ls *LimitedStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	if err = ls.limits.Load().CheckKey(newKey); err != nil {
+		return err
+	}
+	return Rename(ctx, ls.Storage, oldKey, newKey, overwrite)
+}
+
+// у Undelete проверяется только ключ: значение уже было записано раньше, и до возврата его не видно
+func (ls *LimitedStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	if err = ls.limits.Load().CheckKey(key); err != nil {
+		return "", err
+	}
+	return Undelete(ctx, ls.Storage, key)
+}
+
+func (ls *LimitedStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	limits := ls.limits.Load()
+	for _, op := range txnWrites(ops) {
+		if op.Type == TxnSet {
+			if err = limits.Check(op.Key, op.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return Txn(ctx, ls.Storage, ops)
+}
+
+// Append проверяет лимитом значения итог, а не только suffix: его длину знает только хранилка под декоратором
+func (ls *LimitedStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	limits := ls.limits.Load()
+	if err = limits.Check(key, suffix); err != nil {
+		return 0, err
+	}
+	return Append(withValueLimit(ctx, limits.MaxValueBytes), ls.Storage, key, suffix)
+}
+
+func (ls *LimitedStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](ls.Storage)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	if err = ls.limits.Load().CheckKey(key); err != nil {
+		return 0, err
+	}
+	return inc.Increment(ctx, key, delta)
+}
+
+func (ls *LimitedStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	limits := ls.limits.Load() // весь батч проверяем одними лимитами, даже если их подменят посреди
+	for k, v := range kv {
+		if err = limits.Check(k, v); err != nil {
+			return err
+		}
+	}
+	return Replace(ctx, ls.Storage, kv)
+}
+
+// Dump нужен, чтобы As[Dumper] не проскочил мимо Replace с проверками к бэкенду
+func (ls *LimitedStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return Dump(ctx, ls.Storage)
+}
+
+// Unwrap пускает As к остальным возможностям бэкенда
+func (ls *LimitedStorage) Unwrap() Storage {
+	return ls.Storage
+}
+
+// NewLimitedStorage проверяет записи в s по limits. лимиты можно подменить на ходу через limits,
+// все обертки с тем же указателем сразу начнут проверять по новым
+func NewLimitedStorage(s Storage, limits *atomic.Pointer[Limits]) Storage {
+	return &LimitedStorage{Storage: s, limits: limits}
+}
+
+// LimitsOf ищет лимиты в цепочке декораторов, без LimitedStorage ограничений нет.
+// по ним HTTP хендлеры режут тело запроса еще до чтения
+func LimitsOf(s Storage) Limits {
+	return LiveLimits(s)()
+}
+
+// LiveLimits - LimitsOf для тех, кто собирается один раз и живет долго, как хендлеры: цепочка декораторов
+// обходится сразу, а лимиты читаются на каждом вызове, так что перечитанный конфиг действует без перезапуска
+func LiveLimits(s Storage) func() Limits {
+	if ls, ok := As[*LimitedStorage](s); ok {
+		return func() Limits { return *ls.limits.Load() }
+	}
+	return func() Limits { return Limits{} }
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package storage
+
+import "os"
+
+// на остальных платформах (plan9, wasm) пользоваться нечем, работаем без блокировки
+func lockFile(f *os.File) error {
+	return nil
+}
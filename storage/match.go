@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidPattern - шаблон поиска ключей не разбирается
+var ErrInvalidPattern = errors.New("invalid key pattern")
+
+// KeyPattern - шаблон для Match: glob как у path.Match или регулярное выражение RE2
+type KeyPattern struct {
+	glob   string
+	re     *regexp.Regexp
+	prefix string // с чего начинается любой подходящий ключ, по нему Match сужает обход
+}
+
+// GlobPattern разбирает glob: * - любые символы, кроме /, ? - один символ, [a-z] - класс символов
+func GlobPattern(glob string) (p KeyPattern, err error) {
+	// path.Match проверяет весь шаблон, даже когда уже ясно, что строка не подходит
+	if _, err = path.Match(glob, ""); err != nil {
+		return p, fmt.Errorf("%w %q: %w", ErrInvalidPattern, glob, err)
+	}
+	end := strings.IndexAny(glob, `*?[\`)
+	if end < 0 {
+		end = len(glob)
+	}
+	return KeyPattern{glob: glob, prefix: glob[:end]}, nil
+}
+
+// RegexpPattern разбирает регулярное выражение RE2. совпадение ищется в любом месте ключа, целиком - с ^ и $.
+// RE2 работает за линейное время, так что выражение само по себе процессор не повесит, только число ключей
+func RegexpPattern(expr string) (p KeyPattern, err error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return p, fmt.Errorf("%w %q: %w", ErrInvalidPattern, expr, err)
+	}
+	return KeyPattern{re: re}, nil
+}
+
+func (p KeyPattern) MatchString(key string) bool {
+	if p.re != nil {
+		return p.re.MatchString(key)
+	}
+	ok, _ := path.Match(p.glob, key) // шаблон уже проверен в GlobPattern
+	return ok
+}
+
+// KeyMatcher - хранилка, которая ищет ключи по шаблону сама, не отдавая значения
+type KeyMatcher interface {
+	// MatchKeys отдает до limit подходящих ключей по возрастанию. truncated == true - нашлось больше
+	MatchKeys(ctx context.Context, p KeyPattern, limit int) (keys []string, truncated bool, err error)
+}
+
+// Match ищет ключи по шаблону, самое большее limit штук по возрастанию. truncated == true - нашлось больше.
+// у кого нет своего MatchKeys, обход идет через Scan по постоянному началу шаблона. прерывается, когда кончился ctx
+func Match(ctx context.Context, s Storage, p KeyPattern, limit int) (keys []string, truncated bool, err error) {
+	if m, ok := As[KeyMatcher](s); ok {
+		keys, truncated, err = m.MatchKeys(ctx, p, limit)
+	} else {
+		keys, truncated, err = scanMatch(ctx, s, p, limit)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: key search: %w", ErrTimeout, err)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return keys, truncated, nil
+}
+
+func scanMatch(ctx context.Context, s Storage, p KeyPattern, limit int) (keys []string, truncated bool, err error) {
+	keys = make([]string, 0)
+	err = Scan(ctx, s, p.prefix, func(key, _ string) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		if !p.MatchString(key) {
+			return true
+		}
+		if len(keys) == limit {
+			truncated = true
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	return keys, truncated, err
+}
+
+// MatchKeys проверяет шаблон прямо при обходе мапки и копирует только подходящие ключи, без значений.
+// мапка не упорядочена, так что обойти ее приходится целиком, но держим не больше 2*(limit+1) ключей:
+// когда набралось столько, сортируем и оставляем первые limit+1. ctx проверяем по ходу обхода
+func (ms *MemStorage) MatchKeys(ctx context.Context, p KeyPattern, limit int) (keys []string, truncated bool, err error) {
+	log.Println("called mem storage MatchKeys method")
+	ms.mu.RLock()
+	keys = make([]string, 0)
+	shrink := func() {
+		sort.Strings(keys)
+		if len(keys) > limit+1 {
+			keys = keys[:limit+1]
+		}
+	}
+	seen := 0
+	for k := range ms.m {
+		if seen++; seen%matchCheckEvery == 0 && ctx.Err() != nil {
+			break
+		}
+		if !strings.HasPrefix(k, p.prefix) || ms.expired(k) || !p.MatchString(k) {
+			continue
+		}
+		keys = append(keys, k)
+		if len(keys) >= 2*(limit+1) {
+			shrink()
+		}
+	}
+	ms.mu.RUnlock()
+
+	if err = ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	shrink()
+	if len(keys) > limit {
+		return keys[:limit], true, nil
+	}
+	return keys, false, nil
+}
+
+// как часто MatchKeys смотрит на ctx: проверка на каждом ключе стоила бы больше самого glob
+const matchCheckEvery = 1024
@@ -0,0 +1,70 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+	"github.com/Barugoo/example-fs/storagetest"
+)
+
+func TestMatch(t *testing.T) {
+	ctx := context.Background()
+	kv := make(map[string]string)
+	for i := range 500 {
+		kv[fmt.Sprintf("user/%03d", i)] = "v"
+		kv[fmt.Sprintf("item/%03d", i)] = "v"
+	}
+	ms := storage.NewMemStorage()
+	if err := ms.SetMany(ctx, kv); err != nil {
+		t.Fatal(err)
+	}
+	glob, err := storage.GlobPattern("user/?1?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, err := storage.RegexpPattern(`^item/4\d0$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// у FakeStorage своего MatchKeys нет, так что она идет через Scan - ответы должны совпасть
+	for _, tc := range []struct {
+		name  string
+		p     storage.KeyPattern
+		limit int
+		want  []string
+		trunc bool
+	}{
+		{"glob", glob, 3, []string{"user/010", "user/011", "user/012"}, true},
+		{"glob all", glob, 100, nil, false},
+		{"regexp", re, 10, []string{"item/400", "item/410", "item/420", "item/430", "item/440", "item/450", "item/460", "item/470", "item/480", "item/490"}, false},
+		{"regexp exact limit", re, 9, []string{"item/400", "item/410", "item/420", "item/430", "item/440", "item/450", "item/460", "item/470", "item/480"}, true},
+	} {
+		for backend, s := range map[string]storage.Storage{"mem": ms, "scan": storagetest.NewFakeStorage(kv)} {
+			keys, truncated, err := storage.Match(ctx, s, tc.p, tc.limit)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", tc.name, backend, err)
+			}
+			if tc.want == nil {
+				if len(keys) != 50 || !slices.IsSorted(keys) {
+					t.Errorf("%s/%s: got %d keys, sorted %v, want 50 sorted", tc.name, backend, len(keys), slices.IsSorted(keys))
+				}
+			} else if !slices.Equal(keys, tc.want) {
+				t.Errorf("%s/%s: got %v, want %v", tc.name, backend, keys, tc.want)
+			}
+			if truncated != tc.trunc {
+				t.Errorf("%s/%s: truncated %v, want %v", tc.name, backend, truncated, tc.trunc)
+			}
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := storage.Match(cancelled, ms, glob, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("Match with a cancelled context: got %v", err)
+	}
+}
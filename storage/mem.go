@@ -0,0 +1,507 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memory
+type MemStorage struct {
+	mu  sync.RWMutex // хендлеры вызываются конкурентно, а мапка без блокировки этого не переживет
+	m   map[string]string
+	exp map[string]time.Time // дедлайны ключей с ttl, создается при первом SetWithTTL
+
+	meta    map[string]entryMeta        // когда и сколько раз писали ключ, ведется в store
+	history map[string][]VersionedValue // прежние значения от старых к новым, только с историей
+	deleted map[string]tombstone        // удаленные ключи, только с мягким удалением
+
+	now  func() time.Time // подменяется в тестах, чтобы не ждать реального времени
+	done chan struct{}    // закрывается при остановке фоновой чистки
+
+	invalidations atomic.Uint64 // см. Invalidator
+
+	watchers watchHub
+
+	bytes int64 // сумма длин ключей и значений, ведется на каждой записи, чтобы Stats не обходил мапку
+
+	memOptions
+	lru       *list.List               // только с лимитами: ключи, в начале самые свежие
+	elems     map[string]*list.Element // элементы lru по ключам
+	evictions atomic.Uint64
+}
+
+// как часто фоновая горутина выкидывает протухшие ключи
+const sweepInterval = 10 * time.Second
+
+func (ms *MemStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called mem storage Get method")
+	unlock := ms.lockRead()
+
+	var ok bool
+
+	value, ok = ms.m[key]
+	expired := ok && ms.expired(key)
+	if ok && !expired {
+		ms.used(key)
+	}
+	unlock()
+	if !ok {
+		return value, ErrNotFound
+	}
+	if expired {
+		// удаляем протухший ключ сразу, не дожидаясь фоновой чистки.
+		// под запись блокировку пришлось перевзять, так что проверяем еще раз
+		ms.mu.Lock()
+		if ms.expired(key) {
+			ms.drop(key)
+		}
+		ms.mu.Unlock()
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (ms *MemStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called mem storage Set method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.set(key, value)
+	ms.setContentType(ctx, key)
+	return nil
+}
+
+// GetMany возвращает только найденные ключи, отсутствующие просто не попадают в мапку
+func (ms *MemStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called mem storage GetMany method")
+	defer ms.lockRead()()
+
+	kv = make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := ms.m[k]; ok && !ms.expired(k) {
+			kv[k] = v
+			ms.used(k)
+		}
+	}
+	return kv, nil
+}
+
+// SetMany пишет все пары под одной блокировкой, так что читатели не увидят половину батча
+func (ms *MemStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called mem storage SetMany method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for k, v := range kv {
+		ms.set(k, v)
+	}
+	return nil
+}
+
+func (ms *MemStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	log.Println("called mem storage SetWithTTL method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.setWithDeadline(key, value, ms.clock()().Add(ttl))
+	ms.setContentType(ctx, key)
+	return nil
+}
+
+func (ms *MemStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	log.Println("called mem storage CompareAndSwap method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if v, ok := ms.lookup(key); !ok || v != old {
+		return false, nil
+	}
+	ms.set(key, new)
+	ms.setContentType(ctx, key)
+	return true, nil
+}
+
+func (ms *MemStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	log.Println("called mem storage SetIfAbsent method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.lookup(key); ok {
+		return false, nil
+	}
+	ms.set(key, value)
+	ms.setContentType(ctx, key)
+	return true, nil
+}
+
+func (ms *MemStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	log.Println("called mem storage GetOrSet method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if value, ok := ms.lookup(key); ok {
+		return value, true, nil
+	}
+	ms.set(key, defaultValue)
+	ms.setContentType(ctx, key)
+	return defaultValue, false, nil
+}
+
+func (ms *MemStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	log.Println("called mem storage Increment method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.incr(key, delta)
+}
+
+func (ms *MemStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	log.Println("called mem storage Append method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.appendValue(ctx, key, suffix)
+}
+
+func (ms *MemStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called mem storage Delete method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.delete(key)
+}
+
+func (ms *MemStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	log.Println("called mem storage Rename method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	recs, err := ms.renameRecords(oldKey, newKey, overwrite)
+	if err != nil {
+		return err
+	}
+	return ms.applyRecords(recs)
+}
+
+func (ms *MemStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	log.Println("called mem storage Txn method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	recs, err := ms.txnRecords(ops)
+	if err != nil {
+		return err
+	}
+	return ms.applyRecords(recs)
+}
+
+func (ms *MemStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	log.Println("called mem storage DeletePrefix method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return len(ms.deletePrefix(prefix)), nil
+}
+
+func (ms *MemStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called mem storage Keys method")
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	keys = make([]string, 0, len(ms.m)) // не nil, чтобы пустое хранилище отдавало [], а не null
+	for k := range ms.m {
+		if ms.expired(k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // порядок обхода мапки случайный, а клиенту нужен стабильный
+	return keys, nil
+}
+
+// Scan - линейный проход по мапке. совпадения копируются под блокировкой, а fn вызывается уже без нее,
+// так что из fn можно спокойно ходить в ту же хранилку
+func (ms *MemStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	log.Println("called mem storage Scan method")
+	ms.mu.RLock()
+	type pair struct{ key, value string }
+	matches := make([]pair, 0)
+	for k, v := range ms.m {
+		if strings.HasPrefix(k, prefix) && !ms.expired(k) {
+			matches = append(matches, pair{k, v})
+		}
+	}
+	ms.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].key < matches[j].key })
+	for _, p := range matches {
+		if !fn(p.key, p.value) {
+			break
+		}
+	}
+	return nil
+}
+
+// KeysPage сортирует только ключи после afterKey, но проход по мапке все равно полный
+func (ms *MemStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	log.Println("called mem storage KeysPage method")
+	ms.mu.RLock()
+	keys = make([]string, 0)
+	for k := range ms.m {
+		if k > afterKey && !ms.expired(k) {
+			keys = append(keys, k)
+		}
+	}
+	ms.mu.RUnlock()
+
+	sort.Strings(keys)
+	return pageOf(keys, "", limit), nil
+}
+
+// Dump отдает копию, так что ее можно спокойно кодировать уже без блокировки
+func (ms *MemStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	log.Println("called mem storage Dump method")
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	kv = make(map[string]string, len(ms.m))
+	for k, v := range ms.m {
+		if !ms.expired(k) {
+			kv[k] = v
+		}
+	}
+	return kv, nil
+}
+
+func (ms *MemStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called mem storage Replace method")
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.replace(kv)
+	return nil
+}
+
+func (ms *MemStorage) Watch(ctx context.Context, prefix string) (events <-chan Event, err error) {
+	log.Println("called mem storage Watch method")
+	return ms.watchers.subscribe(ctx, prefix), nil
+}
+
+// Close останавливает фоновую чистку протухших ключей и отключает подписчиков Watch
+func (ms *MemStorage) Close() (err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.stopSweeper()
+	ms.watchers.closeAll()
+	return nil
+}
+
+// set и delete работают с мапкой без блокировки - ее берет вызывающий метод.
+// через них же идут события для Watch, так что FileStorage получает их бесплатно
+func (ms *MemStorage) set(key, value string) {
+	ms.store(key, value)
+	delete(ms.exp, key) // обычный Set снимает ttl
+	ms.watchers.notify(Event{Op: EventSet, Key: key, Value: value})
+}
+
+func (ms *MemStorage) setWithDeadline(key, value string, deadline time.Time) {
+	ms.store(key, value)
+	ms.watchers.notify(Event{Op: EventSet, Key: key, Value: value})
+	if ms.exp == nil {
+		ms.exp = make(map[string]time.Time)
+	}
+	ms.exp[key] = deadline
+	ms.startSweeper()
+}
+
+func (ms *MemStorage) delete(key string) (err error) {
+	if _, ok := ms.m[key]; !ok || ms.expired(key) {
+		ms.drop(key)
+		return ErrNotFound
+	}
+	ms.bury(key, ms.clock()())
+	ms.drop(key)
+	return nil
+}
+
+// deletePrefix удаляет живые ключи с префиксом и возвращает их по возрастанию, протухшие просто вычищает
+func (ms *MemStorage) deletePrefix(prefix string) (keys []string) {
+	for k := range ms.m {
+		if strings.HasPrefix(k, prefix) && ms.delete(k) == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// incr не трогает ttl ключа, чтобы на счетчике с ttl можно было строить rate limit
+func (ms *MemStorage) incr(key string, delta int64) (value int64, err error) {
+	current, ok := ms.lookup(key)
+	if value, err = addInt(current, ok, delta); err != nil {
+		return 0, err
+	}
+	if !ok {
+		ms.drop(key) // протухший ключ начинается заново, уже без старого ttl
+	}
+	ms.store(key, strconv.FormatInt(value, 10))
+	ms.watchers.notify(Event{Op: EventSet, Key: key, Value: ms.m[key]})
+	return value, nil
+}
+
+// replace и adopt подменяют данные целиком и событий по ключам не шлют
+func (ms *MemStorage) replace(kv map[string]string) {
+	ms.m = make(map[string]string, len(kv))
+	ms.meta = make(map[string]entryMeta, len(kv))
+	ms.history, ms.deleted = nil, nil
+	ms.bytes = 0
+	if ms.lru != nil {
+		ms.lru.Init()
+		ms.elems = make(map[string]*list.Element, len(kv))
+	}
+	for k, v := range kv {
+		ms.store(k, v)
+	}
+	ms.exp = nil
+}
+
+// adopt забирает данные other и останавливает его фоновую чистку, вызывается под ms.mu
+func (ms *MemStorage) adopt(other *MemStorage) {
+	other.stopSweeper()
+	ms.m, ms.exp, ms.meta, ms.history, ms.deleted, ms.bytes = other.m, other.exp, other.meta, other.history, other.deleted, other.bytes
+	if len(ms.exp) > 0 || len(ms.deleted) > 0 {
+		ms.startSweeper()
+	}
+}
+
+// lookup - значение живого ключа, протухший считается отсутствующим
+func (ms *MemStorage) lookup(key string) (value string, ok bool) {
+	value, ok = ms.m[key]
+	if !ok || ms.expired(key) {
+		return "", false
+	}
+	return value, true
+}
+
+// store кладет значение в мапку и поправляет счетчик байт и метаданные.
+// протухший, но еще не вычищенный ключ считается новым
+func (ms *MemStorage) store(key, value string) {
+	old, ok := ms.m[key]
+	if ok {
+		ms.bytes -= int64(len(key) + len(old))
+	}
+	existed := ok && !ms.expired(key)
+	ms.remember(key, existed)
+	ms.touch(key, existed)
+	delete(ms.deleted, key) // новое значение заменяет и удаленное
+	ms.m[key] = value
+	ms.bytes += int64(len(key) + len(value))
+	if ms.lru != nil {
+		ms.used(key)
+		ms.evict(key)
+	}
+}
+
+// recount пересчитывает байты с нуля, нужен только там, где мапку подменили целиком
+func (ms *MemStorage) recount() {
+	ms.bytes = 0
+	for k, v := range ms.m {
+		ms.bytes += int64(len(k) + len(v))
+	}
+}
+
+func (ms *MemStorage) drop(key string) {
+	old, ok := ms.m[key]
+	if !ok {
+		return
+	}
+	ms.bytes -= int64(len(key) + len(old))
+	delete(ms.m, key)
+	delete(ms.exp, key)
+	delete(ms.meta, key)
+	delete(ms.history, key)
+	if el, ok := ms.elems[key]; ok {
+		ms.lru.Remove(el)
+		delete(ms.elems, key)
+	}
+	ms.watchers.notify(Event{Op: EventDelete, Key: key})
+}
+
+func (ms *MemStorage) expired(key string) bool {
+	deadline, ok := ms.exp[key]
+	return ok && !ms.clock()().Before(deadline)
+}
+
+func (ms *MemStorage) clock() func() time.Time {
+	if ms.now == nil {
+		return time.Now
+	}
+	return ms.now
+}
+
+// sweep раз в sweepInterval удаляет протухшие ключи и старые надгробия, пока не закроют done
+func (ms *MemStorage) sweep(done chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ms.mu.Lock()
+			expired := 0
+			for k := range ms.exp {
+				if ms.expired(k) {
+					ms.drop(k)
+					expired++
+				}
+			}
+			if expired > 0 {
+				ms.invalidations.Add(1)
+			}
+			ms.purge()
+			ms.mu.Unlock()
+		}
+	}
+}
+
+// Invalidations растет, когда фоновая чистка выкидывает протухшие ключи, а у FileStorage еще и когда файл перечитан
+func (ms *MemStorage) Invalidations() uint64 {
+	return ms.invalidations.Load()
+}
+
+// startSweeper запускает фоновую чистку, если она еще не идет. нужна, только когда есть ttl или надгробия
+func (ms *MemStorage) startSweeper() {
+	if ms.done == nil {
+		ms.done = make(chan struct{})
+		go ms.sweep(ms.done)
+	}
+}
+
+func (ms *MemStorage) stopSweeper() {
+	if ms.done != nil {
+		close(ms.done)
+		ms.done = nil
+	}
+}
+
+// без опций хранилка растет без ограничений, как и раньше
+func NewMemStorage(opts ...MemOption) Storage { // обрати внимание, что возвращаем интерфейс
+	ms := &MemStorage{m: make(map[string]string)}
+	for _, opt := range opts {
+		opt(&ms.memOptions)
+	}
+	if ms.maxEntries > 0 || ms.maxBytes > 0 {
+		ms.lru, ms.elems = list.New(), make(map[string]*list.Element)
+	}
+	return ms
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// 50 горутин пишут, читают, удаляют и листают одни и те же ключи. гонку ловит go test -race,
+// а без него тест проверяет, что ни одна запись не потерялась
+func TestMemStorageConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	const workers, perWorker = 50, 100
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				own := fmt.Sprintf("w%d/%d", w, i)
+				if err := s.Set(ctx, own, own); err != nil {
+					t.Errorf("Set(%s): %v", own, err)
+					return
+				}
+				shared := fmt.Sprintf("shared/%d", i%10)
+				s.Set(ctx, shared, own)
+				if _, err := s.Get(ctx, shared); err != nil && !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get(%s): %v", shared, err)
+				}
+				if err := s.Delete(ctx, shared); err != nil && !errors.Is(err, ErrNotFound) {
+					t.Errorf("Delete(%s): %v", shared, err)
+				}
+				if i%20 == 0 {
+					if _, err := s.Keys(ctx); err != nil {
+						t.Errorf("Keys: %v", err)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		for i := 0; i < perWorker; i++ {
+			key := fmt.Sprintf("w%d/%d", w, i)
+			if v, err := s.Get(ctx, key); err != nil || v != key {
+				t.Fatalf("Get(%s) = %q, %v", key, v, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import "time"
+
+// с лимитами MemStorage работает как LRU кэш: запись сверх лимита выкидывает ключи, которые дольше всех
+// не читали и не писали. выкинутые ключи для Watch выглядят как удаленные.
+// учет LRU идет под тем же ms.mu, что и мапка, поэтому при лимитах даже чтение берет блокировку на запись
+
+type memOptions struct {
+	maxEntries int   // 0 - без ограничения
+	maxBytes   int64 // по той же сумме длин ключей и значений, что в Stats; 0 - без ограничения
+
+	historyDepth int           // сколько прежних значений ключа помнить, 0 - без истории
+	softDelete   time.Duration // сколько держать удаленные ключи, 0 - удалять сразу
+}
+
+// MemOption настраивает NewMemStorage
+type MemOption func(*memOptions)
+
+// WithMaxEntries держит в хранилке не больше n ключей
+func WithMaxEntries(n int) MemOption {
+	return func(o *memOptions) { o.maxEntries = n }
+}
+
+// WithMaxBytes держит сумму длин ключей и значений не больше n. значение больше n целиком
+// все равно записывается, просто вытесняет все остальное
+func WithMaxBytes(n int64) MemOption {
+	return func(o *memOptions) { o.maxBytes = n }
+}
+
+// lockRead берет блокировку для чтения и возвращает функцию, которая ее снимает.
+// с LRU чтение двигает ключи в списке, так что вместо RLock приходится брать Lock
+func (ms *MemStorage) lockRead() (unlock func()) {
+	if ms.lru != nil {
+		ms.mu.Lock()
+		return ms.mu.Unlock
+	}
+	ms.mu.RLock()
+	return ms.mu.RUnlock
+}
+
+// used переносит ключ в начало LRU, без лимитов ничего не делает
+func (ms *MemStorage) used(key string) {
+	if ms.lru == nil {
+		return
+	}
+	if el, ok := ms.elems[key]; ok {
+		ms.lru.MoveToFront(el)
+		return
+	}
+	ms.elems[key] = ms.lru.PushFront(key)
+}
+
+// evict выкидывает самые старые ключи, пока хранилка не влезет в лимиты. keep - ключ, который как раз записали,
+// его не трогаем, даже если он один больше лимита
+func (ms *MemStorage) evict(keep string) {
+	for ms.overLimit() {
+		el := ms.lru.Back()
+		if el == nil || el.Value.(string) == keep {
+			return
+		}
+		ms.drop(el.Value.(string))
+		ms.evictions.Add(1)
+	}
+}
+
+func (ms *MemStorage) overLimit() bool {
+	return ms.maxEntries > 0 && len(ms.m) > ms.maxEntries || ms.maxBytes > 0 && ms.bytes > ms.maxBytes
+}
+
+// Evictions - сколько ключей выкинуто по лимитам, для метрик
+func (ms *MemStorage) Evictions() uint64 {
+	return ms.evictions.Load()
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// MigrateStats - сколько Migrate прочитала и записала
+type MigrateStats struct {
+	Keys  int
+	Bytes int64 // сумма длин ключей и значений, как в StorageStats
+}
+
+// Migrate переносит все ключи src в dst пачками по batch ключей через SetMany, так что в памяти не больше одной пачки.
+// dst == nil - только прочитать src, как для пробного прогона. progress, если не nil, зовется после каждой пачки
+// с ее ключами. ключи, удаленные из src посреди переноса, пропускаются; ttl и метаданные не переносятся
+func Migrate(ctx context.Context, src, dst Storage, batch int, progress func(keys []string, stats MigrateStats)) (stats MigrateStats, err error) {
+	err = keyBatches(ctx, src, batch, func(keys []string) error {
+		kv, err := src.GetMany(ctx, keys)
+		if err != nil {
+			return fmt.Errorf("unable to read source keys: %w", err)
+		}
+		if dst != nil && len(kv) > 0 {
+			if err = dst.SetMany(ctx, kv); err != nil {
+				return fmt.Errorf("unable to write keys: %w", err)
+			}
+		}
+		for k, v := range kv {
+			stats.Keys++
+			stats.Bytes += int64(len(k) + len(v))
+		}
+		if progress != nil {
+			progress(keys, stats)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// VerifyMigration перечитывает keys из src и dst и отдает те, что в dst отсутствуют или отличаются.
+// keys == nil - все ключи src. ключ, которого уже нет в src, не считается расхождением
+func VerifyMigration(ctx context.Context, src, dst Storage, keys []string, batch int) (mismatched []string, err error) {
+	compare := func(keys []string) error {
+		want, err := src.GetMany(ctx, keys)
+		if err != nil {
+			return fmt.Errorf("unable to read source keys: %w", err)
+		}
+		got, err := dst.GetMany(ctx, keys)
+		if err != nil {
+			return fmt.Errorf("unable to read destination keys: %w", err)
+		}
+		for _, k := range keys {
+			if v, ok := want[k]; ok {
+				if gv, ok := got[k]; !ok || gv != v {
+					mismatched = append(mismatched, k)
+				}
+			}
+		}
+		return nil
+	}
+	if keys == nil {
+		return mismatched, keyBatches(ctx, src, batch, compare)
+	}
+	for i := 0; i < len(keys); i += batch {
+		if err = ctx.Err(); err != nil {
+			return mismatched, err
+		}
+		if err = compare(keys[i:min(i+batch, len(keys))]); err != nil {
+			return mismatched, err
+		}
+	}
+	return mismatched, nil
+}
+
+// keyBatches отдает fn все ключи s по возрастанию пачками до batch штук. у кого нет KeysPage, список ключей
+// берется один раз, а не на каждую пачку, как у KeysPage
+func keyBatches(ctx context.Context, s Storage, batch int, fn func(keys []string) error) (err error) {
+	next := func(after string) ([]string, error) { return KeysPage(ctx, s, after, batch) }
+	if _, ok := As[KeyPager](s); !ok {
+		all, err := s.Keys(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to list source keys: %w", err)
+		}
+		sort.Strings(all)
+		next = func(after string) ([]string, error) { return pageOf(all, after, batch), nil }
+	}
+	for after := ""; ; {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		keys, err := next(after)
+		if err != nil {
+			return fmt.Errorf("unable to list source keys: %w", err)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err = fn(keys); err != nil {
+			return err
+		}
+		after = keys[len(keys)-1]
+	}
+}
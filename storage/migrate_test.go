@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemStorage()
+	for i := range 25 {
+		src.Set(ctx, fmt.Sprintf("k%02d", i), "v")
+	}
+	for _, tt := range []struct {
+		name    string
+		dst     Storage
+		batch   int
+		batches int
+	}{
+		{"batched", NewMemStorage(), 10, 3},
+		{"one batch", NewMemStorage(), 100, 1},
+		{"dry run", nil, 7, 4},
+	} {
+		var batches []int
+		stats, err := Migrate(ctx, src, tt.dst, tt.batch, func(keys []string, _ MigrateStats) {
+			if !slices.IsSorted(keys) {
+				t.Errorf("%s: batch is not sorted: %v", tt.name, keys)
+			}
+			batches = append(batches, len(keys))
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if stats.Keys != 25 || stats.Bytes != 25*4 || len(batches) != tt.batches {
+			t.Errorf("%s: got %+v in batches %v, want 25 keys in %d batches", tt.name, stats, batches, tt.batches)
+		}
+		if tt.dst == nil {
+			continue
+		}
+		if mismatched, err := VerifyMigration(ctx, src, tt.dst, nil, tt.batch); err != nil || len(mismatched) != 0 {
+			t.Errorf("%s: VerifyMigration = %v, %v", tt.name, mismatched, err)
+		}
+	}
+}
+
+func TestVerifyMigration(t *testing.T) {
+	ctx := context.Background()
+	src, dst := NewMemStorage(), NewMemStorage()
+	src.SetMany(ctx, map[string]string{"same": "1", "differs": "2", "missing": "3"})
+	dst.SetMany(ctx, map[string]string{"same": "1", "differs": "other", "extra": "4"})
+
+	mismatched, err := VerifyMigration(ctx, src, dst, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(mismatched)
+	if !slices.Equal(mismatched, []string{"differs", "missing"}) {
+		t.Errorf("got %v, want [differs missing]", mismatched)
+	}
+	// ключ, которого уже нет в источнике, расхождением не считается
+	if mismatched, err = VerifyMigration(ctx, src, dst, []string{"same", "gone"}, 1); err != nil || len(mismatched) != 0 {
+		t.Errorf("sampled keys: got %v, %v", mismatched, err)
+	}
+}
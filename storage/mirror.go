@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MirrorStorage пишет в две хранилки сразу, а читает из первой. primary - источник правды:
+// условные записи и счетчики решаются в нем, а в secondary уходит уже готовый результат.
+// As сквозь зеркало не ходит (нет Unwrap), иначе запись через найденную возможность primary прошла бы мимо secondary
+type MirrorStorage struct {
+	primary   Storage
+	secondary Storage
+	mirrorOptions
+}
+
+type mirrorOptions struct {
+	strict   bool // ошибка записи в secondary возвращается клиенту, а не только пишется в лог
+	fallback bool // Get, не найдя ключ в primary или получив от него ошибку, идет в secondary
+}
+
+// MirrorOption настраивает NewMirrorStorage
+type MirrorOption func(*mirrorOptions)
+
+// WithStrictMirror возвращает ошибку записи в secondary клиенту. запись в primary при этом не откатывается,
+// ошибка лишь говорит, что копия неполная и ее надо сверить через Diff
+func WithStrictMirror(strict bool) MirrorOption {
+	return func(o *mirrorOptions) { o.strict = strict }
+}
+
+// WithReadFallback читает из secondary то, что не удалось прочитать из primary
+func WithReadFallback(enabled bool) MirrorOption {
+	return func(o *mirrorOptions) { o.fallback = enabled }
+}
+
+// mirrored решает, что делать с ошибкой secondary после удачной записи в primary
+func (ms *MirrorStorage) mirrored(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ms.strict {
+		return fmt.Errorf("unable to %s on mirror secondary: %w", op, err)
+	}
+	log.Printf("unable to %s on mirror secondary: %v", op, err)
+	return nil
+}
+
+// canFallback - в secondary нет смысла идти, если клиент уже ушел или кончился его дедлайн
+func (ms *MirrorStorage) canFallback(err error) bool {
+	return ms.fallback && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+func (ms *MirrorStorage) Get(ctx context.Context, key string) (value string, err error) {
+	value, err = ms.primary.Get(ctx, key)
+	if err == nil || !ms.canFallback(err) {
+		return value, err
+	}
+	if v, serr := ms.secondary.Get(ctx, key); serr == nil {
+		return v, nil
+	}
+	return "", err
+}
+
+func (ms *MirrorStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	kv, err = ms.primary.GetMany(ctx, keys)
+	if err != nil {
+		if !ms.canFallback(err) {
+			return nil, err
+		}
+		if kv, serr := ms.secondary.GetMany(ctx, keys); serr == nil {
+			return kv, nil
+		}
+		return nil, err
+	}
+	if !ms.fallback || len(kv) == len(keys) {
+		return kv, nil
+	}
+	missing := make([]string, 0, len(keys)-len(kv))
+	for _, k := range keys {
+		if _, ok := kv[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	if found, serr := ms.secondary.GetMany(ctx, missing); serr == nil {
+		for k, v := range found {
+			kv[k] = v
+		}
+	}
+	return kv, nil
+}
+
+func (ms *MirrorStorage) Keys(ctx context.Context) (keys []string, err error) {
+	return ms.primary.Keys(ctx)
+}
+
+func (ms *MirrorStorage) Set(ctx context.Context, key, value string) (err error) {
+	if err = ms.primary.Set(ctx, key, value); err != nil {
+		return err
+	}
+	return ms.mirrored("set", ms.secondary.Set(ctx, key, value))
+}
+
+func (ms *MirrorStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	if err = ms.primary.SetMany(ctx, kv); err != nil {
+		return err
+	}
+	return ms.mirrored("set many", ms.secondary.SetMany(ctx, kv))
+}
+
+// Delete удаляет ключ из secondary, даже если в primary его уже нет, - так расхождение заодно чинится
+func (ms *MirrorStorage) Delete(ctx context.Context, key string) (err error) {
+	err = ms.primary.Delete(ctx, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if serr := ms.secondary.Delete(ctx, key); serr != nil && !errors.Is(serr, ErrNotFound) {
+		if merr := ms.mirrored("delete", serr); merr != nil {
+			return merr
+		}
+	}
+	return err
+}
+
+// SetWithTTL работает, только если ttl умеют оба: без ttl в secondary ключ остался бы там навсегда
+func (ms *MirrorStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	pes, ok := As[ExpiringStorage](ms.primary)
+	if !ok {
+		return ErrNotSupported
+	}
+	ses, ok := As[ExpiringStorage](ms.secondary)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err = pes.SetWithTTL(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	return ms.mirrored("set with ttl", ses.SetWithTTL(ctx, key, value, ttl))
+}
+
+func (ms *MirrorStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](ms.primary)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if swapped, err = cs.CompareAndSwap(ctx, key, old, new); err != nil || !swapped {
+		return swapped, err
+	}
+	return true, ms.mirrored("compare and swap", ms.secondary.Set(ctx, key, new))
+}
+
+func (ms *MirrorStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](ms.primary)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if set, err = cs.SetIfAbsent(ctx, key, value); err != nil || !set {
+		return set, err
+	}
+	return true, ms.mirrored("set if absent", ms.secondary.Set(ctx, key, value))
+}
+
+func (ms *MirrorStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	if value, existed, err = GetOrSet(ctx, ms.primary, key, defaultValue); err != nil || existed {
+		return value, existed, err
+	}
+	return value, false, ms.mirrored("set if absent", ms.secondary.Set(ctx, key, value))
+}
+
+func (ms *MirrorStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	if length, err = Append(ctx, ms.primary, key, suffix); err != nil {
+		return 0, err
+	}
+	_, err = Append(ctx, ms.secondary, key, suffix)
+	return length, ms.mirrored("append", err)
+}
+
+func (ms *MirrorStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](ms.primary)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	if value, err = inc.Increment(ctx, key, delta); err != nil {
+		return 0, err
+	}
+	return value, ms.mirrored("increment", ms.secondary.Set(ctx, key, strconv.FormatInt(value, 10)))
+}
+
+func (ms *MirrorStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	return Scan(ctx, ms.primary, prefix, fn)
+}
+
+func (ms *MirrorStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	return KeysPage(ctx, ms.primary, afterKey, limit)
+}
+
+func (ms *MirrorStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return Dump(ctx, ms.primary)
+}
+
+func (ms *MirrorStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	if err = Replace(ctx, ms.primary, kv); err != nil {
+		return err
+	}
+	return ms.mirrored("replace", Replace(ctx, ms.secondary, kv))
+}
+
+func (ms *MirrorStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	if err = Rename(ctx, ms.primary, oldKey, newKey, overwrite); err != nil {
+		return err
+	}
+	// в secondary ключа может и не быть, если прошлая запись в него не дошла, а overwrite там всегда:
+	// primary уже решил, что перенос законный
+	return ms.mirrored("rename", Rename(ctx, ms.secondary, oldKey, newKey, true))
+}
+
+// проверки в secondary не повторяются по той же причине, что и overwrite в Rename
+func (ms *MirrorStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	if err = Txn(ctx, ms.primary, ops); err != nil {
+		return err
+	}
+	return ms.mirrored("txn", Txn(ctx, ms.secondary, txnWrites(ops)))
+}
+
+func (ms *MirrorStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	if deleted, err = DeletePrefix(ctx, ms.primary, prefix); err != nil {
+		return deleted, err
+	}
+	_, err = DeletePrefix(ctx, ms.secondary, prefix)
+	return deleted, ms.mirrored("delete by prefix", err)
+}
+
+// Watch видит только primary: в secondary те же записи, только позже
+func (ms *MirrorStorage) Watch(ctx context.Context, prefix string) (events <-chan Event, err error) {
+	if w, ok := As[Watcher](ms.primary); ok {
+		return w.Watch(ctx, prefix)
+	}
+	return nil, ErrNotSupported
+}
+
+// GetEntry читает метаданные из primary: в secondary ключ записан позже и со своим счетчиком
+func (ms *MirrorStorage) GetEntry(ctx context.Context, key string) (entry Entry, err error) {
+	return GetEntry(ctx, ms.primary, key)
+}
+
+// надгробия есть только в primary, в secondary возвращенное значение просто записывается заново
+func (ms *MirrorStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	if value, err = Undelete(ctx, ms.primary, key); err != nil {
+		return "", err
+	}
+	return value, ms.mirrored("undelete", ms.secondary.Set(ctx, key, value))
+}
+
+// историю, как и метаданные, ведет primary
+func (ms *MirrorStorage) History(ctx context.Context, key string) (versions []VersionedValue, err error) {
+	return History(ctx, ms.primary, key)
+}
+
+func (ms *MirrorStorage) GetVersion(ctx context.Context, key string, version uint64) (value VersionedValue, err error) {
+	return GetVersion(ctx, ms.primary, key, version)
+}
+
+// Stats - статистика primary, secondary отличается от него разве что расхождениями, а их показывает Diff
+func (ms *MirrorStorage) Stats(ctx context.Context) (stats StorageStats, err error) {
+	return Stats(ctx, ms.primary)
+}
+
+// Ping в строгом режиме проверяет обе хранилки, иначе сервер готов, пока жив primary
+func (ms *MirrorStorage) Ping() (err error) {
+	if p, ok := As[Pinger](ms.primary); ok {
+		if err = p.Ping(); err != nil {
+			return err
+		}
+	}
+	if p, ok := As[Pinger](ms.secondary); ok && ms.strict {
+		if err = p.Ping(); err != nil {
+			return fmt.Errorf("mirror secondary: %w", err)
+		}
+	}
+	return nil
+}
+
+func (ms *MirrorStorage) Close() (err error) {
+	var errs []error
+	for _, s := range []Storage{ms.primary, ms.secondary} {
+		if c, ok := As[io.Closer](s); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MirrorDiff - ключи, в которых хранилки зеркала разошлись
+type MirrorDiff struct {
+	OnlyPrimary   []string `json:"only_primary"`
+	OnlySecondary []string `json:"only_secondary"`
+	Different     []string `json:"different"` // есть в обеих, но значения разные
+}
+
+func (d MirrorDiff) Consistent() bool {
+	return len(d.OnlyPrimary) == 0 && len(d.OnlySecondary) == 0 && len(d.Different) == 0
+}
+
+// Differ - хранилка, которая умеет сверить свои копии
+type Differ interface {
+	Diff(ctx context.Context) (diff MirrorDiff, err error)
+}
+
+// Diff снимает дампы обеих хранилок и сравнивает их. снимки берутся не атомарно,
+// так что ключ, записанный прямо во время сверки, может попасть в расхождения - стоит перепроверить
+func (ms *MirrorStorage) Diff(ctx context.Context) (diff MirrorDiff, err error) {
+	p, err := Dump(ctx, ms.primary)
+	if err != nil {
+		return diff, fmt.Errorf("unable to dump mirror primary: %w", err)
+	}
+	s, err := Dump(ctx, ms.secondary)
+	if err != nil {
+		return diff, fmt.Errorf("unable to dump mirror secondary: %w", err)
+	}
+
+	diff = MirrorDiff{OnlyPrimary: make([]string, 0), OnlySecondary: make([]string, 0), Different: make([]string, 0)}
+	for k, pv := range p {
+		sv, ok := s[k]
+		switch {
+		case !ok:
+			diff.OnlyPrimary = append(diff.OnlyPrimary, k)
+		case sv != pv:
+			diff.Different = append(diff.Different, k)
+		}
+	}
+	for k := range s {
+		if _, ok := p[k]; !ok {
+			diff.OnlySecondary = append(diff.OnlySecondary, k)
+		}
+	}
+	sort.Strings(diff.OnlyPrimary)
+	sort.Strings(diff.OnlySecondary)
+	sort.Strings(diff.Different)
+	return diff, nil
+}
+
+func NewMirrorStorage(primary, secondary Storage, opts ...MirrorOption) Storage {
+	ms := &MirrorStorage{primary: primary, secondary: secondary}
+	for _, opt := range opts {
+		opt(&ms.mirrorOptions)
+	}
+	return ms
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"sort"
+)
+
+// KeyPager - хранилка, которая умеет отдавать ключи страницами, не собирая их все
+type KeyPager interface {
+	// KeysPage отдает до limit ключей строго больше afterKey по возрастанию. afterKey == "" - с самого начала
+	KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error)
+}
+
+// KeysPage - страница ключей. у кого нет своего KeysPage, режем отсортированный список из Keys
+func KeysPage(ctx context.Context, s Storage, afterKey string, limit int) (keys []string, err error) {
+	if p, ok := As[KeyPager](s); ok {
+		return p.KeysPage(ctx, afterKey, limit)
+	}
+	all, err := s.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pageOf(all, afterKey, limit), nil
+}
+
+// pageOf вырезает страницу из отсортированного списка ключей
+func pageOf(sorted []string, afterKey string, limit int) []string {
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i] > afterKey })
+	sorted = sorted[i:]
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return append(make([]string, 0, len(sorted)), sorted...)
+}
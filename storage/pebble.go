@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// pebble - LSM-дерево на диске: запись уходит в журнал и memtable, а не переписывает файл,
+// поэтому держит поток записей, на котором FileStorage и bolt захлебываются
+type PebbleStorage struct {
+	db   *pebble.DB
+	sync *pebble.WriteOptions
+
+	// у pebble нет условной записи. обычные записи берут RLock и идут параллельно,
+	// CompareAndSwap, SetIfAbsent и Increment - Lock, чтобы между их чтением и записью никто не вклинился
+	mu sync.RWMutex
+}
+
+type pebbleOptions struct {
+	sync      bool
+	cacheSize int64
+}
+
+type PebbleOption func(*pebbleOptions)
+
+// WithPebbleSync(false) не ждет fsync журнала на каждой записи: быстрее, но при падении машины
+// теряются последние записи. сама база при этом остается целой
+func WithPebbleSync(sync bool) PebbleOption {
+	return func(o *pebbleOptions) {
+		o.sync = sync
+	}
+}
+
+// WithPebbleCacheSize - размер кэша блоков в байтах, 0 - как у pebble по умолчанию (8 MB)
+func WithPebbleCacheSize(n int64) PebbleOption {
+	return func(o *pebbleOptions) {
+		o.cacheSize = n
+	}
+}
+
+func (ps *PebbleStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called pebble storage Get method")
+
+	v, closer, err := ps.db.Get([]byte(key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to get key from pebble: %w", err)
+	}
+	// v живет только до closer.Close, string() делает копию
+	value = string(v)
+	closer.Close()
+	return value, nil
+}
+
+func (ps *PebbleStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called pebble storage Set method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if err = ps.db.Set([]byte(key), []byte(value), ps.sync); err != nil {
+		return fmt.Errorf("unable to put key into pebble: %w", err)
+	}
+	return nil
+}
+
+// pebble удаляет вслепую, так что наличие ключа проверяем сами. под Lock, иначе параллельный
+// Delete того же ключа тоже вернул бы успех
+func (ps *PebbleStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called pebble storage Delete method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, err = ps.get([]byte(key)); err != nil {
+		return err
+	}
+	if err = ps.db.Delete([]byte(key), ps.sync); err != nil {
+		return fmt.Errorf("unable to delete key from pebble: %w", err)
+	}
+	return nil
+}
+
+// get - Get без лога, для методов, которые сначала читают ключ
+func (ps *PebbleStorage) get(key []byte) (value []byte, err error) {
+	v, closer, err := ps.db.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get key from pebble: %w", err)
+	}
+	value = bytes.Clone(v)
+	closer.Close()
+	return value, nil
+}
+
+// each обходит итератором ключи от from (включительно) с префиксом prefix, пока fn возвращает true.
+// k и v живут только до следующего шага итератора
+func (ps *PebbleStorage) each(ctx context.Context, from, prefix []byte, fn func(k, v []byte) bool) (err error) {
+	opts := &pebble.IterOptions{LowerBound: from}
+	if len(prefix) > 0 {
+		opts.UpperBound = prefixEnd(prefix)
+	}
+	it, err := ps.db.NewIter(opts)
+	if err != nil {
+		return err
+	}
+	for ok := it.First(); ok; ok = it.Next() {
+		if err = ctx.Err(); err != nil {
+			it.Close()
+			return err
+		}
+		if !fn(it.Key(), it.Value()) {
+			break
+		}
+	}
+	if err = it.Error(); err != nil {
+		it.Close()
+		return err
+	}
+	return it.Close()
+}
+
+// prefixEnd - первый ключ, который уже не начинается с prefix. nil - у префикса из одних 0xff конца нет
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (ps *PebbleStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called pebble storage Keys method")
+
+	// pebble хранит ключи в побайтовом порядке - это тот же порядок, что дает sort.Strings
+	keys = make([]string, 0)
+	err = ps.each(ctx, nil, nil, func(k, _ []byte) bool {
+		keys = append(keys, string(k))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys in pebble: %w", err)
+	}
+	return keys, nil
+}
+
+// Scan читает только диапазон [prefix, следующий за префиксом ключ), остальную базу не трогает
+func (ps *PebbleStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	log.Println("called pebble storage Scan method")
+
+	p := []byte(prefix)
+	err = ps.each(ctx, p, p, func(k, v []byte) bool {
+		return fn(string(k), string(v))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to scan pebble: %w", err)
+	}
+	return nil
+}
+
+func (ps *PebbleStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	log.Println("called pebble storage KeysPage method")
+
+	keys = make([]string, 0, limit)
+	// ключ сразу за afterKey - он же с нулевым байтом в конце
+	from := []byte(afterKey + "\x00")
+	if afterKey == "" {
+		from = nil
+	}
+	err = ps.each(ctx, from, nil, func(k, _ []byte) bool {
+		keys = append(keys, string(k))
+		return len(keys) < limit
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys in pebble: %w", err)
+	}
+	return keys, nil
+}
+
+func (ps *PebbleStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called pebble storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	for _, k := range keys {
+		v, err := ps.get([]byte(k))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		kv[k] = string(v)
+	}
+	return kv, nil
+}
+
+// SetMany пишет весь батч одним pebble.Batch, он ложится в журнал атомарно
+func (ps *PebbleStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called pebble storage SetMany method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	b := ps.db.NewBatch()
+	defer b.Close()
+	for k, v := range kv {
+		if err = b.Set([]byte(k), []byte(v), nil); err != nil {
+			return fmt.Errorf("unable to put keys into pebble: %w", err)
+		}
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if err = b.Commit(ps.sync); err != nil {
+		return fmt.Errorf("unable to put keys into pebble: %w", err)
+	}
+	return nil
+}
+
+func (ps *PebbleStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	log.Println("called pebble storage CompareAndSwap method")
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	v, err := ps.get([]byte(key))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil || string(v) != old {
+		return false, err
+	}
+	if err = ps.db.Set([]byte(key), []byte(new), ps.sync); err != nil {
+		return false, fmt.Errorf("unable to swap key in pebble: %w", err)
+	}
+	return true, nil
+}
+
+func (ps *PebbleStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	log.Println("called pebble storage SetIfAbsent method")
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, err = ps.get([]byte(key)); !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if err = ps.db.Set([]byte(key), []byte(value), ps.sync); err != nil {
+		return false, fmt.Errorf("unable to put key into pebble: %w", err)
+	}
+	return true, nil
+}
+
+func (ps *PebbleStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	log.Println("called pebble storage Increment method")
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	v, err := ps.get([]byte(key))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+	if value, err = addInt(string(v), err == nil, delta); err != nil {
+		return 0, err
+	}
+	if err = ps.db.Set([]byte(key), []byte(strconv.FormatInt(value, 10)), ps.sync); err != nil {
+		return 0, fmt.Errorf("unable to increment key in pebble: %w", err)
+	}
+	return value, nil
+}
+
+func (ps *PebbleStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	log.Println("called pebble storage Dump method")
+
+	kv = make(map[string]string)
+	err = ps.each(ctx, nil, nil, func(k, v []byte) bool {
+		kv[string(k)] = string(v)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to dump pebble: %w", err)
+	}
+	return kv, nil
+}
+
+// Replace удаляет весь диапазон ключей и пишет новые одним батчем, так что читатели видят
+// либо старые данные, либо новые
+func (ps *PebbleStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called pebble storage Replace method")
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	b := ps.db.NewBatch()
+	defer b.Close()
+	// у диапазона удаления нужен конец, а ключ может состоять из одних 0xff. поэтому конец - сразу за последним ключом базы
+	last, err := ps.lastKey()
+	if err != nil {
+		return fmt.Errorf("unable to replace pebble contents: %w", err)
+	}
+	if last != nil {
+		if err = b.DeleteRange([]byte{}, append(last, 0), nil); err != nil {
+			return fmt.Errorf("unable to replace pebble contents: %w", err)
+		}
+	}
+	for k, v := range kv {
+		if err = b.Set([]byte(k), []byte(v), nil); err != nil {
+			return fmt.Errorf("unable to replace pebble contents: %w", err)
+		}
+	}
+	if err = b.Commit(ps.sync); err != nil {
+		return fmt.Errorf("unable to replace pebble contents: %w", err)
+	}
+	return nil
+}
+
+// lastKey - последний ключ базы, nil - база пустая
+func (ps *PebbleStorage) lastKey() (key []byte, err error) {
+	it, err := ps.db.NewIter(nil)
+	if err != nil {
+		return nil, err
+	}
+	if it.Last() {
+		key = bytes.Clone(it.Key())
+	}
+	if err = it.Error(); err != nil {
+		it.Close()
+		return nil, err
+	}
+	return key, it.Close()
+}
+
+// Close сбрасывает memtable на диск, чтобы следующий Open не проигрывал журнал заново
+func (ps *PebbleStorage) Close() (err error) {
+	log.Println("called pebble storage Close method")
+
+	if err = ps.db.Flush(); err != nil {
+		ps.db.Close()
+		return fmt.Errorf("unable to flush pebble: %w", err)
+	}
+	return ps.db.Close()
+}
+
+// NewPebbleStorage открывает базу pebble в директории dir, создавая ее при необходимости.
+// pebble сам держит блокировку директории, второй процесс на ней получит ошибку
+func NewPebbleStorage(dir string, opts ...PebbleOption) (Storage, error) {
+	o := pebbleOptions{sync: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	popts := &pebble.Options{}
+	if o.cacheSize > 0 {
+		cache := pebble.NewCache(o.cacheSize)
+		defer cache.Unref() // Open берет свою ссылку на кэш
+		popts.Cache = cache
+	}
+	db, err := pebble.Open(dir, popts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open pebble db %s: %w", dir, err)
+	}
+
+	wo := pebble.Sync
+	if !o.sync {
+		wo = pebble.NoSync
+	}
+	return &PebbleStorage{db: db, sync: wo}, nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// PrefixDeleter - хранилка, которая удаляет все ключи с префиксом одной операцией, а не по ключу
+type PrefixDeleter interface {
+	// DeletePrefix удаляет ключи, начинающиеся с prefix, и возвращает, сколько удалила. пустой префикс удаляет все
+	DeletePrefix(ctx context.Context, prefix string) (deleted int, err error)
+}
+
+// DeletePrefix удаляет ключи с префиксом. у кого нет своего DeletePrefix, собираем через Scan и Delete по ключу,
+// так что это уже не атомарно: ошибка посреди оставит часть ключей удаленными
+func DeletePrefix(ctx context.Context, s Storage, prefix string) (deleted int, err error) {
+	if pd, ok := As[PrefixDeleter](s); ok {
+		return pd.DeletePrefix(ctx, prefix)
+	}
+	var keys []string
+	err = Scan(ctx, s, prefix, func(key, _ string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		err = s.Delete(ctx, k)
+		if errors.Is(err, ErrNotFound) { // ключ успели удалить между Scan и Delete
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// ReadOnlyStorage отвергает все записи с ErrReadOnly, пока поднят флаг. флаг общий и его можно
+// переключать на лету, так что один флаг может закрыть на запись сразу несколько хранилок.
+// Unwrap есть, поэтому пишущие возможности переопределены здесь, как и в AuditedStorage
+type ReadOnlyStorage struct {
+	Storage
+	readOnly *atomic.Bool
+}
+
+func (ro *ReadOnlyStorage) check() error {
+	if ro.readOnly.Load() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+func (ro *ReadOnlyStorage) Set(ctx context.Context, key, value string) (err error) {
+	if err = ro.check(); err != nil {
+		return err
+	}
+	return ro.Storage.Set(ctx, key, value)
+}
+
+func (ro *ReadOnlyStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	if err = ro.check(); err != nil {
+		return err
+	}
+	return ro.Storage.SetMany(ctx, kv)
+}
+
+func (ro *ReadOnlyStorage) Delete(ctx context.Context, key string) (err error) {
+	if err = ro.check(); err != nil {
+		return err
+	}
+	return ro.Storage.Delete(ctx, key)
+}
+
+func (ro *ReadOnlyStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](ro.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err = ro.check(); err != nil {
+		return err
+	}
+	return es.SetWithTTL(ctx, key, value, ttl)
+}
+
+func (ro *ReadOnlyStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](ro.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if err = ro.check(); err != nil {
+		return false, err
+	}
+	return cs.CompareAndSwap(ctx, key, old, new)
+}
+
+func (ro *ReadOnlyStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](ro.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if err = ro.check(); err != nil {
+		return false, err
+	}
+	return cs.SetIfAbsent(ctx, key, value)
+}
+
+// в режиме только для чтения GetOrSet отвергается целиком, даже если ключ есть: иначе клиенту
+// пришлось бы разбирать, когда он получил значение, а когда ошибку
+func (ro *ReadOnlyStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	if err = ro.check(); err != nil {
+		return "", false, err
+	}
+	return GetOrSet(ctx, ro.Storage, key, defaultValue)
+}
+
+func (ro *ReadOnlyStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	if err = ro.check(); err != nil {
+		return 0, err
+	}
+	return Append(ctx, ro.Storage, key, suffix)
+}
+
+func (ro *ReadOnlyStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](ro.Storage)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	if err = ro.check(); err != nil {
+		return 0, err
+	}
+	return inc.Increment(ctx, key, delta)
+}
+
+func (ro *ReadOnlyStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	if err = ro.check(); err != nil {
+		return "", err
+	}
+	return Undelete(ctx, ro.Storage, key)
+}
+
+func (ro *ReadOnlyStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	if err = ro.check(); err != nil {
+		return err
+	}
+	return Rename(ctx, ro.Storage, oldKey, newKey, overwrite)
+}
+
+func (ro *ReadOnlyStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	if err = ro.check(); err != nil {
+		return err
+	}
+	return Txn(ctx, ro.Storage, ops)
+}
+
+func (ro *ReadOnlyStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	if err = ro.check(); err != nil {
+		return 0, err
+	}
+	return DeletePrefix(ctx, ro.Storage, prefix)
+}
+
+func (ro *ReadOnlyStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	if err = ro.check(); err != nil {
+		return err
+	}
+	return Replace(ctx, ro.Storage, kv)
+}
+
+// Dump нужен, чтобы As[Dumper] не нашел Replace у бэкенда в обход флага
+func (ro *ReadOnlyStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return Dump(ctx, ro.Storage)
+}
+
+func (ro *ReadOnlyStorage) Unwrap() Storage {
+	return ro.Storage
+}
+
+// NewReadOnlyStorage закрывает s на запись, пока readOnly == true
+func NewReadOnlyStorage(s Storage, readOnly *atomic.Bool) Storage {
+	return &ReadOnlyStorage{Storage: s, readOnly: readOnly}
+}
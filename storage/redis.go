@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redis
+type RedisStorage struct {
+	client *redis.Client
+}
+
+// wrapRedisErr отделяет ответ сервера с ошибкой от проблем со связью.
+// все, что не пришло от самого redis (таймауты, отказ в соединении, закрытый клиент), считаем недоступностью
+func wrapRedisErr(op string, err error) error {
+	var rerr redis.Error
+	if errors.As(err, &rerr) || errors.Is(err, context.Canceled) { // отмену запроса клиентом недоступностью не считаем
+		return fmt.Errorf("unable to %s: %w", op, err)
+	}
+	return fmt.Errorf("unable to %s: %w: %w", op, ErrUnavailable, err)
+}
+
+func (rs *RedisStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called redis storage Get method")
+
+	value, err = rs.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", wrapRedisErr("get key", err)
+	}
+	return value, nil
+}
+
+func (rs *RedisStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called redis storage Set method")
+
+	if err = rs.client.Set(ctx, key, value, 0).Err(); err != nil {
+		return wrapRedisErr("set key", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called redis storage Delete method")
+
+	n, err := rs.client.Del(ctx, key).Result()
+	if err != nil {
+		return wrapRedisErr("delete key", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (rs *RedisStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called redis storage Keys method")
+
+	// SCAN вместо KEYS, чтобы не блокировать redis на большой базе
+	keys = make([]string, 0)
+	iter := rs.client.Scan(ctx, 0, "*", 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err = iter.Err(); err != nil {
+		return nil, wrapRedisErr("scan keys", err)
+	}
+	sort.Strings(keys) // SCAN отдает ключи в произвольном порядке и может повторять их
+	return slices.Compact(keys), nil
+}
+
+func (rs *RedisStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called redis storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return kv, nil
+	}
+	values, err := rs.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, wrapRedisErr("get keys", err)
+	}
+	for i, v := range values {
+		if s, ok := v.(string); ok { // для отсутствующих ключей MGET возвращает nil
+			kv[keys[i]] = s
+		}
+	}
+	return kv, nil
+}
+
+func (rs *RedisStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called redis storage SetMany method")
+
+	if len(kv) == 0 {
+		return nil
+	}
+	if err = rs.client.MSet(ctx, kv).Err(); err != nil {
+		return wrapRedisErr("set keys", err)
+	}
+	return nil
+}
+
+// INCRBY атомарен на стороне сервера
+func (rs *RedisStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	log.Println("called redis storage Increment method")
+
+	value, err = rs.client.IncrBy(ctx, key, delta).Result()
+	var rerr redis.Error
+	if errors.As(err, &rerr) && (strings.Contains(err.Error(), "not an integer") || strings.Contains(err.Error(), "overflow")) {
+		return 0, fmt.Errorf("%w: %w", ErrNotNumeric, err)
+	}
+	if err != nil {
+		return 0, wrapRedisErr("increment key", err)
+	}
+	return value, nil
+}
+
+func (rs *RedisStorage) Ping() (err error) {
+	if err = rs.client.Ping(context.Background()).Err(); err != nil {
+		return wrapRedisErr("ping", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) Close() (err error) {
+	log.Println("called redis storage Close method")
+	return rs.client.Close()
+}
+
+func NewRedisStorage(addr, password string, db int) (Storage, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	// проверяем соединение сразу, а не на первом запросе
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to connect to redis %s: %w", addr, wrapRedisErr("ping", err))
+	}
+	return &RedisStorage{client: client}, nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, Storage) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	s, err := NewRedisStorage(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.(*RedisStorage).Close() })
+	return mr, s
+}
+
+func TestRedisStorage(t *testing.T) {
+	ctx := context.Background()
+	_, s := newRedis(t)
+	if err := s.SetMany(ctx, map[string]string{"a": "1", "b": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, "c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(ctx, "a"); err != nil || v != "1" {
+		t.Errorf("Get(a) = %q, %v", v, err)
+	}
+	if kv, err := s.GetMany(ctx, []string{"b", "missing"}); err != nil || len(kv) != 1 || kv["b"] != "2" {
+		t.Errorf("GetMany = %v, %v", kv, err)
+	}
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for op, err := range map[string]error{"Get": func() error { _, err := s.Get(ctx, "a"); return err }(), "Delete": s.Delete(ctx, "a")} {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s of a deleted key: got %v, want ErrNotFound", op, err)
+		}
+	}
+	if keys, err := s.Keys(ctx); err != nil || len(keys) != 2 || keys[0] != "b" || keys[1] != "c" {
+		t.Errorf("Keys = %v, %v", keys, err)
+	}
+}
+
+// ответ redis с ошибкой - это ошибка запроса, а упавший сервер - ErrUnavailable, из которой выходит 503
+func TestRedisStorageErrors(t *testing.T) {
+	ctx := context.Background()
+	mr, s := newRedis(t)
+	mr.Lpush("queue", "x")
+	if _, err := s.Get(ctx, "queue"); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Errorf("Get of a list: got %v, want a plain redis error", err)
+	}
+
+	addr := mr.Addr()
+	mr.Close()
+	// все методы идут через wrapRedisErr, а каждый вызов к мертвому серверу ждет ретраев go-redis,
+	// так что хватает одного
+	if err := s.Set(ctx, "k", "v"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Set with redis down: got %v, want ErrUnavailable", err)
+	}
+	if _, err := NewRedisStorage(addr, "", 0); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("connect to a stopped redis: got %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// StorageRegistry - хранилки по именам, их регистрируют на старте из конфига.
+// имя попадает в путь /storage/{backend}, поэтому алфавит у него тот же, что у бакетов
+type StorageRegistry struct {
+	mu       sync.RWMutex
+	backends map[string]Storage
+}
+
+func (sr *StorageRegistry) Register(name string, s Storage) error {
+	if !bucketNameRe.MatchString(name) {
+		return fmt.Errorf("invalid backend name %q: want 1-64 characters of A-Z, a-z, 0-9, '_' or '-'", name)
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if _, ok := sr.backends[name]; ok {
+		return fmt.Errorf("backend %q is already registered", name)
+	}
+	sr.backends[name] = s
+	return nil
+}
+
+func (sr *StorageRegistry) Lookup(name string) (s Storage, ok bool) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	s, ok = sr.backends[name]
+	return s, ok
+}
+
+func (sr *StorageRegistry) Names() []string {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	names := make([]string, 0, len(sr.backends))
+	for name := range sr.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close закрывает все зарегистрированные хранилки, реестр при этом пустеет
+func (sr *StorageRegistry) Close() error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	var errs []error
+	for name, s := range sr.backends {
+		if c, ok := As[io.Closer](s); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("unable to close backend %s: %w", name, err))
+			}
+		}
+		delete(sr.backends, name)
+	}
+	return errors.Join(errs...)
+}
+
+func NewStorageRegistry() *StorageRegistry {
+	return &StorageRegistry{backends: make(map[string]Storage)}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Renamer - хранилка, которая переименовывает ключ за один шаг: нет момента, когда есть оба ключа или ни одного
+type Renamer interface {
+	// Rename переносит значение oldKey в newKey вместе с ttl и метаданными. нет oldKey - ErrNotFound,
+	// newKey уже есть и overwrite == false - ErrExists
+	Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error)
+}
+
+// Rename переименовывает ключ. у кого нет своего Rename, переносим через Get, Set и Delete
+func Rename(ctx context.Context, s Storage, oldKey, newKey string, overwrite bool) (err error) {
+	if rn, ok := As[Renamer](s); ok {
+		return rn.Rename(ctx, oldKey, newKey, overwrite)
+	}
+	return renameByCopy(ctx, s, oldKey, newKey, overwrite)
+}
+
+// renameByCopy - Rename из обычных вызовов. между ними другой клиент видит оба ключа сразу, ttl теряется,
+// а без overwrite ключ переносится через SetIfAbsent, если хранилка его умеет, иначе проверка и запись не атомарны
+func renameByCopy(ctx context.Context, s Storage, oldKey, newKey string, overwrite bool) (err error) {
+	value, err := s.Get(ctx, oldKey)
+	if err != nil || oldKey == newKey {
+		return err
+	}
+	cs, conditional := As[ConditionalStorage](s)
+	switch {
+	case overwrite:
+		err = s.Set(ctx, newKey, value)
+	case conditional:
+		var set bool
+		if set, err = cs.SetIfAbsent(ctx, newKey, value); err == nil && !set {
+			err = fmt.Errorf("%w: %q", ErrExists, newKey)
+		}
+	default:
+		if _, err = s.Get(ctx, newKey); err == nil {
+			return fmt.Errorf("%w: %q", ErrExists, newKey)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		err = s.Set(ctx, newKey, value)
+	}
+	if err != nil {
+		return err
+	}
+	if err = s.Delete(ctx, oldKey); errors.Is(err, ErrNotFound) {
+		return nil // старый ключ успели удалить, значение уже на новом месте
+	}
+	return err
+}
+
+// renameRecords собирает записи журнала для переименования, ничего не меняя: новый ключ со значением, ttl
+// и метаданными старого и удаление старого. FileStorage сначала пишет их в файл и только потом применяет
+// к памяти, так что неудачная запись не оставляет в памяти того, чего нет на диске. вызывается под блокировкой
+func (ms *MemStorage) renameRecords(oldKey, newKey string, overwrite bool) (recs []logRecord, err error) {
+	if _, ok := ms.lookup(oldKey); !ok {
+		return nil, ErrNotFound
+	}
+	if oldKey == newKey {
+		return nil, nil
+	}
+	if _, ok := ms.lookup(newKey); ok && !overwrite {
+		return nil, fmt.Errorf("%w: %q", ErrExists, newKey)
+	}
+	now := ms.clock()()
+	rec := ms.record(oldKey)
+	rec.Key = newKey
+	rec.Updated = now.Format(time.RFC3339Nano)
+	if rec.Created == "" { // метаданных нет только у ключей, загруженных из совсем старых файлов
+		rec.Created, rec.Writes = rec.Updated, "1"
+	}
+	return []logRecord{rec, {Op: opDelete, Key: oldKey, Deleted: now.Format(time.RFC3339Nano)}}, nil
+}
+
+// applyRecords применяет к памяти записи, которые уже легли в журнал, вызывается под блокировкой
+func (ms *MemStorage) applyRecords(recs []logRecord) (err error) {
+	for _, rec := range recs {
+		if err = applyRecord(ms, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// RetryStorage повторяет вызовы, упавшие с временной ошибкой, с паузой, растущей вдвое.
+// повторяются только идемпотентные операции: CAS, SetIfAbsent и Increment As находит у бэкенда напрямую,
+// потому что их повтор после потерянного ответа мог бы выполнить запись второй раз
+type RetryStorage struct {
+	Storage
+	retryOptions
+	attempts  int           // всего попыток, включая первую
+	baseDelay time.Duration // пауза перед первым повтором
+
+	retries atomic.Uint64
+}
+
+// RetryOption настраивает NewRetryStorage
+type RetryOption func(*retryOptions)
+
+type retryOptions struct {
+	jitter    float64 // доля паузы, на которую она случайно сокращается, чтобы клиенты не повторяли хором
+	maxDelay  time.Duration
+	retryable func(error) bool
+
+	sleep func(ctx context.Context, d time.Duration) error // подменяется в тестах, чтобы не ждать реального времени
+}
+
+// WithRetryJitter случайно сокращает каждую паузу на долю до fraction, от 0 до 1
+func WithRetryJitter(fraction float64) RetryOption {
+	return func(o *retryOptions) { o.jitter = min(max(fraction, 0), 1) }
+}
+
+// WithRetryMaxDelay ограничивает рост паузы
+func WithRetryMaxDelay(d time.Duration) RetryOption {
+	return func(o *retryOptions) { o.maxDelay = d }
+}
+
+// WithRetryable задает, какие ошибки повторять. ErrNotFound и отмену контекста вызова не повторяем никогда
+func WithRetryable(retryable func(error) bool) RetryOption {
+	return func(o *retryOptions) { o.retryable = retryable }
+}
+
+// IsTransient - что повторяет RetryStorage по умолчанию: недоступный бэкенд и истекший дедлайн одной попытки.
+// открытый breaker не повторяем - он как раз просит бэкенд не трогать
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrUnavailable) && !errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Retries - сколько повторов сделано с запуска
+func (rs *RetryStorage) Retries() uint64 {
+	return rs.retries.Load()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (rs *RetryStorage) delay(attempt int) time.Duration {
+	d := rs.baseDelay << (attempt - 1)
+	if d <= 0 || rs.maxDelay > 0 && d > rs.maxDelay { // <= 0 - сдвиг переполнился
+		d = rs.maxDelay
+	}
+	if rs.jitter > 0 {
+		d -= time.Duration(rand.Float64() * rs.jitter * float64(d))
+	}
+	return d
+}
+
+// retry вызывает fn, пока она не удастся, не вернет неповторяемую ошибку или не кончатся попытки.
+// отмена ctx во время паузы возвращает последнюю ошибку вызова, а не ошибку контекста
+func retry[T any](ctx context.Context, rs *RetryStorage, fn func() (T, error)) (v T, err error) {
+	for attempt := 1; ; attempt++ {
+		v, err = fn()
+		if err == nil || attempt >= rs.attempts || errors.Is(err, ErrNotFound) || ctx.Err() != nil || !rs.retryable(err) {
+			return v, err
+		}
+		if rs.sleep(ctx, rs.delay(attempt)) != nil {
+			return v, err
+		}
+		rs.retries.Add(1)
+	}
+}
+
+// noValue - для операций, которые возвращают только ошибку
+type noValue struct{}
+
+func (rs *RetryStorage) Get(ctx context.Context, key string) (value string, err error) {
+	return retry(ctx, rs, func() (string, error) { return rs.Storage.Get(ctx, key) })
+}
+
+func (rs *RetryStorage) Set(ctx context.Context, key, value string) (err error) {
+	_, err = retry(ctx, rs, func() (noValue, error) { return noValue{}, rs.Storage.Set(ctx, key, value) })
+	return err
+}
+
+// Delete на повторе может получить ErrNotFound, если ключ удалила попытка, ответ которой потерялся.
+// отличить это от ключа, которого не было, нельзя, так что ErrNotFound после повтора считаем удачей
+func (rs *RetryStorage) Delete(ctx context.Context, key string) (err error) {
+	tries := 0
+	_, err = retry(ctx, rs, func() (noValue, error) {
+		tries++
+		err := rs.Storage.Delete(ctx, key)
+		if tries > 1 && errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		return noValue{}, err
+	})
+	return err
+}
+
+func (rs *RetryStorage) Keys(ctx context.Context) (keys []string, err error) {
+	return retry(ctx, rs, func() ([]string, error) { return rs.Storage.Keys(ctx) })
+}
+
+func (rs *RetryStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	return retry(ctx, rs, func() (map[string]string, error) { return rs.Storage.GetMany(ctx, keys) })
+}
+
+func (rs *RetryStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	_, err = retry(ctx, rs, func() (noValue, error) { return noValue{}, rs.Storage.SetMany(ctx, kv) })
+	return err
+}
+
+func (rs *RetryStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](rs.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	_, err = retry(ctx, rs, func() (noValue, error) { return noValue{}, es.SetWithTTL(ctx, key, value, ttl) })
+	return err
+}
+
+func (rs *RetryStorage) GetEntry(ctx context.Context, key string) (entry Entry, err error) {
+	eg, ok := As[EntryGetter](rs.Storage)
+	if !ok {
+		return entry, ErrNotSupported
+	}
+	return retry(ctx, rs, func() (Entry, error) { return eg.GetEntry(ctx, key) })
+}
+
+func (rs *RetryStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return retry(ctx, rs, func() (map[string]string, error) { return Dump(ctx, rs.Storage) })
+}
+
+// Replace подменяет данные целиком, так что его повтор безопасен
+func (rs *RetryStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	_, err = retry(ctx, rs, func() (noValue, error) { return noValue{}, Replace(ctx, rs.Storage, kv) })
+	return err
+}
+
+// Unwrap пускает As к остальным возможностям бэкенда, они идут уже без повторов
+func (rs *RetryStorage) Unwrap() Storage {
+	return rs.Storage
+}
+
+// NewRetryStorage делает до attempts попыток каждого вызова s, начиная с паузы baseDelay.
+// по умолчанию повторяются ошибки IsTransient, без разброса пауз и без верхней границы
+func NewRetryStorage(s Storage, attempts int, baseDelay time.Duration, opts ...RetryOption) Storage {
+	rs := &RetryStorage{Storage: s, attempts: attempts, baseDelay: baseDelay}
+	rs.retryable, rs.sleep = IsTransient, sleepCtx
+	for _, opt := range opts {
+		opt(&rs.retryOptions)
+	}
+	return rs
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Revision - ревизия значения для ETag. это хэш содержимого, а не счетчик, так что ее не нужно хранить:
+// она одинакова на всех бэкендах, переживает перезапуск и возвращается к прежней, если вернуть старое значение
+func Revision(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+// GetRevision читает ключ вместе с его ревизией
+func GetRevision(ctx context.Context, s Storage, key string) (value, rev string, err error) {
+	if value, err = s.Get(ctx, key); err != nil {
+		return "", "", err
+	}
+	return value, Revision(value), nil
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Client - часть клиента S3, которой пользуется S3Storage. *s3.Client ее реализует,
+// а в тестах вместо него можно подсунуть свою реализацию
+type S3Client interface {
+	GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	HeadBucket(ctx context.Context, in *s3.HeadBucketInput, opts ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// s3: каждый ключ - отдельный объект prefix+key в бакете. батчей и транзакций в S3 нет,
+// так что SetMany пишет объекты по одному и может остановиться на середине
+type S3Storage struct {
+	client S3Client
+	bucket string
+	prefix string
+}
+
+// wrapS3Err, как и wrapRedisErr, отличает ответ S3 с ошибкой от проблем со связью
+func wrapS3Err(op string, err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("unable to %s: %w", op, err)
+	}
+	return fmt.Errorf("unable to %s: %w: %w", op, ErrUnavailable, err)
+}
+
+// isS3NotFound - GetObject отвечает NoSuchKey, а HeadObject, у которого нет тела, просто NotFound
+func isS3NotFound(err error) bool {
+	var noKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noKey) || errors.As(err, &notFound)
+}
+
+func (ss *S3Storage) object(key string) *string {
+	return aws.String(ss.prefix + key)
+}
+
+func (ss *S3Storage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called s3 storage Get method")
+
+	out, err := ss.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &ss.bucket, Key: ss.object(key)})
+	if isS3NotFound(err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", wrapS3Err("get object", err)
+	}
+	defer out.Body.Close()
+
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", wrapS3Err("read object", err)
+	}
+	return string(b), nil
+}
+
+func (ss *S3Storage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called s3 storage Set method")
+
+	_, err = ss.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        &ss.bucket,
+		Key:           ss.object(key),
+		Body:          strings.NewReader(value),
+		ContentLength: aws.Int64(int64(len(value))),
+	})
+	if err != nil {
+		return wrapS3Err("put object", err)
+	}
+	return nil
+}
+
+// DeleteObject в S3 удачно завершается и на отсутствующем объекте, поэтому сначала HeadObject
+func (ss *S3Storage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called s3 storage Delete method")
+
+	if _, err = ss.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &ss.bucket, Key: ss.object(key)}); err != nil {
+		if isS3NotFound(err) {
+			return ErrNotFound
+		}
+		return wrapS3Err("head object", err)
+	}
+	if _, err = ss.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &ss.bucket, Key: ss.object(key)}); err != nil {
+		return wrapS3Err("delete object", err)
+	}
+	return nil
+}
+
+// list обходит объекты с префиксом prefix+keyPrefix после afterKey страницами ListObjectsV2.
+// S3 отдает ключи по возрастанию байт, так что сортировать ничего не нужно
+func (ss *S3Storage) list(ctx context.Context, keyPrefix, afterKey string, fn func(key string) bool) (err error) {
+	in := &s3.ListObjectsV2Input{Bucket: &ss.bucket, Prefix: aws.String(ss.prefix + keyPrefix)}
+	if afterKey != "" {
+		in.StartAfter = ss.object(afterKey)
+	}
+	pages := s3.NewListObjectsV2Paginator(ss.client, in)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return wrapS3Err("list objects", err)
+		}
+		for _, obj := range page.Contents {
+			if !fn(strings.TrimPrefix(aws.ToString(obj.Key), ss.prefix)) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (ss *S3Storage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called s3 storage Keys method")
+
+	keys = make([]string, 0)
+	err = ss.list(ctx, "", "", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, err
+}
+
+func (ss *S3Storage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	log.Println("called s3 storage KeysPage method")
+
+	keys = make([]string, 0, limit)
+	err = ss.list(ctx, "", afterKey, func(key string) bool {
+		keys = append(keys, key)
+		return len(keys) < limit
+	})
+	return keys, err
+}
+
+// Scan читает значения по одному GetObject на ключ, так что на большом префиксе это долго
+func (ss *S3Storage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	log.Println("called s3 storage Scan method")
+
+	keys := make([]string, 0)
+	if err = ss.list(ctx, prefix, "", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		v, err := ss.Get(ctx, k)
+		if errors.Is(err, ErrNotFound) { // удалили, пока мы шли по списку
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (ss *S3Storage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called s3 storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	for _, k := range keys {
+		v, err := ss.Get(ctx, k)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		kv[k] = v
+	}
+	return kv, nil
+}
+
+func (ss *S3Storage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called s3 storage SetMany method")
+
+	for k, v := range kv {
+		if err = ss.Set(ctx, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *S3Storage) Ping() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = ss.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &ss.bucket}); err != nil {
+		return wrapS3Err("head bucket", err)
+	}
+	return nil
+}
+
+// NewS3Storage берет учетные данные и регион из стандартной цепочки AWS: переменные окружения,
+// ~/.aws, роль инстанса. с endpoint ходит на него с path-style адресами, как нужно MinIO
+func NewS3Storage(bucket, prefix, endpoint string) (Storage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load aws config: %w", err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	// проверяем бакет сразу, а не на первом запросе
+	ss := &S3Storage{client: client, bucket: bucket, prefix: prefix}
+	if err := ss.Ping(); err != nil {
+		return nil, fmt.Errorf("unable to open s3 bucket %s: %w", bucket, err)
+	}
+	return ss, nil
+}
+
+// NewS3StorageWithClient - то же самое поверх готового клиента, без похода в AWS за настройками
+func NewS3StorageWithClient(client S3Client, bucket, prefix string) Storage {
+	return &S3Storage{client: client, bucket: bucket, prefix: prefix}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"strings"
+)
+
+// Scanner - хранилка, которая умеет сама обойти ключи с префиксом, не вытаскивая весь список ключей
+type Scanner interface {
+	// Scan вызывает fn для каждого ключа с префиксом prefix по возрастанию ключей, пока fn возвращает true
+	Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error)
+}
+
+// Scan обходит ключи с префиксом. у кого нет своего Scan, собираем через Keys и GetMany
+func Scan(ctx context.Context, s Storage, prefix string, fn func(key, value string) bool) (err error) {
+	if sc, ok := As[Scanner](s); ok {
+		return sc.Scan(ctx, prefix, fn)
+	}
+	all, err := s.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0)
+	for _, k := range all {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	kv, err := s.GetMany(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		v, ok := kv[k]
+		if !ok { // ключ успели удалить между Keys и GetMany
+			continue
+		}
+		if !fn(k, v) {
+			return nil
+		}
+	}
+	return nil
+}
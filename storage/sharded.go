@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// сколько точек на кольце у каждого шарда: чем больше, тем ровнее ключи делятся между шардами
+const shardReplicas = 160
+
+// ShardedStorage раскладывает ключи по нескольким хранилкам через консистентное хэширование:
+// при добавлении шарда в конец списка переезжает примерно 1/N ключей, а не почти все, как при hash % N.
+// точки на кольце считаются от номера шарда, так что порядок шардов менять нельзя - только дописывать новые
+type ShardedStorage struct {
+	shards []Storage
+	ring   []ringPoint // по возрастанию hash
+}
+
+type ringPoint struct {
+	hash  uint32
+	shard int
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// shardOf - номер шарда, владеющего ключом: первая точка кольца по часовой стрелке от хэша ключа
+func (ss *ShardedStorage) shardOf(key string) int {
+	h := hashKey(key)
+	i := sort.Search(len(ss.ring), func(i int) bool { return ss.ring[i].hash >= h })
+	if i == len(ss.ring) {
+		i = 0
+	}
+	return ss.ring[i].shard
+}
+
+func (ss *ShardedStorage) owner(key string) Storage {
+	return ss.shards[ss.shardOf(key)]
+}
+
+func (ss *ShardedStorage) Get(ctx context.Context, key string) (value string, err error) {
+	return ss.owner(key).Get(ctx, key)
+}
+
+func (ss *ShardedStorage) Set(ctx context.Context, key, value string) (err error) {
+	return ss.owner(key).Set(ctx, key, value)
+}
+
+func (ss *ShardedStorage) Delete(ctx context.Context, key string) (err error) {
+	return ss.owner(key).Delete(ctx, key)
+}
+
+// Keys собирает ключи всех шардов. ключ, который Rebalance как раз переносит, может найтись в двух, его отдаем один раз
+func (ss *ShardedStorage) Keys(ctx context.Context) (keys []string, err error) {
+	seen := make(map[string]bool)
+	keys = make([]string, 0)
+	for i, s := range ss.shards {
+		part, err := s.Keys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list keys of shard %d: %w", i, err)
+		}
+		for _, k := range part {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// KeysPage берет по странице у каждого шарда и сливает их: limit первых ключей общего списка
+// точно лежат среди limit первых ключей какого-то из шардов
+func (ss *ShardedStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	all := make([]string, 0)
+	for i, s := range ss.shards {
+		part, err := KeysPage(ctx, s, afterKey, limit)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list keys of shard %d: %w", i, err)
+		}
+		all = append(all, part...)
+	}
+	sort.Strings(all)
+	keys = make([]string, 0, min(limit, len(all)))
+	for _, k := range all {
+		if len(keys) == limit {
+			break
+		}
+		if len(keys) == 0 || keys[len(keys)-1] != k {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// Scan собирает совпадения всех шардов в память и отдает их по порядку ключей
+func (ss *ShardedStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	kv := make(map[string]string)
+	for i, s := range ss.shards {
+		err = Scan(ctx, s, prefix, func(key, value string) bool {
+			if _, dup := kv[key]; !dup || i == ss.shardOf(key) { // при дубле верим владельцу
+				kv[key] = value
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("unable to scan shard %d: %w", i, err)
+		}
+	}
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn(k, kv[k]) {
+			break
+		}
+	}
+	return nil
+}
+
+// byShard раскладывает ключи по шардам-владельцам
+func (ss *ShardedStorage) byShard(keys []string) map[int][]string {
+	groups := make(map[int][]string)
+	for _, k := range keys {
+		i := ss.shardOf(k)
+		groups[i] = append(groups[i], k)
+	}
+	return groups
+}
+
+func (ss *ShardedStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	kv = make(map[string]string, len(keys))
+	for i, group := range ss.byShard(keys) {
+		part, err := ss.shards[i].GetMany(ctx, group)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range part {
+			kv[k] = v
+		}
+	}
+	return kv, nil
+}
+
+// SetMany атомарен только в пределах шарда: если один шард упал, записи в остальные уже не откатить
+func (ss *ShardedStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	groups := make(map[int]map[string]string)
+	for k, v := range kv {
+		i := ss.shardOf(k)
+		if groups[i] == nil {
+			groups[i] = make(map[string]string)
+		}
+		groups[i][k] = v
+	}
+	for i, group := range groups {
+		if err = ss.shards[i].SetMany(ctx, group); err != nil {
+			return fmt.Errorf("unable to set keys on shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// условные записи и счетчики касаются одного ключа, так что их атомарность целиком на шарде-владельце
+
+func (ss *ShardedStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	if es, ok := As[ExpiringStorage](ss.owner(key)); ok {
+		return es.SetWithTTL(ctx, key, value, ttl)
+	}
+	return ErrNotSupported
+}
+
+func (ss *ShardedStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	if cs, ok := As[ConditionalStorage](ss.owner(key)); ok {
+		return cs.CompareAndSwap(ctx, key, old, new)
+	}
+	return false, ErrNotSupported
+}
+
+func (ss *ShardedStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	if cs, ok := As[ConditionalStorage](ss.owner(key)); ok {
+		return cs.SetIfAbsent(ctx, key, value)
+	}
+	return false, ErrNotSupported
+}
+
+func (ss *ShardedStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	return GetOrSet(ctx, ss.owner(key), key, defaultValue)
+}
+
+func (ss *ShardedStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	return Append(ctx, ss.owner(key), key, suffix)
+}
+
+func (ss *ShardedStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	if inc, ok := As[Incrementer](ss.owner(key)); ok {
+		return inc.Increment(ctx, key, delta)
+	}
+	return 0, ErrNotSupported
+}
+
+// Rename атомарный, только если оба ключа живут в одном шарде, иначе это перенос через Get, Set и Delete
+func (ss *ShardedStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	if i := ss.shardOf(oldKey); i == ss.shardOf(newKey) {
+		return Rename(ctx, ss.shards[i], oldKey, newKey, overwrite)
+	}
+	return renameByCopy(ctx, ss, oldKey, newKey, overwrite)
+}
+
+// Txn идет в шард, только если все ключи транзакции живут в нем: атомарности между шардами нет
+func (ss *ShardedStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	if len(ops) == 0 {
+		return nil
+	}
+	i := ss.shardOf(ops[0].Key)
+	for _, op := range ops[1:] {
+		if ss.shardOf(op.Key) != i {
+			return fmt.Errorf("%w: transaction keys %q and %q are in different shards", ErrNotSupported, ops[0].Key, op.Key)
+		}
+	}
+	return Txn(ctx, ss.shards[i], ops)
+}
+
+// DeletePrefix удаляет префикс в каждом шарде: после неоконченного Rebalance ключ может лежать не у своего шарда
+func (ss *ShardedStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	for i, s := range ss.shards {
+		n, err := DeletePrefix(ctx, s, prefix)
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("unable to delete prefix in shard %d: %w", i, err)
+		}
+	}
+	return deleted, nil
+}
+
+func (ss *ShardedStorage) GetEntry(ctx context.Context, key string) (entry Entry, err error) {
+	return GetEntry(ctx, ss.owner(key), key)
+}
+
+func (ss *ShardedStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	return Undelete(ctx, ss.owner(key), key)
+}
+
+func (ss *ShardedStorage) History(ctx context.Context, key string) (versions []VersionedValue, err error) {
+	return History(ctx, ss.owner(key), key)
+}
+
+func (ss *ShardedStorage) GetVersion(ctx context.Context, key string, version uint64) (value VersionedValue, err error) {
+	return GetVersion(ctx, ss.owner(key), key, version)
+}
+
+// Stats складывает статистику шардов, в Info - статистика каждого по отдельности
+func (ss *ShardedStorage) Stats(ctx context.Context) (stats StorageStats, err error) {
+	shards := make([]StorageStats, 0, len(ss.shards))
+	for i, s := range ss.shards {
+		st, err := Stats(ctx, s)
+		if err != nil {
+			return stats, fmt.Errorf("unable to get stats of shard %d: %w", i, err)
+		}
+		stats.Keys += st.Keys
+		stats.Bytes += st.Bytes
+		shards = append(shards, st)
+	}
+	stats.Backend = "sharded"
+	stats.Info = map[string]any{"shards": shards}
+	return stats, nil
+}
+
+// Ping - готовы, только когда доступны все шарды: без любого из них часть ключей не прочитать
+func (ss *ShardedStorage) Ping() (err error) {
+	for i, s := range ss.shards {
+		if p, ok := As[Pinger](s); ok {
+			if err = p.Ping(); err != nil {
+				return fmt.Errorf("shard %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (ss *ShardedStorage) Close() (err error) {
+	var errs []error
+	for i, s := range ss.shards {
+		if c, ok := As[io.Closer](s); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("unable to close shard %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Rebalancer - хранилка, которая умеет переложить ключи туда, где им положено быть
+type Rebalancer interface {
+	Rebalance(ctx context.Context, retired ...Storage) (moved int, err error)
+}
+
+// Rebalance переносит ключи, лежащие не на своем шарде, к владельцу, - после добавления шарда это ~1/N ключей.
+// retired - шарды, которые убрали из списка: их ключи переезжают целиком. ключ сначала пишется владельцу
+// и только потом удаляется со старого места, так что при ошибке посередине он окажется в двух местах, но не пропадет.
+// запись, пришедшая во время переноса, может быть перезаписана старым значением - лучше делать это без нагрузки
+func (ss *ShardedStorage) Rebalance(ctx context.Context, retired ...Storage) (moved int, err error) {
+	log.Println("called sharded storage Rebalance method")
+
+	sources := make([]Storage, 0, len(ss.shards)+len(retired))
+	sources = append(sources, ss.shards...)
+	sources = append(sources, retired...)
+	for i, s := range sources {
+		keys, err := s.Keys(ctx)
+		if err != nil {
+			return moved, fmt.Errorf("unable to list keys of shard %d: %w", i, err)
+		}
+		for _, k := range keys {
+			if i < len(ss.shards) && ss.shardOf(k) == i {
+				continue
+			}
+			v, err := s.Get(ctx, k)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return moved, err
+			}
+			if err = ss.owner(k).Set(ctx, k, v); err != nil {
+				return moved, err
+			}
+			if err = s.Delete(ctx, k); err != nil && !errors.Is(err, ErrNotFound) {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+func NewShardedStorage(shards ...Storage) (Storage, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("sharded storage needs at least one shard")
+	}
+	ss := &ShardedStorage{shards: shards, ring: make([]ringPoint, 0, len(shards)*shardReplicas)}
+	for i := range shards {
+		for r := 0; r < shardReplicas; r++ {
+			ss.ring = append(ss.ring, ringPoint{hash: hashKey(strconv.Itoa(r) + "-" + strconv.Itoa(i)), shard: i})
+		}
+	}
+	sort.Slice(ss.ring, func(a, b int) bool { return ss.ring[a].hash < ss.ring[b].hash })
+	return ss, nil
+}
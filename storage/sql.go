@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite" // драйвер без cgo, регистрируется как "sqlite"
+)
+
+// sqlite
+type SQLStorage struct {
+	db *sql.DB
+}
+
+const sqlSchema = `CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value TEXT NOT NULL)`
+
+// upsert: новый ключ вставляем, существующий перезаписываем
+const sqlUpsert = `INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`
+
+func (ss *SQLStorage) Get(ctx context.Context, key string) (value string, err error) {
+	log.Println("called sql storage Get method")
+
+	err = ss.db.QueryRowContext(ctx, `SELECT value FROM kv WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to select key: %w", err)
+	}
+	return value, nil
+}
+
+func (ss *SQLStorage) Set(ctx context.Context, key, value string) (err error) {
+	log.Println("called sql storage Set method")
+
+	if _, err = ss.db.ExecContext(ctx, sqlUpsert, key, value); err != nil {
+		return fmt.Errorf("unable to upsert key: %w", err)
+	}
+	return nil
+}
+
+func (ss *SQLStorage) Delete(ctx context.Context, key string) (err error) {
+	log.Println("called sql storage Delete method")
+
+	res, err := ss.db.ExecContext(ctx, `DELETE FROM kv WHERE key = ?`, key)
+	if err != nil {
+		return fmt.Errorf("unable to delete key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to delete key: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (ss *SQLStorage) Keys(ctx context.Context) (keys []string, err error) {
+	log.Println("called sql storage Keys method")
+
+	// сортировка BINARY в sqlite побайтовая, как и sort.Strings
+	rows, err := ss.db.QueryContext(ctx, `SELECT key FROM kv ORDER BY key`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys = make([]string, 0)
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, fmt.Errorf("unable to scan key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to list keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Scan читает диапазон от prefix по индексу первичного ключа и останавливается на первом ключе без префикса
+func (ss *SQLStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	log.Println("called sql storage Scan method")
+
+	rows, err := ss.db.QueryContext(ctx, `SELECT key, value FROM kv WHERE key >= ? ORDER BY key`, prefix)
+	if err != nil {
+		return fmt.Errorf("unable to scan keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k, v string
+		if err = rows.Scan(&k, &v); err != nil {
+			return fmt.Errorf("unable to scan key: %w", err)
+		}
+		if !strings.HasPrefix(k, prefix) || !fn(k, v) {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to scan keys: %w", err)
+	}
+	return nil
+}
+
+func (ss *SQLStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	log.Println("called sql storage KeysPage method")
+
+	rows, err := ss.db.QueryContext(ctx, `SELECT key FROM kv WHERE key > ? ORDER BY key LIMIT ?`, afterKey, limit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys = make([]string, 0, limit)
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, fmt.Errorf("unable to scan key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to list keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (ss *SQLStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	log.Println("called sql storage GetMany method")
+
+	kv = make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return kv, nil
+	}
+
+	args := make([]any, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	query := `SELECT key, value FROM kv WHERE key IN (?` + strings.Repeat(`, ?`, len(keys)-1) + `)`
+	rows, err := ss.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to select keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k, v string
+		if err = rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		kv[k] = v
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to select keys: %w", err)
+	}
+	return kv, nil
+}
+
+// SetMany пишет весь батч одной транзакцией
+func (ss *SQLStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called sql storage SetMany method")
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // после Commit ничего не делает
+
+	stmt, err := tx.PrepareContext(ctx, sqlUpsert)
+	if err != nil {
+		return fmt.Errorf("unable to prepare upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for k, v := range kv {
+		if _, err = stmt.ExecContext(ctx, k, v); err != nil {
+			return fmt.Errorf("unable to upsert key: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// условие проверяет сам UPDATE, так что гонки между чтением и записью нет
+func (ss *SQLStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	log.Println("called sql storage CompareAndSwap method")
+
+	res, err := ss.db.ExecContext(ctx, `UPDATE kv SET value = ? WHERE key = ? AND value = ?`, new, key, old)
+	if err != nil {
+		return false, fmt.Errorf("unable to swap key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unable to swap key: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (ss *SQLStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	log.Println("called sql storage SetIfAbsent method")
+
+	res, err := ss.db.ExecContext(ctx, `INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT (key) DO NOTHING`, key, value)
+	if err != nil {
+		return false, fmt.Errorf("unable to insert key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unable to insert key: %w", err)
+	}
+	return n == 1, nil
+}
+
+// Increment читает и пишет в одной транзакции, а с одним соединением транзакции и так идут по очереди
+func (ss *SQLStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	log.Println("called sql storage Increment method")
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT value FROM kv WHERE key = ?`, key).Scan(&current)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("unable to select key: %w", err)
+	}
+	if value, err = addInt(current, exists, delta); err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, sqlUpsert, key, strconv.FormatInt(value, 10)); err != nil {
+		return 0, fmt.Errorf("unable to upsert key: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return value, nil
+}
+
+func (ss *SQLStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	log.Println("called sql storage Dump method")
+
+	rows, err := ss.db.QueryContext(ctx, `SELECT key, value FROM kv`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dump table: %w", err)
+	}
+	defer rows.Close()
+
+	kv = make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err = rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		kv[k] = v
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to dump table: %w", err)
+	}
+	return kv, nil
+}
+
+// Replace чистит таблицу и заливает новые данные одной транзакцией
+func (ss *SQLStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	log.Println("called sql storage Replace method")
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM kv`); err != nil {
+		return fmt.Errorf("unable to clear table: %w", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO kv (key, value) VALUES (?, ?)`)
+	if err != nil {
+		return fmt.Errorf("unable to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for k, v := range kv {
+		if _, err = stmt.ExecContext(ctx, k, v); err != nil {
+			return fmt.Errorf("unable to insert key: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (ss *SQLStorage) Ping() (err error) {
+	return ss.db.Ping()
+}
+
+func (ss *SQLStorage) Close() (err error) {
+	log.Println("called sql storage Close method")
+	return ss.db.Close()
+}
+
+func NewSQLStorage(dsn string) (Storage, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sqlite db %s: %w", dsn, err)
+	}
+	// sqlite не любит конкурентных писателей, одно соединение избавляет от SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec(sqlSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create schema in sqlite db %s: %w", dsn, err)
+	}
+	return &SQLStorage{db: db}, nil
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// обрезанная база - это ошибка при открытии или чтении, а не тихо пропавшая половина ключей
+func TestSQLStorageTruncated(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.db")
+	s, err := NewSQLStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := make(map[string]string)
+	for i := 0; i < 500; i++ {
+		kv[fmt.Sprintf("k%03d", i)] = fmt.Sprintf("value %d, long enough to take several pages", i)
+	}
+	if err = s.SetMany(ctx, kv); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(path, st.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewSQLStorage(path)
+	if err != nil {
+		return
+	}
+	defer s.(io.Closer).Close()
+	if keys, err := s.Keys(ctx); err == nil {
+		t.Errorf("truncated db opened and listed %d of %d keys without an error", len(keys), len(kv))
+	}
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"log"
+)
+
+// StorageStats - размер хранилки. Bytes - сумма длин ключей и значений, без накладных расходов бэкенда.
+// в Info бэкенд кладет то, что есть только у него, например путь и размер файла
+type StorageStats struct {
+	Backend string         `json:"backend"`
+	Keys    int            `json:"keys"`
+	Bytes   int64          `json:"bytes"`
+	Info    map[string]any `json:"info,omitempty"`
+}
+
+// Statter - хранилка, которая знает свой размер, не перебирая все данные
+type Statter interface {
+	Stats(ctx context.Context) (stats StorageStats, err error)
+}
+
+// Stats отдает статистику хранилки. у кого нет своего Stats, тех считаем по полному дампу - это дорого,
+// так что на больших sql и redis лучше не дергать такой эндпоинт часто
+func Stats(ctx context.Context, s Storage) (stats StorageStats, err error) {
+	if st, ok := As[Statter](s); ok {
+		return st.Stats(ctx)
+	}
+	kv, err := Dump(ctx, s)
+	if err != nil {
+		return stats, err
+	}
+	stats.Keys = len(kv)
+	for k, v := range kv {
+		stats.Bytes += int64(len(k) + len(v))
+	}
+	return stats, nil
+}
+
+// ключи с истекшим ttl, которые еще не выкинула фоновая чистка, тоже попадают в счет
+func (ms *MemStorage) Stats(ctx context.Context) (stats StorageStats, err error) {
+	log.Println("called mem storage Stats method")
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	stats = StorageStats{Backend: "mem", Keys: len(ms.m), Bytes: ms.bytes}
+	if ms.lru != nil {
+		stats.Info = map[string]any{"max_entries": ms.maxEntries, "max_bytes": ms.maxBytes, "evictions": ms.Evictions()}
+	}
+	return stats, nil
+}
+
+func (fs *FileStorage) Stats(ctx context.Context) (stats StorageStats, err error) {
+	log.Println("called file storage Stats method")
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if fs.closed {
+		return stats, ErrClosed
+	}
+	return StorageStats{
+		Backend: "file",
+		Keys:    len(fs.m),
+		Bytes:   fs.bytes,
+		Info: map[string]any{
+			"path":           fs.name,
+			"file_bytes":     fs.size,
+			"records":        fs.records,
+			"pending_writes": len(fs.pending),
+		},
+	}, nil
+}
@@ -0,0 +1,151 @@
+// Package storage - хранилки ключ-значение, которые отдает наружу HTTP API
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+type Storage interface {
+	Get(ctx context.Context, key string) (value string, err error)
+	Set(ctx context.Context, key, value string) (err error)
+	Delete(ctx context.Context, key string) (err error)
+	Keys(ctx context.Context) (keys []string, err error)
+	SetMany(ctx context.Context, kv map[string]string) (err error)
+	GetMany(ctx context.Context, keys []string) (kv map[string]string, err error)
+}
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrClosed   = errors.New("storage closed")
+
+	// ErrInvalidKey - ключ нельзя сохранить в этом бэкенде (пустой, слишком длинный и т.п.)
+	ErrInvalidKey = errors.New("invalid key")
+
+	// ErrUnavailable - бэкенд сейчас недоступен (например, нет связи с сервером), запрос можно повторить позже
+	ErrUnavailable = errors.New("storage unavailable")
+
+	// ErrLocked - файл с данными уже открыт другим процессом
+	ErrLocked = errors.New("file is locked by another process")
+
+	// ErrCorrupt - файл с данными поврежден: обрезан, не сходится контрольная сумма и т.п.
+	ErrCorrupt = errors.New("data file is corrupt")
+
+	// ErrUnsupportedFormat - файл целый, но это не наш формат: JSON массив, число и т.п. в отличие от ErrCorrupt
+	// WithForce такой файл не открывает, иначе он был бы молча переписан пустым
+	ErrUnsupportedFormat = errors.New("unsupported file format")
+
+	// ErrNotSupported - декоратор пробросил вызов, который нижняя хранилка не умеет
+	ErrNotSupported = errors.New("not supported by this storage")
+
+	// ErrNotNumeric - Increment по ключу, в котором лежит не целое число
+	ErrNotNumeric = errors.New("value is not an integer")
+
+	// ErrReadOnly - хранилка сейчас только для чтения, запись отвергнута
+	ErrReadOnly = errors.New("storage is read-only")
+
+	// ErrExists - ключ уже есть, а операция ждала, что его нет
+	ErrExists = errors.New("key already exists")
+
+	// ErrTimeout - вызов не уложился в дедлайн TimeoutStorage или клиента. всегда идет вместе с context.DeadlineExceeded
+	ErrTimeout = errors.New("storage call timed out")
+)
+
+// ExpiringStorage - хранилка, которая умеет сама удалять ключи по истечении ttl.
+// это отдельный интерфейс, а не метод Storage, чтобы не заставлять реализовывать его все бэкенды
+type ExpiringStorage interface {
+	Storage
+	SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error)
+}
+
+// ConditionalStorage - хранилка с атомарными условными записями, на них можно строить блокировки и счетчики
+type ConditionalStorage interface {
+	Storage
+	// CompareAndSwap пишет new, только если сейчас в key лежит old. на отсутствующем ключе возвращает false
+	CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error)
+	// SetIfAbsent пишет value, только если ключа еще нет
+	SetIfAbsent(ctx context.Context, key, value string) (set bool, err error)
+}
+
+// GetOrSetter - хранилка, которая за один шаг отдает значение ключа или, если его нет, записывает и отдает значение по умолчанию
+type GetOrSetter interface {
+	// GetOrSet возвращает текущее значение и existed == true, а на отсутствующем ключе пишет defaultValue
+	GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error)
+}
+
+// GetOrSet - GetOrSetter.GetOrSet для любой ConditionalStorage: SetIfAbsent и Get, пока ключ не
+// удалят между ними. гонки между клиентами нет и тут, просто это два вызова вместо одного
+func GetOrSet(ctx context.Context, s Storage, key, defaultValue string) (value string, existed bool, err error) {
+	if g, ok := As[GetOrSetter](s); ok {
+		return g.GetOrSet(ctx, key, defaultValue)
+	}
+	cs, ok := As[ConditionalStorage](s)
+	if !ok {
+		return "", false, ErrNotSupported
+	}
+	for {
+		set, err := cs.SetIfAbsent(ctx, key, defaultValue)
+		if err != nil || set {
+			return defaultValue, false, err
+		}
+		value, err = s.Get(ctx, key)
+		if !errors.Is(err, ErrNotFound) {
+			return value, true, err
+		}
+	}
+}
+
+// Incrementer - хранилка с атомарным счетчиком: прочитать, прибавить и записать без гонки между клиентами
+type Incrementer interface {
+	// Increment прибавляет delta к числу в key и возвращает результат. отсутствующий ключ считается нулем
+	Increment(ctx context.Context, key string, delta int64) (value int64, err error)
+}
+
+// addInt - общая часть Increment для бэкендов, которые считают сами, а не отдают это серверу
+func addInt(current string, exists bool, delta int64) (value int64, err error) {
+	if exists {
+		if value, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrNotNumeric, current)
+		}
+	}
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		return 0, fmt.Errorf("%w: %d%+d overflows int64", ErrNotNumeric, value, delta)
+	}
+	return value + delta, nil
+}
+
+// Reloader - хранилка, которая умеет перечитать данные, измененные в обход нее
+type Reloader interface {
+	Reload() (err error)
+}
+
+// Invalidator - хранилка, которая меняет данные и без записей через нее: чистит протухшие ключи, перечитывает файл.
+// Invalidations растет после каждого такого изменения, по нему кэш поверх понимает, что пора сброситься
+type Invalidator interface {
+	Invalidations() uint64
+}
+
+// Pinger - хранилка, которая умеет проверить, что она готова обслуживать запросы
+type Pinger interface {
+	Ping() (err error)
+}
+
+// As ищет в цепочке декораторов хранилку, которая умеет T, по аналогии с errors.As.
+// декоратор пропускает поиск дальше, только если у него есть метод Unwrap() Storage
+func As[T any](s Storage) (t T, ok bool) {
+	for s != nil {
+		if t, ok = s.(T); ok {
+			return t, true
+		}
+		w, isWrapper := s.(interface{ Unwrap() Storage })
+		if !isWrapper {
+			break
+		}
+		s = w.Unwrap()
+	}
+	return t, false
+}
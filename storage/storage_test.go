@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// данные на диске переживают Close и повторное открытие
+func TestReopen(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		open func(path string) (Storage, error)
+	}{
+		{"file", func(path string) (Storage, error) { return NewFileStorage(path) }},
+		{"bolt", NewBoltStorage},
+		{"sql", NewSQLStorage},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data")
+			s, err := tt.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = s.SetMany(ctx, map[string]string{"a": "1", "b": "2", "c": "3"}); err != nil {
+				t.Fatal(err)
+			}
+			if err = s.Set(ctx, "a", "one"); err != nil {
+				t.Fatal(err)
+			}
+			if err = s.Delete(ctx, "b"); err != nil {
+				t.Fatal(err)
+			}
+			if err = s.(io.Closer).Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if s, err = tt.open(path); err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer s.(io.Closer).Close()
+			kv, err := s.GetMany(ctx, []string{"a", "b", "c"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(kv) != 2 || kv["a"] != "one" || kv["c"] != "3" {
+				t.Errorf("after reopening got %v, want a=one c=3", kv)
+			}
+			if _, err = s.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(b) after reopening: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// сколько ключей Swap переносит за один GetMany и SetMany
+const swapBatch = 1000
+
+// SwitchableStorage дает подменить бэкенд на лету. вызов, начатый на старом бэкенде, на нем и заканчивается:
+// Swap ждет, пока такие вызовы выйдут, и только потом отдает старый бэкенд для закрытия.
+// пока Swap копирует ключи, чтение идет из старого бэкенда, а записи ждут конца копирования, иначе они
+// попали бы в старый бэкенд уже после того, как его скопировали, и потерялись.
+// Unwrap отдает текущий бэкенд, так что все пишущие возможности переопределены здесь, как и в AuditedStorage
+type SwitchableStorage struct {
+	writes sync.RWMutex // записи держат на чтение, Swap - на запись все время копирования
+	use    sync.RWMutex // все вызовы держат на чтение, Swap берет на запись только для подмены cur
+	cur    Storage
+}
+
+// read отдает текущий бэкенд для чтения, done нужно вызвать после вызова бэкенда
+func (ss *SwitchableStorage) read() (s Storage, done func()) {
+	ss.use.RLock()
+	return ss.cur, ss.use.RUnlock
+}
+
+func (ss *SwitchableStorage) write() (s Storage, done func()) {
+	ss.writes.RLock()
+	ss.use.RLock()
+	return ss.cur, func() {
+		ss.use.RUnlock()
+		ss.writes.RUnlock()
+	}
+}
+
+// SwapProgress - сколько ключей уже перенесено из скольких
+type SwapProgress struct {
+	Copied int
+	Total  int
+}
+
+// Swap делает next текущим бэкендом и возвращает прежний, закрыть его - дело вызывающего.
+// с copyKeys сначала переносит в next все ключи пачками, сообщая о каждой в progress (может быть nil).
+// ttl и метаданные не переносятся. если копирование не удалось, текущим остается прежний бэкенд
+func (ss *SwitchableStorage) Swap(ctx context.Context, next Storage, copyKeys bool, progress func(SwapProgress)) (old Storage, err error) {
+	log.Println("called switchable storage Swap method")
+	ss.writes.Lock()
+	defer ss.writes.Unlock()
+
+	old = ss.cur // cur меняется только здесь, а мы держим writes
+	if copyKeys {
+		start := time.Now()
+		n, err := copyKeysInto(ctx, old, next, progress)
+		if err != nil {
+			return nil, fmt.Errorf("unable to copy keys into the new storage after %d keys: %w", n, err)
+		}
+		log.Printf("copied %d keys into the new storage in %v", n, time.Since(start))
+	}
+
+	ss.use.Lock()
+	ss.cur = next
+	ss.use.Unlock()
+	return old, nil
+}
+
+func copyKeysInto(ctx context.Context, from, to Storage, progress func(SwapProgress)) (copied int, err error) {
+	keys, err := from.Keys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(keys)
+	for len(keys) > 0 {
+		batch := keys[:min(swapBatch, len(keys))]
+		keys = keys[len(batch):]
+		kv, err := from.GetMany(ctx, batch)
+		if err != nil {
+			return copied, err
+		}
+		// пока копировали, ключ могли удалить - GetMany его просто не вернет
+		if err = to.SetMany(ctx, kv); err != nil {
+			return copied, err
+		}
+		copied += len(kv)
+		if progress != nil {
+			progress(SwapProgress{Copied: copied, Total: copied + len(keys)})
+		}
+	}
+	return copied, nil
+}
+
+func (ss *SwitchableStorage) Get(ctx context.Context, key string) (value string, err error) {
+	s, done := ss.read()
+	defer done()
+	return s.Get(ctx, key)
+}
+
+func (ss *SwitchableStorage) Keys(ctx context.Context) (keys []string, err error) {
+	s, done := ss.read()
+	defer done()
+	return s.Keys(ctx)
+}
+
+func (ss *SwitchableStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	s, done := ss.read()
+	defer done()
+	return s.GetMany(ctx, keys)
+}
+
+func (ss *SwitchableStorage) Set(ctx context.Context, key, value string) (err error) {
+	s, done := ss.write()
+	defer done()
+	return s.Set(ctx, key, value)
+}
+
+func (ss *SwitchableStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	s, done := ss.write()
+	defer done()
+	return s.SetMany(ctx, kv)
+}
+
+func (ss *SwitchableStorage) Delete(ctx context.Context, key string) (err error) {
+	s, done := ss.write()
+	defer done()
+	return s.Delete(ctx, key)
+}
+
+func (ss *SwitchableStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	s, done := ss.write()
+	defer done()
+	es, ok := As[ExpiringStorage](s)
+	if !ok {
+		return ErrNotSupported
+	}
+	return es.SetWithTTL(ctx, key, value, ttl)
+}
+
+func (ss *SwitchableStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	s, done := ss.write()
+	defer done()
+	cs, ok := As[ConditionalStorage](s)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return cs.CompareAndSwap(ctx, key, old, new)
+}
+
+func (ss *SwitchableStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	s, done := ss.write()
+	defer done()
+	cs, ok := As[ConditionalStorage](s)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return cs.SetIfAbsent(ctx, key, value)
+}
+
+func (ss *SwitchableStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	s, done := ss.write()
+	defer done()
+	return GetOrSet(ctx, s, key, defaultValue)
+}
+
+func (ss *SwitchableStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	s, done := ss.write()
+	defer done()
+	return Append(ctx, s, key, suffix)
+}
+
+func (ss *SwitchableStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	s, done := ss.write()
+	defer done()
+	inc, ok := As[Incrementer](s)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return inc.Increment(ctx, key, delta)
+}
+
+func (ss *SwitchableStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	s, done := ss.write()
+	defer done()
+	return Undelete(ctx, s, key)
+}
+
+func (ss *SwitchableStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	s, done := ss.read()
+	defer done()
+	return Dump(ctx, s)
+}
+
+func (ss *SwitchableStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	s, done := ss.write()
+	defer done()
+	return Rename(ctx, s, oldKey, newKey, overwrite)
+}
+
+func (ss *SwitchableStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	s, done := ss.write()
+	defer done()
+	return Txn(ctx, s, ops)
+}
+
+func (ss *SwitchableStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	s, done := ss.write()
+	defer done()
+	return DeletePrefix(ctx, s, prefix)
+}
+
+func (ss *SwitchableStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	s, done := ss.write()
+	defer done()
+	return Replace(ctx, s, kv)
+}
+
+// Close закрывает текущий бэкенд. прежние закрывает тот, кто их подменил
+func (ss *SwitchableStorage) Close() (err error) {
+	s, done := ss.read()
+	defer done()
+	if c, ok := As[io.Closer](s); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Unwrap отдает текущий бэкенд. найденное через As после Swap продолжает работать со старым
+func (ss *SwitchableStorage) Unwrap() Storage {
+	s, done := ss.read()
+	defer done()
+	return s
+}
+
+func NewSwitchableStorage(s Storage) *SwitchableStorage {
+	return &SwitchableStorage{cur: s}
+}
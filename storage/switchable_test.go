@@ -0,0 +1,134 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+func seeded(t *testing.T, n int) storage.Storage {
+	t.Helper()
+	kv := make(map[string]string, n)
+	for i := range n {
+		kv[fmt.Sprintf("k%04d", i)] = fmt.Sprint(i)
+	}
+	s := storage.NewMemStorage()
+	if err := s.SetMany(context.Background(), kv); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSwapWithCopy(t *testing.T) {
+	ctx := context.Background()
+	old := seeded(t, 2500) // больше одной пачки swapBatch
+	ss := storage.NewSwitchableStorage(old)
+	next := storage.NewMemStorage()
+
+	var last storage.SwapProgress
+	got, err := ss.Swap(ctx, next, true, func(p storage.SwapProgress) { last = p })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != old {
+		t.Error("Swap did not return the previous backend")
+	}
+	if last.Copied != 2500 || last.Total != 2500 {
+		t.Errorf("last progress %+v, want 2500 of 2500", last)
+	}
+	if v, err := ss.Get(ctx, "k1234"); err != nil || v != "1234" {
+		t.Errorf("Get after the swap = %q, %v", v, err)
+	}
+	if err = ss.Set(ctx, "new", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = old.Get(ctx, "new"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("a write after the swap reached the old backend: %v", err)
+	}
+}
+
+func TestSwapWithoutCopy(t *testing.T) {
+	ctx := context.Background()
+	ss := storage.NewSwitchableStorage(seeded(t, 10))
+	if _, err := ss.Swap(ctx, storage.NewMemStorage(), false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := ss.Keys(ctx); err != nil || len(keys) != 0 {
+		t.Errorf("Keys after a swap without copying = %v, %v", keys, err)
+	}
+}
+
+// fullStorage не принимает пачки записей
+type fullStorage struct {
+	storage.Storage
+	err error
+}
+
+func (fs fullStorage) SetMany(context.Context, map[string]string) error { return fs.err }
+
+// не удалось скопировать - работаем дальше на старом бэкенде
+func TestSwapFailedCopy(t *testing.T) {
+	ctx := context.Background()
+	old := seeded(t, 10)
+	ss := storage.NewSwitchableStorage(old)
+	boom := errors.New("disk full")
+	next := fullStorage{storage.NewMemStorage(), boom}
+
+	if _, err := ss.Swap(ctx, next, true, nil); !errors.Is(err, boom) {
+		t.Fatalf("Swap: got %v, want %v", err, boom)
+	}
+	if err := ss.Set(ctx, "after", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := old.Get(ctx, "after"); err != nil || v != "v" {
+		t.Errorf("write after a failed swap went elsewhere: %q, %v", v, err)
+	}
+}
+
+// чтения и записи во время Swap не падают и не теряются
+func TestSwapConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ss := storage.NewSwitchableStorage(seeded(t, 2000))
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	var written sync.Map
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				if v, err := ss.Get(ctx, "k0042"); err != nil || v != "42" {
+					t.Errorf("Get during the swap = %q, %v", v, err)
+					return
+				}
+				key := fmt.Sprintf("w%d/%d", w, i)
+				if err := ss.Set(ctx, key, "v"); err != nil {
+					t.Errorf("Set during the swap: %v", err)
+					return
+				}
+				written.Store(key, true)
+			}
+		}()
+	}
+	for range 5 {
+		if _, err := ss.Swap(ctx, storage.NewMemStorage(), true, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	written.Range(func(k, _ any) bool {
+		if _, err := ss.Get(ctx, k.(string)); err != nil {
+			t.Errorf("write of %s was lost in a swap: %v", k, err)
+			return false
+		}
+		return true
+	})
+}
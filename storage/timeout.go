@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TimeoutStorage ставит дедлайн на каждый вызов хранилки, чтобы зависший бэкенд не держал запросы вечно.
+// дедлайн накладывается на контекст вызова, так что более короткий дедлайн клиента по-прежнему работает.
+// бэкенды, которые контекст не смотрят (память, файл на зависшем диске), вызов сам не прервет, поэтому
+// он идет в отдельной горутине: по дедлайну вызывающий сразу получает ErrTimeout, а горутина доживает
+// до ответа бэкенда, и результат выбрасывается. брошенная так запись может все-таки выполниться
+type TimeoutStorage struct {
+	Storage
+	timeout time.Duration
+}
+
+// timed выполняет fn с дедлайном декоратора
+func timed[T any](ctx context.Context, ts *TimeoutStorage, fn func(ctx context.Context) (T, error)) (v T, err error) {
+	ctx, cancel := context.WithTimeout(ctx, ts.timeout)
+	defer cancel()
+
+	type result struct {
+		v     T
+		err   error
+		panic any
+	}
+	done := make(chan result, 1) // с буфером, чтобы брошенная горутина не зависла на отправке
+	go func() {
+		var r result
+		defer func() {
+			r.panic = recover()
+			done <- r
+		}()
+		r.v, r.err = fn(ctx)
+	}()
+	select {
+	case r := <-done:
+		if r.panic != nil {
+			panic(r.panic) // паника бэкенда должна дойти до recover сервера, а не уронить процесс в чужой горутине
+		}
+		v, err = r.v, r.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return v, err
+}
+
+func (ts *TimeoutStorage) Get(ctx context.Context, key string) (value string, err error) {
+	return timed(ctx, ts, func(ctx context.Context) (string, error) { return ts.Storage.Get(ctx, key) })
+}
+
+func (ts *TimeoutStorage) Set(ctx context.Context, key, value string) (err error) {
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) { return noValue{}, ts.Storage.Set(ctx, key, value) })
+	return err
+}
+
+func (ts *TimeoutStorage) Delete(ctx context.Context, key string) (err error) {
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) { return noValue{}, ts.Storage.Delete(ctx, key) })
+	return err
+}
+
+func (ts *TimeoutStorage) Keys(ctx context.Context) (keys []string, err error) {
+	return timed(ctx, ts, func(ctx context.Context) ([]string, error) { return ts.Storage.Keys(ctx) })
+}
+
+func (ts *TimeoutStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	return timed(ctx, ts, func(ctx context.Context) (map[string]string, error) { return ts.Storage.GetMany(ctx, keys) })
+}
+
+func (ts *TimeoutStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) { return noValue{}, ts.Storage.SetMany(ctx, kv) })
+	return err
+}
+
+func (ts *TimeoutStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](ts.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) { return noValue{}, es.SetWithTTL(ctx, key, value, ttl) })
+	return err
+}
+
+func (ts *TimeoutStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](ts.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return timed(ctx, ts, func(ctx context.Context) (bool, error) { return cs.CompareAndSwap(ctx, key, old, new) })
+}
+
+func (ts *TimeoutStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](ts.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return timed(ctx, ts, func(ctx context.Context) (bool, error) { return cs.SetIfAbsent(ctx, key, value) })
+}
+
+func (ts *TimeoutStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	type result struct {
+		value   string
+		existed bool
+	}
+	r, err := timed(ctx, ts, func(ctx context.Context) (r result, err error) {
+		r.value, r.existed, err = GetOrSet(ctx, ts.Storage, key, defaultValue)
+		return r, err
+	})
+	return r.value, r.existed, err
+}
+
+func (ts *TimeoutStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	return timed(ctx, ts, func(ctx context.Context) (int, error) { return Append(ctx, ts.Storage, key, suffix) })
+}
+
+func (ts *TimeoutStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](ts.Storage)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return timed(ctx, ts, func(ctx context.Context) (int64, error) { return inc.Increment(ctx, key, delta) })
+}
+
+func (ts *TimeoutStorage) GetEntry(ctx context.Context, key string) (entry Entry, err error) {
+	return timed(ctx, ts, func(ctx context.Context) (Entry, error) { return GetEntry(ctx, ts.Storage, key) })
+}
+
+func (ts *TimeoutStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	return timed(ctx, ts, func(ctx context.Context) (string, error) { return Undelete(ctx, ts.Storage, key) })
+}
+
+func (ts *TimeoutStorage) KeysPage(ctx context.Context, afterKey string, limit int) (keys []string, err error) {
+	return timed(ctx, ts, func(ctx context.Context) ([]string, error) { return KeysPage(ctx, ts.Storage, afterKey, limit) })
+}
+
+// Scan зовет fn из горутины timed, так что после дедлайна fn больше не вызывается: вызывающий уже
+// получил ErrTimeout и мог выбросить то, во что fn пишет, а горутина бэкенда доживает сама
+func (ts *TimeoutStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) (err error) {
+	var mu sync.Mutex
+	stopped := false
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) {
+		return noValue{}, Scan(ctx, ts.Storage, prefix, func(key, value string) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return !stopped && fn(key, value)
+		})
+	})
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	return err
+}
+
+func (ts *TimeoutStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return timed(ctx, ts, func(ctx context.Context) (map[string]string, error) { return Dump(ctx, ts.Storage) })
+}
+
+func (ts *TimeoutStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) { return noValue{}, Replace(ctx, ts.Storage, kv) })
+	return err
+}
+
+func (ts *TimeoutStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) {
+		return noValue{}, Rename(ctx, ts.Storage, oldKey, newKey, overwrite)
+	})
+	return err
+}
+
+func (ts *TimeoutStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	_, err = timed(ctx, ts, func(ctx context.Context) (noValue, error) { return noValue{}, Txn(ctx, ts.Storage, ops) })
+	return err
+}
+
+func (ts *TimeoutStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	return timed(ctx, ts, func(ctx context.Context) (int, error) { return DeletePrefix(ctx, ts.Storage, prefix) })
+}
+
+// Unwrap пускает As к остальным возможностям бэкенда: Watch живет сколько угодно долго,
+// а у Compact, Backup и Reload нет контекста, на который можно повесить дедлайн
+func (ts *TimeoutStorage) Unwrap() Storage {
+	return ts.Storage
+}
+
+func NewTimeoutStorage(s Storage, timeout time.Duration) Storage {
+	return &TimeoutStorage{Storage: s, timeout: timeout}
+}
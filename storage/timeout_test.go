@@ -0,0 +1,130 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Barugoo/example-fs/storage"
+	"github.com/Barugoo/example-fs/storagetest"
+)
+
+// hungStorage - бэкенд, у которого расширенные вызовы висят, пока не закроют release, и контекст не смотрят
+type hungStorage struct {
+	storage.Storage
+	release chan struct{}
+}
+
+func (h *hungStorage) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	<-h.release
+	return 0, nil
+}
+
+func (h *hungStorage) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	<-h.release
+	return true, nil
+}
+
+func (h *hungStorage) SetIfAbsent(ctx context.Context, key, value string) (bool, error) {
+	<-h.release
+	return true, nil
+}
+
+func (h *hungStorage) Txn(ctx context.Context, ops []storage.TxnOp) error {
+	<-h.release
+	return nil
+}
+
+func (h *hungStorage) Dump(ctx context.Context) (map[string]string, error) {
+	<-h.release
+	return nil, nil
+}
+
+func (h *hungStorage) Replace(ctx context.Context, kv map[string]string) error {
+	<-h.release
+	return nil
+}
+
+func (h *hungStorage) Scan(ctx context.Context, prefix string, fn func(key, value string) bool) error {
+	fn("a", "1")
+	<-h.release
+	fn("b", "2")
+	return nil
+}
+
+func TestTimeoutStorageBasic(t *testing.T) {
+	backend := storagetest.NewFakeStorage(nil)
+	backend.Delay("Get", time.Second)
+	ts := storage.NewTimeoutStorage(backend, 20*time.Millisecond)
+
+	start := time.Now()
+	if _, err := ts.Get(context.Background(), "k"); !errors.Is(err, storage.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get: got %v, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Get returned after %s, the deadline is 20ms", d)
+	}
+	if err := ts.Set(context.Background(), "k", "v"); err != nil {
+		t.Errorf("Set on a healthy backend: %v", err)
+	}
+}
+
+func TestTimeoutStorageExtended(t *testing.T) {
+	h := &hungStorage{Storage: storage.NewMemStorage(), release: make(chan struct{})}
+	defer close(h.release)
+	ts := storage.NewTimeoutStorage(h, 20*time.Millisecond)
+	ctx := context.Background()
+
+	inc, _ := storage.As[storage.Incrementer](ts)
+	cs, _ := storage.As[storage.ConditionalStorage](ts)
+	for name, call := range map[string]func() error{
+		"Increment": func() error { _, err := inc.Increment(ctx, "n", 1); return err },
+		"CompareAndSwap": func() error {
+			_, err := cs.CompareAndSwap(ctx, "k", "a", "b")
+			return err
+		},
+		"SetIfAbsent": func() error { _, err := cs.SetIfAbsent(ctx, "k", "v"); return err },
+		"Append": func() error {
+			// Append без своего у бэкенда идет через зависший CompareAndSwap
+			_, err := storage.Append(ctx, ts, "k", "v")
+			return err
+		},
+		"Txn": func() error {
+			return storage.Txn(ctx, ts, []storage.TxnOp{{Type: storage.TxnSet, Key: "k", Value: "v"}})
+		},
+		"Dump":    func() error { _, err := storage.Dump(ctx, ts); return err },
+		"Replace": func() error { return storage.Replace(ctx, ts, map[string]string{"k": "v"}) },
+	} {
+		done := make(chan error, 1)
+		go func() { done <- call() }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, storage.ErrTimeout) {
+				t.Errorf("%s: got %v, want ErrTimeout", name, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s hangs past the deadline", name)
+		}
+	}
+}
+
+// после дедлайна fn больше не зовется: вызывающий уже вернулся, и писать ему некуда
+func TestTimeoutStorageScanStopsCallback(t *testing.T) {
+	h := &hungStorage{Storage: storage.NewMemStorage(), release: make(chan struct{})}
+	ts := storage.NewTimeoutStorage(h, 20*time.Millisecond)
+
+	var seen []string
+	err := storage.Scan(context.Background(), ts, "", func(key, value string) bool {
+		seen = append(seen, key)
+		return true
+	})
+	if !errors.Is(err, storage.ErrTimeout) {
+		t.Fatalf("Scan: got %v, want ErrTimeout", err)
+	}
+	close(h.release)
+	time.Sleep(20 * time.Millisecond) // даем брошенной горутине дойти до второго fn
+	if len(seen) != 1 || seen[0] != "a" {
+		t.Errorf("callback saw %v, want only the key before the deadline", seen)
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// с мягким удалением Delete не забывает значение сразу, а кладет его в надгробие: для Get и Keys ключа нет,
+// но в течение срока хранения его можно вернуть через Undelete. протухшие по ttl и выкинутые LRU ключи
+// надгробий не оставляют. в журнале FileStorage отдельного формата нет: значение и так лежит там записью set
+// перед записью delete, а снимок пишет надгробие той же парой записей
+
+// Undeleter - хранилка с мягким удалением
+type Undeleter interface {
+	// Undelete возвращает удаленный ключ с прежним значением и отдает это значение.
+	// ErrNotFound - ключ не удаляли или надгробие уже вычищено, ErrExists - ключ сейчас есть
+	Undelete(ctx context.Context, key string) (value string, err error)
+}
+
+// Undelete возвращает удаленный ключ. у бэкендов без мягкого удаления это ErrNotSupported
+func Undelete(ctx context.Context, s Storage, key string) (value string, err error) {
+	if u, ok := As[Undeleter](s); ok {
+		return u.Undelete(ctx, key)
+	}
+	return "", ErrNotSupported
+}
+
+// WithMemSoftDelete держит удаленные ключи retention, прежде чем забыть их совсем, 0 - удалять сразу
+func WithMemSoftDelete(retention time.Duration) MemOption {
+	return func(o *memOptions) { o.softDelete = retention }
+}
+
+// WithSoftDelete держит удаленные ключи retention, в том числе после перезапуска, 0 - удалять сразу
+func WithSoftDelete(retention time.Duration) FileOption {
+	return func(o *fileOptions) { o.softDelete = retention }
+}
+
+// tombstone - удаленный ключ, каким он был перед Delete
+type tombstone struct {
+	value   string
+	meta    entryMeta
+	deleted time.Time
+}
+
+func (ms *MemStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	log.Println("called mem storage Undelete method")
+	if ms.softDelete <= 0 {
+		return "", ErrNotSupported
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.undelete(key)
+}
+
+// bury кладет живой ключ в надгробие перед удалением, вызывается под блокировкой до drop
+func (ms *MemStorage) bury(key string, deleted time.Time) {
+	if ms.softDelete <= 0 || !ms.clock()().Before(deleted.Add(ms.softDelete)) {
+		return
+	}
+	if ms.deleted == nil {
+		ms.deleted = make(map[string]tombstone)
+	}
+	ms.deleted[key] = tombstone{value: ms.m[key], meta: ms.meta[key], deleted: deleted}
+	ms.startSweeper()
+}
+
+// undelete возвращает ключ из надгробия. метаданные остаются прежними, а сам возврат считается еще одной записью
+func (ms *MemStorage) undelete(key string) (value string, err error) {
+	rec, err := ms.undeleteRecord(key)
+	if err != nil {
+		return "", err
+	}
+	return rec.Value, applyRecord(ms, rec)
+}
+
+// undeleteRecord собирает запись журнала для возврата ключа из надгробия, ничего не меняя, как renameRecords
+func (ms *MemStorage) undeleteRecord(key string) (rec logRecord, err error) {
+	if _, ok := ms.lookup(key); ok {
+		return rec, fmt.Errorf("unable to undelete key %q: %w", key, ErrExists)
+	}
+	t, ok := ms.deleted[key]
+	if !ok || !ms.clock()().Before(t.deleted.Add(ms.softDelete)) {
+		return rec, ErrNotFound
+	}
+	return logRecord{
+		Op:      opSet,
+		Key:     key,
+		Value:   t.value,
+		Created: t.meta.created.Format(time.RFC3339Nano),
+		Updated: ms.clock()().Format(time.RFC3339Nano),
+		Writes:  strconv.FormatUint(t.meta.writes+1, 10),
+		Type:    t.meta.contentType,
+	}, nil
+}
+
+// purge забывает надгробия старше срока хранения, вызывается из sweep под блокировкой
+func (ms *MemStorage) purge() {
+	now := ms.clock()()
+	for k, t := range ms.deleted {
+		if !now.Before(t.deleted.Add(ms.softDelete)) {
+			delete(ms.deleted, k)
+		}
+	}
+}
+
+func (fs *FileStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	log.Println("called file storage Undelete method")
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+	if fs.softDelete <= 0 {
+		return "", ErrNotSupported
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err = fs.writable(); err != nil {
+		return "", err
+	}
+	rec, err := fs.undeleteRecord(key)
+	if err != nil {
+		return "", err
+	}
+	if err = fs.commit(rec); err != nil {
+		return "", err
+	}
+	return rec.Value, nil
+}
+
+// tombstoneRecords - пара записей журнала, из которой при загрузке надгробие сложится обратно
+func tombstoneRecords(key string, t tombstone) []logRecord {
+	return []logRecord{
+		{
+			Op:      opSet,
+			Key:     key,
+			Value:   t.value,
+			Created: t.meta.created.Format(time.RFC3339Nano),
+			Updated: t.meta.updated.Format(time.RFC3339Nano),
+			Writes:  strconv.FormatUint(t.meta.writes, 10),
+			Type:    t.meta.contentType,
+		},
+		{Op: opDelete, Key: key, Deleted: t.deleted.Format(time.RFC3339Nano)},
+	}
+}
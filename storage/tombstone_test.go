@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// brokenUndeleter падает на каждом Undelete, как недоступный бэкенд, и считает вызовы
+type brokenUndeleter struct {
+	Storage
+	calls int
+}
+
+func (b *brokenUndeleter) Undelete(ctx context.Context, key string) (string, error) {
+	b.calls++
+	return "", ErrUnavailable
+}
+
+// Undelete идет через breaker: падения его открывают, а открытый breaker не пускает вызов до бэкенда
+func TestCircuitBreakerUndelete(t *testing.T) {
+	ctx := context.Background()
+	b := &brokenUndeleter{Storage: NewMemStorage()}
+	s := NewCircuitBreakerStorage(b, "test", 1, time.Minute)
+
+	if _, err := Undelete(ctx, s, "a"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("first Undelete: %v", err)
+	}
+	var open *CircuitOpenError
+	if _, err := Undelete(ctx, s, "a"); !errors.As(err, &open) {
+		t.Fatalf("Undelete with an open breaker: %v", err)
+	}
+	if b.calls != 1 {
+		t.Errorf("backend got %d calls, want 1", b.calls)
+	}
+}
+
+// Undelete идет через лимиты и проверяет ключ, как и остальные записи
+func TestLimitedStorageUndelete(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemStorage(WithMemSoftDelete(time.Minute))
+	limits := new(atomic.Pointer[Limits])
+	limits.Store(&Limits{MaxKeyBytes: 8})
+	s := NewLimitedStorage(mem, limits)
+
+	long := strings.Repeat("k", 9)
+	mem.Set(ctx, long, "1")
+	mem.Delete(ctx, long)
+	if _, err := Undelete(ctx, s, long); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Undelete of a too long key: %v", err)
+	}
+
+	s.Set(ctx, "a", "1")
+	s.Delete(ctx, "a")
+	if v, err := Undelete(ctx, s, "a"); err != nil || v != "1" {
+		t.Errorf("Undelete = %q, %v", v, err)
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Barugoo/example-fs/storage"
+
+// TracedStorage открывает дочерний span на каждый вызов хранилки, так что в трейсе запроса видно,
+// сколько из него заняла сама хранилка. с hashKeys в span попадает не ключ, а его хэш:
+// по ключам бывает видно, чьи это данные, а трейсы читает больше людей, чем данные
+type TracedStorage struct {
+	Storage
+
+	tracer   trace.Tracer
+	hashKeys bool
+}
+
+func (ts *TracedStorage) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if id := RequestIDFrom(ctx); id != "" {
+		attrs = append(attrs, attribute.String("http.request_id", id))
+	}
+	return ts.tracer.Start(ctx, "storage."+op,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(append(attrs, attribute.String("storage.operation", op))...),
+	)
+}
+
+func (ts *TracedStorage) keyAttr(key string) attribute.KeyValue {
+	if ts.hashKeys {
+		sum := sha256.Sum256([]byte(key))
+		return attribute.String("storage.key_hash", hex.EncodeToString(sum[:8]))
+	}
+	return attribute.String("storage.key", key)
+}
+
+// endSpan закрывает span. ErrNotFound - обычный ответ, а не сбой, поэтому он идет атрибутом, а не статусом
+func endSpan(span trace.Span, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNotFound):
+		span.SetAttributes(attribute.Bool("storage.found", false))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (ts *TracedStorage) Get(ctx context.Context, key string) (value string, err error) {
+	ctx, span := ts.start(ctx, "Get", ts.keyAttr(key))
+	defer func() { endSpan(span, err) }()
+	return ts.Storage.Get(ctx, key)
+}
+
+func (ts *TracedStorage) Set(ctx context.Context, key, value string) (err error) {
+	ctx, span := ts.start(ctx, "Set", ts.keyAttr(key), attribute.Int("storage.value_bytes", len(value)))
+	defer func() { endSpan(span, err) }()
+	return ts.Storage.Set(ctx, key, value)
+}
+
+func (ts *TracedStorage) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := ts.start(ctx, "Delete", ts.keyAttr(key))
+	defer func() { endSpan(span, err) }()
+	return ts.Storage.Delete(ctx, key)
+}
+
+func (ts *TracedStorage) Keys(ctx context.Context) (keys []string, err error) {
+	ctx, span := ts.start(ctx, "Keys")
+	defer func() { endSpan(span, err) }()
+	return ts.Storage.Keys(ctx)
+}
+
+// в батчах ключей может быть тысячи, так что пишем только их количество
+func (ts *TracedStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	ctx, span := ts.start(ctx, "GetMany", attribute.Int("storage.keys", len(keys)))
+	defer func() { endSpan(span, err) }()
+	return ts.Storage.GetMany(ctx, keys)
+}
+
+func (ts *TracedStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	ctx, span := ts.start(ctx, "SetMany", attribute.Int("storage.keys", len(kv)))
+	defer func() { endSpan(span, err) }()
+	return ts.Storage.SetMany(ctx, kv)
+}
+
+// Unwrap пускает As к остальным возможностям бэкенда, их вызовы идут уже без своих span
+func (ts *TracedStorage) Unwrap() Storage {
+	return ts.Storage
+}
+
+func NewTracedStorage(s Storage, tp trace.TracerProvider, hashKeys bool) Storage {
+	return &TracedStorage{Storage: s, tracer: tp.Tracer(tracerName), hashKeys: hashKeys}
+}
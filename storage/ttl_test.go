@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock - часы, которые двигаются только руками
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestMemStorageTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	ms := NewMemStorage().(*MemStorage)
+	ms.now = clock.now
+	defer ms.Close()
+
+	if err := ms.SetWithTTL(ctx, "short", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.SetWithTTL(ctx, "reset", "2", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Set(ctx, "reset", "3"); err != nil { // обычный Set снимает ttl
+		t.Fatal(err)
+	}
+	if err := ms.Set(ctx, "forever", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ms.Get(ctx, "short"); err != nil || v != "1" {
+		t.Fatalf("Get(short) before the deadline = %q, %v", v, err)
+	}
+
+	clock.advance(time.Minute)
+	if _, err := ms.Get(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(short) after the deadline: got %v, want ErrNotFound", err)
+	}
+	if err := ms.Delete(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete(short) after the deadline: got %v, want ErrNotFound", err)
+	}
+	keys, err := ms.Keys(ctx)
+	if err != nil || len(keys) != 2 || keys[0] != "forever" || keys[1] != "reset" {
+		t.Errorf("Keys after the deadline = %v, %v", keys, err)
+	}
+}
+
+// дедлайн лежит в журнале, так что после перезапуска ключ все равно протухает
+func TestFileStorageTTLSurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := s.(*FileStorage)
+	clock := &fakeClock{t: time.Now()}
+	fs.now = clock.now
+	if err = fs.SetWithTTL(ctx, "short", "1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.SetWithTTL(ctx, "long", "2", 48*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	if s, err = NewFileStorage(path); err != nil {
+		t.Fatal(err)
+	}
+	fs = s.(*FileStorage)
+	defer fs.Close()
+	clock.advance(2 * time.Hour)
+	fs.now = clock.now
+	if _, err = fs.Get(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(short) after reopening past the deadline: got %v, want ErrNotFound", err)
+	}
+	if v, err := fs.Get(ctx, "long"); err != nil || v != "2" {
+		t.Errorf("Get(long) after reopening = %q, %v", v, err)
+	}
+}
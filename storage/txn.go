@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// типы операций транзакции
+const (
+	TxnSet    = "set"
+	TxnDelete = "delete"
+	TxnCheck  = "check"
+)
+
+// TxnOp - одна операция Txn. check проверяет, что в Key сейчас лежит Value, а с Absent - что ключа нет.
+// delete отсутствующего ключа ничего не делает: если это важно, перед ним ставится check
+type TxnOp struct {
+	Type   string `json:"type"`
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Absent bool   `json:"absent,omitempty"`
+}
+
+// ErrInvalidTxn - транзакция собрана неправильно: незнакомый тип операции, пустой ключ и т.п.
+var ErrInvalidTxn = errors.New("invalid transaction")
+
+// ErrTxnFailed - проверка транзакции не прошла, и ничего не записано
+var ErrTxnFailed = errors.New("transaction check failed")
+
+// TxnCheckError уточняет, какая проверка не прошла
+type TxnCheckError struct {
+	Index int // номер операции в транзакции, с нуля
+	Key   string
+}
+
+func (e *TxnCheckError) Error() string {
+	return fmt.Sprintf("%v: op #%d, key %q", ErrTxnFailed, e.Index, e.Key)
+}
+
+func (e *TxnCheckError) Unwrap() error {
+	return ErrTxnFailed
+}
+
+// Txner - хранилка, которая применяет несколько операций как одну: либо все, либо ни одной
+type Txner interface {
+	// Txn выполняет ops по порядку под одной блокировкой. check видит то, что записали операции до него.
+	// не прошла проверка - *TxnCheckError, и хранилка остается как была
+	Txn(ctx context.Context, ops []TxnOp) (err error)
+}
+
+// Txn применяет ops атомарно. из обычных вызовов транзакцию не собрать, так что без своего Txn это ErrNotSupported
+func Txn(ctx context.Context, s Storage, ops []TxnOp) (err error) {
+	if err = validateTxn(ops); err != nil {
+		return err
+	}
+	if tx, ok := As[Txner](s); ok {
+		return tx.Txn(ctx, ops)
+	}
+	return ErrNotSupported
+}
+
+func validateTxn(ops []TxnOp) error {
+	for i, op := range ops {
+		switch {
+		case op.Type != TxnSet && op.Type != TxnDelete && op.Type != TxnCheck:
+			return fmt.Errorf("%w: op #%d: unknown type %q, want %s, %s or %s", ErrInvalidTxn, i, op.Type, TxnSet, TxnDelete, TxnCheck)
+		case op.Key == "":
+			return fmt.Errorf("%w: op #%d: empty key", ErrInvalidTxn, i)
+		case op.Absent && op.Type != TxnCheck:
+			return fmt.Errorf("%w: op #%d: absent is only for %s", ErrInvalidTxn, i, TxnCheck)
+		}
+	}
+	return nil
+}
+
+// txnWrites - операции транзакции без проверок: то, что она меняет, для декораторов с кэшем, журналом и событиями
+func txnWrites(ops []TxnOp) (writes []TxnOp) {
+	for _, op := range ops {
+		if op.Type != TxnCheck {
+			writes = append(writes, op)
+		}
+	}
+	return writes
+}
+
+// txnRecords проверяет ops и собирает записи журнала для них, ничего не меняя, как renameRecords.
+// set снимает ttl, как обычный Set. вызывается под блокировкой
+func (ms *MemStorage) txnRecords(ops []TxnOp) (recs []logRecord, err error) {
+	if err = validateTxn(ops); err != nil {
+		return nil, err
+	}
+	now := ms.clock()().Format(time.RFC3339Nano)
+	pending := make(map[string]logRecord) // последняя запись транзакции по ключу
+	current := func(key string) (value string, ok bool) {
+		if rec, seen := pending[key]; seen {
+			return rec.Value, rec.Op == opSet
+		}
+		return ms.lookup(key)
+	}
+	for i, op := range ops {
+		var rec logRecord
+		switch op.Type {
+		case TxnCheck:
+			if value, ok := current(op.Key); op.Absent && ok || !op.Absent && (!ok || value != op.Value) {
+				return nil, &TxnCheckError{Index: i, Key: op.Key}
+			}
+			continue
+		case TxnDelete:
+			if _, ok := current(op.Key); !ok {
+				continue
+			}
+			rec = logRecord{Op: opDelete, Key: op.Key, Deleted: now}
+		case TxnSet:
+			rec = logRecord{Op: opSet, Key: op.Key, Value: op.Value, Created: now, Updated: now, Writes: "1"}
+			if prev, seen := pending[op.Key]; seen && prev.Op == opSet {
+				writes, _ := strconv.ParseUint(prev.Writes, 10, 64)
+				rec.Created, rec.Writes = prev.Created, strconv.FormatUint(writes+1, 10)
+			} else if _, ok := ms.lookup(op.Key); ok && !seen {
+				if meta, ok := ms.meta[op.Key]; ok {
+					rec.Created, rec.Writes = meta.created.Format(time.RFC3339Nano), strconv.FormatUint(meta.writes+1, 10)
+				}
+			}
+		}
+		pending[op.Key] = rec
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name      string
+		ops       []TxnOp
+		wantErr   error
+		wantIndex int // для ErrTxnFailed - какая проверка не прошла
+		want      map[string]string
+	}{
+		{
+			name: "set and delete",
+			ops:  []TxnOp{{Type: TxnCheck, Key: "a", Value: "1"}, {Type: TxnSet, Key: "c", Value: "3"}, {Type: TxnDelete, Key: "b"}},
+			want: map[string]string{"a": "1", "c": "3"},
+		},
+		{
+			name: "check sees earlier ops",
+			ops:  []TxnOp{{Type: TxnSet, Key: "a", Value: "new"}, {Type: TxnCheck, Key: "a", Value: "new"}, {Type: TxnDelete, Key: "missing"}},
+			want: map[string]string{"a": "new", "b": "2"},
+		},
+		{
+			name:      "failed check writes nothing",
+			ops:       []TxnOp{{Type: TxnSet, Key: "a", Value: "lost"}, {Type: TxnCheck, Key: "b", Absent: true}},
+			wantErr:   ErrTxnFailed,
+			wantIndex: 1,
+			want:      map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:    "unknown op",
+			ops:     []TxnOp{{Type: TxnSet, Key: "a", Value: "x"}, {Type: "incr", Key: "a"}},
+			wantErr: ErrInvalidTxn,
+			want:    map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:    "empty key",
+			ops:     []TxnOp{{Type: TxnSet, Value: "x"}},
+			wantErr: ErrInvalidTxn,
+			want:    map[string]string{"a": "1", "b": "2"},
+		},
+	} {
+		for _, backend := range []string{"mem", "file"} {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				var (
+					s    Storage
+					err  error
+					path = filepath.Join(t.TempDir(), "data")
+				)
+				if backend == "mem" {
+					s = NewMemStorage()
+				} else if s, err = NewFileStorage(path); err != nil {
+					t.Fatal(err)
+				}
+				if err = s.SetMany(ctx, map[string]string{"a": "1", "b": "2"}); err != nil {
+					t.Fatal(err)
+				}
+
+				err = Txn(ctx, s, tt.ops)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Txn: got %v, want %v", err, tt.wantErr)
+				}
+				var checkErr *TxnCheckError
+				if errors.As(err, &checkErr) && checkErr.Index != tt.wantIndex {
+					t.Errorf("failed check #%d, want #%d", checkErr.Index, tt.wantIndex)
+				}
+				// у файла транзакция должна пережить переоткрытие целиком
+				if fs, ok := s.(*FileStorage); ok {
+					fs.Close()
+					if s, err = NewFileStorage(path); err != nil {
+						t.Fatal(err)
+					}
+					defer s.(*FileStorage).Close()
+				}
+				kv, err := Dump(ctx, s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(kv) != len(tt.want) {
+					t.Errorf("got %v, want %v", kv, tt.want)
+				}
+				for k, v := range tt.want {
+					if kv[k] != v {
+						t.Errorf("%s = %q, want %q", k, kv[k], v)
+					}
+				}
+			})
+		}
+	}
+}
+
+// без своего Txn транзакция не собирается из обычных вызовов
+func TestTxnNotSupported(t *testing.T) {
+	s := struct{ Storage }{NewMemStorage()}
+	if err := Txn(context.Background(), s, []TxnOp{{Type: TxnSet, Key: "a", Value: "1"}}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("got %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// как часто WaitChange перечитывает ключ там, где подписаться на изменения нельзя
+const waitPollInterval = 500 * time.Millisecond
+
+// WaitChange ждет, пока ревизия key перестанет быть since, и возвращает значение с новой ревизией.
+// если она уже другая, возвращает сразу. ключа нет или его удалили - ErrNotFound, кончился ctx - его ошибка.
+// изменения ловит через Watch, а у хранилок без него, или если подписчика отключили как медленного,
+// перечитывает ключ раз в waitPollInterval. подписка живет, пока идет вызов, так что брошенный ожидающий не течет
+func WaitChange(ctx context.Context, s Storage, key, since string) (value, rev string, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// подписываемся до первого чтения, иначе изменение между ними прошло бы мимо
+	var events <-chan Event
+	if w, ok := As[Watcher](s); ok {
+		if events, err = w.Watch(ctx, key); err != nil {
+			return "", "", err
+		}
+	}
+	var poll <-chan time.Time
+	startPolling := func() {
+		t := time.NewTicker(waitPollInterval)
+		context.AfterFunc(ctx, t.Stop)
+		poll = t.C
+	}
+	if events == nil {
+		startPolling()
+	}
+
+	for {
+		if value, rev, err = GetRevision(ctx, s, key); err != nil || rev != since {
+			return value, rev, err
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return "", "", ctx.Err()
+			case <-poll:
+				break wait
+			case ev, ok := <-events:
+				switch {
+				case !ok:
+					events = nil
+					startPolling()
+					break wait // событие, на котором нас отключили, потеряно, так что перечитываем сразу
+				case ev.Key == key: // подписка по префиксу, так что приходят и более длинные ключи
+					break wait
+				}
+			}
+		}
+	}
+}
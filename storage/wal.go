@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// WAL: каждая запись до ответа клиенту дописывается в маленький журнал name+".wal" и сбрасывается на диск fsync'ом,
+// а в основной файл уходит позже, пачкой, как при write-behind. после того как пачка легла в основной файл и тот
+// тоже сброшен на диск, WAL обрезается. при открытии хвост WAL проигрывается поверх основного файла, так что
+// подтвержденная запись не теряется, даже если процесс упал между WAL и основным файлом.
+//
+// запись WAL - длина тела в uvarint, тело и crc32 от него. тело - байт флагов и запись журнала в кодеке файла,
+// с ключом шифрования - зашифрованная. запись, недописанную при падении, выдает контрольная сумма,
+// и она вместе со всем, что за ней, пропускается.
+//
+// если упасть после сброса основного файла, но до обрезки WAL, его записи проиграются второй раз. они несут
+// значения и метаданные целиком, так что данные получатся те же, разве что в истории ключа значение повторится
+const walSuffix = ".wal"
+
+// как часто записи из WAL сбрасываются в основной файл, если write-behind не настроен отдельно
+const walFlushInterval = time.Second
+
+// флаги тела записи WAL
+const walSealed = 1 // тело зашифровано AES-GCM ключом файла
+
+// WithWAL включает WAL: запись подтверждается, только когда она на диске, а основной файл пишется в фоне.
+// без WithFlushInterval и WithFlushEvery его записи сбрасываются в основной файл раз в секунду
+func WithWAL(enabled bool) FileOption {
+	return func(o *fileOptions) { o.wal = enabled }
+}
+
+// encodeWAL собирает записи WAL для recs одним буфером, чтобы они ушли одним Write
+func encodeWAL(o fileOptions, recs []logRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		var payload bytes.Buffer
+		if err := o.codec.Encode(&payload, recordMap(rec)); err != nil {
+			return nil, fmt.Errorf("unable to encode record: %w", err)
+		}
+		body := append([]byte{0}, payload.Bytes()...)
+		if o.aead != nil {
+			body = append([]byte{walSealed}, seal(o.aead, payload.Bytes())...)
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(len(body))))
+		buf.Write(body)
+		buf.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(body, crcTable)))
+	}
+	return buf.Bytes(), nil
+}
+
+// errTornRecord - запись WAL не дописана или повреждена: так выглядит хвост после падения посреди записи
+var errTornRecord = errors.New("torn write-ahead log record")
+
+// decodeWAL читает одну запись WAL. io.EOF - WAL кончился ровно на границе записи
+func decodeWAL(r *bufio.Reader, o fileOptions) (rec logRecord, size int, err error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return rec, 0, io.EOF
+	}
+	if err != nil || n == 0 || n > maxFrameSize {
+		return rec, 0, errTornRecord
+	}
+	frame, err := readN(r, int(n)+crc32.Size)
+	if err != nil {
+		return rec, 0, errTornRecord
+	}
+	body := frame[:n]
+	if binary.BigEndian.Uint32(frame[n:]) != crc32.Checksum(body, crcTable) {
+		return rec, 0, errTornRecord
+	}
+	size = len(binary.AppendUvarint(nil, n)) + len(frame)
+
+	// дальше сумма сошлась, так что запись целая, и ошибки ниже - уже не обрыв
+	payload := body[1:]
+	if body[0]&walSealed != 0 {
+		if o.aead == nil {
+			return rec, 0, errors.New("write-ahead log is encrypted, but no encryption key is configured")
+		}
+		if payload, err = unseal(o.aead, payload); err != nil {
+			return rec, 0, ErrWrongKey
+		}
+	}
+	raw, err := o.codec.Decode(bytes.NewReader(payload))
+	if err != nil {
+		return rec, 0, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	rec, ok := asLogRecord(raw)
+	if !ok {
+		return rec, 0, fmt.Errorf("%w: unexpected record", ErrCorrupt)
+	}
+	return rec, size, nil
+}
+
+// replayWAL применяет к ms записи WAL из file и возвращает их вместе с длиной целой части WAL.
+// все, что после нее, - недописанный хвост, его replayWAL только называет в логе
+func replayWAL(ms *MemStorage, file *os.File, o fileOptions) (recs []logRecord, good int64, err error) {
+	br := bufio.NewReader(file)
+	for {
+		rec, size, err := decodeWAL(br, o)
+		if err == io.EOF {
+			return recs, good, nil
+		}
+		if errors.Is(err, errTornRecord) {
+			log.Printf("warning: skipping torn record at offset %d of %s, it was never acknowledged", good, file.Name())
+			return recs, good, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to replay %s: record #%d: %w", file.Name(), len(recs)+1, err)
+		}
+		if err = applyRecord(ms, rec); err != nil {
+			return nil, 0, fmt.Errorf("unable to replay %s: %w: record #%d: %w", file.Name(), ErrCorrupt, len(recs)+1, err)
+		}
+		recs = append(recs, rec)
+		good += int64(size)
+	}
+}
+
+// openWAL открывает WAL рядом с filename и проигрывает его в ms. недописанный хвост сразу отрезается,
+// иначе новые записи легли бы за ним и replay их бы уже не увидел
+func openWAL(filename string, ms *MemStorage, o fileOptions) (wal *os.File, recs []logRecord, err error) {
+	wal, err = os.OpenFile(filename+walSuffix, os.O_RDWR|os.O_CREATE|os.O_APPEND, o.fileMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open write-ahead log %s: %w", filename+walSuffix, err)
+	}
+	recs, good, err := replayWAL(ms, wal, o)
+	if err == nil && good < fileSize(wal) {
+		err = wal.Truncate(good)
+	}
+	if err != nil {
+		wal.Close()
+		return nil, nil, err
+	}
+	if len(recs) > 0 {
+		log.Printf("recovered %d writes from %s", len(recs), wal.Name())
+	}
+	return wal, recs, nil
+}
+
+// replayWALReadOnly - openWAL для WithReadOnly: WAL читается, если он есть, но не создается и не обрезается
+func replayWALReadOnly(filename string, ms *MemStorage, o fileOptions) (err error) {
+	wal, err := os.Open(filename + walSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open write-ahead log %s: %w", filename+walSuffix, err)
+	}
+	defer wal.Close()
+	_, _, err = replayWAL(ms, wal, o)
+	return err
+}
+
+// logAhead дописывает recs в WAL и ждет fsync, вызывается под блокировкой
+func (fs *FileStorage) logAhead(recs []logRecord) (err error) {
+	b, err := encodeWAL(fs.opts, recs)
+	if err != nil {
+		return err
+	}
+	n, err := fs.wal.Write(b)
+	if err == nil {
+		err = fs.wal.Sync()
+	}
+	if err != nil {
+		// недописанная запись посреди WAL спрятала бы от replay все следующие, так что отрезаем ее сразу
+		if terr := fs.wal.Truncate(fs.walSize); terr != nil {
+			log.Printf("unable to cut a failed write off %s: %v", fs.wal.Name(), terr)
+		}
+		return fmt.Errorf("unable to append to write-ahead log %s: %w", fs.wal.Name(), err)
+	}
+	fs.walSize += int64(n)
+	return nil
+}
+
+// truncateWAL обрезает WAL, когда все его записи уже в основном файле. основной файл сначала сбрасывается на диск:
+// без этого после падения не осталось бы ни записей в WAL, ни их копии в файле. вызывается под блокировкой
+func (fs *FileStorage) truncateWAL() (err error) {
+	if fs.wal == nil || fs.walSize == 0 {
+		return nil
+	}
+	if err = fs.f.Sync(); err != nil {
+		return fmt.Errorf("unable to sync file %s: %w", fs.name, err)
+	}
+	if err = fs.wal.Truncate(0); err != nil {
+		return fmt.Errorf("unable to truncate write-ahead log %s: %w", fs.wal.Name(), err)
+	}
+	fs.walSize = 0
+	return nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// падение процесса изображаем копией файлов, снятой, пока хранилка открыта и основной файл еще не сброшен:
+// все подтвержденные записи должны вернуться из WAL, а недописанный хвост - пропуститься
+func TestFileStorageWALRecovery(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		opts []FileOption
+	}{
+		{"json", nil},
+		{"gob", []FileOption{WithCodec(gobCodec{})}},
+		{"encrypted", []FileOption{WithEncryption(bytes.Repeat([]byte{7}, 32))}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "data")
+			opts := append([]FileOption{WithWAL(true), WithFlushInterval(time.Hour)}, tt.opts...)
+			s, err := NewFileStorage(path, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.(*FileStorage).Close()
+			for _, k := range []string{"a", "b", "c"} {
+				if err = s.Set(ctx, k, "value of "+k); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = s.Delete(ctx, "b"); err != nil {
+				t.Fatal(err)
+			}
+
+			crashed := filepath.Join(t.TempDir(), "data")
+			for _, suffix := range []string{"", walSuffix} {
+				if err = copyFile(path+suffix, crashed+suffix, 0600); err != nil && !errors.Is(err, os.ErrNotExist) {
+					t.Fatal(err)
+				}
+			}
+			wal, err := os.OpenFile(crashed+walSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wal.Write([]byte{0x20, 0, 'g', 'a'}) // длина 32, а тела всего два байта
+			wal.Close()
+
+			r, err := NewFileStorage(crashed, opts...)
+			if err != nil {
+				t.Fatalf("open after the crash: %v", err)
+			}
+			defer r.(*FileStorage).Close()
+			for k, want := range map[string]string{"a": "value of a", "c": "value of c"} {
+				if v, err := r.Get(ctx, k); err != nil || v != want {
+					t.Errorf("Get(%s) after recovery = %q, %v", k, v, err)
+				}
+			}
+			if _, err = r.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("deleted key came back from the WAL: %v", err)
+			}
+			// восстановленные записи уже в основном файле, WAL начинается пустым
+			if fi, err := os.Stat(crashed + walSuffix); err == nil && fi.Size() != 0 {
+				t.Errorf("WAL is %d bytes after recovery, want empty", fi.Size())
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+)
+
+// операции в Event
+const (
+	EventSet    = "set"
+	EventDelete = "delete"
+)
+
+// Event - одно изменение ключа. у delete Value пустой
+type Event struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// Watcher - хранилка, на изменения которой можно подписаться
+type Watcher interface {
+	// Watch отдает изменения ключей с префиксом prefix ("" - все ключи), пока не отменят ctx.
+	// канал закрывается при отмене ctx, закрытии хранилки или если подписчик не успевает читать
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+}
+
+// сколько событий может накопиться у подписчика, прежде чем его отключат
+const watchBuffer = 256
+
+// watchHub раздает события подписчикам. notify вызывается под блокировкой хранилки,
+// поэтому никогда не ждет: медленного подписчика мы отключаем, а не тормозим из-за него запись
+type watchHub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	prefix string
+	ch     chan Event
+}
+
+func (h *watchHub) subscribe(ctx context.Context, prefix string) <-chan Event {
+	sub := &subscriber{prefix: prefix, ch: make(chan Event, watchBuffer)}
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	context.AfterFunc(ctx, func() { h.unsubscribe(sub) })
+	return sub.ch
+}
+
+func (h *watchHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (h *watchHub) notify(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if !strings.HasPrefix(ev.Key, sub.prefix) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			log.Printf("dropping slow watcher of prefix %q", sub.prefix)
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// closeAll закрывает каналы всех подписчиков, когда закрывается хранилка
+func (h *watchHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// сколько событий ждет отправки на один адрес. если получатель не успевает, новые события теряются, а не тормозят запись
+const webhookQueue = 1024
+
+// пауза между попытками растет вдвое, но не дольше webhookMaxBackoff
+const webhookMaxBackoff = 30 * time.Second
+
+// WebhookEvent - тело POST на вебхук. у delete и replace Value пустой, у replace пустой и Key
+type WebhookEvent struct {
+	Op        string    `json:"op"`
+	Backend   string    `json:"backend"`
+	Key       string    `json:"key,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	To        string    `json:"to,omitempty"` // для rename - новое имя ключа, Key - старое
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookOption настраивает NewWebhooks
+type WebhookOption func(*webhookOptions)
+
+type webhookOptions struct {
+	client  *http.Client
+	retries int           // повторы после первой неудачной попытки
+	backoff time.Duration // пауза перед первым повтором
+}
+
+// WithWebhookRetries - сколько раз повторять неудачную доставку и с какой паузы начинать
+func WithWebhookRetries(retries int, backoff time.Duration) WebhookOption {
+	return func(o *webhookOptions) { o.retries, o.backoff = retries, backoff }
+}
+
+// WithWebhookClient задает HTTP клиент, например с другим таймаутом или транспортом
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(o *webhookOptions) { o.client = client }
+}
+
+// Webhooks рассылает события изменений на адреса. у каждого адреса своя очередь и своя горутина,
+// так что медленный получатель не задерживает остальных, а события до него доходят по порядку
+type Webhooks struct {
+	opts   webhookOptions
+	hooks  []*webhook
+	ctx    context.Context // отменяется, когда Shutdown больше не ждет доставки
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type webhook struct {
+	url   string
+	queue chan WebhookEvent
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64 // не доставлены после всех повторов
+	dropped   atomic.Uint64 // не влезли в очередь
+}
+
+// WebhookStats - счетчики одного адреса для метрик
+type WebhookStats struct {
+	URL       string
+	Delivered uint64
+	Failed    uint64
+	Dropped   uint64
+}
+
+// Stats отдает счетчики по каждому адресу
+func (wh *Webhooks) Stats() []WebhookStats {
+	wh.mu.RLock()
+	defer wh.mu.RUnlock()
+	stats := make([]WebhookStats, 0, len(wh.hooks))
+	for _, h := range wh.hooks {
+		stats = append(stats, WebhookStats{URL: h.url, Delivered: h.delivered.Load(), Failed: h.failed.Load(), Dropped: h.dropped.Load()})
+	}
+	return stats
+}
+
+// Notify ставит событие в очереди всех адресов и никогда не ждет
+func (wh *Webhooks) Notify(e WebhookEvent) {
+	wh.mu.RLock()
+	defer wh.mu.RUnlock()
+	if wh.closed {
+		return
+	}
+	for _, h := range wh.hooks {
+		select {
+		case h.queue <- e:
+		default:
+			h.dropped.Add(1)
+			log.Printf("webhook %s queue is full, dropping %s event for key %q", h.url, e.Op, e.Key)
+		}
+	}
+}
+
+// SetURLs меняет адреса на ходу. у адресов, что остались, сохраняются очередь и счетчики, новые запускаются
+// с пустыми, а убранные досылают то, что уже стоит в их очереди, и больше событий не получают
+func (wh *Webhooks) SetURLs(urls []string) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if wh.closed {
+		return
+	}
+	old := wh.hooks
+	wh.hooks = make([]*webhook, 0, len(urls))
+	for _, url := range urls {
+		if i := slices.IndexFunc(old, func(h *webhook) bool { return h.url == url }); i >= 0 {
+			wh.hooks = append(wh.hooks, old[i])
+			old = slices.Delete(old, i, i+1)
+			continue
+		}
+		wh.hooks = append(wh.hooks, wh.start(url))
+	}
+	for _, h := range old {
+		close(h.queue)
+	}
+}
+
+func (wh *Webhooks) start(url string) *webhook {
+	h := &webhook{url: url, queue: make(chan WebhookEvent, webhookQueue)}
+	wh.wg.Add(1)
+	go wh.run(h)
+	return h
+}
+
+func (wh *Webhooks) run(h *webhook) {
+	defer wh.wg.Done()
+	for e := range h.queue {
+		if wh.ctx.Err() != nil { // Shutdown больше не ждет, остаток очереди только считаем
+			h.failed.Add(1)
+			continue
+		}
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("unable to encode webhook event: %v", err)
+			continue
+		}
+		if err = wh.deliver(h.url, body); err != nil {
+			h.failed.Add(1)
+			log.Printf("unable to deliver %s event for key %q to webhook %s: %v", e.Op, e.Key, h.url, err)
+			continue
+		}
+		h.delivered.Add(1)
+	}
+}
+
+// deliver отправляет тело с повторами. повторяем сетевые ошибки, 429 и 5xx,
+// остальные 4xx говорят, что получатель такое событие не примет никогда
+func (wh *Webhooks) deliver(url string, body []byte) (err error) {
+	backoff := wh.opts.backoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = wh.post(url, body); err == nil || !retry || attempt == wh.opts.retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-wh.ctx.Done():
+			return fmt.Errorf("%w, gave up after %d attempts", err, attempt+1)
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+}
+
+func (wh *Webhooks) post(url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(wh.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wh.opts.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10)) // чтобы соединение вернулось в пул
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	return retry, fmt.Errorf("webhook returned %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+}
+
+// Shutdown перестает принимать события и ждет, пока уйдут уже поставленные в очередь.
+// когда ctx истекает, текущие доставки прерываются, а оставшиеся события теряются
+func (wh *Webhooks) Shutdown(ctx context.Context) (err error) {
+	wh.mu.Lock()
+	if wh.closed {
+		wh.mu.Unlock()
+		return nil
+	}
+	wh.closed = true
+	for _, h := range wh.hooks {
+		close(h.queue)
+	}
+	wh.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wh.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		wh.cancel()
+		return nil
+	case <-ctx.Done():
+		wh.cancel()
+		<-done
+		return fmt.Errorf("unable to deliver all webhooks before shutdown: %w", ctx.Err())
+	}
+}
+
+// NewWebhooks запускает рассылку на urls. по умолчанию 5 повторов с паузы в полсекунды и клиент с 10 секундами таймаута
+func NewWebhooks(urls []string, opts ...WebhookOption) *Webhooks {
+	o := webhookOptions{client: &http.Client{Timeout: 10 * time.Second}, retries: 5, backoff: 500 * time.Millisecond}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	wh := &Webhooks{opts: o}
+	wh.ctx, wh.cancel = context.WithCancel(context.Background())
+	for _, url := range urls {
+		wh.hooks = append(wh.hooks, wh.start(url))
+	}
+	return wh
+}
+
+// NotifyingStorage рассылает удачные изменения через Webhooks уже после ответа хранилки, запрос доставку не ждет.
+// как и у AuditedStorage, все пишущие возможности переопределены, чтобы As не нашел их в обход рассылки
+type NotifyingStorage struct {
+	Storage
+	hooks   *Webhooks
+	backend string
+}
+
+func (ns *NotifyingStorage) notify(op, key, value string) {
+	ns.hooks.Notify(WebhookEvent{Op: op, Backend: ns.backend, Key: key, Value: value, Timestamp: time.Now().UTC()})
+}
+
+func (ns *NotifyingStorage) Set(ctx context.Context, key, value string) (err error) {
+	if err = ns.Storage.Set(ctx, key, value); err == nil {
+		ns.notify(EventSet, key, value)
+	}
+	return err
+}
+
+func (ns *NotifyingStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	if err = ns.Storage.SetMany(ctx, kv); err == nil {
+		for k, v := range kv {
+			ns.notify(EventSet, k, v)
+		}
+	}
+	return err
+}
+
+func (ns *NotifyingStorage) Delete(ctx context.Context, key string) (err error) {
+	if err = ns.Storage.Delete(ctx, key); err == nil {
+		ns.notify(EventDelete, key, "")
+	}
+	return err
+}
+
+func (ns *NotifyingStorage) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	es, ok := As[ExpiringStorage](ns.Storage)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err = es.SetWithTTL(ctx, key, value, ttl); err == nil {
+		ns.notify(EventSet, key, value)
+	}
+	return err
+}
+
+func (ns *NotifyingStorage) CompareAndSwap(ctx context.Context, key, old, new string) (swapped bool, err error) {
+	cs, ok := As[ConditionalStorage](ns.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if swapped, err = cs.CompareAndSwap(ctx, key, old, new); err == nil && swapped {
+		ns.notify(EventSet, key, new)
+	}
+	return swapped, err
+}
+
+func (ns *NotifyingStorage) SetIfAbsent(ctx context.Context, key, value string) (set bool, err error) {
+	cs, ok := As[ConditionalStorage](ns.Storage)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	if set, err = cs.SetIfAbsent(ctx, key, value); err == nil && set {
+		ns.notify(EventSet, key, value)
+	}
+	return set, err
+}
+
+func (ns *NotifyingStorage) GetOrSet(ctx context.Context, key, defaultValue string) (value string, existed bool, err error) {
+	if value, existed, err = GetOrSet(ctx, ns.Storage, key, defaultValue); err == nil && !existed {
+		ns.notify(EventSet, key, value)
+	}
+	return value, existed, err
+}
+
+// Append шлет событие append с дописанным куском, а не set: значение целиком декоратор не видит
+func (ns *NotifyingStorage) Append(ctx context.Context, key, suffix string) (length int, err error) {
+	if length, err = Append(ctx, ns.Storage, key, suffix); err == nil {
+		ns.notify("append", key, suffix)
+	}
+	return length, err
+}
+
+func (ns *NotifyingStorage) Increment(ctx context.Context, key string, delta int64) (value int64, err error) {
+	inc, ok := As[Incrementer](ns.Storage)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	if value, err = inc.Increment(ctx, key, delta); err == nil {
+		ns.notify(EventSet, key, strconv.FormatInt(value, 10))
+	}
+	return value, err
+}
+
+// для получателя возвращенный ключ ничем не отличается от записанного заново
+func (ns *NotifyingStorage) Undelete(ctx context.Context, key string) (value string, err error) {
+	if value, err = Undelete(ctx, ns.Storage, key); err == nil {
+		ns.notify(EventSet, key, value)
+	}
+	return value, err
+}
+
+func (ns *NotifyingStorage) Rename(ctx context.Context, oldKey, newKey string, overwrite bool) (err error) {
+	if err = Rename(ctx, ns.Storage, oldKey, newKey, overwrite); err == nil {
+		ns.hooks.Notify(WebhookEvent{Op: "rename", Backend: ns.backend, Key: oldKey, To: newKey, Timestamp: time.Now().UTC()})
+	}
+	return err
+}
+
+// Txn шлет обычные set и delete на каждую запись: получателю не важно, что они пришли одной транзакцией.
+// delete отсутствующего ключа транзакция пропускает, а событие о нем все равно уйдет
+func (ns *NotifyingStorage) Txn(ctx context.Context, ops []TxnOp) (err error) {
+	if err = Txn(ctx, ns.Storage, ops); err != nil {
+		return err
+	}
+	for _, op := range txnWrites(ops) {
+		ns.notify(op.Type, op.Key, op.Value)
+	}
+	return nil
+}
+
+// DeletePrefix шлет одно событие delete_prefix с префиксом в key: какие именно ключи удалены, хранилка не отдает
+func (ns *NotifyingStorage) DeletePrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	if deleted, err = DeletePrefix(ctx, ns.Storage, prefix); err == nil && deleted > 0 {
+		ns.notify("delete_prefix", prefix, "")
+	}
+	return deleted, err
+}
+
+// Replace шлет одно событие replace без ключа: получателю проще перечитать все, чем разбирать весь снимок
+func (ns *NotifyingStorage) Replace(ctx context.Context, kv map[string]string) (err error) {
+	if err = Replace(ctx, ns.Storage, kv); err == nil {
+		ns.notify("replace", "", "")
+	}
+	return err
+}
+
+// Dump нужен, чтобы As[Dumper] не нашел Replace у бэкенда в обход рассылки
+func (ns *NotifyingStorage) Dump(ctx context.Context) (kv map[string]string, err error) {
+	return Dump(ctx, ns.Storage)
+}
+
+func (ns *NotifyingStorage) Unwrap() Storage {
+	return ns.Storage
+}
+
+// NewNotifyingStorage рассылает изменения s через hooks с меткой backend
+func NewNotifyingStorage(s Storage, hooks *Webhooks, backend string) Storage {
+	return &NotifyingStorage{Storage: s, hooks: hooks, backend: backend}
+}
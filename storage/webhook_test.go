@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer отвечает статусами из codes по очереди (последний - на все остальные запросы) и запоминает события
+type hookServer struct {
+	mu     sync.Mutex
+	codes  []int
+	calls  int
+	events []WebhookEvent
+}
+
+func (hs *hookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var e WebhookEvent
+	json.NewDecoder(r.Body).Decode(&e)
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	code := hs.codes[min(hs.calls, len(hs.codes)-1)]
+	hs.calls++
+	if code == http.StatusOK {
+		hs.events = append(hs.events, e)
+	}
+	w.WriteHeader(code)
+}
+
+func TestNotifyingStorage(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name      string
+		codes     []int
+		calls     int // сколько запросов дошло до получателя
+		delivered uint64
+		failed    uint64
+	}{
+		{"ok", []int{http.StatusOK}, 3, 3, 0},
+		{"retried 5xx", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, 5, 3, 0},
+		{"4xx is not retried", []int{http.StatusBadRequest}, 3, 0, 3},
+		{"gave up after retries", []int{http.StatusInternalServerError}, 9, 0, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := &hookServer{codes: tt.codes}
+			srv := httptest.NewServer(hs)
+			defer srv.Close()
+
+			hooks := NewWebhooks([]string{srv.URL}, WithWebhookRetries(2, time.Millisecond))
+			s := NewNotifyingStorage(NewMemStorage(), hooks, "memory")
+			s.Set(ctx, "a", "1")
+			s.Delete(ctx, "missing") // неудачное удаление не рассылается
+			if inc, ok := As[Incrementer](s); ok {
+				inc.Increment(ctx, "n", 2)
+			}
+			s.Delete(ctx, "a")
+			if err := hooks.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			hs.mu.Lock()
+			defer hs.mu.Unlock()
+			stats := hooks.Stats()[0]
+			if hs.calls != tt.calls || stats.Delivered != tt.delivered || stats.Failed != tt.failed || stats.Dropped != 0 {
+				t.Fatalf("calls=%d stats=%+v, want calls=%d delivered=%d failed=%d", hs.calls, stats, tt.calls, tt.delivered, tt.failed)
+			}
+			if tt.delivered == 0 {
+				return
+			}
+			want := []WebhookEvent{{Op: EventSet, Key: "a", Value: "1"}, {Op: EventSet, Key: "n", Value: "2"}, {Op: EventDelete, Key: "a"}}
+			for i, w := range want {
+				e := hs.events[i]
+				if e.Op != w.Op || e.Key != w.Key || e.Value != w.Value || e.Backend != "memory" || e.Timestamp.IsZero() {
+					t.Errorf("event %d = %+v, want %+v", i, e, w)
+				}
+			}
+		})
+	}
+}
+
+// после Shutdown новые события молча отбрасываются, а повторный Shutdown ничего не делает
+func TestWebhooksShutdown(t *testing.T) {
+	ctx := context.Background()
+	hs := &hookServer{codes: []int{http.StatusOK}}
+	srv := httptest.NewServer(hs)
+	defer srv.Close()
+
+	hooks := NewWebhooks([]string{srv.URL})
+	if err := hooks.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	hooks.Notify(WebhookEvent{Op: EventSet, Key: "late"})
+	if err := hooks.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.calls != 0 {
+		t.Errorf("got %d calls after Shutdown, want 0", hs.calls)
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// write-behind: записи сразу попадают в память, а в файл уходят пачкой - раз в flushInterval
+// или как только накопилось flushEvery записей. при падении процесса теряется то, что не успело уйти, если нет WithWAL.
+// по умолчанию режим выключен и каждая запись ложится в файл до ответа клиенту
+
+// WithFlushInterval сбрасывает накопленные записи в файл раз в d
+func WithFlushInterval(d time.Duration) FileOption {
+	return func(o *fileOptions) { o.flushInterval = d }
+}
+
+// WithFlushEvery сбрасывает записи в файл, как только их накопилось n
+func WithFlushEvery(n int) FileOption {
+	return func(o *fileOptions) { o.flushEvery = n }
+}
+
+func (o fileOptions) writeBehind() bool {
+	return o.flushInterval > 0 || o.flushEvery > 0
+}
+
+// Flush дописывает в файл все накопленные записи. без write-behind копить нечего и он ничего не делает
+func (fs *FileStorage) Flush() (err error) {
+	log.Println("called file storage Flush method")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return ErrClosed
+	}
+	return fs.flush()
+}
+
+// flush вызывается под fs.mu, так что Set, пришедший во время записи, просто подождет и попадет в следующую пачку.
+// при ошибке записи пачка остается в очереди и уйдет при следующем сбросе. с WAL после пачки он обрезается
+func (fs *FileStorage) flush() (err error) {
+	// после чужой правки файла при ConflictReadOnly отложенные записи ждут Reload, а файл не трогаем
+	if len(fs.pending) == 0 || fs.conflict {
+		return nil
+	}
+	if err = fs.writeRecords(fs.pending...); err != nil {
+		return err
+	}
+	fs.pending = nil
+	return fs.truncateWAL()
+}
+
+// queue откладывает записи до сброса и будит flushLoop, если набралась пачка
+func (fs *FileStorage) queue(recs ...logRecord) {
+	fs.pending = append(fs.pending, recs...)
+	if fs.opts.flushEvery > 0 && len(fs.pending) >= fs.opts.flushEvery {
+		select {
+		case fs.flushKick <- struct{}{}:
+		default: // сброс уже запрошен
+		}
+	}
+}
+
+func (fs *FileStorage) flushLoop(done chan struct{}) {
+	var tick <-chan time.Time
+	if fs.opts.flushInterval > 0 {
+		ticker := time.NewTicker(fs.opts.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick:
+		case <-fs.flushKick:
+		}
+		// не через Flush, чтобы не писать в лог строку на каждый тик
+		fs.mu.Lock()
+		if !fs.closed {
+			if err := fs.flush(); err != nil {
+				log.Printf("background flush of %s failed: %v", fs.name, err)
+			}
+		}
+		fs.mu.Unlock()
+	}
+}
@@ -0,0 +1,146 @@
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// размеры, на которых BenchmarkStorage гоняет каждую операцию
+var (
+	benchValueSizes = []int{64, 4 << 10, 64 << 10}
+	benchStoreSizes = []int{1_000, 100_000}
+)
+
+// больше этого объема данных BenchmarkStorage заранее не заливает, иначе 100k ключей по 64 KB - это 6 GB
+const benchMaxPrefill = 256 << 20
+
+// BenchmarkStorage меряет Set, Get и смесь из трех чтений на одну запись на всех сочетаниях размера значения
+// и числа ключей. newStorage зовется на каждое сочетание и должна отдавать пустую хранилку, файлы класть в b.TempDir().
+// подключается так же, как TestStorage, а запускается go test -bench:
+//
+//	func BenchmarkMyStorage(b *testing.B) {
+//		storagetest.BenchmarkStorage(b, func(b *testing.B) storage.Storage { return NewMyStorage(b.TempDir()) })
+//	}
+func BenchmarkStorage(b *testing.B, newStorage func(b *testing.B) storage.Storage) {
+	for _, keys := range benchStoreSizes {
+		for _, size := range benchValueSizes {
+			name := fmt.Sprintf("keys=%d/value=%s", keys, byteSize(size))
+			b.Run(name+"/Set", func(b *testing.B) {
+				s, ids := benchStorage(b, newStorage, keys, size)
+				value := strings.Repeat("y", size)
+				b.SetBytes(int64(size))
+				i := 0
+				for b.Loop() {
+					if err := s.Set(context.Background(), ids[i%len(ids)], value); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+			b.Run(name+"/Get", func(b *testing.B) {
+				s, ids := benchStorage(b, newStorage, keys, size)
+				b.SetBytes(int64(size))
+				i := 0
+				for b.Loop() {
+					if _, err := s.Get(context.Background(), ids[i%len(ids)]); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+			b.Run(name+"/Mixed", func(b *testing.B) {
+				s, ids := benchStorage(b, newStorage, keys, size)
+				value := strings.Repeat("z", size)
+				b.SetBytes(int64(size))
+				i := 0
+				for b.Loop() {
+					var err error
+					if key := ids[i%len(ids)]; i%4 == 0 {
+						err = s.Set(context.Background(), key, value)
+					} else {
+						_, err = s.Get(context.Background(), key)
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkHTTP меряет PUT и GET одного значения через HTTP API поверх хранилки, то есть вместе с роутером,
+// хендлерами и loopback-соединением. разница с BenchmarkStorage - это цена всего, что выше хранилки
+func BenchmarkHTTP(b *testing.B, newStorage func(b *testing.B) storage.Storage) {
+	for _, size := range benchValueSizes {
+		b.Run("value="+byteSize(size), func(b *testing.B) {
+			s, ids := benchStorage(b, newStorage, 1_000, size)
+			srv := NewServer(b, "/bench", s)
+			value := strings.Repeat("h", size)
+			b.SetBytes(int64(size))
+			i := 0
+			for b.Loop() {
+				url := srv.URL + "/bench/" + ids[i%len(ids)]
+				method, body := http.MethodGet, io.Reader(nil)
+				if i%4 == 0 {
+					method, body = http.MethodPut, strings.NewReader(value)
+				}
+				req, err := http.NewRequest(method, url, body)
+				if err != nil {
+					b.Fatal(err)
+				}
+				resp, err := srv.Client().Do(req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body) // иначе соединение не вернется в пул
+				resp.Body.Close()
+				if resp.StatusCode >= 300 {
+					b.Fatalf("%s %s: %s", method, url, resp.Status)
+				}
+				i++
+			}
+		})
+	}
+}
+
+// benchStorage - хранилка, в которую уже залиты keys ключей по size байт, и сами ключи.
+// заливка идет одним SetMany и в замер не входит
+func benchStorage(b *testing.B, newStorage func(b *testing.B) storage.Storage, keys, size int) (s storage.Storage, ids []string) {
+	b.Helper()
+	if keys*size > benchMaxPrefill {
+		b.Skipf("%d keys of %s is more than %s of data", keys, byteSize(size), byteSize(benchMaxPrefill))
+	}
+	s = newStorage(b)
+	if c, ok := storage.As[io.Closer](s); ok {
+		b.Cleanup(func() { c.Close() })
+	}
+	value := strings.Repeat("x", size)
+	kv := make(map[string]string, keys)
+	ids = make([]string, keys)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("key%06d", i)
+		kv[ids[i]] = value
+	}
+	if err := s.SetMany(context.Background(), kv); err != nil {
+		b.Fatalf("unable to prefill storage: %v", err)
+	}
+	return s, ids
+}
+
+func byteSize(n int) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,190 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// TestStorage прогоняет на бэкенде проверки, которые должна проходить любая реализация storage.Storage.
+// newStorage зовется на каждый подтест и должна отдавать пустую хранилку, io.Closer закрывается в конце подтеста.
+// новый бэкенд проверяется так:
+//
+//	func TestMyStorage(t *testing.T) {
+//		storagetest.TestStorage(t, func() storage.Storage { return NewMyStorage(t.TempDir()) })
+//	}
+func TestStorage(t *testing.T, newStorage func() storage.Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"GetSet", testGetSet},
+		{"Overwrite", testOverwrite},
+		{"NotFound", testNotFound},
+		{"Delete", testDelete},
+		{"Keys", testKeys},
+		{"Many", testMany},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage()
+			if c, ok := storage.As[io.Closer](s); ok {
+				t.Cleanup(func() {
+					if err := c.Close(); err != nil {
+						t.Errorf("Close: %v", err)
+					}
+				})
+			}
+			tt.fn(t, s)
+		})
+	}
+}
+
+func mustSet(t *testing.T, s storage.Storage, key, value string) {
+	t.Helper()
+	if err := s.Set(context.Background(), key, value); err != nil {
+		t.Fatalf("Set(%q): %v", key, err)
+	}
+}
+
+func wantValue(t *testing.T, s storage.Storage, key, want string) {
+	t.Helper()
+	got, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if got != want {
+		t.Fatalf("Get(%q) = %q, want %q", key, got, want)
+	}
+}
+
+func testGetSet(t *testing.T, s storage.Storage) {
+	mustSet(t, s, "a", "1")
+	mustSet(t, s, "nested/key", "with spaces and юникод")
+	mustSet(t, s, "empty", "")
+	wantValue(t, s, "a", "1")
+	wantValue(t, s, "nested/key", "with spaces and юникод")
+	wantValue(t, s, "empty", "")
+}
+
+func testOverwrite(t *testing.T, s storage.Storage) {
+	mustSet(t, s, "a", "1")
+	mustSet(t, s, "a", "2")
+	wantValue(t, s, "a", "2")
+	keys, err := s.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("Keys = %q after overwriting one key, want one key", keys)
+	}
+}
+
+func testNotFound(t *testing.T, s storage.Storage) {
+	if _, err := s.Get(context.Background(), "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get of a missing key: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(context.Background(), "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Delete of a missing key: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	mustSet(t, s, "a", "1")
+	mustSet(t, s, "b", "2")
+	if err := s.Delete(context.Background(), "a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(context.Background(), "a"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	wantValue(t, s, "b", "2")
+}
+
+func testKeys(t *testing.T, s storage.Storage) {
+	keys, err := s.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if keys == nil || len(keys) != 0 {
+		t.Fatalf("Keys of an empty storage = %#v, want an empty non-nil slice", keys)
+	}
+	for _, k := range []string{"c", "a", "b"} {
+		mustSet(t, s, k, k)
+	}
+	if keys, err = s.Keys(context.Background()); err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	slices.Sort(keys)
+	if want := []string{"a", "b", "c"}; !slices.Equal(keys, want) {
+		t.Fatalf("Keys = %q, want %q", keys, want)
+	}
+}
+
+func testMany(t *testing.T, s storage.Storage) {
+	kv := map[string]string{"a": "1", "b": "2", "c": "3"}
+	if err := s.SetMany(context.Background(), kv); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+	got, err := s.GetMany(context.Background(), []string{"a", "c", "missing"})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if want := map[string]string{"a": "1", "c": "3"}; !maps.Equal(got, want) {
+		t.Fatalf("GetMany = %v, want %v without missing keys", got, want)
+	}
+	wantValue(t, s, "b", "2")
+}
+
+// testConcurrent пишет и читает из многих горутин сразу. под -race ловит гонки, без него - потерянные записи
+func testConcurrent(t *testing.T, s storage.Storage) {
+	const workers, perWorker = 8, 50
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWorker {
+				key := fmt.Sprintf("w%d/k%d", w, i)
+				if err := s.Set(ctx, key, key); err != nil {
+					errs <- fmt.Errorf("Set(%q): %w", key, err)
+					return
+				}
+				if _, err := s.Get(ctx, "w0/k0"); err != nil && !errors.Is(err, storage.ErrNotFound) {
+					errs <- fmt.Errorf("Get: %w", err)
+					return
+				}
+				if i%10 == 0 {
+					if _, err := s.Keys(ctx); err != nil {
+						errs <- fmt.Errorf("Keys: %w", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(keys) != workers*perWorker {
+		t.Fatalf("Keys returned %d keys after concurrent writes, want %d", len(keys), workers*perWorker)
+	}
+	wantValue(t, s, "w3/k7", "w3/k7")
+}
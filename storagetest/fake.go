@@ -0,0 +1,201 @@
+// Package storagetest - то, что нужно для тестов поверх storage: FakeStorage, которой можно задать ошибки
+// и задержки, общий набор проверок TestStorage для любого бэкенда и NewServer с HTTP API поверх хранилки
+package storagetest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// Call - один записанный вызов FakeStorage
+type Call struct {
+	Method string // имя метода Storage: Get, Set, Delete, Keys, GetMany или SetMany
+	Key    string // для Get, Set и Delete
+	Value  string // для Set
+	Keys   []string
+	KV     map[string]string // для SetMany
+}
+
+// FakeStorage - хранилка в памяти для тестов. записывает все вызовы, а по методу можно задать
+// задержку и ошибки. ошибка из Fail возвращается вместо вызова, данные при этом не меняются.
+// нулевое значение готово к работе
+type FakeStorage struct {
+	mu      sync.Mutex
+	m       map[string]string
+	calls   []Call
+	fail    map[string][]error // ошибки на следующие вызовы метода по порядку
+	always  map[string]error   // ошибка на все вызовы метода, после того как кончится fail
+	latency map[string]time.Duration
+}
+
+// Fail заставляет следующие вызовы method вернуть errs по одной на вызов
+func (f *FakeStorage) Fail(method string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail == nil {
+		f.fail = make(map[string][]error)
+	}
+	f.fail[method] = append(f.fail[method], errs...)
+}
+
+// FailAlways заставляет все вызовы method возвращать err, nil снова пускает их к данным
+func (f *FakeStorage) FailAlways(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.always == nil {
+		f.always = make(map[string]error)
+	}
+	f.always[method] = err
+}
+
+// Delay задерживает каждый вызов method на d. отмена контекста прерывает ожидание
+func (f *FakeStorage) Delay(method string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.latency == nil {
+		f.latency = make(map[string]time.Duration)
+	}
+	f.latency[method] = d
+}
+
+// Calls - копия всех вызовов по порядку
+func (f *FakeStorage) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// CallCount - сколько раз вызывали method
+func (f *FakeStorage) CallCount(method string) (n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// Data - копия данных, мимо записи вызовов и заданных ошибок
+func (f *FakeStorage) Data() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.m)
+}
+
+// call записывает вызов, выжидает задержку и отдает заданную ошибку, если она есть
+func (f *FakeStorage) call(ctx context.Context, c Call) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, c)
+	d := f.latency[c.Method]
+	err := f.always[c.Method]
+	if errs := f.fail[c.Method]; len(errs) > 0 {
+		err, f.fail[c.Method] = errs[0], errs[1:]
+	}
+	f.mu.Unlock()
+
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (f *FakeStorage) Get(ctx context.Context, key string) (value string, err error) {
+	if err = f.call(ctx, Call{Method: "Get", Key: key}); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.m[key]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *FakeStorage) Set(ctx context.Context, key, value string) (err error) {
+	if err = f.call(ctx, Call{Method: "Set", Key: key, Value: value}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m == nil {
+		f.m = make(map[string]string)
+	}
+	f.m[key] = value
+	return nil
+}
+
+func (f *FakeStorage) Delete(ctx context.Context, key string) (err error) {
+	if err = f.call(ctx, Call{Method: "Delete", Key: key}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.m[key]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(f.m, key)
+	return nil
+}
+
+func (f *FakeStorage) Keys(ctx context.Context) (keys []string, err error) {
+	if err = f.call(ctx, Call{Method: "Keys"}); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys = make([]string, 0, len(f.m))
+	for k := range f.m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys) // как у настоящих хранилок: fallback'и Scan и KeysPage рассчитывают на порядок
+	return keys, nil
+}
+
+func (f *FakeStorage) GetMany(ctx context.Context, keys []string) (kv map[string]string, err error) {
+	if err = f.call(ctx, Call{Method: "GetMany", Keys: slices.Clone(keys)}); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kv = make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := f.m[k]; ok {
+			kv[k] = v
+		}
+	}
+	return kv, nil
+}
+
+func (f *FakeStorage) SetMany(ctx context.Context, kv map[string]string) (err error) {
+	if err = f.call(ctx, Call{Method: "SetMany", KV: maps.Clone(kv)}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m == nil {
+		f.m = make(map[string]string, len(kv))
+	}
+	maps.Copy(f.m, kv)
+	return nil
+}
+
+// NewFakeStorage - FakeStorage, в которой уже лежат kv
+func NewFakeStorage(kv map[string]string) *FakeStorage {
+	f := &FakeStorage{m: make(map[string]string, len(kv))}
+	maps.Copy(f.m, kv)
+	return f
+}
@@ -0,0 +1,22 @@
+package storagetest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Barugoo/example-fs/httpapi"
+	"github.com/Barugoo/example-fs/storage"
+)
+
+// NewServer поднимает HTTP API сервера поверх s, как его отдает examplefs, только без авторизации и metrics.
+// ключи лежат под prefix, например "/memory". сервер останавливается в конце теста
+func NewServer(tb testing.TB, prefix string, s storage.Storage) *httptest.Server {
+	tb.Helper()
+	stop := make(chan struct{})
+	srv := httptest.NewServer(httpapi.NewRouter(prefix, s, stop))
+	tb.Cleanup(func() {
+		close(stop) // иначе Close ждал бы открытые потоки _watch
+		srv.Close()
+	})
+	return srv
+}